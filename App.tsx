@@ -9,8 +9,8 @@ const useDebouncedValue = <T,>(value: T, delayMs: number): T => {
   }, [value, delayMs]);
   return debounced;
 };
-import { Question, Subject, AIConfig } from './types';
-import { apiService } from './services/apiService';
+import { Question, Subject, AIConfig, AuthUser } from './types';
+import { apiService, setUnauthorizedHandler } from './services/apiService';
 import QuestionCard from './components/QuestionCard';
 import QuestionListItem from './components/QuestionListItem';
 import QuestionDialog from './components/QuestionDialog';
@@ -21,10 +21,15 @@ import TestGenerator from './components/TestGenerator';
 import WorkbookGenerator from './components/WorkbookGenerator';
 import HelpCenter from './components/HelpCenter';
 import SettingsDialog from './components/SettingsDialog';
+import AuthScreen from './components/AuthScreen';
 
 type MainTab = 'LIBRARY' | 'TRASH';
+// CHECKING: 正在询问后端登录状态；SETUP: 还没有任何账号；LOGIN: 需要登录
+type AuthState = 'CHECKING' | 'SETUP' | 'LOGIN' | 'READY';
 
 const App: React.FC = () => {
+  const [authState, setAuthState] = useState<AuthState>('CHECKING');
+  const [currentUser, setCurrentUser] = useState<AuthUser | null>(null);
   const [questions, setQuestions] = useState<Question[]>([]);
   const [trashQuestions, setTrashQuestions] = useState<Question[]>([]);
   const [activeTab, setActiveTab] = useState<MainTab>('LIBRARY');
@@ -57,14 +62,32 @@ const App: React.FC = () => {
   const [aiConfig, setAiConfig] = useState<AIConfig | null>(null);
 
   useEffect(() => {
+    // Any 401 from the API means the session is gone: back to the login screen.
+    setUnauthorizedHandler(() => {
+      setCurrentUser(null);
+      setAuthState('LOGIN');
+    });
+    apiService
+      .getAuthStatus()
+      .then((status) => {
+        setCurrentUser(status.user);
+        setAuthState(status.user ? 'READY' : status.setupRequired ? 'SETUP' : 'LOGIN');
+      })
+      .catch(() => setAuthState('LOGIN'));
+    return () => setUnauthorizedHandler(null);
+  }, []);
+
+  useEffect(() => {
+    if (authState !== 'READY') return;
     // Initial sync of global AI config
     apiService.syncRemoteConfig();
     apiService.getAIConfig().then(setAiConfig).catch(() => setAiConfig(null));
-  }, []);
+  }, [authState]);
 
   useEffect(() => {
+    if (authState !== 'READY') return;
     loadData();
-  }, [activeTab, page, selectedTag, pageSize, debouncedSearchQuery, selectedSubject]);
+  }, [authState, activeTab, page, selectedTag, pageSize, debouncedSearchQuery, selectedSubject]);
 
   useEffect(() => {
     // Switching tabs should reset paging.
@@ -306,6 +329,41 @@ const App: React.FC = () => {
 
   const isAllSelected = filteredQuestions.length > 0 && filteredQuestions.every(q => selectedIds.has(q.id));
 
+  const handleSignedIn = (user: AuthUser) => {
+    setCurrentUser(user);
+    setAuthState('READY');
+  };
+
+  const handleLogout = async () => {
+    try {
+      await apiService.logout();
+    } finally {
+      setCurrentUser(null);
+      setQuestions([]);
+      setTrashQuestions([]);
+      setSelectedIds(new Set());
+      setActiveQuestion(null);
+      setAuthState('LOGIN');
+    }
+  };
+
+  if (authState === 'CHECKING') {
+    return (
+      <div className="min-h-screen flex items-center justify-center bg-slate-50">
+        <div className="w-8 h-8 border-4 border-indigo-200 border-t-indigo-600 rounded-full animate-spin" />
+      </div>
+    );
+  }
+  if (authState === 'SETUP' || authState === 'LOGIN') {
+    return (
+      <AuthScreen
+        key={authState}
+        mode={authState === 'SETUP' ? 'setup' : 'login'}
+        onSignedIn={handleSignedIn}
+      />
+    );
+  }
+
   return (
     <div className="min-h-screen flex flex-col">
       {/* Header */}
@@ -394,6 +452,16 @@ const App: React.FC = () => {
               </svg>
             </button>
             
+            <button
+              onClick={handleLogout}
+              className="p-2 text-slate-400 hover:text-red-500 hover:bg-red-50 rounded-xl transition-all"
+              title={currentUser ? `退出登录（${currentUser.username}）` : '退出登录'}
+            >
+              <svg className="w-6 h-6" fill="none" stroke="currentColor" viewBox="0 0 24 24">
+                <path strokeLinecap="round" strokeLinejoin="round" strokeWidth="2" d="M17 16l4-4m0 0l-4-4m4 4H7m6 4v1a3 3 0 01-3 3H6a3 3 0 01-3-3V7a3 3 0 013-3h4a3 3 0 013 3v1" />
+              </svg>
+            </button>
+
             <button 
               onClick={() => setActiveTab(activeTab === 'LIBRARY' ? 'TRASH' : 'LIBRARY')}
               className={`relative p-2 rounded-xl border transition-all ${
@@ -0,0 +1,189 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"E-Bu-backend/models"
+)
+
+// Part is one piece of a message: text, or an image given as a data URL
+// (or plain base64, which is assumed to be JPEG).
+type Part struct {
+	Text     string
+	ImageURL string
+}
+
+type Message struct {
+	Role  string
+	Parts []Part
+}
+
+type Request struct {
+	System   string
+	Messages []Message
+	// JSON asks the provider for a JSON object response where supported.
+	JSON bool
+}
+
+// Client sends a chat request to an AI provider and returns the text reply.
+type Client interface {
+	Complete(ctx context.Context, req Request) (string, error)
+	Model() string
+}
+
+// NewClientFromConfig builds a client for the active provider in cfg.
+// All built-in providers are reached through their OpenAI-compatible
+// chat completions endpoint.
+func NewClientFromConfig(cfg *models.AIConfig) (Client, error) {
+	s, err := SettingsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &OpenAICompatibleClient{Settings: *s, HTTP: &http.Client{Timeout: 120 * time.Second}}, nil
+}
+
+type OpenAICompatibleClient struct {
+	Settings Settings
+	HTTP     *http.Client
+}
+
+func (c *OpenAICompatibleClient) Model() string {
+	return string(c.Settings.Type) + "/" + c.Settings.ModelName
+}
+
+type chatContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+type chatMessage struct {
+	Role    string        `json:"role"`
+	Content []chatContent `json:"content"`
+}
+
+type chatRequest struct {
+	Model          string            `json:"model"`
+	Messages       []chatMessage     `json:"messages"`
+	ResponseFormat map[string]string `json:"response_format,omitempty"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func imageDataURL(s string) string {
+	if strings.HasPrefix(s, "data:") || strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+		return s
+	}
+	return "data:image/jpeg;base64," + s
+}
+
+func (c *OpenAICompatibleClient) Complete(ctx context.Context, req Request) (string, error) {
+	body := chatRequest{Model: c.Settings.ModelName}
+	if req.System != "" {
+		body.Messages = append(body.Messages, chatMessage{
+			Role:    "system",
+			Content: []chatContent{{Type: "text", Text: req.System}},
+		})
+	}
+	for _, m := range req.Messages {
+		msg := chatMessage{Role: m.Role}
+		for _, p := range m.Parts {
+			if p.ImageURL != "" {
+				cc := chatContent{Type: "image_url"}
+				cc.ImageURL = &struct {
+					URL string `json:"url"`
+				}{URL: imageDataURL(p.ImageURL)}
+				msg.Content = append(msg.Content, cc)
+				continue
+			}
+			msg.Content = append(msg.Content, chatContent{Type: "text", Text: p.Text})
+		}
+		body.Messages = append(body.Messages, msg)
+	}
+	// Doubao rejects response_format on most vision endpoints.
+	if req.JSON && c.Settings.Type != models.Doubao {
+		body.ResponseFormat = map[string]string{"type": "json_object"}
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Settings.BaseURL+"/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+c.Settings.APIKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return "", err
+	}
+	var parsed chatResponse
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return "", fmt.Errorf("AI request failed: HTTP %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || parsed.Error != nil {
+		msg := fmt.Sprintf("HTTP %d", resp.StatusCode)
+		if parsed.Error != nil && parsed.Error.Message != "" {
+			msg = parsed.Error.Message
+		}
+		return "", fmt.Errorf("AI request failed: %s", msg)
+	}
+	if len(parsed.Choices) == 0 || strings.TrimSpace(parsed.Choices[0].Message.Content) == "" {
+		return "", errors.New("AI returned an empty response")
+	}
+	return parsed.Choices[0].Message.Content, nil
+}
+
+// DecodeJSON parses a JSON object out of a model reply, tolerating Markdown
+// code fences and text around the object.
+func DecodeJSON(text string, v interface{}) error {
+	s := strings.TrimSpace(text)
+	s = strings.TrimPrefix(s, "```json")
+	s = strings.TrimPrefix(s, "```")
+	s = strings.TrimSuffix(s, "```")
+	s = strings.TrimSpace(s)
+	if err := json.Unmarshal([]byte(s), v); err == nil {
+		return nil
+	}
+	start := strings.Index(s, "{")
+	end := strings.LastIndex(s, "}")
+	if start < 0 || end <= start {
+		return errors.New("AI response is not JSON")
+	}
+	if err := json.Unmarshal([]byte(s[start:end+1]), v); err != nil {
+		return fmt.Errorf("AI response is not valid JSON: %w", err)
+	}
+	return nil
+}
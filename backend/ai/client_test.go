@@ -0,0 +1,78 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"E-Bu-backend/models"
+)
+
+func TestSettingsFromConfigData(t *testing.T) {
+	cfg := &models.AIConfig{ConfigData: `{
+		"activeProvider": "custom-1",
+		"providers": {"QWEN": {"apiKey": "qwen-key"}},
+		"customProviders": [{"id": "custom-1", "name": "Local", "config": {"apiKey": "k", "baseUrl": "http://localhost:1234/v1/", "modelName": "m"}}]
+	}`}
+	s, err := SettingsFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("SettingsFromConfig: %v", err)
+	}
+	if s.BaseURL != "http://localhost:1234/v1" || s.ModelName != "m" || s.APIKey != "k" {
+		t.Fatalf("unexpected custom settings: %+v", s)
+	}
+
+	cfg.ConfigData = `{"activeProvider": "QWEN", "providers": {"QWEN": {"apiKey": "qwen-key"}}}`
+	s, err = SettingsFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("SettingsFromConfig: %v", err)
+	}
+	if s.ModelName != "qwen-vl-max" || s.BaseURL != "https://dashscope.aliyuncs.com/compatible-mode/v1" {
+		t.Fatalf("expected Qwen defaults, got %+v", s)
+	}
+
+	cfg.ConfigData = `{"activeProvider": "DOUBAO", "providers": {"DOUBAO": {"apiKey": "k"}}}`
+	if _, err := SettingsFromConfig(cfg); err != ErrModelRequired {
+		t.Fatalf("expected ErrModelRequired for Doubao without endpoint, got %v", err)
+	}
+
+	if _, err := SettingsFromConfig(&models.AIConfig{Type: models.Gemini}); err != ErrNotConfigured {
+		t.Fatalf("expected ErrNotConfigured without API key, got %v", err)
+	}
+}
+
+func TestOpenAICompatibleClientComplete(t *testing.T) {
+	var got chatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, `{"error":{"message":"bad request"}}`, http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"choices":[{"message":{"content":"{\"verdict\":\"correct\",\"score\":100,\"explanation\":\"ok\"}"}}]}`))
+	}))
+	defer srv.Close()
+
+	client := &OpenAICompatibleClient{Settings: Settings{Type: models.OpenAI, APIKey: "secret", BaseURL: srv.URL + "/v1", ModelName: "gpt-test"}}
+	result, err := Grade(context.Background(), client, GradeInput{Content: "1+1", ReferenceAnswer: "2", StudentImage: "aGVsbG8="})
+	if err != nil {
+		t.Fatalf("Grade: %v", err)
+	}
+	if result.Verdict != VerdictCorrect || result.Score != 100 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if got.Model != "gpt-test" || got.ResponseFormat["type"] != "json_object" || len(got.Messages) != 2 {
+		t.Fatalf("unexpected request: %+v", got)
+	}
+	user := got.Messages[1].Content
+	if len(user) != 2 || user[1].ImageURL == nil || user[1].ImageURL.URL != "data:image/jpeg;base64,aGVsbG8=" {
+		t.Fatalf("expected handwriting photo as data URL, got %+v", user)
+	}
+
+	client.Settings.APIKey = "wrong"
+	if _, err := client.Complete(context.Background(), Request{}); err == nil {
+		t.Fatalf("expected provider error to surface")
+	}
+}
@@ -0,0 +1,92 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	VerdictCorrect   = "correct"
+	VerdictPartial   = "partial"
+	VerdictIncorrect = "incorrect"
+)
+
+const gradingPrompt = `你是一名严谨的中学阅卷老师。给定题目、参考答案、标准解析和学生的作答（文字或手写照片），判断学生作答是否正确。
+要求：
+1. 以参考答案和解析为准，但允许等价的表达、不同的解题方法和合理的化简形式。
+2. verdict 只能是 "correct"（完全正确）、"partial"（思路基本正确但有错误或不完整）、"incorrect"（错误或未作答）。
+3. score 为 0-100 的整数。
+4. explanation 用中文指出学生具体错在哪一步、为什么错、应该怎样改正；如果完全正确，简要说明亮点。公式使用 LaTeX 并用 $...$ 包裹。
+5. 只返回 JSON：{"verdict": "...", "score": 0, "explanation": "..."}`
+
+type GradeInput struct {
+	Subject         string
+	Content         string
+	Options         []string
+	ReferenceAnswer string
+	Analysis        string
+	StudentAnswer   string
+	// StudentImage is a photo of handwritten work (data URL or base64).
+	StudentImage string
+}
+
+type GradeResult struct {
+	Verdict     string `json:"verdict"`
+	Score       int    `json:"score"`
+	Explanation string `json:"explanation"`
+	// Raw is the unparsed model reply, kept for auditing.
+	Raw string `json:"-"`
+}
+
+// Grade asks the provider to check a free-text (or handwritten) answer
+// against the stored reference answer and analysis.
+func Grade(ctx context.Context, client Client, in GradeInput) (*GradeResult, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "学科：%s\n\n题目：\n%s\n", in.Subject, in.Content)
+	if len(in.Options) > 0 {
+		fmt.Fprintf(&b, "\n选项：\n%s\n", strings.Join(in.Options, "\n"))
+	}
+	fmt.Fprintf(&b, "\n参考答案：\n%s\n\n标准解析：\n%s\n", in.ReferenceAnswer, in.Analysis)
+	if in.StudentAnswer != "" {
+		fmt.Fprintf(&b, "\n学生作答：\n%s\n", in.StudentAnswer)
+	}
+	if in.StudentImage != "" {
+		b.WriteString("\n学生的手写作答见附图。\n")
+	}
+
+	parts := []Part{{Text: b.String()}}
+	if in.StudentImage != "" {
+		parts = append(parts, Part{ImageURL: in.StudentImage})
+	}
+
+	raw, err := client.Complete(ctx, Request{
+		System:   gradingPrompt,
+		Messages: []Message{{Role: "user", Parts: parts}},
+		JSON:     true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result GradeResult
+	if err := DecodeJSON(raw, &result); err != nil {
+		return nil, err
+	}
+	result.Raw = raw
+	result.Verdict = strings.ToLower(strings.TrimSpace(result.Verdict))
+	switch result.Verdict {
+	case VerdictCorrect, VerdictPartial, VerdictIncorrect:
+	case "partially_correct", "partially correct":
+		result.Verdict = VerdictPartial
+	default:
+		return nil, fmt.Errorf("AI returned unknown verdict %q", result.Verdict)
+	}
+	if result.Score < 0 {
+		result.Score = 0
+	}
+	if result.Score > 100 {
+		result.Score = 100
+	}
+	return &result, nil
+}
@@ -0,0 +1,135 @@
+package ai
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"E-Bu-backend/models"
+)
+
+var (
+	ErrNotConfigured = errors.New("AI provider API key is not configured")
+	ErrModelRequired = errors.New("Doubao requires an inference endpoint ID (ep-xxxxxxxxxx) as model name")
+	ErrBaseURLNeeded = errors.New("custom AI provider has no base URL")
+)
+
+// Settings is the connection info of the active provider, flattened the same
+// way the frontend's getActiveProviderConfig does.
+type Settings struct {
+	Type         models.AIProviderType `json:"type"`
+	APIKey       string                `json:"-"`
+	BaseURL      string                `json:"baseUrl"`
+	ModelName    string                `json:"modelName"`
+	SystemPrompt string                `json:"systemPrompt,omitempty"`
+}
+
+type providerConfig struct {
+	APIKey    string `json:"apiKey"`
+	BaseURL   string `json:"baseUrl"`
+	ModelName string `json:"modelName"`
+}
+
+// configData mirrors the frontend AIConfig stored in AIConfig.ConfigData.
+type configData struct {
+	ActiveProvider  string                    `json:"activeProvider"`
+	Providers       map[string]providerConfig `json:"providers"`
+	CustomProviders []struct {
+		ID     string         `json:"id"`
+		Name   string         `json:"name"`
+		Config providerConfig `json:"config"`
+	} `json:"customProviders"`
+	SystemPrompt string `json:"systemPrompt"`
+}
+
+func isBuiltIn(t string) bool {
+	switch models.AIProviderType(t) {
+	case models.Gemini, models.Qwen, models.Doubao, models.OpenAI:
+		return true
+	}
+	return false
+}
+
+// SettingsFromConfig resolves the active provider from the stored config,
+// preferring the full ConfigData JSON and falling back to the legacy columns.
+func SettingsFromConfig(cfg *models.AIConfig) (*Settings, error) {
+	s := &Settings{
+		Type:         cfg.Type,
+		APIKey:       cfg.APIKey,
+		BaseURL:      cfg.BaseURL,
+		ModelName:    cfg.ModelName,
+		SystemPrompt: cfg.SystemPrompt,
+	}
+
+	if cfg.ConfigData != "" {
+		var data configData
+		if err := json.Unmarshal([]byte(cfg.ConfigData), &data); err != nil {
+			return nil, err
+		}
+		s = &Settings{Type: models.Gemini, SystemPrompt: data.SystemPrompt}
+		active := data.ActiveProvider
+		if active == "" {
+			active = string(models.Gemini)
+		}
+		if isBuiltIn(active) {
+			p := data.Providers[active]
+			s.Type = models.AIProviderType(active)
+			s.APIKey, s.BaseURL, s.ModelName = p.APIKey, p.BaseURL, p.ModelName
+		} else {
+			for _, cp := range data.CustomProviders {
+				if cp.ID != active {
+					continue
+				}
+				s.Type = models.AIProviderType(cp.ID)
+				s.APIKey, s.BaseURL, s.ModelName = cp.Config.APIKey, cp.Config.BaseURL, cp.Config.ModelName
+				if s.BaseURL == "" {
+					return nil, ErrBaseURLNeeded
+				}
+				break
+			}
+		}
+	}
+
+	if s.Type == "" {
+		s.Type = models.Gemini
+	}
+	s.BaseURL = strings.TrimRight(strings.TrimSpace(s.BaseURL), "/")
+	s.ModelName = strings.TrimSpace(s.ModelName)
+
+	// Same defaults as services/imageAnalysisService.ts.
+	switch s.Type {
+	case models.Gemini:
+		if s.BaseURL == "" {
+			s.BaseURL = "https://generativelanguage.googleapis.com/v1beta/openai"
+		}
+		if s.ModelName == "" {
+			s.ModelName = "gemini-2.0-flash"
+		}
+	case models.Qwen:
+		if s.BaseURL == "" {
+			s.BaseURL = "https://dashscope.aliyuncs.com/compatible-mode/v1"
+		}
+		if s.ModelName == "" {
+			s.ModelName = "qwen-vl-max"
+		}
+	case models.Doubao:
+		if s.BaseURL == "" {
+			s.BaseURL = "https://ark.cn-beijing.volces.com/api/v3"
+		}
+		if s.ModelName == "" {
+			return nil, ErrModelRequired
+		}
+	default:
+		if s.BaseURL == "" {
+			s.BaseURL = "https://api.openai.com/v1"
+		}
+		if s.ModelName == "" {
+			s.ModelName = "gpt-4o"
+		}
+	}
+
+	if s.APIKey == "" {
+		return nil, ErrNotConfigured
+	}
+	return s, nil
+}
@@ -0,0 +1,62 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"E-Bu-backend/models"
+)
+
+const variantPrompt = `你是一名中学命题老师。学生已经弄懂了下面这道错题，请围绕相同的知识点、相同学科、相近难度，重新编写全新的变式题，用来检验学生是否真正掌握，而不是记住原题。
+要求：
+1. 不要照抄原题，改变情境、数据或设问方式，但考查的知识点必须一致。
+2. 原题是选择题时，变式题也给出选项，格式为 ["A. 选项内容", "B. 选项内容"]；否则 options 为空数组。
+3. 每道题都要给出 answer、详细的 analysis 和 learningGuide。
+4. difficulty 为 1-5 的整数。
+5. 所有公式使用 LaTeX 并用 $...$ 包裹，JSON 字符串中的反斜杠写成双反斜杠。
+6. 只返回 JSON：{"questions": [{"content": "...", "options": [], "answer": "...", "analysis": "...", "learningGuide": "...", "difficulty": 3}]}`
+
+type VariantInput struct {
+	Subject         string
+	Content         string
+	Options         []string
+	Answer          string
+	Analysis        string
+	KnowledgePoints []string
+	Difficulty      int
+	Count           int
+}
+
+// GenerateVariants asks the provider for Count new questions on the same
+// knowledge points as the original. The raw reply is returned for logging.
+func GenerateVariants(ctx context.Context, client Client, in VariantInput) ([]models.GeminiAnalysisResponse, string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "请编写 %d 道变式题。\n\n学科：%s\n难度：%d\n知识点：%s\n\n原题：\n%s\n",
+		in.Count, in.Subject, in.Difficulty, strings.Join(in.KnowledgePoints, "、"), in.Content)
+	if len(in.Options) > 0 {
+		fmt.Fprintf(&b, "\n原题选项：\n%s\n", strings.Join(in.Options, "\n"))
+	}
+	fmt.Fprintf(&b, "\n原题答案：\n%s\n\n原题解析：\n%s\n", in.Answer, in.Analysis)
+
+	raw, err := client.Complete(ctx, Request{
+		System:   variantPrompt,
+		Messages: []Message{{Role: "user", Parts: []Part{{Text: b.String()}}}},
+		JSON:     true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var parsed struct {
+		Questions []models.GeminiAnalysisResponse `json:"questions"`
+	}
+	if err := DecodeJSON(raw, &parsed); err != nil {
+		return nil, raw, err
+	}
+	if len(parsed.Questions) == 0 {
+		return nil, raw, errors.New("AI returned no variant questions")
+	}
+	return parsed.Questions, raw, nil
+}
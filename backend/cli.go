@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"E-Bu-backend/database"
+	"E-Bu-backend/models"
+)
+
+const usage = `Usage: E-Bu-backend [command]
+
+Without a command the HTTP server starts. Every command works on the
+database at DB_PATH and reads the same SNAPSHOT_* settings as the server.
+
+Commands:
+  serve                       start the HTTP server
+  migrate status              list applied and pending migrations
+  migrate up                  apply every pending migration
+  migrate down [n]            revert the newest n migrations (default 1)
+  migrate to <version>        migrate up or down to <version>
+  migrate restore [version]   restore the snapshot taken before migration
+                              <version> was applied (default: the newest one)
+  backup list                 list database snapshots
+  backup create               take a manual snapshot
+  backup restore <name>       restore a snapshot; the current database is
+                              snapshotted first
+  import -user <name> [flags] <file>
+                              import a .json or .ebu.zip backup into the
+                              account's mistake book
+      -mode merge|replace|append, -conflict keep-newer|overwrite|skip,
+      -on-error abort|skip, -dry-run
+  export -user <name> [-format json|zip] <file>
+                              export the account's questions; "-" writes to
+                              stdout
+  purge-trash [-days N]       permanently delete questions that have been in
+                              every account's trash for more than N days
+                              (default: all)
+  check [-repair]             check the database file, migrations, schema and
+                              question rows; exits 1 if anything needs
+                              attention. -repair fixes the rows it can
+  user list                   list accounts
+  user create [-admin | -role <role>] <name>
+                              create an account (role admin, teacher,
+                              student or parent; default student); the
+                              password is read from the first line of stdin.
+                              The first account must be an admin and takes
+                              over existing data
+  user role <name> <role>     change an account's role
+  user passwd <name>          set a new password, read from stdin, and sign
+                              the account out everywhere
+`
+
+// runCommand runs one command and returns the process exit code: 0 on
+// success, 1 on failure and 2 on a usage error.
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		return serve()
+	}
+	switch args[0] {
+	case "serve":
+		return serve()
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "backup":
+		return backupCommand(args[1:])
+	case "import":
+		return importCommand(args[1:])
+	case "export":
+		return exportCommand(args[1:])
+	case "purge-trash":
+		return purgeTrashCommand(args[1:])
+	case "check":
+		return checkCommand(args[1:])
+	case "user":
+		return userCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		return usageError("unknown command %q", args[0])
+	}
+}
+
+func usageError(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, format+"\n\n", args...)
+	fmt.Fprint(os.Stderr, usage)
+	return 2
+}
+
+func fail(what string, err error) int {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", what, err)
+	return 1
+}
+
+// openDatabase opens DB_PATH. With migrate it behaves like server startup:
+// pending migrations run, after a pre-migration snapshot. Without it the
+// schema is left alone. Changes through the returned DB are audited as made
+// from the command line.
+func openDatabase(migrate bool) (*database.DB, string, error) {
+	dbPath := databasePath()
+	var db *database.DB
+	var err error
+	if migrate {
+		db, err = database.NewDBWithOptions(dbPath, database.Options{SnapshotDir: snapshotConfig(filepath.Dir(dbPath)).Dir})
+	} else {
+		db, err = database.Open(dbPath)
+	}
+	if err != nil {
+		return nil, dbPath, err
+	}
+	return db.As(cliActor), dbPath, nil
+}
+
+// cliActor is what the audit log records for changes made from the command
+// line, which has no account behind it.
+var cliActor = database.Actor{UserAgent: "command line"}
+
+func newSnapshotter(db *database.DB, dbPath string) *database.Snapshotter {
+	return database.NewSnapshotter(db, snapshotConfig(filepath.Dir(dbPath)))
+}
+
+func migrateCommand(args []string) int {
+	if len(args) == 0 {
+		return usageError("migrate needs a subcommand")
+	}
+	switch args[0] {
+	case "status":
+		return migrationStatus()
+	case "up":
+		return migrateTo(func(int) int { return database.LatestMigrationVersion() })
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return usageError("invalid number of migrations %q", args[1])
+			}
+			steps = n
+		}
+		return migrateTo(func(current int) int { return current - steps })
+	case "to":
+		if len(args) != 2 {
+			return usageError("migrate to needs a version")
+		}
+		target, err := strconv.Atoi(args[1])
+		if err != nil {
+			return usageError("invalid migration version %q", args[1])
+		}
+		return migrateTo(func(int) int { return target })
+	case "restore":
+		return restorePreMigration(args[1:])
+	default:
+		return usageError("unknown migrate subcommand %q", args[0])
+	}
+}
+
+func restorePreMigration(args []string) int {
+	version := 0
+	if len(args) > 0 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil || v <= 0 {
+			return usageError("invalid migration version %q", args[0])
+		}
+		version = v
+	}
+
+	db, dbPath, err := openDatabase(false)
+	if err != nil {
+		return fail("failed to open database", err)
+	}
+	migration, safety, err := newSnapshotter(db, dbPath).RestoreBeforeMigration(version)
+	if safety != nil {
+		fmt.Printf("current database saved as %s\n", safety.Name)
+	}
+	if err != nil {
+		return fail("restore failed", err)
+	}
+	fmt.Printf("restored %s (taken before migration %d: %s)\n", *migration.SnapshotPath, migration.Version, migration.Name)
+	fmt.Println("the migrations it predates are pending again and run the next time the server starts")
+	return 0
+}
+
+func migrationStatus() int {
+	db, dbPath, err := openDatabase(false)
+	if err != nil {
+		return fail("failed to open database", err)
+	}
+	shape, err := database.DetectSchemaShape(db.DB)
+	if err != nil {
+		return fail("failed to read migrations", err)
+	}
+	status, err := database.GetMigrationStatus(db.DB, dbPath)
+	if err != nil {
+		return fail("failed to read migrations", err)
+	}
+	fmt.Printf("%s (%s): version %d of %d\n", dbPath, shape, status.Current, status.Latest)
+	for _, m := range status.Applied {
+		notes := ""
+		if !m.Reversible {
+			notes += " (irreversible)"
+		}
+		if m.ChecksumMismatch {
+			notes += " (CHANGED since it was applied)"
+		}
+		fmt.Printf("  applied  %3d  %s%s\n", m.Version, m.Name, notes)
+	}
+	for _, m := range status.Pending {
+		fmt.Printf("  pending  %3d  %s\n", m.Version, m.Name)
+	}
+	return 0
+}
+
+// migrateTo migrates to the version target picks from the current one.
+func migrateTo(target func(current int) int) int {
+	db, dbPath, err := openDatabase(false)
+	if err != nil {
+		return fail("failed to open database", err)
+	}
+	status, err := database.GetMigrationStatus(db.DB, dbPath)
+	if err != nil {
+		return fail("failed to read migrations", err)
+	}
+	version := target(status.Current)
+	if version < 0 || version > status.Latest {
+		fmt.Fprintf(os.Stderr, "target version %d is outside 0-%d\n", version, status.Latest)
+		return 1
+	}
+
+	opts := database.MigrateOptions{SnapshotDir: snapshotConfig(filepath.Dir(dbPath)).Dir}
+	result, err := database.MigrateTo(db.DB, version, opts)
+	if result != nil {
+		if result.SnapshotPath != "" {
+			fmt.Printf("snapshot taken: %s\n", result.SnapshotPath)
+		}
+		for _, m := range result.Reverted {
+			fmt.Printf("reverted %3d  %s\n", m.Version, m.Name)
+		}
+		for _, m := range result.Applied {
+			fmt.Printf("applied  %3d  %s\n", m.Version, m.Name)
+		}
+	}
+	if err != nil {
+		return fail("migration failed", err)
+	}
+	fmt.Printf("database is at version %d\n", version)
+	return 0
+}
+
+func backupCommand(args []string) int {
+	if len(args) == 0 {
+		return usageError("backup needs a subcommand")
+	}
+	db, dbPath, err := openDatabase(false)
+	if err != nil {
+		return fail("failed to open database", err)
+	}
+	snapshots := newSnapshotter(db, dbPath)
+
+	switch args[0] {
+	case "list":
+		snaps, err := snapshots.List()
+		if err != nil {
+			return fail("failed to list snapshots", err)
+		}
+		for _, s := range snaps {
+			fmt.Printf("%s  %-13s  %s  %9d bytes  %5d questions  schema %d\n",
+				s.Name, s.Kind, s.CreatedAt.Local().Format("2006-01-02 15:04:05"), s.Size, s.QuestionCount, s.SchemaVersion)
+		}
+		return 0
+	case "create":
+		snap, err := snapshots.Take(database.SnapshotManual)
+		if err != nil {
+			return fail("snapshot failed", err)
+		}
+		fmt.Printf("snapshot %s written (%d bytes, %d questions)\n", snap.Name, snap.Size, snap.QuestionCount)
+		return 0
+	case "restore":
+		if len(args) != 2 {
+			return usageError("backup restore needs a snapshot name")
+		}
+		safety, err := snapshots.Restore(args[1], cliActor)
+		if safety != nil {
+			fmt.Printf("current database saved as %s\n", safety.Name)
+		}
+		if err != nil {
+			return fail("restore failed", err)
+		}
+		fmt.Printf("restored %s\n", args[1])
+		return 0
+	default:
+		return usageError("unknown backup subcommand %q", args[0])
+	}
+}
+
+func importCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	var opts database.ImportOptions
+	fs.StringVar(&opts.Mode, "mode", database.ImportMerge, "merge, replace or append")
+	fs.StringVar(&opts.Conflict, "conflict", database.ConflictKeepNewer, "keep-newer, overwrite or skip (merge mode)")
+	fs.StringVar(&opts.OnError, "on-error", database.OnErrorAbort, "abort or skip invalid records")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	username := fs.String("user", "", "account whose mistake book to import into")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		return usageError("import needs exactly one file")
+	}
+	if *username == "" {
+		return usageError("import needs -user")
+	}
+	if err := opts.Normalize(); err != nil {
+		return usageError("%v", err)
+	}
+
+	db, _, err := openDatabase(true)
+	if err != nil {
+		return fail("failed to open database", err)
+	}
+	owner, err := db.GetUserByUsername(*username)
+	if err != nil {
+		return fail("failed to find user "+*username, err)
+	}
+	src, closeSrc, err := database.OpenBackupFile(fs.Arg(0))
+	if err != nil {
+		return fail("failed to open backup", err)
+	}
+	defer closeSrc()
+
+	if *dryRun {
+		preview, err := db.PreviewImportStream(owner.ID, src, opts)
+		if err != nil {
+			return fail("preview failed", err)
+		}
+		return printJSON(preview)
+	}
+	report, err := db.ImportQuestionStream(owner.ID, src, opts, nil)
+	if report != nil {
+		printJSON(report)
+	}
+	if err != nil {
+		return fail("import failed", err)
+	}
+	return 0
+}
+
+func exportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "", `json or zip (default: zip for *.zip files, json otherwise)`)
+	username := fs.String("user", "", "account whose questions to export")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		return usageError("export needs exactly one file")
+	}
+	if *username == "" {
+		return usageError("export needs -user")
+	}
+	target := fs.Arg(0)
+	if *format == "" {
+		*format = "json"
+		if strings.HasSuffix(strings.ToLower(target), ".zip") {
+			*format = "zip"
+		}
+	}
+	if *format != "json" && *format != "zip" {
+		return usageError("unknown export format %q", *format)
+	}
+
+	db, _, err := openDatabase(true)
+	if err != nil {
+		return fail("failed to open database", err)
+	}
+	owner, err := db.GetUserByUsername(*username)
+	if err != nil {
+		return fail("failed to find user "+*username, err)
+	}
+	write := func(w io.Writer) (int, error) {
+		if *format == "zip" {
+			return db.ExportBackupZip(owner.ID, w)
+		}
+		return db.ExportBackupJSON(owner.ID, w)
+	}
+
+	if target == "-" {
+		if _, err := write(os.Stdout); err != nil {
+			return fail("export failed", err)
+		}
+		return 0
+	}
+	// Write next to the target and rename, so a failed export never leaves
+	// a truncated backup under the real name.
+	tmp, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return fail("export failed", err)
+	}
+	defer os.Remove(tmp.Name())
+	count, err := write(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		return fail("export failed", err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d questions to %s\n", count, target)
+	return 0
+}
+
+func purgeTrashCommand(args []string) int {
+	fs := flag.NewFlagSet("purge-trash", flag.ContinueOnError)
+	days := fs.Int("days", 0, "only purge questions trashed more than this many days ago")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 || *days < 0 {
+		return usageError("purge-trash takes only -days N")
+	}
+
+	db, _, err := openDatabase(true)
+	if err != nil {
+		return fail("failed to open database", err)
+	}
+	var cutoff time.Time
+	if *days > 0 {
+		cutoff = time.Now().AddDate(0, 0, -*days)
+	}
+	purged, err := db.PurgeTrash(cutoff)
+	if err != nil {
+		return fail("purge failed", err)
+	}
+	fmt.Printf("purged %d questions from the trash\n", purged)
+	return 0
+}
+
+func checkCommand(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "fix the question rows that can be fixed")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		return usageError("check takes only -repair")
+	}
+	// A repair writes rows, so it needs the current schema.
+	db, _, err := openDatabase(*repair)
+	if err != nil {
+		return fail("failed to open database", err)
+	}
+	check := db.CheckIntegrity
+	if *repair {
+		check = db.RepairIntegrity
+	}
+	report, err := check()
+	if err != nil {
+		return fail("check failed", err)
+	}
+	printJSON(report)
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
+
+// passwordInput is where user create and user passwd read the password.
+var passwordInput io.Reader = os.Stdin
+
+func userCommand(args []string) int {
+	if len(args) == 0 {
+		return usageError("user needs a subcommand")
+	}
+	db, _, err := openDatabase(true)
+	if err != nil {
+		return fail("failed to open database", err)
+	}
+
+	switch args[0] {
+	case "list":
+		users, err := db.ListUsers()
+		if err != nil {
+			return fail("failed to list users", err)
+		}
+		for _, u := range users {
+			fmt.Printf("%-32s  %-7s  created %s\n", u.Username, u.Role, u.CreatedAt.Local().Format("2006-01-02 15:04"))
+		}
+		return 0
+	case "create":
+		fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+		admin := fs.Bool("admin", false, "make the account an admin (same as -role admin)")
+		role := fs.String("role", models.RoleStudent, "admin, teacher, student or parent")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if fs.NArg() != 1 {
+			return usageError("user create needs a username")
+		}
+		if *admin {
+			*role = models.RoleAdmin
+		}
+		if !models.ValidRole(*role) {
+			return usageError("unknown role %q", *role)
+		}
+		password, err := readPassword()
+		if err != nil {
+			return fail("failed to read password", err)
+		}
+		n, err := db.CountUsers()
+		if err != nil {
+			return fail("failed to count users", err)
+		}
+		var user *models.User
+		switch {
+		case n == 0 && *role != models.RoleAdmin:
+			return usageError("the first account must be created with -admin")
+		case n == 0:
+			// Same as first-run setup: the admin adopts the existing data.
+			user, err = db.CreateFirstAdmin(fs.Arg(0), password)
+		default:
+			user, err = db.CreateUser(fs.Arg(0), password, *role)
+		}
+		if err != nil {
+			return fail("failed to create user", err)
+		}
+		fmt.Printf("created %s %s\n", user.Role, user.Username)
+		return 0
+	case "role":
+		if len(args) != 3 {
+			return usageError("user role needs a username and a role")
+		}
+		if !models.ValidRole(args[2]) {
+			return usageError("unknown role %q", args[2])
+		}
+		user, err := db.GetUserByUsername(args[1])
+		if err != nil {
+			return fail("failed to find user "+args[1], err)
+		}
+		if _, err := db.SetUserRole(user.ID, args[2]); err != nil {
+			return fail("failed to change role", err)
+		}
+		fmt.Printf("%s is now a %s\n", user.Username, args[2])
+		return 0
+	case "passwd":
+		if len(args) != 2 {
+			return usageError("user passwd needs a username")
+		}
+		user, err := db.GetUserByUsername(args[1])
+		if err != nil {
+			return fail("failed to find user "+args[1], err)
+		}
+		password, err := readPassword()
+		if err != nil {
+			return fail("failed to read password", err)
+		}
+		if err := db.SetPassword(user.ID, password); err != nil {
+			return fail("failed to set password", err)
+		}
+		fmt.Printf("password of %s changed\n", user.Username)
+		return 0
+	default:
+		return usageError("unknown user subcommand %q", args[0])
+	}
+}
+
+// readPassword reads the first line of passwordInput, so passwords never end
+// up in the shell history or the process list.
+func readPassword() (string, error) {
+	line, err := bufio.NewReader(passwordInput).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func printJSON(v interface{}) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fail("failed to write output", err)
+	}
+	return 0
+}
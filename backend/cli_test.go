@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"E-Bu-backend/database"
+	"E-Bu-backend/models"
+)
+
+// newCLIDatabase creates a database at path with the admin account "admin"
+// and returns it open.
+func newCLIDatabase(t *testing.T, path string) (*database.DB, *models.User) {
+	t.Helper()
+	db, err := database.NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	admin, err := db.CreateFirstAdmin("admin", "correct horse")
+	if err != nil {
+		t.Fatalf("CreateFirstAdmin: %v", err)
+	}
+	return db, admin
+}
+
+func closeCLIDatabase(db *database.DB) {
+	sqlDB, _ := db.DB.DB()
+	sqlDB.Close()
+}
+
+func seedCLIDatabase(t *testing.T, path string) string {
+	t.Helper()
+	db, admin := newCLIDatabase(t, path)
+	defer closeCLIDatabase(db)
+	kps := `["k"]`
+	for _, id := range []string{"kept", "trashed"} {
+		q := models.Question{ID: id, Content: id, Analysis: "a", LearningGuide: "l", KnowledgePoints: &kps, Subject: models.Math, Difficulty: 1, CreatedAt: time.Now()}
+		if err := db.CreateQuestion(admin.ID, &q); err != nil {
+			t.Fatalf("CreateQuestion: %v", err)
+		}
+	}
+	if err := db.DeleteQuestion(admin.ID, "trashed"); err != nil {
+		t.Fatal(err)
+	}
+	return admin.ID
+}
+
+func TestRunCommand(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.db")
+	owner := seedCLIDatabase(t, source)
+	t.Setenv("DB_PATH", source)
+	t.Setenv("SNAPSHOT_DIR", filepath.Join(dir, "snapshots"))
+
+	run := func(want int, args ...string) {
+		t.Helper()
+		if code := runCommand(args); code != want {
+			t.Fatalf("%v exited %d, want %d", args, code, want)
+		}
+	}
+
+	run(0, "check")
+	run(0, "migrate", "status")
+	run(0, "migrate", "down")
+	run(0, "migrate", "up")
+	run(0, "backup", "create")
+	run(0, "backup", "list")
+
+	for _, name := range []string{"backup.json", "backup.ebu.zip"} {
+		run(0, "export", "-user", "admin", filepath.Join(dir, name))
+	}
+	run(0, "purge-trash")
+	db, err := database.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trash, _ := db.GetTrash(owner); len(trash) != 0 {
+		t.Fatalf("purge-trash left %d questions", len(trash))
+	}
+
+	for _, name := range []string{"backup.json", "backup.ebu.zip"} {
+		target := filepath.Join(dir, "import-"+name+".db")
+		db, admin := newCLIDatabase(t, target)
+		closeCLIDatabase(db)
+		t.Setenv("DB_PATH", target)
+		run(0, "import", "-user", "admin", "-dry-run", filepath.Join(dir, name))
+		run(0, "import", "-user", "admin", "-mode", "append", filepath.Join(dir, name))
+		db, err := database.Open(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all, _ := db.GetQuestions(admin.ID)
+		trash, _ := db.GetTrash(admin.ID)
+		if len(all) != 1 || len(trash) != 1 {
+			t.Fatalf("%s imported %d questions and %d in the trash", name, len(all), len(trash))
+		}
+	}
+
+	run(1, "import", "-user", "admin", filepath.Join(dir, "missing.json"))
+	run(1, "import", "-user", "nobody", filepath.Join(dir, "backup.json"))
+	run(2, "frobnicate")
+	run(2, "import")
+	run(2, "import", filepath.Join(dir, "backup.json"))
+	run(2, "export", filepath.Join(dir, "again.json"))
+	run(2, "import", "-user", "admin", "-mode", "sideways", filepath.Join(dir, "backup.json"))
+	run(2, "migrate", "to", "latest")
+	run(2, "backup", "restore")
+	run(2, "user", "create")
+}
+
+func TestUserCommand(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "ebu.db")
+	t.Setenv("DB_PATH", dbPath)
+	t.Setenv("SNAPSHOT_DIR", filepath.Join(t.TempDir(), "snapshots"))
+	defer func(r io.Reader) { passwordInput = r }(passwordInput)
+
+	run := func(want int, password string, args ...string) {
+		t.Helper()
+		passwordInput = strings.NewReader(password)
+		if code := runCommand(args); code != want {
+			t.Fatalf("%v exited %d, want %d", args, code, want)
+		}
+	}
+	run(2, "correct horse\n", "user", "create", "student")
+	run(0, "correct horse\n", "user", "create", "-admin", "admin")
+	run(1, "correct horse\n", "user", "create", "admin")
+	run(1, "short\n", "user", "create", "student")
+	run(0, "correct horse\n", "user", "create", "-role", "teacher", "teacher")
+	run(2, "correct horse\n", "user", "create", "-role", "janitor", "janitor")
+	run(0, "", "user", "role", "teacher", "parent")
+	run(2, "", "user", "role", "teacher", "janitor")
+	run(1, "", "user", "role", "admin", "student")
+	run(0, "battery staple", "user", "passwd", "admin")
+	run(1, "battery staple", "user", "passwd", "nobody")
+	run(0, "", "user", "list")
+
+	db, err := database.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.Authenticate("admin", "battery staple")
+	if err != nil || user.Role != models.RoleAdmin {
+		t.Fatalf("Authenticate = %+v, %v", user, err)
+	}
+	if parent, err := db.GetUserByUsername("teacher"); err != nil || parent.Role != models.RoleParent {
+		t.Fatalf("role not changed: %+v, %v", parent, err)
+	}
+}
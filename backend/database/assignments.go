@@ -0,0 +1,289 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidAssignment wraps what is wrong with a new assignment.
+var ErrInvalidAssignment = errors.New("invalid assignment")
+
+// maxAssignmentQuestions is the largest practice session there can be.
+const maxAssignmentQuestions = 100
+
+// CreateAssignment sends teacherID's questions to everyone in one of their
+// classes. Each student gets copies of the questions in their own mistake
+// book, so they practise and review them like any other, and a practice
+// session over the copies that tracks their work on the assignment.
+// Students who join the class before the due date get it when they join.
+func (db *DB) CreateAssignment(teacherID string, classID string, title string, dueAt time.Time, questionIDs []string) (*models.Assignment, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return nil, fmt.Errorf("%w: a title is required", ErrInvalidAssignment)
+	}
+	if !dueAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: the due date has passed", ErrInvalidAssignment)
+	}
+	questionIDs = uniqueStrings(questionIDs)
+	if len(questionIDs) == 0 || len(questionIDs) > maxAssignmentQuestions {
+		return nil, fmt.Errorf("%w: it needs 1-%d questions", ErrInvalidAssignment, maxAssignmentQuestions)
+	}
+	assignment := &models.Assignment{
+		ID:        uuid.New().String(),
+		ClassID:   classID,
+		TeacherID: teacherID,
+		Title:     title,
+		DueAt:     dueAt,
+		CreatedAt: time.Now(),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var class models.Class
+		if err := tx.First(&class, "id = ? AND teacher_id = ?", classID, teacherID).Error; err != nil {
+			return err
+		}
+		questions, err := (&DB{tx}).SelectPracticeQuestions(teacherID, PracticeSelection{QuestionIDs: questionIDs, Limit: maxAssignmentQuestions})
+		if err != nil {
+			return err
+		}
+		if len(questions) != len(questionIDs) {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.Create(assignment).Error; err != nil {
+			return err
+		}
+		for i, q := range questions {
+			if err := tx.Create(&models.AssignmentQuestion{AssignmentID: assignment.ID, QuestionID: q.ID, Position: i + 1}).Error; err != nil {
+				return err
+			}
+		}
+
+		var members []models.ClassMember
+		if err := tx.Where("class_id = ?", classID).Find(&members).Error; err != nil {
+			return err
+		}
+		for _, m := range members {
+			if err := assignToStudent(tx, assignment.ID, questions, m.StudentID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return assignment, nil
+}
+
+// assignToStudent copies an assignment's questions into studentID's mistake
+// book and starts the practice session the student works on it in.
+func assignToStudent(tx *gorm.DB, assignmentID string, questions []models.Question, studentID string) error {
+	ids := make([]string, len(questions))
+	for i, q := range questions {
+		copied := copyQuestion(q, studentID)
+		if err := tx.Create(&copied).Error; err != nil {
+			return err
+		}
+		ids[i] = copied.ID
+	}
+	session, err := createPracticeSession(tx, studentID, ids)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&models.AssignmentStudent{AssignmentID: assignmentID, StudentID: studentID, SessionID: session.ID}).Error
+}
+
+// assignOpenAssignments gives a student who joined a class the class's
+// assignments that are not yet due and that they do not have already.
+func assignOpenAssignments(tx *gorm.DB, classID string, studentID string) error {
+	var assignments []models.Assignment
+	err := tx.Where("class_id = ? AND due_at > ?", classID, time.Now()).
+		Where("id NOT IN (?)", tx.Model(&models.AssignmentStudent{}).Select("assignment_id").Where("student_id = ?", studentID)).
+		Order("created_at").Find(&assignments).Error
+	if err != nil {
+		return err
+	}
+	for _, a := range assignments {
+		var questions []models.Question
+		err := tx.Joins("JOIN assignment_questions ON assignment_questions.question_id = questions.id").
+			Where("assignment_questions.assignment_id = ? AND questions.deleted_at IS NULL", a.ID).
+			Order("assignment_questions.position").Find(&questions).Error
+		if err != nil {
+			return err
+		}
+		// The teacher may have deleted or trashed every question since.
+		if len(questions) == 0 {
+			continue
+		}
+		if err := assignToStudent(tx, a.ID, questions, studentID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListClassAssignments returns the assignments of one of teacherID's
+// classes, newest first.
+func (db *DB) ListClassAssignments(teacherID string, classID string) ([]models.Assignment, error) {
+	if _, err := db.GetClass(teacherID, classID); err != nil {
+		return nil, err
+	}
+	var assignments []models.Assignment
+	err := db.Where("class_id = ?", classID).Order("created_at DESC").Find(&assignments).Error
+	return assignments, err
+}
+
+// AssignmentProgress is how far one student has got with an assignment.
+// Score is the percentage of questions answered correctly.
+type AssignmentProgress struct {
+	StudentID string `json:"studentId"`
+	Username  string `json:"username,omitempty"`
+	SessionID string `json:"sessionId"`
+	Total     int    `json:"total"`
+	Answered  int    `json:"answered"`
+	Correct   int    `json:"correct"`
+	Score     int    `json:"score"`
+	Completed bool   `json:"completed"`
+	// Late is set when the student finished after the due date, or has
+	// not finished and the due date has passed.
+	Late       bool       `json:"late"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+type AssignmentReport struct {
+	models.Assignment
+	Questions int                  `json:"questions"`
+	Students  []AssignmentProgress `json:"students"`
+}
+
+// GetAssignmentReport returns the per-student completion and scores of one
+// of teacherID's assignments.
+func (db *DB) GetAssignmentReport(teacherID string, assignmentID string) (*AssignmentReport, error) {
+	var assignment models.Assignment
+	if err := db.First(&assignment, "id = ? AND teacher_id = ?", assignmentID, teacherID).Error; err != nil {
+		return nil, err
+	}
+	report := &AssignmentReport{Assignment: assignment, Students: []AssignmentProgress{}}
+	var questions int64
+	if err := db.Model(&models.AssignmentQuestion{}).Where("assignment_id = ?", assignmentID).Count(&questions).Error; err != nil {
+		return nil, err
+	}
+	report.Questions = int(questions)
+
+	var rows []struct {
+		models.AssignmentStudent
+		Username string
+	}
+	if err := db.Model(&models.AssignmentStudent{}).
+		Select("assignment_students.*, users.username").
+		Joins("LEFT JOIN users ON users.id = assignment_students.student_id").
+		Where("assignment_students.assignment_id = ?", assignmentID).
+		Order("users.username").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		progress, err := db.assignmentProgress(&assignment, &row.AssignmentStudent)
+		if err != nil {
+			return nil, err
+		}
+		progress.Username = row.Username
+		report.Students = append(report.Students, *progress)
+	}
+	return report, nil
+}
+
+// StudentAssignment is an assignment as one student sees it.
+type StudentAssignment struct {
+	models.Assignment
+	ClassName string             `json:"className"`
+	Progress  AssignmentProgress `json:"progress"`
+}
+
+// ListStudentAssignments returns the assignments studentID got, the soonest
+// due first. The student works on one through the practice session in
+// Progress.SessionID.
+func (db *DB) ListStudentAssignments(studentID string) ([]StudentAssignment, error) {
+	var rows []struct {
+		models.Assignment
+		ClassName string
+		SessionID string
+	}
+	if err := db.Model(&models.Assignment{}).
+		Select("assignments.*, classes.name AS class_name, assignment_students.session_id").
+		Joins("JOIN assignment_students ON assignment_students.assignment_id = assignments.id").
+		Joins("LEFT JOIN classes ON classes.id = assignments.class_id").
+		Where("assignment_students.student_id = ?", studentID).
+		Order("assignments.due_at").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	assignments := make([]StudentAssignment, 0, len(rows))
+	for _, row := range rows {
+		link := models.AssignmentStudent{AssignmentID: row.ID, StudentID: studentID, SessionID: row.SessionID}
+		progress, err := db.assignmentProgress(&row.Assignment, &link)
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, StudentAssignment{Assignment: row.Assignment, ClassName: row.ClassName, Progress: *progress})
+	}
+	return assignments, nil
+}
+
+func (db *DB) assignmentProgress(assignment *models.Assignment, link *models.AssignmentStudent) (*AssignmentProgress, error) {
+	progress := &AssignmentProgress{StudentID: link.StudentID, SessionID: link.SessionID}
+	summary, err := db.GetPracticeSummary(link.StudentID, link.SessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// The student's account (and with it the session) is gone.
+		return progress, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	progress.Total = summary.Total
+	progress.Answered = summary.Correct + summary.Incorrect
+	progress.Correct = summary.Correct
+	if summary.Total > 0 {
+		progress.Score = int(math.Round(100 * float64(summary.Correct) / float64(summary.Total)))
+	}
+	progress.Completed = summary.Pending == 0 && summary.AwaitingSelfGrade == 0
+	if progress.Completed {
+		finished := summary.FinishedAt
+		if finished == nil {
+			finished = lastGradedAt(summary.Attempts)
+		}
+		progress.FinishedAt = finished
+		progress.Late = finished != nil && finished.After(assignment.DueAt)
+	} else {
+		progress.Late = time.Now().After(assignment.DueAt)
+	}
+	return progress, nil
+}
+
+func lastGradedAt(attempts []models.PracticeAttempt) *time.Time {
+	var last *time.Time
+	for _, a := range attempts {
+		if a.GradedAt != nil && (last == nil || a.GradedAt.After(*last)) {
+			last = a.GradedAt
+		}
+	}
+	return last
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Audited actions. Each is recorded in the transaction of the change it
+// describes, so the log never claims something that was rolled back.
+const (
+	AuditQuestionDelete     = "question.delete"
+	AuditQuestionRestore    = "question.restore"
+	AuditQuestionHardDelete = "question.hard_delete"
+	AuditTrashPurge         = "trash.purge"
+	AuditImport             = "backup.import"
+	AuditAIConfigUpdate     = "ai_config.update"
+	AuditMigrationApply     = "migration.apply"
+	AuditMigrationRevert    = "migration.revert"
+	AuditMigrationRestore   = "migration.restore"
+	AuditSnapshotRestore    = "snapshot.restore"
+	AuditIntegrityRepair    = "integrity.repair"
+	AuditUserCreate         = "user.create"
+	AuditUserDelete         = "user.delete"
+	AuditUserRole           = "user.role"
+)
+
+// maxAuditTargets is how many target IDs one entry lists.
+const maxAuditTargets = 100
+
+// Actor is who the audit log records for the changes made through a DB.
+type Actor struct {
+	UserID    string
+	Username  string
+	IP        string
+	UserAgent string
+}
+
+type actorKey struct{}
+
+// As returns db acting for actor: the audit entries written through it name
+// actor. Writes through a DB without an actor are recorded without one.
+func (db *DB) As(actor Actor) *DB {
+	return &DB{db.WithContext(context.WithValue(db.Statement.Context, actorKey{}, actor))}
+}
+
+// recordAudit appends an entry for action to the audit log through tx, the
+// transaction of the change itself.
+func recordAudit(tx *gorm.DB, action string, targetIDs []string, details map[string]interface{}) error {
+	actor, _ := tx.Statement.Context.Value(actorKey{}).(Actor)
+	entry := models.AuditEntry{
+		At:          time.Now(),
+		ActorID:     actor.UserID,
+		ActorName:   actor.Username,
+		IP:          actor.IP,
+		UserAgent:   actor.UserAgent,
+		Action:      action,
+		TargetIDs:   targetIDs,
+		TargetCount: len(targetIDs),
+		Details:     details,
+	}
+	if len(targetIDs) > maxAuditTargets {
+		entry.TargetIDs = targetIDs[:maxAuditTargets]
+	}
+	if entry.TargetIDs == nil {
+		entry.TargetIDs = []string{}
+	}
+	return tx.Create(&entry).Error
+}
+
+// AuditFilter narrows ListAuditEntries. Action matches an action exactly or
+// by its prefix before the dot ("question" matches every question action);
+// TargetID matches entries listing that ID. Zero fields match everything.
+type AuditFilter struct {
+	Action   string
+	ActorID  string
+	TargetID string
+	Since    time.Time
+	Until    time.Time
+}
+
+type PagedAuditEntries struct {
+	Items    []models.AuditEntry `json:"items"`
+	Total    int64               `json:"total"`
+	Page     int                 `json:"page"`
+	PageSize int                 `json:"pageSize"`
+}
+
+// ListAuditEntries returns a page of the audit log, newest first.
+func (db *DB) ListAuditEntries(filter AuditFilter, page int, pageSize int) (*PagedAuditEntries, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 50
+	}
+	if pageSize > 200 {
+		pageSize = 200
+	}
+
+	base := db.Model(&models.AuditEntry{})
+	if filter.Action != "" {
+		base = base.Where("(action = ? OR action LIKE ?)", filter.Action, filter.Action+".%")
+	}
+	if filter.ActorID != "" {
+		base = base.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.TargetID != "" {
+		base = base.Where("target_ids LIKE ?", `%"`+filter.TargetID+`"%`)
+	}
+	if !filter.Since.IsZero() {
+		base = base.Where("at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		base = base.Where("at < ?", filter.Until)
+	}
+
+	var total int64
+	if err := base.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	entries := []models.AuditEntry{}
+	if err := base.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return &PagedAuditEntries{Items: entries, Total: total, Page: page, PageSize: pageSize}, nil
+}
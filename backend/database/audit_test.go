@@ -0,0 +1,165 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"E-Bu-backend/models"
+)
+
+func TestAuditLog_RecordsActionsWithTheirActor(t *testing.T) {
+	base := &DB{newTestDB(t)}
+	actor := Actor{UserID: "u1", Username: "alice", IP: "192.0.2.1", UserAgent: "test"}
+	db := base.As(actor)
+
+	for _, id := range []string{"q1", "q2"} {
+		q := backupQuestion(id, "question "+id, time.Now())
+		if err := db.CreateQuestion("u1", &q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DeleteQuestion("u1", "q1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RestoreQuestion("u1", "q1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HardDeleteQuestion("u1", "q2"); err != nil {
+		t.Fatal(err)
+	}
+	// Another user's question is not touched, so nothing is recorded.
+	if err := db.HardDeleteQuestion("u2", "q1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveAIConfig("u1", &models.AIConfig{Type: models.OpenAI, ModelName: "m", APIKey: "secret-key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An aborted import is rolled back with its audit entry.
+	bad := backupQuestion("bad", "", time.Now())
+	if _, err := db.ImportQuestions("u1", []models.Question{bad}, ImportOptions{Mode: ImportReplace, OnError: OnErrorAbort}); !errors.Is(err, ErrImportAborted) {
+		t.Fatalf("import err = %v", err)
+	}
+	if _, err := db.ImportQuestions("u1", []models.Question{backupQuestion("q3", "imported", time.Now())}, ImportOptions{Mode: ImportReplace}); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := base.ListAuditEntries(AuditFilter{}, 1, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for i := len(page.Items) - 1; i >= 0; i-- {
+		e := page.Items[i]
+		if e.Action == AuditMigrationApply {
+			continue
+		}
+		actions = append(actions, e.Action)
+		if e.ActorID != "u1" || e.ActorName != "alice" || e.IP != "192.0.2.1" || e.UserAgent != "test" {
+			t.Fatalf("entry %s has actor %+v", e.Action, e)
+		}
+	}
+	want := []string{AuditQuestionDelete, AuditQuestionRestore, AuditQuestionHardDelete, AuditAIConfigUpdate, AuditImport}
+	if len(actions) != len(want) {
+		t.Fatalf("recorded %v, want %v", actions, want)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Fatalf("recorded %v, want %v", actions, want)
+		}
+	}
+
+	imports, _ := base.ListAuditEntries(AuditFilter{Action: "backup", TargetID: "q1"}, 1, 50)
+	if imports.Total != 1 || imports.Items[0].TargetCount != 1 || imports.Items[0].Details["mode"] != ImportReplace {
+		t.Fatalf("replace import entry = %+v", imports.Items)
+	}
+	configs, _ := base.ListAuditEntries(AuditFilter{Action: AuditAIConfigUpdate}, 1, 50)
+	if configs.Total != 1 || configs.Items[0].Details["apiKeySet"] != true || configs.Items[0].Details["apiKey"] != nil {
+		t.Fatalf("config entry = %+v", configs.Items)
+	}
+	if later, _ := base.ListAuditEntries(AuditFilter{Since: time.Now().Add(time.Hour)}, 1, 50); later.Total != 0 {
+		t.Fatalf("since filter matched %d entries", later.Total)
+	}
+
+	// Entries cannot be changed or removed.
+	if err := base.Exec("UPDATE audit_log SET actor_name = 'mallory'").Error; err == nil {
+		t.Fatalf("audit entry updated")
+	}
+	if err := base.Exec("DELETE FROM audit_log").Error; err == nil {
+		t.Fatalf("audit entries deleted")
+	}
+}
+
+func TestDeleteAndRestoreQuestion_NoChangeIsNotAudited(t *testing.T) {
+	base := &DB{newTestDB(t)}
+	db := base.As(Actor{UserID: "u1", Username: "alice"})
+	q := backupQuestion("q1", "question", time.Now())
+	if err := db.CreateQuestion("u1", &q); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restoring a question that is not in the trash changes nothing.
+	if err := db.RestoreQuestion("u1", "q1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteQuestion("u1", "q1"); err != nil {
+		t.Fatal(err)
+	}
+	trashed, _ := findQuestion(base.DB, "q1")
+	// Deleting it again keeps the first deletion.
+	time.Sleep(10 * time.Millisecond)
+	if err := db.DeleteQuestion("u1", "q1"); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := findQuestion(base.DB, "q1")
+	if again.Version != trashed.Version || again.DeletedAt == nil || !again.DeletedAt.Equal(*trashed.DeletedAt) {
+		t.Fatalf("second delete changed the question: %+v, was %+v", again, trashed)
+	}
+
+	for action, want := range map[string]int64{AuditQuestionDelete: 1, AuditQuestionRestore: 0} {
+		if page, _ := base.ListAuditEntries(AuditFilter{Action: action}, 1, 50); page.Total != want {
+			t.Fatalf("%s recorded %d times, want %d", action, page.Total, want)
+		}
+	}
+}
+
+func TestAuditLog_RecordsMigrationsAndAccounts(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	admin, err := db.CreateFirstAdmin("admin", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	student := newTestUser(t, db, "student", models.RoleStudent)
+	if _, err := db.SetUserRole(student.ID, models.RoleTeacher); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteUser(student.ID); err != nil {
+		t.Fatal(err)
+	}
+	accounts, _ := db.ListAuditEntries(AuditFilter{Action: "user", TargetID: student.ID}, 1, 50)
+	if accounts.Total != 3 || accounts.Items[1].Details["to"] != models.RoleTeacher {
+		t.Fatalf("account entries = %+v", accounts.Items)
+	}
+	if setup, _ := db.ListAuditEntries(AuditFilter{TargetID: admin.ID}, 1, 50); setup.Total != 1 {
+		t.Fatalf("first admin not recorded: %+v", setup.Items)
+	}
+
+	latest := LatestMigrationVersion()
+	if _, err := MigrateTo(db.DB, latest-1, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateTo(db.DB, latest, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateTo(db.DB, latest-1, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateTo(db.DB, latest, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	migrations, _ := db.ListAuditEntries(AuditFilter{Action: "migration"}, 1, 50)
+	if migrations.Total == 0 || migrations.Items[0].Action != AuditMigrationApply || migrations.Items[0].Details["version"] != float64(latest) {
+		t.Fatalf("migration entries = %+v", migrations.Items)
+	}
+}
@@ -0,0 +1,362 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Import modes.
+const (
+	// ImportReplace deletes every existing question, then inserts the backup
+	// keeping its IDs.
+	ImportReplace = "replace"
+	// ImportMerge matches records by ID and resolves conflicts with the
+	// configured policy.
+	ImportMerge = "merge"
+	// ImportAppend inserts every record as a new question with a fresh ID.
+	ImportAppend = "append"
+)
+
+// Conflict policies for ImportMerge.
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictKeepNewer = "keep-newer"
+)
+
+// What to do when a single record cannot be written.
+const (
+	// OnErrorAbort rolls the whole import back.
+	OnErrorAbort = "abort"
+	// OnErrorSkip rolls back only the failing record and carries on.
+	OnErrorSkip = "skip"
+)
+
+// maxImportErrors caps how many per-record errors are returned.
+const maxImportErrors = 100
+
+var ErrImportAborted = errors.New("import aborted, no changes were saved")
+
+type ImportOptions struct {
+	Mode     string
+	Conflict string
+	OnError  string
+}
+
+// Normalize fills defaults and rejects unknown values.
+func (o *ImportOptions) Normalize() error {
+	if o.Mode == "" {
+		o.Mode = ImportMerge
+	}
+	if o.Conflict == "" {
+		o.Conflict = ConflictKeepNewer
+	}
+	if o.OnError == "" {
+		o.OnError = OnErrorAbort
+	}
+	switch o.Mode {
+	case ImportReplace, ImportMerge, ImportAppend:
+	default:
+		return fmt.Errorf("unknown import mode %q", o.Mode)
+	}
+	switch o.Conflict {
+	case ConflictSkip, ConflictOverwrite, ConflictKeepNewer:
+	default:
+		return fmt.Errorf("unknown conflict policy %q", o.Conflict)
+	}
+	switch o.OnError {
+	case OnErrorAbort, OnErrorSkip:
+	default:
+		return fmt.Errorf("unknown onError value %q", o.OnError)
+	}
+	return nil
+}
+
+type ImportError struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id,omitempty"`
+	Reason string `json:"reason"`
+}
+
+type ImportReport struct {
+	Mode       string        `json:"mode"`
+	Conflict   string        `json:"conflict,omitempty"`
+	Total      int           `json:"total"`
+	Inserted   int           `json:"inserted"`
+	Updated    int           `json:"updated"`
+	Skipped    int           `json:"skipped"`
+	Failed     int           `json:"failed"`
+	Deleted    int64         `json:"deleted"`
+	RolledBack bool          `json:"rolledBack"`
+	Errors     []ImportError `json:"errors,omitempty"`
+}
+
+func (r *ImportReport) addError(index int, id string, reason string) {
+	r.Failed++
+	if len(r.Errors) < maxImportErrors {
+		r.Errors = append(r.Errors, ImportError{Index: index, ID: id, Reason: reason})
+	}
+}
+
+// ImportQuestions writes backup records into ownerID's mistake book in a
+// single transaction. On ErrImportAborted (or any database error) nothing is
+// saved and the report describes what happened up to the failure.
+func (db *DB) ImportQuestions(ownerID string, questions []models.Question, opts ImportOptions) (*ImportReport, error) {
+	return db.ImportQuestionStream(ownerID, NewSliceSource(questions), opts, nil)
+}
+
+// ImportQuestionStream is ImportQuestions for a source that is read one
+// record at a time, so large backups never have to fit in memory. progress,
+// if set, is called after every record with the running report; it must not
+// keep the pointer.
+func (db *DB) ImportQuestionStream(ownerID string, src QuestionSource, opts ImportOptions, progress func(*ImportReport)) (*ImportReport, error) {
+	if ownerID == "" {
+		return nil, ErrNoOwner
+	}
+	if err := opts.Normalize(); err != nil {
+		return nil, err
+	}
+	report := &ImportReport{Mode: opts.Mode}
+	if opts.Mode == ImportMerge {
+		report.Conflict = opts.Conflict
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var deleted []string
+		if opts.Mode == ImportReplace {
+			if err := tx.Model(&models.Question{}).Scopes(ownedBy(ownerID)).Pluck("id", &deleted).Error; err != nil {
+				return err
+			}
+			if len(deleted) > 0 {
+				n, err := hardDeleteQuestions(tx, deleted)
+				if err != nil {
+					return err
+				}
+				report.Deleted = n
+			}
+		}
+
+		imp := &importer{
+			tx:      tx,
+			ownerID: ownerID,
+			opts:    opts,
+			report:  report,
+			newIDs:  map[string]string{},
+			parents: map[string]string{},
+			seen:    map[string]bool{},
+		}
+		for index := 0; ; index++ {
+			q, err := src.Next()
+			if err == io.EOF {
+				break
+			}
+			var recErr *RecordError
+			if err != nil && !errors.As(err, &recErr) {
+				return err
+			}
+			report.Total++
+			if recErr != nil {
+				err = imp.fail(index, recErr.ID, recErr.Err.Error())
+			} else {
+				err = imp.importRecord(q, index)
+			}
+			if err != nil {
+				return err
+			}
+			if progress != nil {
+				progress(report)
+			}
+		}
+		if err := imp.relinkParents(); err != nil {
+			return err
+		}
+		// The targets are the questions a replace import deleted.
+		return recordAudit(tx, AuditImport, deleted, map[string]interface{}{
+			"mode":     report.Mode,
+			"conflict": report.Conflict,
+			"total":    report.Total,
+			"inserted": report.Inserted,
+			"updated":  report.Updated,
+			"skipped":  report.Skipped,
+			"failed":   report.Failed,
+			"deleted":  report.Deleted,
+		})
+	})
+	if err != nil {
+		report.RolledBack = true
+		return report, err
+	}
+	return report, nil
+}
+
+type importer struct {
+	tx      *gorm.DB
+	ownerID string
+	opts    ImportOptions
+	report  *ImportReport
+	// Append mode re-keys records, so parent links inside the backup
+	// have to follow the new IDs: newIDs maps backup ID to new ID, parents
+	// maps each re-keyed child to the backup ID of its parent.
+	newIDs  map[string]string
+	parents map[string]string
+	seen    map[string]bool
+}
+
+// importRecord validates and writes one record inside a savepoint so that
+// with OnErrorSkip a failing record does not take the others down with it.
+func (imp *importer) importRecord(q *models.Question, index int) error {
+	originalID := q.ID
+	if reasons := validateImportRecord(q, imp.seen); len(reasons) > 0 {
+		return imp.fail(index, originalID, strings.Join(reasons, "; "))
+	}
+
+	if err := imp.tx.SavePoint("import_record").Error; err != nil {
+		return err
+	}
+	outcome, err := writeImportedQuestion(imp.tx, imp.ownerID, q, imp.opts, imp.newIDs)
+	if err == nil && originalID != q.ID && q.ParentID != nil {
+		imp.parents[q.ID] = *q.ParentID
+	}
+	if err != nil {
+		if rbErr := imp.tx.RollbackTo("import_record").Error; rbErr != nil {
+			return rbErr
+		}
+		return imp.fail(index, originalID, err.Error())
+	}
+	if err := imp.tx.Exec("RELEASE SAVEPOINT import_record").Error; err != nil {
+		return err
+	}
+
+	switch outcome {
+	case "inserted":
+		imp.report.Inserted++
+	case "updated":
+		imp.report.Updated++
+	default:
+		imp.report.Skipped++
+	}
+	return nil
+}
+
+func (imp *importer) fail(index int, id string, reason string) error {
+	imp.report.addError(index, id, reason)
+	if imp.opts.OnError == OnErrorAbort {
+		return ErrImportAborted
+	}
+	return nil
+}
+
+// validateImportRecord runs ValidateQuestion plus the checks that only make
+// sense across a whole backup, such as duplicate IDs.
+func validateImportRecord(q *models.Question, seen map[string]bool) []string {
+	reasons := ValidateQuestion(q)
+	if q.ID != "" {
+		if seen[q.ID] {
+			reasons = append(reasons, "duplicate id in backup")
+		}
+		seen[q.ID] = true
+	}
+	return reasons
+}
+
+// writeImportedQuestion saves one record as ownerID's. A record whose ID
+// belongs to another user's question is inserted under a new ID, as in
+// append mode, so an import can never touch someone else's data.
+func writeImportedQuestion(tx *gorm.DB, ownerID string, q *models.Question, opts ImportOptions, newIDs map[string]string) (string, error) {
+	if q.CreatedAt.IsZero() {
+		q.CreatedAt = time.Now()
+	}
+	if q.UpdatedAt.IsZero() {
+		q.UpdatedAt = q.CreatedAt
+	}
+	q.OwnerID = ownerID
+
+	var existing *models.Question
+	if opts.Mode != ImportAppend && q.ID != "" {
+		found, err := findQuestion(tx, q.ID)
+		if err != nil {
+			return "", err
+		}
+		existing = found
+	}
+	if opts.Mode == ImportAppend || q.ID == "" || (existing != nil && existing.OwnerID != ownerID) {
+		newID := uuid.New().String()
+		if q.ID != "" {
+			newIDs[q.ID] = newID
+		}
+		q.ID = newID
+		return "inserted", tx.Create(q).Error
+	}
+	if existing == nil {
+		return "inserted", tx.Create(q).Error
+	}
+
+	if len(diffQuestions(existing, q)) == 0 {
+		return "skipped", nil
+	}
+	switch opts.Conflict {
+	case ConflictSkip:
+		return "skipped", nil
+	case ConflictKeepNewer:
+		if !lastModified(q).After(lastModified(existing)) {
+			return "skipped", nil
+		}
+	}
+	q.Version = existing.Version + 1
+	// SkipHooks keeps the backup's updated_at instead of stamping now.
+	if err := tx.Session(&gorm.Session{SkipHooks: true}).Select("*").Omit("id").Scopes(ownedBy(ownerID)).Where("id = ?", q.ID).Updates(q).Error; err != nil {
+		return "", err
+	}
+	return "updated", recordRevision(tx, existing, q, models.RevisionImport, nil)
+}
+
+// findQuestion looks a question up by ID, returning nil when it does not
+// exist (without First's "record not found" log line for every new record).
+func findQuestion(tx *gorm.DB, id string) (*models.Question, error) {
+	var found []models.Question
+	if err := tx.Where("id = ?", id).Limit(1).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	return &found[0], nil
+}
+
+func lastModified(q *models.Question) time.Time {
+	if q.UpdatedAt.IsZero() {
+		return q.CreatedAt
+	}
+	return q.UpdatedAt
+}
+
+// relinkParents points re-keyed children at the new ID of their parent when
+// the parent was part of the same backup. Links to the owner's other
+// questions are left as they are; links to questions the owner cannot see
+// are dropped.
+func (imp *importer) relinkParents() error {
+	for childID, oldParent := range imp.parents {
+		newParent, ok := imp.newIDs[oldParent]
+		if !ok {
+			continue
+		}
+		err := imp.tx.Model(&models.Question{}).
+			Where("id = ?", childID).
+			UpdateColumns(map[string]interface{}{"parent_id": newParent, "version": gorm.Expr("version + 1")}).Error
+		if err != nil {
+			return err
+		}
+	}
+	owned := imp.tx.Model(&models.Question{}).Select("id").Scopes(ownedBy(imp.ownerID))
+	return imp.tx.Model(&models.Question{}).Scopes(ownedBy(imp.ownerID)).
+		Where("parent_id IS NOT NULL AND parent_id NOT IN (?)", owned).
+		UpdateColumns(map[string]interface{}{"parent_id": nil, "version": gorm.Expr("version + 1")}).Error
+}
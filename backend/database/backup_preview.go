@@ -0,0 +1,290 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"E-Bu-backend/models"
+)
+
+// Preview record statuses.
+const (
+	PreviewNew       = "new"
+	PreviewChanged   = "changed"
+	PreviewIdentical = "identical"
+	PreviewInvalid   = "invalid"
+)
+
+// maxPreviewRecords caps how many non-identical records are listed.
+const maxPreviewRecords = 1000
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+type PreviewRecord struct {
+	Index   int           `json:"index"`
+	ID      string        `json:"id,omitempty"`
+	Status  string        `json:"status"`
+	Action  string        `json:"action"` // insert, update, skip or reject
+	Changes []FieldChange `json:"changes,omitempty"`
+	Reasons []string      `json:"reasons,omitempty"`
+}
+
+type ImportPreview struct {
+	Mode      string `json:"mode"`
+	Conflict  string `json:"conflict,omitempty"`
+	Total     int    `json:"total"`
+	New       int    `json:"new"`
+	Changed   int    `json:"changed"`
+	Identical int    `json:"identical"`
+	Invalid   int    `json:"invalid"`
+	// What a real import with the same options would do.
+	WouldInsert int             `json:"wouldInsert"`
+	WouldUpdate int             `json:"wouldUpdate"`
+	WouldSkip   int             `json:"wouldSkip"`
+	WouldDelete int64           `json:"wouldDelete"`
+	Records     []PreviewRecord `json:"records"`
+	Truncated   bool            `json:"truncated"`
+}
+
+// PreviewImport validates every record and diffs it against ownerID's
+// questions without writing anything.
+func (db *DB) PreviewImport(ownerID string, questions []models.Question, opts ImportOptions) (*ImportPreview, error) {
+	return db.PreviewImportStream(ownerID, NewSliceSource(questions), opts)
+}
+
+// PreviewImportStream is PreviewImport for a streamed source.
+func (db *DB) PreviewImportStream(ownerID string, src QuestionSource, opts ImportOptions) (*ImportPreview, error) {
+	if err := opts.Normalize(); err != nil {
+		return nil, err
+	}
+	preview := &ImportPreview{Mode: opts.Mode, Records: []PreviewRecord{}}
+	if opts.Mode == ImportMerge {
+		preview.Conflict = opts.Conflict
+	}
+	if opts.Mode == ImportReplace {
+		if err := db.Model(&models.Question{}).Scopes(ownedBy(ownerID)).Count(&preview.WouldDelete).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	seen := map[string]bool{}
+	for i := 0; ; i++ {
+		next, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		var recErr *RecordError
+		if err != nil && !errors.As(err, &recErr) {
+			return nil, err
+		}
+		preview.Total++
+		if recErr != nil {
+			preview.Invalid++
+			preview.add(PreviewRecord{Index: i, ID: recErr.ID, Status: PreviewInvalid, Action: "reject", Reasons: []string{recErr.Err.Error()}})
+			continue
+		}
+		q := *next
+		rec := PreviewRecord{Index: i, ID: q.ID}
+
+		if reasons := validateImportRecord(&q, seen); len(reasons) > 0 {
+			rec.Status, rec.Action, rec.Reasons = PreviewInvalid, "reject", reasons
+			preview.Invalid++
+			preview.add(rec)
+			continue
+		}
+
+		// Another user's question with the same ID is never compared
+		// against; the import would insert the record under a new ID.
+		var existing *models.Question
+		if q.ID != "" && opts.Mode != ImportAppend {
+			found, err := findQuestion(db.DB, q.ID)
+			if err != nil {
+				return nil, err
+			}
+			if found != nil && found.OwnerID == ownerID {
+				existing = found
+			}
+		}
+
+		switch {
+		case existing == nil:
+			rec.Status, rec.Action = PreviewNew, "insert"
+			preview.New++
+		default:
+			rec.Changes = diffQuestions(existing, &q)
+			if len(rec.Changes) == 0 {
+				rec.Status = PreviewIdentical
+				preview.Identical++
+			} else {
+				rec.Status = PreviewChanged
+				preview.Changed++
+			}
+			rec.Action = mergeAction(existing, &q, rec.Status, opts)
+		}
+
+		switch rec.Action {
+		case "insert":
+			preview.WouldInsert++
+		case "update":
+			preview.WouldUpdate++
+		default:
+			preview.WouldSkip++
+		}
+		if rec.Status != PreviewIdentical {
+			preview.add(rec)
+		}
+	}
+	return preview, nil
+}
+
+func (p *ImportPreview) add(rec PreviewRecord) {
+	if len(p.Records) >= maxPreviewRecords {
+		p.Truncated = true
+		return
+	}
+	p.Records = append(p.Records, rec)
+}
+
+func mergeAction(existing *models.Question, incoming *models.Question, status string, opts ImportOptions) string {
+	if opts.Mode == ImportReplace {
+		// Everything is deleted first, so every record is re-inserted.
+		return "insert"
+	}
+	if status == PreviewIdentical {
+		return "skip"
+	}
+	switch opts.Conflict {
+	case ConflictSkip:
+		return "skip"
+	case ConflictKeepNewer:
+		if !lastModified(incoming).After(lastModified(existing)) {
+			return "skip"
+		}
+	}
+	return "update"
+}
+
+// diffQuestions lists the user-visible fields that differ. Bookkeeping
+// (updatedAt) is ignored; images are compared by hash so the diff does not
+// carry megabytes of base64.
+func diffQuestions(existing *models.Question, incoming *models.Question) []FieldChange {
+	var changes []FieldChange
+	add := func(field string, o interface{}, n interface{}) {
+		changes = append(changes, FieldChange{Field: field, Old: o, New: n})
+	}
+
+	if existing.Content != incoming.Content {
+		add("content", existing.Content, incoming.Content)
+	}
+	if !sameJSONList(existing.Options, incoming.Options) {
+		add("options", decodeJSONList(existing.Options), decodeJSONList(incoming.Options))
+	}
+	if strOrEmpty(existing.DiagramDescription) != strOrEmpty(incoming.DiagramDescription) {
+		add("diagramDescription", existing.DiagramDescription, incoming.DiagramDescription)
+	}
+	if strOrEmpty(existing.Answer) != strOrEmpty(incoming.Answer) {
+		add("answer", existing.Answer, incoming.Answer)
+	}
+	if existing.Analysis != incoming.Analysis {
+		add("analysis", existing.Analysis, incoming.Analysis)
+	}
+	if existing.LearningGuide != incoming.LearningGuide {
+		add("learningGuide", existing.LearningGuide, incoming.LearningGuide)
+	}
+	if !sameJSONList(existing.KnowledgePoints, incoming.KnowledgePoints) {
+		add("knowledgePoints", decodeJSONList(existing.KnowledgePoints), decodeJSONList(incoming.KnowledgePoints))
+	}
+	if existing.Subject != incoming.Subject {
+		add("subject", existing.Subject, incoming.Subject)
+	}
+	if existing.Difficulty != incoming.Difficulty {
+		add("difficulty", existing.Difficulty, incoming.Difficulty)
+	}
+	if oh, nh := imageDigest(existing.Image), imageDigest(incoming.Image); oh != nh {
+		add("image", oh, nh)
+	}
+	if oh, nh := imageDigest(existing.CroppedDiagram), imageDigest(incoming.CroppedDiagram); oh != nh {
+		add("croppedDiagram", oh, nh)
+	}
+	if !existing.CreatedAt.Equal(incoming.CreatedAt) {
+		add("createdAt", existing.CreatedAt, incoming.CreatedAt)
+	}
+	if !sameTime(existing.LastReviewedAt, incoming.LastReviewedAt) {
+		add("lastReviewedAt", existing.LastReviewedAt, incoming.LastReviewedAt)
+	}
+	if !sameTime(existing.DeletedAt, incoming.DeletedAt) {
+		add("deletedAt", existing.DeletedAt, incoming.DeletedAt)
+	}
+	if !sameTime(existing.NextReviewAt, incoming.NextReviewAt) {
+		add("nextReviewAt", existing.NextReviewAt, incoming.NextReviewAt)
+	}
+	if existing.ReviewStreak != incoming.ReviewStreak {
+		add("reviewStreak", existing.ReviewStreak, incoming.ReviewStreak)
+	}
+	if strOrEmpty(existing.ParentID) != strOrEmpty(incoming.ParentID) {
+		add("parentId", existing.ParentID, incoming.ParentID)
+	}
+	if existing.AIGenerated != incoming.AIGenerated {
+		add("aiGenerated", existing.AIGenerated, incoming.AIGenerated)
+	}
+	return changes
+}
+
+func strOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func sameTime(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(*b)
+}
+
+func decodeJSONList(s *string) []string {
+	if s == nil {
+		return []string{}
+	}
+	var items []string
+	if err := json.Unmarshal([]byte(*s), &items); err != nil || items == nil {
+		return []string{}
+	}
+	return items
+}
+
+// sameJSONList compares decoded lists, so `null`, `[]` and a missing value
+// count as equal and whitespace differences in the JSON do not matter.
+func sameJSONList(a *string, b *string) bool {
+	x, y := decodeJSONList(a), decodeJSONList(b)
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// imageDigest summarizes an image for diffs: "" when absent, otherwise
+// "sha256:<first 12 hex chars>".
+func imageDigest(s *string) string {
+	if s == nil || strings.TrimSpace(*s) == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(*s))
+	return "sha256:" + hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,243 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"E-Bu-backend/models"
+)
+
+// ErrInvalidBackup wraps errors that make the whole backup unreadable
+// (malformed JSON, wrong top-level shape).
+var ErrInvalidBackup = errors.New("invalid backup file format")
+
+// RecordError is returned by a QuestionSource for a single record that cannot
+// be decoded. The stream itself is still usable; importers count the record
+// as failed and move on.
+type RecordError struct {
+	Index int
+	ID    string
+	Err   error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("record %d: %v", e.Index, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// QuestionSource yields backup records one at a time and returns io.EOF
+// after the last one.
+type QuestionSource interface {
+	Next() (*models.Question, error)
+}
+
+type sliceSource struct {
+	questions []models.Question
+	pos       int
+}
+
+// NewSliceSource adapts an in-memory slice to QuestionSource.
+func NewSliceSource(questions []models.Question) QuestionSource {
+	return &sliceSource{questions: questions}
+}
+
+func (s *sliceSource) Next() (*models.Question, error) {
+	if s.pos >= len(s.questions) {
+		return nil, io.EOF
+	}
+	q := s.questions[s.pos]
+	s.pos++
+	return &q, nil
+}
+
+// BackupDecoder reads a models.BackupData JSON document incrementally, so
+// only one question is held in memory at a time. Records from older backup
+// versions are upgraded to CurrentBackupVersion as they are read.
+type BackupDecoder struct {
+	dec        *json.Decoder
+	Version    string
+	ExportedAt int64
+
+	// from is the resolved version records are upgraded from; "version"
+	// is expected before "data", files without one are read as BackupV1_0.
+	from    string
+	started bool
+	inData  bool
+	done    bool
+	index   int
+}
+
+func NewBackupDecoder(r io.Reader) *BackupDecoder {
+	return &BackupDecoder{dec: json.NewDecoder(r)}
+}
+
+func (d *BackupDecoder) invalid(err error) error {
+	return fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+}
+
+func (d *BackupDecoder) expectDelim(want json.Delim) error {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return d.invalid(err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != want {
+		return d.invalid(fmt.Errorf("expected %q, got %v", want, tok))
+	}
+	return nil
+}
+
+func (d *BackupDecoder) Next() (*models.Question, error) {
+	if d.done {
+		return nil, io.EOF
+	}
+	if !d.started {
+		d.started = true
+		if err := d.expectDelim('{'); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		if d.inData {
+			if d.dec.More() {
+				return d.nextRecord()
+			}
+			if err := d.expectDelim(']'); err != nil {
+				return nil, err
+			}
+			d.inData = false
+			continue
+		}
+
+		if !d.dec.More() {
+			if err := d.expectDelim('}'); err != nil {
+				return nil, err
+			}
+			d.done = true
+			return nil, io.EOF
+		}
+
+		tok, err := d.dec.Token()
+		if err != nil {
+			return nil, d.invalid(err)
+		}
+		key, _ := tok.(string)
+		switch key {
+		case "version":
+			if err := d.dec.Decode(&d.Version); err != nil {
+				return nil, d.invalid(err)
+			}
+			from, err := ResolveBackupVersion(d.Version)
+			if err != nil {
+				return nil, err
+			}
+			if d.from == "" {
+				d.from = from
+			}
+		case "exportedAt":
+			if err := d.dec.Decode(&d.ExportedAt); err != nil {
+				return nil, d.invalid(err)
+			}
+		case "data":
+			tok, err := d.dec.Token()
+			if err != nil {
+				return nil, d.invalid(err)
+			}
+			if tok == nil {
+				continue // "data": null
+			}
+			if d.from == "" {
+				d.from, _ = ResolveBackupVersion("")
+			}
+			if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+				return nil, d.invalid(fmt.Errorf("data must be an array"))
+			}
+			d.inData = true
+		default:
+			var skip json.RawMessage
+			if err := d.dec.Decode(&skip); err != nil {
+				return nil, d.invalid(err)
+			}
+		}
+	}
+}
+
+func (d *BackupDecoder) nextRecord() (*models.Question, error) {
+	index := d.index
+	d.index++
+
+	var raw json.RawMessage
+	if err := d.dec.Decode(&raw); err != nil {
+		return nil, d.invalid(err)
+	}
+	return decodeBackupRecord(raw, d.from, index)
+}
+
+// decodeBackupRecord upgrades one raw record from version from and decodes
+// it. Failures are per record.
+func decodeBackupRecord(raw []byte, from string, index int) (*models.Question, error) {
+	upgraded, err := upgradeRecord(raw, from)
+	if err == nil {
+		var q models.Question
+		if err = json.Unmarshal(upgraded, &q); err == nil {
+			return &q, nil
+		}
+	}
+	var id struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(raw, &id)
+	return nil, &RecordError{Index: index, ID: id.ID, Err: err}
+}
+
+// ExportBackupJSON streams every question of ownerID (including the trash)
+// as a models.BackupData document, reading rows from a database cursor
+// instead of loading the whole table. It returns the number of questions
+// written.
+func (db *DB) ExportBackupJSON(ownerID string, w io.Writer) (int, error) {
+	rows, err := db.Model(&models.Question{}).Scopes(ownedBy(ownerID)).Order("created_at ASC").Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	bw := bufio.NewWriterSize(w, 64*1024)
+	if _, err := fmt.Fprintf(bw, `{"version":%q,"exportedAt":%d,"data":[`, CurrentBackupVersion, time.Now().Unix()); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for rows.Next() {
+		var q models.Question
+		if err := db.ScanRows(rows, &q); err != nil {
+			return count, err
+		}
+		record, err := json.Marshal(q)
+		if err != nil {
+			return count, err
+		}
+		if count > 0 {
+			if err := bw.WriteByte(','); err != nil {
+				return count, err
+			}
+		}
+		if _, err := bw.Write(record); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	if _, err := bw.WriteString("]}\n"); err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
@@ -0,0 +1,325 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"E-Bu-backend/models"
+)
+
+func backupQuestion(id string, content string, updatedAt time.Time) models.Question {
+	return models.Question{
+		ID:              id,
+		Content:         content,
+		Analysis:        "a",
+		LearningGuide:   "l",
+		KnowledgePoints: stringPtr(`["kp"]`),
+		Subject:         models.Math,
+		Difficulty:      2,
+		CreatedAt:       updatedAt.Add(-time.Hour),
+		UpdatedAt:       updatedAt,
+	}
+}
+
+func TestImportQuestions_Modes(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	old := time.Now().Add(-48 * time.Hour)
+	recent := time.Now().Add(-time.Hour)
+
+	existing := backupQuestion("q1", "mine", recent)
+	if err := db.CreateQuestion(testOwner, &existing); err != nil {
+		t.Fatalf("CreateQuestion: %v", err)
+	}
+	stale := backupQuestion("q2", "mine too", old)
+	if err := db.CreateQuestion(testOwner, &stale); err != nil {
+		t.Fatalf("CreateQuestion: %v", err)
+	}
+
+	backup := []models.Question{
+		backupQuestion("q1", "theirs, older", old),
+		backupQuestion("q2", "theirs, newer", recent),
+		backupQuestion("q3", "new", recent),
+	}
+
+	report, err := db.ImportQuestions(testOwner, backup, ImportOptions{Mode: ImportMerge, Conflict: ConflictKeepNewer})
+	if err != nil {
+		t.Fatalf("merge import: %v", err)
+	}
+	if report.Inserted != 1 || report.Updated != 1 || report.Skipped != 1 || report.Failed != 0 {
+		t.Fatalf("unexpected merge report: %+v", report)
+	}
+	q1, _ := db.GetQuestionByID(testOwner, "q1")
+	q2, _ := db.GetQuestionByID(testOwner, "q2")
+	if q1.Content != "mine" || q2.Content != "theirs, newer" {
+		t.Fatalf("keep-newer picked the wrong side: q1=%q q2=%q", q1.Content, q2.Content)
+	}
+	if !q2.UpdatedAt.Equal(recent) {
+		t.Fatalf("overwrite should keep the backup's updatedAt, got %v", q2.UpdatedAt)
+	}
+
+	report, err = db.ImportQuestions(testOwner, backup, ImportOptions{Mode: ImportAppend})
+	if err != nil {
+		t.Fatalf("append import: %v", err)
+	}
+	if report.Inserted != 3 {
+		t.Fatalf("append should insert everything, got %+v", report)
+	}
+
+	// History of the replaced questions must not carry over to re-imported
+	// ones with the same IDs.
+	session, err := db.CreatePracticeSession(testOwner, []string{"q1", "q2"})
+	if err != nil {
+		t.Fatalf("CreatePracticeSession: %v", err)
+	}
+	correct := true
+	if _, _, err := db.AnswerPracticeAttempt(testOwner, session.ID, "q1", "A", nil, &correct); err != nil {
+		t.Fatalf("AnswerPracticeAttempt: %v", err)
+	}
+	collection, err := db.CreateCollection(testOwner, "set", "")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	if err := db.AddCollectionQuestions(testOwner, collection.ID, []string{"q1"}); err != nil {
+		t.Fatalf("AddCollectionQuestions: %v", err)
+	}
+
+	report, err = db.ImportQuestions(testOwner, backup[:1], ImportOptions{Mode: ImportReplace})
+	if err != nil {
+		t.Fatalf("replace import: %v", err)
+	}
+	if report.Deleted != 6 || report.Inserted != 1 {
+		t.Fatalf("unexpected replace report: %+v", report)
+	}
+	all, _ := db.GetQuestions(testOwner)
+	if len(all) != 1 || all[0].ID != "q1" {
+		t.Fatalf("replace should keep only the backup with its IDs, got %d rows", len(all))
+	}
+	if attempts, _ := db.GetQuestionAttempts(testOwner, "q1"); len(attempts) != 0 {
+		t.Fatalf("replaced question kept %d attempts", len(attempts))
+	}
+	if logs, _ := db.GetReviewLogs(testOwner, "q1"); len(logs) != 0 {
+		t.Fatalf("replaced question kept %d review logs", len(logs))
+	}
+	if summary, _ := db.GetPracticeSummary(testOwner, session.ID); summary.Total != 0 || len(summary.Attempts) != 0 {
+		t.Fatalf("practice session still points at replaced questions: %+v", summary)
+	}
+	if detail, _ := db.GetCollection(testOwner, collection.ID); len(detail.Questions) != 0 {
+		t.Fatalf("collection still lists %d replaced questions", len(detail.Questions))
+	}
+}
+
+func TestImportQuestions_AbortRollsBack(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	now := time.Now()
+
+	bad := backupQuestion("bad", "bad difficulty", now)
+	bad.Difficulty = 9
+	backup := []models.Question{backupQuestion("ok", "ok", now), bad}
+
+	report, err := db.ImportQuestions(testOwner, backup, ImportOptions{Mode: ImportMerge})
+	if !errors.Is(err, ErrImportAborted) {
+		t.Fatalf("expected ErrImportAborted, got %v", err)
+	}
+	if !report.RolledBack || report.Failed != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if all, _ := db.GetQuestions(testOwner); len(all) != 0 {
+		t.Fatalf("aborted import must not leave rows behind, got %d", len(all))
+	}
+
+	report, err = db.ImportQuestions(testOwner, backup, ImportOptions{Mode: ImportMerge, OnError: OnErrorSkip})
+	if err != nil {
+		t.Fatalf("skip import: %v", err)
+	}
+	if report.Inserted != 1 || report.Failed != 1 || len(report.Errors) != 1 || report.Errors[0].ID != "bad" {
+		t.Fatalf("unexpected skip report: %+v", report)
+	}
+}
+
+func TestPreviewImport_DiffWithoutWriting(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	now := time.Now()
+
+	same := backupQuestion("same", "same", now)
+	changed := backupQuestion("changed", "before", now)
+	for _, q := range []*models.Question{&same, &changed} {
+		if err := db.CreateQuestion(testOwner, q); err != nil {
+			t.Fatalf("CreateQuestion: %v", err)
+		}
+	}
+
+	edited := backupQuestion("changed", "after", now.Add(time.Minute))
+	edited.CreatedAt = changed.CreatedAt
+	edited.KnowledgePoints = stringPtr(`["kp", "new kp"]`)
+	invalid := backupQuestion("invalid", "", now)
+	invalid.Subject = "地理"
+	backup := []models.Question{
+		backupQuestion("same", "same", now),
+		edited,
+		backupQuestion("fresh", "fresh", now),
+		invalid,
+		backupQuestion("fresh", "dup", now),
+	}
+
+	preview, err := db.PreviewImport(testOwner, backup, ImportOptions{})
+	if err != nil {
+		t.Fatalf("PreviewImport: %v", err)
+	}
+	if preview.New != 1 || preview.Changed != 1 || preview.Identical != 1 || preview.Invalid != 2 {
+		t.Fatalf("unexpected preview counts: %+v", preview)
+	}
+	if preview.WouldInsert != 1 || preview.WouldUpdate != 1 || preview.WouldSkip != 1 {
+		t.Fatalf("unexpected preview actions: %+v", preview)
+	}
+
+	var changes []FieldChange
+	var reasons []string
+	for _, rec := range preview.Records {
+		switch rec.ID {
+		case "changed":
+			changes = rec.Changes
+		case "invalid":
+			reasons = rec.Reasons
+		}
+	}
+	if len(changes) != 2 || changes[0].Field != "content" || changes[1].Field != "knowledgePoints" {
+		t.Fatalf("unexpected field changes: %+v", changes)
+	}
+	if len(reasons) != 2 {
+		t.Fatalf("expected empty content and unknown subject, got %v", reasons)
+	}
+
+	if all, _ := db.GetQuestions(testOwner); len(all) != 2 {
+		t.Fatalf("dry run must not write, got %d rows", len(all))
+	}
+	q, _ := db.GetQuestionByID(testOwner, "changed")
+	if q.Content != "before" {
+		t.Fatalf("dry run modified a row")
+	}
+}
+
+func TestBackupDecoder_StreamsRecords(t *testing.T) {
+	doc := `{"version":"1.2.0","extra":{"ignored":[1,2]},"data":[
+		{"id":"a","content":"x"},
+		{"id":"b","difficulty":"hard"},
+		{"id":"c","content":"z"}
+	],"exportedAt":42}`
+	dec := NewBackupDecoder(strings.NewReader(doc))
+
+	var ids []string
+	var recErr *RecordError
+	for {
+		q, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if errors.As(err, &recErr) {
+			ids = append(ids, "!"+recErr.ID)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		ids = append(ids, q.ID)
+	}
+	if strings.Join(ids, ",") != "a,!b,c" || recErr.Index != 1 {
+		t.Fatalf("unexpected records %v (last error %+v)", ids, recErr)
+	}
+	if dec.Version != "1.2.0" || dec.ExportedAt != 42 {
+		t.Fatalf("header not read: version=%q exportedAt=%d", dec.Version, dec.ExportedAt)
+	}
+
+	trunc := NewBackupDecoder(strings.NewReader(`{"data":[{"id":"a"},`))
+	if _, err := trunc.Next(); err != nil {
+		t.Fatalf("first record should decode before the truncation: %v", err)
+	}
+	if _, err := trunc.Next(); !errors.Is(err, ErrInvalidBackup) {
+		t.Fatalf("expected ErrInvalidBackup for a truncated document, got %v", err)
+	}
+}
+
+func TestExportBackupJSON_RoundTrip(t *testing.T) {
+	src := &DB{newTestDB(t)}
+	now := time.Now()
+	parent := backupQuestion("p", "parent", now)
+	child := backupQuestion("c", "child", now)
+	child.ParentID = stringPtr("p")
+	for _, q := range []*models.Question{&parent, &child} {
+		if err := src.CreateQuestion(testOwner, q); err != nil {
+			t.Fatalf("CreateQuestion: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	count, err := src.ExportBackupJSON(testOwner, &buf)
+	if err != nil || count != 2 {
+		t.Fatalf("ExportBackupJSON = %d, %v", count, err)
+	}
+	var doc models.BackupData
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil || doc.Version != CurrentBackupVersion || len(doc.Data) != 2 {
+		t.Fatalf("export is not a valid backup document: %v", err)
+	}
+
+	// Append re-keys both rows; the child must follow its parent's new ID.
+	dst := &DB{newTestDB(t)}
+	report, err := dst.ImportQuestionStream(testOwner, NewBackupDecoder(&buf), ImportOptions{Mode: ImportAppend}, nil)
+	if err != nil || report.Inserted != 2 || report.Total != 2 {
+		t.Fatalf("import = %+v, %v", report, err)
+	}
+	all, _ := dst.GetQuestions(testOwner)
+	byContent := map[string]models.Question{}
+	for _, q := range all {
+		byContent[q.Content] = q
+	}
+	newParent, newChild := byContent["parent"], byContent["child"]
+	if newParent.ID == "p" || newChild.ParentID == nil || *newChild.ParentID != newParent.ID {
+		t.Fatalf("parent link not relinked: parent=%s child.parentId=%v", newParent.ID, newChild.ParentID)
+	}
+}
+
+// hookWriter runs hook on the first write it gets, which comes while the
+// export is still reading rows.
+type hookWriter struct {
+	hook func() error
+	err  error
+	hit  bool
+}
+
+func (w *hookWriter) Write(p []byte) (int, error) {
+	if !w.hit {
+		w.hit = true
+		w.err = w.hook()
+	}
+	return len(p), nil
+}
+
+func TestExport_DoesNotBlockWriters(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	// Large enough that the export writes before it has read every row.
+	for _, id := range []string{"q1", "q2", "q3"} {
+		q := backupQuestion(id, strings.Repeat(id, 40<<10), time.Now())
+		if err := db.CreateQuestion(testOwner, &q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, export := range map[string]func(string, io.Writer) (int, error){
+		"json": db.ExportBackupJSON,
+		"zip":  db.ExportBackupZip,
+	} {
+		w := &hookWriter{hook: func() error {
+			return db.Model(&models.Question{}).Where("id = ?", "q3").Update("analysis", "edited during "+name).Error
+		}}
+		if count, err := export(testOwner, w); err != nil || count != 3 {
+			t.Fatalf("%s export = %d, %v", name, count, err)
+		}
+		if !w.hit || w.err != nil {
+			t.Fatalf("write during the %s export: %v", name, w.err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backup schema versions. A version names the shape of the question records
+// in a backup, not the app release that wrote it.
+const (
+	// BackupV1_0 is the frontend's localStorage export: options and
+	// knowledgePoints as arrays, timestamps in epoch milliseconds, and no
+	// learningGuide on the oldest files. Files without a version are read
+	// as this.
+	BackupV1_0 = "1.0.0"
+	// BackupV1_2 is the first backend export (models.BackupData). The API
+	// docs show it with frontend-shaped records, so those are accepted too.
+	BackupV1_2 = "1.2.0"
+	// BackupV1_3 adds updatedAt, nextReviewAt, reviewStreak, parentId and
+	// aiGenerated.
+	BackupV1_3 = "1.3.0"
+
+	CurrentBackupVersion = BackupV1_3
+)
+
+var ErrUnsupportedBackupVersion = errors.New("unsupported backup version")
+
+// backupUpgrades converts one record from one version to the next. Steps run
+// in order, starting at the file's version, until the record is current.
+var backupUpgrades = []struct {
+	from    string
+	to      string
+	convert func(rec map[string]interface{}) error
+}{
+	{BackupV1_0, BackupV1_2, upgradeFrontendRecord},
+	{BackupV1_2, BackupV1_3, upgradeV1_2Record},
+}
+
+// ResolveBackupVersion maps the version found in a backup to the known
+// version its records are read as. Versions newer than CurrentBackupVersion
+// are rejected; unknown older versions are read as the closest known version
+// below them.
+func ResolveBackupVersion(version string) (string, error) {
+	if version == "" {
+		return BackupV1_0, nil
+	}
+	v, err := parseBackupVersion(version)
+	if err != nil {
+		return "", fmt.Errorf("%w %q", ErrUnsupportedBackupVersion, version)
+	}
+	current, _ := parseBackupVersion(CurrentBackupVersion)
+	if compareBackupVersions(v, current) > 0 {
+		return "", fmt.Errorf("%w: backup version %s is newer than this server supports (%s)",
+			ErrUnsupportedBackupVersion, version, CurrentBackupVersion)
+	}
+
+	resolved := BackupV1_0
+	for _, step := range backupUpgrades {
+		known, _ := parseBackupVersion(step.to)
+		if compareBackupVersions(known, v) <= 0 {
+			resolved = step.to
+		}
+	}
+	return resolved, nil
+}
+
+// upgradeRecord runs the converter chain on one raw record. from must come
+// from ResolveBackupVersion.
+func upgradeRecord(raw []byte, from string) ([]byte, error) {
+	if from == CurrentBackupVersion {
+		return raw, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var rec map[string]interface{}
+	if err := dec.Decode(&rec); err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("record is not an object")
+	}
+
+	version := from
+	for _, step := range backupUpgrades {
+		if step.from != version {
+			continue
+		}
+		if err := step.convert(rec); err != nil {
+			return nil, fmt.Errorf("upgrading from %s: %w", step.from, err)
+		}
+		version = step.to
+	}
+	if version != CurrentBackupVersion {
+		return nil, fmt.Errorf("no upgrade path from %s", from)
+	}
+	return json.Marshal(rec)
+}
+
+// upgradeFrontendRecord turns a frontend Question into the backend shape.
+// Records that already have the backend shape pass through unchanged.
+func upgradeFrontendRecord(rec map[string]interface{}) error {
+	for _, field := range []string{"options", "knowledgePoints"} {
+		if list, ok := rec[field].([]interface{}); ok {
+			encoded, err := json.Marshal(list)
+			if err != nil {
+				return fmt.Errorf("%s: %w", field, err)
+			}
+			rec[field] = string(encoded)
+		}
+	}
+	if rec["knowledgePoints"] == nil {
+		rec["knowledgePoints"] = "[]"
+	}
+	for _, field := range []string{"analysis", "learningGuide"} {
+		if rec[field] == nil {
+			rec[field] = ""
+		}
+	}
+	for _, field := range []string{"createdAt", "lastReviewedAt", "deletedAt"} {
+		ms, ok := rec[field].(json.Number)
+		if !ok {
+			continue
+		}
+		n, err := ms.Int64()
+		if err != nil {
+			return fmt.Errorf("%s is not an epoch timestamp", field)
+		}
+		rec[field] = time.UnixMilli(n).UTC().Format(time.RFC3339Nano)
+	}
+	return nil
+}
+
+// upgradeV1_2Record fills the fields 1.3.0 added. updatedAt starts at
+// createdAt so keep-newer merges treat the record as never edited.
+func upgradeV1_2Record(rec map[string]interface{}) error {
+	if err := upgradeFrontendRecord(rec); err != nil {
+		return err
+	}
+	if rec["updatedAt"] == nil {
+		if created, ok := rec["createdAt"]; ok {
+			rec["updatedAt"] = created
+		}
+	}
+	if rec["reviewStreak"] == nil {
+		rec["reviewStreak"] = 0
+	}
+	if rec["aiGenerated"] == nil {
+		rec["aiGenerated"] = false
+	}
+	return nil
+}
+
+func parseBackupVersion(s string) ([3]int, error) {
+	var v [3]int
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return v, fmt.Errorf("bad version %q", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("bad version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func compareBackupVersions(a [3]int, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"E-Bu-backend/models"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite testdata/backups/*.golden.json")
+
+// TestBackupVersions_Golden decodes one backup per historical version and
+// compares the upgraded records with testdata/backups/<name>.golden.json.
+// Run with -update after an intentional change to the current shape.
+func TestBackupVersions_Golden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "backups", "v*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range inputs {
+		if strings.HasSuffix(input, ".golden.json") {
+			continue
+		}
+		t.Run(filepath.Base(input), func(t *testing.T) {
+			f, err := os.Open(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			dec := NewBackupDecoder(f)
+			var questions []models.Question
+			for {
+				q, err := dec.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Next: %v", err)
+				}
+				if reasons := ValidateQuestion(q); len(reasons) > 0 {
+					t.Fatalf("upgraded record %s is invalid: %v", q.ID, reasons)
+				}
+				questions = append(questions, *q)
+			}
+
+			got, err := json.MarshalIndent(questions, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+			golden := strings.TrimSuffix(input, ".json") + ".golden.json"
+			if *updateGolden {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("missing golden file (run with -update): %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("upgraded records differ from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestResolveBackupVersion(t *testing.T) {
+	cases := map[string]string{
+		"":      BackupV1_0,
+		"1.0.0": BackupV1_0,
+		"1.1.0": BackupV1_0,
+		"1.2.0": BackupV1_2,
+		"1.2.5": BackupV1_2,
+		"1.3":   BackupV1_3,
+		"1.3.0": BackupV1_3,
+	}
+	for in, want := range cases {
+		got, err := ResolveBackupVersion(in)
+		if err != nil || got != want {
+			t.Errorf("ResolveBackupVersion(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"1.3.1", "2.0.0", "latest"} {
+		if _, err := ResolveBackupVersion(in); !errors.Is(err, ErrUnsupportedBackupVersion) {
+			t.Errorf("ResolveBackupVersion(%q) should be rejected, got %v", in, err)
+		}
+	}
+
+	dec := NewBackupDecoder(strings.NewReader(`{"version":"9.0.0","data":[{"id":"a"}]}`))
+	if _, err := dec.Next(); !errors.Is(err, ErrUnsupportedBackupVersion) {
+		t.Fatalf("newer backup should be rejected before any record, got %v", err)
+	}
+}
@@ -0,0 +1,428 @@
+package database
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// AppVersion is recorded in ZIP manifests; keep it in sync with package.json.
+const AppVersion = "1.3.0"
+
+// ZIP backup layout.
+const (
+	ZipFormatName     = "ebu-backup"
+	ZipFormatVersion  = 1
+	zipManifestFile   = "manifest.json"
+	zipQuestionsFile  = "questions.jsonl"
+	zipImageDir       = "images/"
+	ZipBackupFileName = "E-Bu_backup.ebu.zip"
+)
+
+// maxZipImageBytes caps the size of one image in an archive, so a small
+// upload cannot unpack into a huge allocation.
+var maxZipImageBytes int64 = 32 << 20
+
+// zipMagic starts every ZIP archive; used to tell the two import formats
+// apart.
+var zipMagic = []byte("PK\x03\x04")
+
+// IsZipBackup reports whether the first bytes of an upload look like a ZIP
+// archive rather than a JSON document.
+func IsZipBackup(head []byte) bool {
+	return bytes.HasPrefix(head, zipMagic)
+}
+
+type ZipManifest struct {
+	Format        string `json:"format"`
+	FormatVersion int    `json:"formatVersion"`
+	AppVersion    string `json:"appVersion"`
+	// BackupVersion is the record shape of questions.jsonl, see
+	// CurrentBackupVersion. Archives written before it existed hold
+	// BackupV1_2 records.
+	BackupVersion string `json:"backupVersion,omitempty"`
+	// SchemaVersion is the database migration version of the exporting
+	// server.
+	SchemaVersion int   `json:"schemaVersion"`
+	ExportedAt    int64 `json:"exportedAt"`
+	QuestionCount int   `json:"questionCount"`
+	// Files lists every other entry of the archive with its checksum.
+	Files map[string]ZipFileInfo `json:"files"`
+}
+
+type ZipFileInfo struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// MediaType is set for images so the original data URL can be rebuilt.
+	MediaType string `json:"mediaType,omitempty"`
+}
+
+// ExportBackupZip streams every question of ownerID as a .ebu.zip archive:
+// images are stored once each under images/<sha256>.<ext> and
+// questions.jsonl refers to them by path. The manifest is written last, once
+// all checksums are known.
+func (db *DB) ExportBackupZip(ownerID string, w io.Writer) (int, error) {
+	// Rows are read twice, once for questions.jsonl and once for the
+	// images; one read transaction makes both passes see the same rows, so
+	// every image the questions refer to is in the archive. In WAL mode
+	// (see Open) that transaction does not hold up writers, so it may stay
+	// open while a slow client downloads the archive.
+	count := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		count, err = (&DB{tx}).writeZipBackup(ownerID, w)
+		return err
+	})
+	return count, err
+}
+
+func (db *DB) writeZipBackup(ownerID string, w io.Writer) (int, error) {
+	rows, err := db.Model(&models.Question{}).Scopes(ownedBy(ownerID)).Order("created_at ASC").Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	zw := zip.NewWriter(w)
+	manifest := ZipManifest{
+		Format:        ZipFormatName,
+		FormatVersion: ZipFormatVersion,
+		AppVersion:    AppVersion,
+		BackupVersion: CurrentBackupVersion,
+		SchemaVersion: LatestMigrationVersion(),
+		ExportedAt:    time.Now().Unix(),
+		Files:         map[string]ZipFileInfo{},
+	}
+
+	// Neither pass holds the rows in memory.
+	entry, err := zw.Create(zipQuestionsFile)
+	if err != nil {
+		return 0, err
+	}
+	sum := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(entry, sum)}
+	bw := bufio.NewWriterSize(counter, 64*1024)
+
+	count := 0
+	for rows.Next() {
+		var q models.Question
+		if err := db.ScanRows(rows, &q); err != nil {
+			return count, err
+		}
+		q.Image = imageRef(q.Image)
+		q.CroppedDiagram = imageRef(q.CroppedDiagram)
+		line, err := json.Marshal(q)
+		if err != nil {
+			return count, err
+		}
+		if _, err := bw.Write(append(line, '\n')); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	if err := bw.Flush(); err != nil {
+		return count, err
+	}
+	rows.Close()
+	manifest.QuestionCount = count
+	manifest.Files[zipQuestionsFile] = ZipFileInfo{SHA256: hex.EncodeToString(sum.Sum(nil)), Size: counter.n}
+
+	if err := db.writeZipImages(ownerID, zw, manifest.Files); err != nil {
+		return count, err
+	}
+
+	entry, err = zw.Create(zipManifestFile)
+	if err != nil {
+		return count, err
+	}
+	enc := json.NewEncoder(entry)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return count, err
+	}
+	return count, zw.Close()
+}
+
+// writeZipImages adds every distinct image to the archive, reading one row
+// at a time.
+func (db *DB) writeZipImages(ownerID string, zw *zip.Writer, files map[string]ZipFileInfo) error {
+	rows, err := db.Model(&models.Question{}).Scopes(ownedBy(ownerID)).Select("image", "cropped_diagram").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var image, cropped *string
+		if err := rows.Scan(&image, &cropped); err != nil {
+			return err
+		}
+		for _, dataURL := range []*string{image, cropped} {
+			if dataURL == nil || *dataURL == "" {
+				continue
+			}
+			mediaType, data, err := decodeImageDataURL(*dataURL)
+			if err != nil {
+				continue // kept inline by imageRef
+			}
+			digest := sha256.Sum256(data)
+			name := imagePath(hex.EncodeToString(digest[:]), mediaType)
+			if _, done := files[name]; done {
+				continue
+			}
+			entry, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+			if err != nil {
+				return err
+			}
+			if _, err := entry.Write(data); err != nil {
+				return err
+			}
+			files[name] = ZipFileInfo{SHA256: hex.EncodeToString(digest[:]), Size: int64(len(data)), MediaType: mediaType}
+		}
+	}
+	return rows.Err()
+}
+
+// imageRef replaces a data URL by the archive path of its image. Values that
+// are not valid data URLs stay inline so a bad row does not break the export.
+func imageRef(dataURL *string) *string {
+	if dataURL == nil || *dataURL == "" {
+		return dataURL
+	}
+	mediaType, data, err := decodeImageDataURL(*dataURL)
+	if err != nil {
+		return dataURL
+	}
+	digest := sha256.Sum256(data)
+	ref := imagePath(hex.EncodeToString(digest[:]), mediaType)
+	return &ref
+}
+
+func imagePath(digest string, mediaType string) string {
+	ext := "bin"
+	switch strings.ToLower(strings.SplitN(mediaType, ";", 2)[0]) {
+	case "image/png":
+		ext = "png"
+	case "image/jpeg", "image/jpg":
+		ext = "jpg"
+	case "image/gif":
+		ext = "gif"
+	case "image/webp":
+		ext = "webp"
+	case "image/svg+xml":
+		ext = "svg"
+	}
+	return zipImageDir + digest + "." + ext
+}
+
+// decodeImageDataURL splits "data:<mediaType>;base64,<payload>". The media
+// type keeps any parameters so the URL can be rebuilt byte for byte.
+func decodeImageDataURL(s string) (string, []byte, error) {
+	if err := checkImageDataURL(s); err != nil {
+		return "", nil, err
+	}
+	comma := strings.Index(s, ",")
+	mediaType := strings.TrimSuffix(strings.TrimPrefix(s[:comma], "data:"), ";base64")
+	data, err := base64.StdEncoding.DecodeString(s[comma+1:])
+	return mediaType, data, err
+}
+
+// ZipBackupReader reads a .ebu.zip archive as a QuestionSource, verifying
+// every file against the manifest checksums.
+type ZipBackupReader struct {
+	Manifest ZipManifest
+
+	zr      *zip.Reader
+	from    string
+	file    io.ReadCloser
+	sum     hash.Hash
+	lines   *bufio.Reader
+	index   int
+	checked map[string]bool
+}
+
+// OpenZipBackup reads the manifest and prepares questions.jsonl for
+// streaming. r must stay open until the import is done.
+func OpenZipBackup(r io.ReaderAt, size int64) (*ZipBackupReader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+	b := &ZipBackupReader{zr: zr, checked: map[string]bool{}}
+
+	mf, err := zr.Open(zipManifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalidBackup, zipManifestFile)
+	}
+	err = json.NewDecoder(mf).Decode(&b.Manifest)
+	mf.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidBackup, zipManifestFile, err)
+	}
+	if b.Manifest.Format != ZipFormatName {
+		return nil, fmt.Errorf("%w: not an E-Bu backup archive", ErrInvalidBackup)
+	}
+	if b.Manifest.FormatVersion > ZipFormatVersion {
+		return nil, fmt.Errorf("%w: archive format %d is newer than this server supports (%d)",
+			ErrInvalidBackup, b.Manifest.FormatVersion, ZipFormatVersion)
+	}
+	version := b.Manifest.BackupVersion
+	if version == "" {
+		version = BackupV1_2
+	}
+	if b.from, err = ResolveBackupVersion(version); err != nil {
+		return nil, err
+	}
+	if _, ok := b.Manifest.Files[zipQuestionsFile]; !ok {
+		return nil, fmt.Errorf("%w: manifest does not list %s", ErrInvalidBackup, zipQuestionsFile)
+	}
+
+	b.file, err = zr.Open(zipQuestionsFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalidBackup, zipQuestionsFile)
+	}
+	b.sum = sha256.New()
+	b.lines = bufio.NewReaderSize(io.TeeReader(b.file, b.sum), 64*1024)
+	return b, nil
+}
+
+// OpenBackupFile opens a backup of either format from disk. The returned
+// close function releases the file.
+func OpenBackupFile(name string) (QuestionSource, func() error, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	head := make([]byte, len(zipMagic))
+	n, _ := io.ReadFull(f, head)
+	if !IsZipBackup(head[:n]) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return NewBackupDecoder(bufio.NewReader(f)), f.Close, nil
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	archive, err := OpenZipBackup(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return archive, func() error {
+		archive.Close()
+		return f.Close()
+	}, nil
+}
+
+func (b *ZipBackupReader) Close() error {
+	return b.file.Close()
+}
+
+func (b *ZipBackupReader) Next() (*models.Question, error) {
+	for {
+		line, err := b.lines.ReadBytes('\n')
+		if err == io.EOF && len(bytes.TrimSpace(line)) == 0 {
+			return nil, b.verifyQuestions()
+		}
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		index := b.index
+		b.index++
+
+		q, err := decodeBackupRecord(line, b.from, index)
+		if err != nil {
+			return nil, err
+		}
+		if q.Image, err = b.resolveImage(q.Image); err != nil {
+			return nil, &RecordError{Index: index, ID: q.ID, Err: fmt.Errorf("image %w", err)}
+		}
+		if q.CroppedDiagram, err = b.resolveImage(q.CroppedDiagram); err != nil {
+			return nil, &RecordError{Index: index, ID: q.ID, Err: fmt.Errorf("croppedDiagram %w", err)}
+		}
+		return q, nil
+	}
+}
+
+// verifyQuestions runs once questions.jsonl has been read to the end.
+func (b *ZipBackupReader) verifyQuestions() error {
+	want := b.Manifest.Files[zipQuestionsFile].SHA256
+	if got := hex.EncodeToString(b.sum.Sum(nil)); want != "" && got != want {
+		return fmt.Errorf("%w: %s checksum mismatch", ErrInvalidBackup, zipQuestionsFile)
+	}
+	return io.EOF
+}
+
+// resolveImage turns an archive path back into a data URL. Values that are
+// not archive paths (for instance data URLs written by hand) pass through.
+func (b *ZipBackupReader) resolveImage(ref *string) (*string, error) {
+	if ref == nil || !strings.HasPrefix(*ref, zipImageDir) {
+		return ref, nil
+	}
+	name := path.Clean(*ref)
+	info, ok := b.Manifest.Files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is not listed in the manifest", name)
+	}
+	if info.Size > maxZipImageBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, maxZipImageBytes)
+	}
+	f, err := b.zr.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s is missing from the archive", name)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxZipImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxZipImageBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, maxZipImageBytes)
+	}
+	if !b.checked[name] {
+		digest := sha256.Sum256(data)
+		if hex.EncodeToString(digest[:]) != info.SHA256 {
+			return nil, fmt.Errorf("%s checksum mismatch", name)
+		}
+		b.checked[name] = true
+	}
+	dataURL := "data:" + info.MediaType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	return &dataURL, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
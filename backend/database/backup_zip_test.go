@@ -0,0 +1,248 @@
+package database
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"E-Bu-backend/models"
+)
+
+func TestZipBackup_RoundTrip(t *testing.T) {
+	src := &DB{newTestDB(t)}
+	now := time.Now()
+	photo := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString([]byte("jpeg bytes"))
+	broken := "not a data url"
+
+	a := backupQuestion("a", "a", now)
+	a.Image = &photo
+	b := backupQuestion("b", "b", now)
+	b.Image = &photo // same photo, stored once
+	b.CroppedDiagram = &broken
+	for _, q := range []*models.Question{&a, &b} {
+		if err := src.CreateQuestion(testOwner, q); err != nil {
+			t.Fatalf("CreateQuestion: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if count, err := src.ExportBackupZip(testOwner, &buf); err != nil || count != 2 {
+		t.Fatalf("ExportBackupZip = %d, %v", count, err)
+	}
+	if !IsZipBackup(buf.Bytes()) {
+		t.Fatalf("export does not start with the ZIP magic")
+	}
+
+	archive, err := OpenZipBackup(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenZipBackup: %v", err)
+	}
+	defer archive.Close()
+	m := archive.Manifest
+	if m.FormatVersion != ZipFormatVersion || m.SchemaVersion != LatestMigrationVersion() || m.QuestionCount != 2 {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+	images := 0
+	for name, info := range m.Files {
+		if strings.HasPrefix(name, zipImageDir) {
+			images++
+			if !strings.HasSuffix(name, ".jpg") || info.MediaType != "image/jpeg" {
+				t.Fatalf("unexpected image entry %s: %+v", name, info)
+			}
+		}
+	}
+	if images != 1 {
+		t.Fatalf("expected the shared photo once, got %d images", images)
+	}
+
+	dst := &DB{newTestDB(t)}
+	// The broken image survives the export inline, and import validation
+	// still rejects it.
+	report, err := dst.ImportQuestionStream(testOwner, archive, ImportOptions{Mode: ImportMerge, OnError: OnErrorSkip}, nil)
+	if err != nil || report.Inserted != 1 || report.Failed != 1 || report.Errors[0].ID != "b" {
+		t.Fatalf("import = %+v, %v", report, err)
+	}
+	gotA, _ := dst.GetQuestionByID(testOwner, "a")
+	if gotA.Image == nil || *gotA.Image != photo {
+		t.Fatalf("image did not round-trip")
+	}
+}
+
+func TestZipBackup_ConsistentWhileWriting(t *testing.T) {
+	src := &DB{newTestDB(t)}
+	before := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString([]byte("before"))
+	after := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString([]byte("after"))
+	q := backupQuestion("a", strings.Repeat("a", 64<<10), time.Now())
+	q.Image = &before
+	if err := src.CreateQuestion(testOwner, &q); err != nil {
+		t.Fatal(err)
+	}
+
+	// The image changes while the archive is being sent; the export neither
+	// blocks the write nor mixes the two versions.
+	var buf bytes.Buffer
+	w := &hookWriter{hook: func() error {
+		return src.Model(&models.Question{}).Where("id = ?", "a").Update("image", after).Error
+	}}
+	if _, err := src.ExportBackupZip(testOwner, io.MultiWriter(&buf, w)); err != nil {
+		t.Fatalf("ExportBackupZip: %v", err)
+	}
+	if !w.hit || w.err != nil {
+		t.Fatalf("write during the export: %v", w.err)
+	}
+	archive, err := OpenZipBackup(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenZipBackup: %v", err)
+	}
+	defer archive.Close()
+	dst := &DB{newTestDB(t)}
+	if report, err := dst.ImportQuestionStream(testOwner, archive, ImportOptions{}, nil); err != nil || report.Inserted != 1 {
+		t.Fatalf("import = %+v, %v", report, err)
+	}
+	if got, _ := dst.GetQuestionByID(testOwner, "a"); got.Image == nil || *got.Image != before {
+		t.Fatalf("archive does not hold the image as of the export")
+	}
+}
+
+func TestZipBackup_RejectsTampering(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	q := backupQuestion("a", "a", time.Now())
+	if err := db.CreateQuestion(testOwner, &q); err != nil {
+		t.Fatalf("CreateQuestion: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := db.ExportBackupZip(testOwner, &buf); err != nil {
+		t.Fatalf("ExportBackupZip: %v", err)
+	}
+
+	// Rewrite the archive with an edited questions.jsonl but the original
+	// manifest.
+	zr, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	var tampered bytes.Buffer
+	zw := zip.NewWriter(&tampered)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if f.Name == zipQuestionsFile {
+			data = bytes.Replace(data, []byte(`"content":"a"`), []byte(`"content":"b"`), 1)
+		}
+		w, _ := zw.Create(f.Name)
+		w.Write(data)
+	}
+	zw.Close()
+
+	archive, err := OpenZipBackup(bytes.NewReader(tampered.Bytes()), int64(tampered.Len()))
+	if err != nil {
+		t.Fatalf("OpenZipBackup: %v", err)
+	}
+	defer archive.Close()
+	dst := &DB{newTestDB(t)}
+	if _, err := dst.ImportQuestionStream(testOwner, archive, ImportOptions{}, nil); !errors.Is(err, ErrInvalidBackup) {
+		t.Fatalf("expected checksum failure, got %v", err)
+	}
+	if all, _ := dst.GetQuestions(testOwner); len(all) != 0 {
+		t.Fatalf("tampered archive left %d rows behind", len(all))
+	}
+}
+
+func TestZipBackup_CapsImageSize(t *testing.T) {
+	defer func(limit int64) { maxZipImageBytes = limit }(maxZipImageBytes)
+	src := &DB{newTestDB(t)}
+	photo := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("ten bytes!"))
+	q := backupQuestion("a", "a", time.Now())
+	q.Image = &photo
+	if err := src.CreateQuestion(testOwner, &q); err != nil {
+		t.Fatalf("CreateQuestion: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := src.ExportBackupZip(testOwner, &buf); err != nil {
+		t.Fatalf("ExportBackupZip: %v", err)
+	}
+
+	// The manifest claims a small image, so only the read itself can tell.
+	zr, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	var lying bytes.Buffer
+	zw := zip.NewWriter(&lying)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if f.Name == zipManifestFile {
+			data = bytes.Replace(data, []byte(`"size": 10,`), []byte(`"size": 1,`), 1)
+		}
+		w, _ := zw.Create(f.Name)
+		w.Write(data)
+	}
+	zw.Close()
+
+	maxZipImageBytes = 4
+	for name, archive := range map[string][]byte{"manifest size": buf.Bytes(), "entry size": lying.Bytes()} {
+		backup, err := OpenZipBackup(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			t.Fatalf("%s: OpenZipBackup: %v", name, err)
+		}
+		report, err := (&DB{newTestDB(t)}).ImportQuestionStream(testOwner, backup, ImportOptions{OnError: OnErrorSkip}, nil)
+		backup.Close()
+		if err != nil || report.Failed != 1 || !strings.Contains(report.Errors[0].Reason, "larger than 4 bytes") {
+			t.Fatalf("%s: import = %+v, %v", name, report, err)
+		}
+	}
+}
+
+func TestOpenBackupFile_DetectsFormat(t *testing.T) {
+	src := &DB{newTestDB(t)}
+	for _, id := range []string{"a", "b"} {
+		q := backupQuestion(id, id, time.Now())
+		if err := src.CreateQuestion(testOwner, &q); err != nil {
+			t.Fatalf("CreateQuestion: %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	exports := map[string]func(string, io.Writer) (int, error){
+		"backup.json":    src.ExportBackupJSON,
+		"backup.ebu.zip": src.ExportBackupZip,
+	}
+	for name, export := range exports {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := export(testOwner, f); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		f.Close()
+
+		source, closeSource, err := OpenBackupFile(path)
+		if err != nil {
+			t.Fatalf("OpenBackupFile(%s): %v", name, err)
+		}
+		count := 0
+		for {
+			_, err := source.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			count++
+		}
+		if err := closeSource(); err != nil || count != 2 {
+			t.Fatalf("%s: read %d questions, close error %v", name, count, err)
+		}
+	}
+
+	if _, _, err := OpenBackupFile(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing file error = %v", err)
+	}
+}
@@ -0,0 +1,361 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Bulk operations on questions.
+const (
+	BulkDelete        = "delete"
+	BulkRestore       = "restore"
+	BulkHardDelete    = "hardDelete"
+	BulkSetSubject    = "setSubject"
+	BulkSetDifficulty = "setDifficulty"
+	BulkAddTags       = "addTags"
+	BulkRemoveTags    = "removeTags"
+)
+
+// What happened to each question of a bulk operation.
+const (
+	BulkDone     = "done"
+	BulkNoChange = "unchanged"
+	BulkNotFound = "not_found"
+	// BulkUnconfirmed marks the questions a hard delete would remove, when
+	// it was not confirmed.
+	BulkUnconfirmed = "unconfirmed"
+)
+
+// maxBulkQuestions is how many questions one bulk operation may touch.
+const maxBulkQuestions = 1000
+
+// ErrInvalidBulk wraps what is wrong with a bulk operation request.
+var ErrInvalidBulk = errors.New("invalid bulk operation")
+
+// ErrBulkUnconfirmed is returned for a bulk hard delete without the
+// confirmation token of the questions it matches. Nothing is deleted; the
+// result carries the token.
+var ErrBulkUnconfirmed = errors.New("bulk hard delete not confirmed")
+
+// BulkFilter selects questions like the question list does; with Trash it
+// selects from the trash instead.
+type BulkFilter struct {
+	Tag     string `json:"tag"`
+	Query   string `json:"q"`
+	Subject string `json:"subject"`
+	Trash   bool   `json:"trash"`
+}
+
+// BulkRequest is one operation on many questions, given by IDs or by a
+// filter. Subject, Difficulty and Tags are the operation's argument.
+type BulkRequest struct {
+	Operation  string
+	IDs        []string
+	Filter     *BulkFilter
+	Subject    models.Subject
+	Difficulty int
+	Tags       []string
+	// Confirm is the token a hard delete returns when it is not confirmed.
+	Confirm string
+}
+
+type BulkItemResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+type BulkResult struct {
+	Operation string           `json:"operation"`
+	Matched   int              `json:"matched"`
+	Changed   int              `json:"changed"`
+	Results   []BulkItemResult `json:"results"`
+	// ConfirmToken confirms a hard delete of exactly the matched questions.
+	ConfirmToken string `json:"confirmToken,omitempty"`
+}
+
+// BulkQuestions runs req over ownerID's questions in one transaction: if
+// anything fails, nothing is changed. Questions the owner does not have are
+// reported as not found; questions already in the requested state as
+// unchanged.
+func (db *DB) BulkQuestions(ownerID string, req BulkRequest) (*BulkResult, error) {
+	if err := checkBulkRequest(&req); err != nil {
+		return nil, err
+	}
+	result := &BulkResult{Operation: req.Operation, Results: []BulkItemResult{}}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		questions, err := bulkTargets(tx, ownerID, req)
+		if err != nil {
+			return err
+		}
+		result.Matched = len(questions)
+		ids := make([]string, len(questions))
+		for i, q := range questions {
+			ids[i] = q.ID
+		}
+		// Results follow the requested IDs, or the filter's order.
+		order := req.IDs
+		if req.Filter != nil {
+			order = ids
+		}
+
+		var changed []string
+		switch req.Operation {
+		case BulkHardDelete:
+			if len(ids) == 0 {
+				break
+			}
+			now := time.Now()
+			if !checkBulkConfirmToken(req.Confirm, ownerID, ids, now) {
+				result.ConfirmToken = bulkConfirmToken(ownerID, ids, now)
+				result.setAll(order, ids, nil, BulkUnconfirmed)
+				return ErrBulkUnconfirmed
+			}
+			if _, err := hardDeleteQuestions(tx, ids); err != nil {
+				return err
+			}
+			changed = ids
+		case BulkDelete, BulkRestore:
+			deleting := req.Operation == BulkDelete
+			for _, q := range questions {
+				if (q.DeletedAt == nil) == deleting {
+					changed = append(changed, q.ID)
+				}
+			}
+			if len(changed) > 0 {
+				var deletedAt interface{}
+				if deleting {
+					deletedAt = time.Now()
+				}
+				if err := tx.Model(&models.Question{}).Where("id IN ?", changed).
+					Updates(map[string]interface{}{"deleted_at": deletedAt, "version": gorm.Expr("version + 1")}).Error; err != nil {
+					return err
+				}
+			}
+		default:
+			txDB := &DB{tx}
+			for i := range questions {
+				q := &questions[i]
+				columns := bulkEdit(q, req)
+				if len(columns) == 0 {
+					continue
+				}
+				if err := txDB.PatchQuestion(ownerID, q.ID, q, columns); err != nil {
+					return err
+				}
+				changed = append(changed, q.ID)
+			}
+		}
+
+		result.Changed = len(changed)
+		result.setAll(order, ids, changed, BulkNoChange)
+		if action := bulkAuditAction(req.Operation); action != "" && len(changed) > 0 {
+			return recordAudit(tx, action, changed, map[string]interface{}{"bulk": true})
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrBulkUnconfirmed) {
+		return nil, err
+	}
+	return result, err
+}
+
+func checkBulkRequest(req *BulkRequest) error {
+	if (len(req.IDs) == 0) == (req.Filter == nil) {
+		return fmt.Errorf("%w: give either ids or a filter", ErrInvalidBulk)
+	}
+	req.IDs = uniqueStrings(req.IDs)
+	if len(req.IDs) > maxBulkQuestions {
+		return fmt.Errorf("%w: at most %d questions at a time", ErrInvalidBulk, maxBulkQuestions)
+	}
+	switch req.Operation {
+	case BulkDelete, BulkRestore, BulkHardDelete:
+	case BulkSetSubject:
+		if !validSubjects[req.Subject] {
+			return fmt.Errorf("%w: unknown subject %q", ErrInvalidBulk, req.Subject)
+		}
+	case BulkSetDifficulty:
+		if req.Difficulty < 1 || req.Difficulty > 5 {
+			return fmt.Errorf("%w: difficulty must be from 1 to 5", ErrInvalidBulk)
+		}
+	case BulkAddTags, BulkRemoveTags:
+		var tags []string
+		for _, tag := range req.Tags {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		if len(tags) == 0 {
+			return fmt.Errorf("%w: tags are required", ErrInvalidBulk)
+		}
+		req.Tags = uniqueStrings(tags)
+	default:
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidBulk, req.Operation)
+	}
+	return nil
+}
+
+// bulkTargets loads the questions req applies to. Requested IDs that are
+// not ownerID's are left out.
+func bulkTargets(tx *gorm.DB, ownerID string, req BulkRequest) ([]models.Question, error) {
+	var questions []models.Question
+	base := tx.Scopes(ownedBy(ownerID))
+	if req.Filter == nil {
+		err := base.Where("id IN ?", req.IDs).Find(&questions).Error
+		return questions, err
+	}
+
+	if req.Filter.Trash {
+		base = base.Where("deleted_at IS NOT NULL")
+	} else {
+		base = base.Where("deleted_at IS NULL")
+	}
+	base = applyQuestionFilters(base, req.Filter.Tag, req.Filter.Query, req.Filter.Subject)
+	if err := base.Order("created_at DESC").Limit(maxBulkQuestions + 1).Find(&questions).Error; err != nil {
+		return nil, err
+	}
+	if len(questions) > maxBulkQuestions {
+		return nil, fmt.Errorf("%w: the filter matches more than %d questions", ErrInvalidBulk, maxBulkQuestions)
+	}
+	return questions, nil
+}
+
+// bulkEdit applies an editing operation to q and returns the columns it
+// changed, none when q already is as requested.
+func bulkEdit(q *models.Question, req BulkRequest) []string {
+	switch req.Operation {
+	case BulkSetSubject:
+		if q.Subject != req.Subject {
+			q.Subject = req.Subject
+			return []string{"subject"}
+		}
+	case BulkSetDifficulty:
+		if q.Difficulty != req.Difficulty {
+			q.Difficulty = req.Difficulty
+			return []string{"difficulty"}
+		}
+	case BulkAddTags, BulkRemoveTags:
+		tags := decodeJSONList(q.KnowledgePoints)
+		has := make(map[string]bool, len(tags))
+		for _, tag := range tags {
+			has[tag] = true
+		}
+		var edited []string
+		if req.Operation == BulkAddTags {
+			edited = tags
+			for _, tag := range req.Tags {
+				if !has[tag] {
+					edited = append(edited, tag)
+				}
+			}
+		} else {
+			remove := make(map[string]bool, len(req.Tags))
+			for _, tag := range req.Tags {
+				remove[tag] = true
+			}
+			edited = []string{}
+			for _, tag := range tags {
+				if !remove[tag] {
+					edited = append(edited, tag)
+				}
+			}
+		}
+		if len(edited) != len(tags) {
+			encoded, _ := json.Marshal(edited)
+			kps := string(encoded)
+			q.KnowledgePoints = &kps
+			return []string{"knowledge_points"}
+		}
+	}
+	return nil
+}
+
+func bulkAuditAction(operation string) string {
+	switch operation {
+	case BulkDelete:
+		return AuditQuestionDelete
+	case BulkRestore:
+		return AuditQuestionRestore
+	case BulkHardDelete:
+		return AuditQuestionHardDelete
+	}
+	return ""
+}
+
+// bulkConfirmTTL is how long a hard delete confirmation token is accepted.
+const bulkConfirmTTL = 10 * time.Minute
+
+// bulkConfirmKey signs confirmation tokens. It is made at startup, so
+// tokens do not outlive the process that issued them.
+var bulkConfirmKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("database: generating the bulk confirmation key: %v", err))
+	}
+	return key
+}()
+
+// bulkConfirmToken is the confirmation for hard-deleting exactly ids, issued
+// at issued: the issue time followed by an HMAC over it, the owner and the
+// IDs, so it changes whenever the set of questions does and cannot be made
+// up by a client.
+func bulkConfirmToken(ownerID string, ids []string, issued time.Time) string {
+	ts := strconv.FormatInt(issued.Unix(), 36)
+	return ts + "." + bulkConfirmMAC(ts, ownerID, ids)
+}
+
+// checkBulkConfirmToken reports whether token confirms hard-deleting
+// exactly ids and was issued no longer than bulkConfirmTTL before now.
+func checkBulkConfirmToken(token string, ownerID string, ids []string, now time.Time) bool {
+	ts, mac, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(ts, 36, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age < 0 || age > bulkConfirmTTL {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(bulkConfirmMAC(ts, ownerID, ids)))
+}
+
+func bulkConfirmMAC(ts string, ownerID string, ids []string) string {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+	mac := hmac.New(sha256.New, bulkConfirmKey)
+	mac.Write([]byte(BulkHardDelete + "\n" + ts + "\n" + ownerID + "\n" + strings.Join(sorted, "\n")))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// setAll reports on every ID in order: the changed ones as done, the other
+// matched ones with status and the rest as not found.
+func (r *BulkResult) setAll(order []string, matched []string, changed []string, status string) {
+	statuses := make(map[string]string, len(matched))
+	for _, id := range matched {
+		statuses[id] = status
+	}
+	for _, id := range changed {
+		statuses[id] = BulkDone
+	}
+	for _, id := range order {
+		s, ok := statuses[id]
+		if !ok {
+			s = BulkNotFound
+		}
+		r.Results = append(r.Results, BulkItemResult{ID: id, Status: s})
+	}
+}
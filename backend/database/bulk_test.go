@@ -0,0 +1,175 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"E-Bu-backend/models"
+)
+
+func TestBulkQuestions(t *testing.T) {
+	base := &DB{newTestDB(t)}
+	db := base.As(Actor{UserID: "u1", Username: "alice"})
+	now := time.Now()
+	for i, id := range []string{"q1", "q2", "q3"} {
+		q := backupQuestion(id, "question "+id, now)
+		q.CreatedAt = now.Add(time.Duration(i) * time.Minute)
+		if err := db.CreateQuestion("u1", &q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := backupQuestion("theirs", "someone else's", now)
+	if err := db.CreateQuestion("u2", &other); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := func(result *BulkResult) map[string]string {
+		m := map[string]string{}
+		for _, r := range result.Results {
+			m[r.ID] = r.Status
+		}
+		return m
+	}
+
+	// IDs: other users' questions are not found, results keep the order.
+	result, err := db.BulkQuestions("u1", BulkRequest{Operation: BulkSetDifficulty, Difficulty: 4, IDs: []string{"q2", "theirs", "q1", "q2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Matched != 2 || result.Changed != 2 || len(result.Results) != 3 || result.Results[0].ID != "q2" || result.Results[1].Status != BulkNotFound {
+		t.Fatalf("set difficulty = %+v", result)
+	}
+	if q, _ := db.GetQuestionByID("u1", "q1"); q.Difficulty != 4 || q.Version != 2 {
+		t.Fatalf("q1 difficulty %d at version %d", q.Difficulty, q.Version)
+	}
+	if revisions, _ := db.ListRevisions("u1", "q1"); len(revisions) != 2 || revisions[1].AuthorName != "alice" {
+		t.Fatalf("bulk edit revisions = %+v", revisions)
+	}
+	if q, _ := db.GetQuestionByID("u2", "theirs"); q.Difficulty == 4 {
+		t.Fatalf("another user's question was changed")
+	}
+
+	// Tags through a filter; questions that already have the tag are unchanged.
+	if _, err := db.BulkQuestions("u1", BulkRequest{Operation: BulkAddTags, Tags: []string{"fractions"}, IDs: []string{"q1"}}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = db.BulkQuestions("u1", BulkRequest{Operation: BulkAddTags, Tags: []string{"fractions", " "}, Filter: &BulkFilter{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := statuses(result); result.Matched != 3 || s["q1"] != BulkNoChange || s["q3"] != BulkDone || result.Results[0].ID != "q3" {
+		t.Fatalf("add tags = %+v", result)
+	}
+	result, _ = db.BulkQuestions("u1", BulkRequest{Operation: BulkRemoveTags, Tags: []string{"fractions"}, Filter: &BulkFilter{Tag: "fractions", Query: "q2"}})
+	if result.Matched != 1 || result.Changed != 1 {
+		t.Fatalf("remove tags = %+v", result)
+	}
+	if q, _ := db.GetQuestionByID("u1", "q2"); !sameJSONList(q.KnowledgePoints, other.KnowledgePoints) {
+		t.Fatalf("q2 knowledge points = %s", *q.KnowledgePoints)
+	}
+
+	// Soft delete and restore are audited once per operation.
+	result, err = db.BulkQuestions("u1", BulkRequest{Operation: BulkDelete, IDs: []string{"q1", "q2"}})
+	if err != nil || result.Changed != 2 {
+		t.Fatalf("delete = %+v, %v", result, err)
+	}
+	result, _ = db.BulkQuestions("u1", BulkRequest{Operation: BulkRestore, Filter: &BulkFilter{Trash: true, Query: "q1"}})
+	if result.Changed != 1 {
+		t.Fatalf("restore = %+v", result)
+	}
+	deletes, _ := base.ListAuditEntries(AuditFilter{Action: AuditQuestionDelete}, 1, 50)
+	if deletes.Total != 1 || deletes.Items[0].TargetCount != 2 || deletes.Items[0].Details["bulk"] != true {
+		t.Fatalf("delete audit = %+v", deletes.Items)
+	}
+
+	// A hard delete needs the token for exactly the questions it matches.
+	trash := &BulkFilter{Trash: true}
+	result, err = db.BulkQuestions("u1", BulkRequest{Operation: BulkHardDelete, Filter: trash})
+	if !errors.Is(err, ErrBulkUnconfirmed) || result.ConfirmToken == "" || result.Matched != 1 || result.Results[0].Status != BulkUnconfirmed {
+		t.Fatalf("unconfirmed hard delete = %+v, %v", result, err)
+	}
+	token := result.ConfirmToken
+	if _, err := db.BulkQuestions("u1", BulkRequest{Operation: BulkDelete, IDs: []string{"q3"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.BulkQuestions("u1", BulkRequest{Operation: BulkHardDelete, Filter: trash, Confirm: token}); !errors.Is(err, ErrBulkUnconfirmed) {
+		t.Fatalf("stale token err = %v", err)
+	}
+	if q, _ := findQuestion(base.DB, "q2"); q == nil {
+		t.Fatalf("unconfirmed hard delete removed q2")
+	}
+	result, _ = db.BulkQuestions("u1", BulkRequest{Operation: BulkHardDelete, Filter: trash})
+	result, err = db.BulkQuestions("u1", BulkRequest{Operation: BulkHardDelete, Filter: trash, Confirm: result.ConfirmToken})
+	if err != nil || result.Changed != 2 {
+		t.Fatalf("confirmed hard delete = %+v, %v", result, err)
+	}
+	if left, _ := db.GetQuestions("u1"); len(left) != 1 || left[0].ID != "q1" {
+		t.Fatalf("questions left = %+v", left)
+	}
+}
+
+func TestBulkQuestions_ConfirmTokenMustBeIssued(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	q := backupQuestion("q1", "question", time.Now())
+	if err := db.CreateQuestion("u1", &q); err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{"q1"}
+
+	// The unkeyed hash a client could compute on its own, a token for
+	// another owner and one issued too long ago are all refused.
+	sum := sha256.Sum256([]byte(BulkHardDelete + "\nu1\nq1"))
+	for name, token := range map[string]string{
+		"client-built": hex.EncodeToString(sum[:16]),
+		"other owner":  bulkConfirmToken("u2", ids, time.Now()),
+		"expired":      bulkConfirmToken("u1", ids, time.Now().Add(-bulkConfirmTTL-time.Minute)),
+		"malformed":    "zz." + hex.EncodeToString(sum[:16]),
+	} {
+		if _, err := db.BulkQuestions("u1", BulkRequest{Operation: BulkHardDelete, IDs: ids, Confirm: token}); !errors.Is(err, ErrBulkUnconfirmed) {
+			t.Fatalf("%s token err = %v", name, err)
+		}
+	}
+	if q, _ := findQuestion(db.DB, "q1"); q == nil {
+		t.Fatalf("a refused token deleted q1")
+	}
+
+	result, err := db.BulkQuestions("u1", BulkRequest{Operation: BulkHardDelete, IDs: ids, Confirm: bulkConfirmToken("u1", ids, time.Now().Add(-bulkConfirmTTL+time.Minute))})
+	if err != nil || result.Changed != 1 {
+		t.Fatalf("hard delete with a recent token = %+v, %v", result, err)
+	}
+}
+
+func TestBulkQuestions_Invalid(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	q := backupQuestion("q1", "question", time.Now())
+	if err := db.CreateQuestion("u1", &q); err != nil {
+		t.Fatal(err)
+	}
+	tooMany := make([]string, maxBulkQuestions+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("q%d", i)
+	}
+	for name, req := range map[string]BulkRequest{
+		"no target":        {Operation: BulkDelete},
+		"ids and a filter": {Operation: BulkDelete, IDs: []string{"q1"}, Filter: &BulkFilter{}},
+		"unknown":          {Operation: "archive", IDs: []string{"q1"}},
+		"bad subject":      {Operation: BulkSetSubject, Subject: "Physics", IDs: []string{"q1"}},
+		"bad difficulty":   {Operation: BulkSetDifficulty, Difficulty: 9, IDs: []string{"q1"}},
+		"no tags":          {Operation: BulkAddTags, Tags: []string{" "}, IDs: []string{"q1"}},
+		"too many":         {Operation: BulkDelete, IDs: tooMany},
+	} {
+		if _, err := db.BulkQuestions("u1", req); !errors.Is(err, ErrInvalidBulk) {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+	if got, _ := db.GetQuestionByID("u1", "q1"); got.Version != 1 {
+		t.Fatalf("an invalid request changed the question")
+	}
+	if result, err := db.BulkQuestions("u1", BulkRequest{Operation: BulkSetSubject, Subject: models.Physics, IDs: []string{"q1"}}); err != nil || result.Changed != 1 {
+		t.Fatalf("set subject = %+v, %v", result, err)
+	}
+}
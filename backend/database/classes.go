@@ -0,0 +1,254 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotStudent = errors.New("only student accounts can be added")
+	ErrNotParent  = errors.New("only parent accounts can follow a student")
+)
+
+// Classes are managed by their teacher: every function that changes or lists
+// a class takes the teacher's ID and treats other teachers' classes as
+// missing.
+
+func (db *DB) CreateClass(teacherID string, name string) (*models.Class, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("class name is required")
+	}
+	class := &models.Class{ID: uuid.New().String(), Name: name, TeacherID: teacherID, CreatedAt: time.Now()}
+	if err := db.Create(class).Error; err != nil {
+		return nil, err
+	}
+	return class, nil
+}
+
+// ListTeacherClasses returns the classes teacherID teaches.
+func (db *DB) ListTeacherClasses(teacherID string) ([]models.Class, error) {
+	var classes []models.Class
+	err := db.Where("teacher_id = ?", teacherID).Order("name").Find(&classes).Error
+	return classes, err
+}
+
+// ListStudentClasses returns the classes studentID is in.
+func (db *DB) ListStudentClasses(studentID string) ([]models.Class, error) {
+	var classes []models.Class
+	err := db.Joins("JOIN class_members ON class_members.class_id = classes.id").
+		Where("class_members.student_id = ?", studentID).Order("classes.name").Find(&classes).Error
+	return classes, err
+}
+
+func (db *DB) GetClass(teacherID string, classID string) (*models.Class, error) {
+	var class models.Class
+	result := db.First(&class, "id = ? AND teacher_id = ?", classID, teacherID)
+	return &class, result.Error
+}
+
+// DeleteClass removes a class with its members and assignments. What the
+// students got from its assignments stays in their mistake books, and
+// collections published to the class become private.
+func (db *DB) DeleteClass(teacherID string, classID string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND teacher_id = ?", classID, teacherID).Delete(&models.Class{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return clearClass(tx, classID)
+	})
+}
+
+// clearClass removes what hangs off a deleted class: its assignments, its
+// members and the collections published to it.
+func clearClass(tx *gorm.DB, classID string) error {
+	assignments := tx.Model(&models.Assignment{}).Select("id").Where("class_id = ?", classID)
+	if err := tx.Where("assignment_id IN (?)", assignments).Delete(&models.AssignmentQuestion{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("assignment_id IN (?)", assignments).Delete(&models.AssignmentStudent{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("class_id = ?", classID).Delete(&models.Assignment{}).Error; err != nil {
+		return err
+	}
+	// Collections published to the class go back to their owners only.
+	if err := tx.Model(&models.Collection{}).Where("class_id = ?", classID).
+		Updates(map[string]interface{}{"visibility": models.VisibilityPrivate, "class_id": nil}).Error; err != nil {
+		return err
+	}
+	return tx.Where("class_id = ?", classID).Delete(&models.ClassMember{}).Error
+}
+
+// ListClassMembers returns the students in one of teacherID's classes.
+func (db *DB) ListClassMembers(teacherID string, classID string) ([]models.User, error) {
+	if _, err := db.GetClass(teacherID, classID); err != nil {
+		return nil, err
+	}
+	var users []models.User
+	err := db.Joins("JOIN class_members ON class_members.student_id = users.id").
+		Where("class_members.class_id = ?", classID).Order("users.username").Find(&users).Error
+	return users, err
+}
+
+// AddClassMember puts the student with the given username in one of
+// teacherID's classes and gives them the class's open assignments. Adding a
+// member twice is not an error.
+func (db *DB) AddClassMember(teacherID string, classID string, username string) (*models.User, error) {
+	if _, err := db.GetClass(teacherID, classID); err != nil {
+		return nil, err
+	}
+	student, err := db.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if student.Role != models.RoleStudent {
+		return nil, ErrNotStudent
+	}
+	member := models.ClassMember{ClassID: classID, StudentID: student.ID, CreatedAt: time.Now()}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&member).Error; err != nil {
+			return err
+		}
+		return assignOpenAssignments(tx, classID, student.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return student, nil
+}
+
+func (db *DB) RemoveClassMember(teacherID string, classID string, studentID string) error {
+	if _, err := db.GetClass(teacherID, classID); err != nil {
+		return err
+	}
+	result := db.Where("class_id = ? AND student_id = ?", classID, studentID).Delete(&models.ClassMember{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// LinkParent lets parentID follow studentID's progress.
+func (db *DB) LinkParent(parentID string, studentID string) error {
+	parent, err := db.GetUserByID(parentID)
+	if err != nil {
+		return err
+	}
+	if parent.Role != models.RoleParent {
+		return ErrNotParent
+	}
+	student, err := db.GetUserByID(studentID)
+	if err != nil {
+		return err
+	}
+	if student.Role != models.RoleStudent {
+		return ErrNotStudent
+	}
+	link := models.ParentLink{ParentID: parentID, StudentID: studentID, CreatedAt: time.Now()}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&link).Error
+}
+
+func (db *DB) UnlinkParent(parentID string, studentID string) error {
+	result := db.Where("parent_id = ? AND student_id = ?", parentID, studentID).Delete(&models.ParentLink{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListChildren returns the students parentID follows.
+func (db *DB) ListChildren(parentID string) ([]models.User, error) {
+	var users []models.User
+	err := db.Joins("JOIN parent_links ON parent_links.student_id = users.id").
+		Where("parent_links.parent_id = ?", parentID).Order("users.username").Find(&users).Error
+	return users, err
+}
+
+// TeachesStudent reports whether studentID is in one of teacherID's classes.
+func (db *DB) TeachesStudent(teacherID string, studentID string) (bool, error) {
+	var n int64
+	err := db.Model(&models.ClassMember{}).
+		Joins("JOIN classes ON classes.id = class_members.class_id").
+		Where("classes.teacher_id = ? AND class_members.student_id = ?", teacherID, studentID).
+		Count(&n).Error
+	return n > 0, err
+}
+
+// IsParentOf reports whether parentID follows studentID.
+func (db *DB) IsParentOf(parentID string, studentID string) (bool, error) {
+	var n int64
+	err := db.Model(&models.ParentLink{}).Where("parent_id = ? AND student_id = ?", parentID, studentID).Count(&n).Error
+	return n > 0, err
+}
+
+// StudentStats sums up a student's mistake book and review history.
+type StudentStats struct {
+	Questions      int64          `json:"questions"`
+	Trashed        int64          `json:"trashed"`
+	DueForReview   int64          `json:"dueForReview"`
+	Reviews        int64          `json:"reviews"`
+	CorrectReviews int64          `json:"correctReviews"`
+	LastReviewedAt *time.Time     `json:"lastReviewedAt,omitempty"`
+	BySubject      []SubjectCount `json:"bySubject"`
+}
+
+type SubjectCount struct {
+	Subject models.Subject `json:"subject"`
+	Count   int64          `json:"count"`
+}
+
+func (db *DB) GetStudentStats(studentID string) (*StudentStats, error) {
+	stats := &StudentStats{BySubject: []SubjectCount{}}
+	questions := func() *gorm.DB { return db.Model(&models.Question{}).Scopes(ownedBy(studentID)) }
+	if err := questions().Where("deleted_at IS NULL").Count(&stats.Questions).Error; err != nil {
+		return nil, err
+	}
+	if err := questions().Where("deleted_at IS NOT NULL").Count(&stats.Trashed).Error; err != nil {
+		return nil, err
+	}
+	// Same rule as a dueOnly practice session.
+	if err := questions().Where("deleted_at IS NULL AND (next_review_at IS NULL OR next_review_at <= ?)", time.Now()).Count(&stats.DueForReview).Error; err != nil {
+		return nil, err
+	}
+	if err := questions().Select("subject, COUNT(*) AS count").Where("deleted_at IS NULL").
+		Group("subject").Order("count DESC, subject").Scan(&stats.BySubject).Error; err != nil {
+		return nil, err
+	}
+
+	var reviews struct {
+		Reviews int64
+		Correct int64
+	}
+	if err := db.Model(&models.ReviewLog{}).Scopes(ownedBy(studentID)).
+		Select("COUNT(*) AS reviews, COALESCE(SUM(CASE WHEN correct THEN 1 ELSE 0 END), 0) AS correct").
+		Scan(&reviews).Error; err != nil {
+		return nil, err
+	}
+	stats.Reviews, stats.CorrectReviews = reviews.Reviews, reviews.Correct
+	var last []models.ReviewLog
+	if err := db.Scopes(ownedBy(studentID)).Order("reviewed_at DESC").Limit(1).Find(&last).Error; err != nil {
+		return nil, err
+	}
+	if len(last) > 0 {
+		stats.LastReviewedAt = &last[0].ReviewedAt
+	}
+	return stats, nil
+}
@@ -0,0 +1,260 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"gorm.io/gorm"
+)
+
+func newTestUser(t *testing.T, db *DB, name string, role string) *models.User {
+	t.Helper()
+	user, err := db.CreateUser(name, "correct horse", role)
+	if err != nil {
+		t.Fatalf("CreateUser(%s): %v", name, err)
+	}
+	return user
+}
+
+func TestAssignments_CopyQuestionsAndReportProgress(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	teacher := newTestUser(t, db, "teacher", models.RoleTeacher)
+	other := newTestUser(t, db, "other", models.RoleTeacher)
+	alice := newTestUser(t, db, "alice", models.RoleStudent)
+	bob := newTestUser(t, db, "bob", models.RoleStudent)
+	parent := newTestUser(t, db, "parent", models.RoleParent)
+
+	class, err := db.CreateClass(teacher.ID, " 7A ")
+	if err != nil || class.Name != "7A" {
+		t.Fatalf("CreateClass = %+v, %v", class, err)
+	}
+	for _, name := range []string{"alice", "Bob", "alice"} {
+		if _, err := db.AddClassMember(teacher.ID, class.ID, name); err != nil {
+			t.Fatalf("AddClassMember(%s): %v", name, err)
+		}
+	}
+	if _, err := db.AddClassMember(teacher.ID, class.ID, "parent"); !errors.Is(err, ErrNotStudent) {
+		t.Fatalf("adding a parent err = %v", err)
+	}
+	if _, err := db.AddClassMember(other.ID, class.ID, "alice"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("another teacher changed the class: %v", err)
+	}
+	if members, _ := db.ListClassMembers(teacher.ID, class.ID); len(members) != 2 {
+		t.Fatalf("class has %d members, want 2", len(members))
+	}
+	if ok, _ := db.TeachesStudent(teacher.ID, alice.ID); !ok {
+		t.Fatalf("teacher does not teach alice")
+	}
+	if ok, _ := db.TeachesStudent(other.ID, alice.ID); ok {
+		t.Fatalf("other teacher teaches alice")
+	}
+
+	q1 := backupQuestion("t1", "question one", time.Now())
+	q1.Options, q1.Answer = stringPtr(`["A. 1","B. 2"]`), stringPtr("A")
+	q2 := backupQuestion("t2", "question two", time.Now())
+	for _, q := range []*models.Question{&q1, &q2} {
+		if err := db.CreateQuestion(teacher.ID, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	due := time.Now().Add(24 * time.Hour)
+	if _, err := db.CreateAssignment(teacher.ID, class.ID, "homework", due, []string{"t1", "foreign"}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("assignment with an unknown question err = %v", err)
+	}
+	if _, err := db.CreateAssignment(teacher.ID, class.ID, " ", due, []string{"t1"}); !errors.Is(err, ErrInvalidAssignment) {
+		t.Fatalf("assignment without a title err = %v", err)
+	}
+	if _, err := db.CreateAssignment(teacher.ID, class.ID, "homework", time.Now().Add(-time.Hour), []string{"t1"}); !errors.Is(err, ErrInvalidAssignment) {
+		t.Fatalf("assignment due in the past err = %v", err)
+	}
+	assignment, err := db.CreateAssignment(teacher.ID, class.ID, "homework", due, []string{"t1", "t2", "t1"})
+	if err != nil {
+		t.Fatalf("CreateAssignment: %v", err)
+	}
+
+	// Alice got her own copies and works on them in her session.
+	mine, err := db.ListStudentAssignments(alice.ID)
+	if err != nil || len(mine) != 1 || mine[0].ClassName != "7A" || mine[0].Progress.Total != 2 {
+		t.Fatalf("alice's assignments = %+v, %v", mine, err)
+	}
+	copies, _ := db.GetQuestions(alice.ID)
+	if len(copies) != 2 || copies[0].ID == "t1" || copies[1].ID == "t1" {
+		t.Fatalf("alice's questions = %+v", copies)
+	}
+	sessionID := mine[0].Progress.SessionID
+	attempts, _ := db.GetPracticeAttempts(alice.ID, sessionID)
+	correct, wrong := true, false
+	for _, a := range attempts {
+		grade := &wrong
+		if a.Position == 1 {
+			grade = &correct
+		}
+		if _, _, err := db.AnswerPracticeAttempt(alice.ID, sessionID, a.QuestionID, "A", nil, grade); err != nil {
+			t.Fatalf("AnswerPracticeAttempt: %v", err)
+		}
+	}
+
+	if _, err := db.GetAssignmentReport(other.ID, assignment.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("another teacher read the report: %v", err)
+	}
+	report, err := db.GetAssignmentReport(teacher.ID, assignment.ID)
+	if err != nil || report.Questions != 2 || len(report.Students) != 2 {
+		t.Fatalf("GetAssignmentReport = %+v, %v", report, err)
+	}
+	got := map[string]AssignmentProgress{}
+	for _, p := range report.Students {
+		got[p.Username] = p
+	}
+	if p := got["alice"]; !p.Completed || p.Score != 50 || p.Correct != 1 || p.Late || p.FinishedAt == nil {
+		t.Fatalf("alice's progress = %+v", p)
+	}
+	if p := got["bob"]; p.Completed || p.Answered != 0 || p.StudentID != bob.ID {
+		t.Fatalf("bob's progress = %+v", p)
+	}
+
+	// Parents see their own children only.
+	if err := db.LinkParent(parent.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LinkParent(teacher.ID, alice.ID); !errors.Is(err, ErrNotParent) {
+		t.Fatalf("linking a teacher as parent err = %v", err)
+	}
+	if ok, _ := db.IsParentOf(parent.ID, bob.ID); ok {
+		t.Fatalf("parent follows bob")
+	}
+	stats, err := db.GetStudentStats(alice.ID)
+	if err != nil || stats.Questions != 2 || stats.Reviews != 2 || stats.CorrectReviews != 1 || stats.LastReviewedAt == nil {
+		t.Fatalf("GetStudentStats = %+v, %v", stats, err)
+	}
+
+	// A student who stops being one leaves their classes and parents.
+	if _, err := db.SetUserRole(alice.ID, models.RoleTeacher); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := db.TeachesStudent(teacher.ID, alice.ID); ok {
+		t.Fatalf("alice is still in the class")
+	}
+	if children, _ := db.ListChildren(parent.ID); len(children) != 0 {
+		t.Fatalf("parent still follows alice")
+	}
+
+	if err := db.DeleteClass(teacher.ID, class.ID); err != nil {
+		t.Fatal(err)
+	}
+	if mine, _ := db.ListStudentAssignments(bob.ID); len(mine) != 0 {
+		t.Fatalf("assignment outlived its class")
+	}
+	if copies, _ := db.GetQuestions(bob.ID); len(copies) != 2 {
+		t.Fatalf("bob lost his copies with the class")
+	}
+}
+
+func TestMigration8_PlainUsersBecomeStudents(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "v7.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateTo(db.DB, 7, MigrateOptions{}); err != nil {
+		t.Fatalf("MigrateTo(7): %v", err)
+	}
+	if err := db.Exec(`INSERT INTO users (id, username, password_hash, role) VALUES ('u1', 'old', 'x', 'user'), ('a1', 'root', 'x', 'admin')`).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateTo(db.DB, LatestMigrationVersion(), MigrateOptions{}); err != nil {
+		t.Fatalf("MigrateTo(latest): %v", err)
+	}
+	for id, want := range map[string]string{"u1": models.RoleStudent, "a1": models.RoleAdmin} {
+		if user, _ := db.GetUserByID(id); user.Role != want {
+			t.Fatalf("%s has role %q, want %q", id, user.Role, want)
+		}
+	}
+}
+
+func TestAddClassMember_GetsOpenAssignments(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	teacher := newTestUser(t, db, "teacher", models.RoleTeacher)
+	carol := newTestUser(t, db, "carol", models.RoleStudent)
+	class, _ := db.CreateClass(teacher.ID, "7A")
+	q := backupQuestion("t1", "question one", time.Now())
+	if err := db.CreateQuestion(teacher.ID, &q); err != nil {
+		t.Fatal(err)
+	}
+	open, err := db.CreateAssignment(teacher.ID, class.ID, "open", time.Now().Add(24*time.Hour), []string{"t1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	overdue, err := db.CreateAssignment(teacher.ID, class.ID, "overdue", time.Now().Add(time.Hour), []string{"t1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(overdue).Update("due_at", time.Now().Add(-time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Joining twice, or again after leaving, does not hand out a second copy.
+	for i := 0; i < 2; i++ {
+		if _, err := db.AddClassMember(teacher.ID, class.ID, "carol"); err != nil {
+			t.Fatalf("AddClassMember: %v", err)
+		}
+	}
+	if err := db.RemoveClassMember(teacher.ID, class.ID, carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddClassMember(teacher.ID, class.ID, "carol"); err != nil {
+		t.Fatal(err)
+	}
+
+	mine, err := db.ListStudentAssignments(carol.ID)
+	if err != nil || len(mine) != 1 || mine[0].ID != open.ID || mine[0].Progress.Total != 1 {
+		t.Fatalf("carol's assignments = %+v, %v", mine, err)
+	}
+	if copies, _ := db.GetQuestions(carol.ID); len(copies) != 1 || copies[0].ID == "t1" {
+		t.Fatalf("carol's questions = %+v", copies)
+	}
+	report, err := db.GetAssignmentReport(teacher.ID, open.ID)
+	if err != nil || len(report.Students) != 1 || report.Students[0].StudentID != carol.ID {
+		t.Fatalf("GetAssignmentReport = %+v, %v", report, err)
+	}
+}
+
+func TestAddClassMember_SkipsTrashedQuestions(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	teacher := newTestUser(t, db, "teacher", models.RoleTeacher)
+	carol := newTestUser(t, db, "carol", models.RoleStudent)
+	class, _ := db.CreateClass(teacher.ID, "7A")
+	for _, id := range []string{"t1", "t2", "t3"} {
+		q := backupQuestion(id, "question "+id, time.Now())
+		if err := db.CreateQuestion(teacher.ID, &q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	due := time.Now().Add(24 * time.Hour)
+	partly, err := db.CreateAssignment(teacher.ID, class.ID, "partly trashed", due, []string{"t1", "t2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateAssignment(teacher.ID, class.ID, "all trashed", due, []string{"t3"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"t2", "t3"} {
+		if err := db.DeleteQuestion(teacher.ID, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := db.AddClassMember(teacher.ID, class.ID, "carol"); err != nil {
+		t.Fatalf("AddClassMember: %v", err)
+	}
+	mine, err := db.ListStudentAssignments(carol.ID)
+	if err != nil || len(mine) != 1 || mine[0].ID != partly.ID || mine[0].Progress.Total != 1 {
+		t.Fatalf("carol's assignments = %+v, %v", mine, err)
+	}
+	copies, _ := db.GetQuestions(carol.ID)
+	if len(copies) != 1 || copies[0].Content != "question t1" || copies[0].DeletedAt != nil {
+		t.Fatalf("carol's questions = %+v", copies)
+	}
+}
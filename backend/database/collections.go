@@ -0,0 +1,349 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidCollection wraps what is wrong with a collection's name or
+	// visibility.
+	ErrInvalidCollection = errors.New("invalid collection")
+	// ErrReadOnlyCollection is returned when someone other than the owner
+	// tries to change a collection they can see.
+	ErrReadOnlyCollection = errors.New("collection is read-only")
+)
+
+// Collections belong to their owner, who alone changes them. Everyone they
+// are published to can read them and copy their questions; collections
+// nobody shared with a user are missing to that user.
+
+// visibleTo restricts a collections query to the ones userID can read: their
+// own, the ones published to everyone, and the ones published to a class
+// they are in or teach.
+func visibleTo(userID string) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(`(collections.owner_id = ? OR collections.visibility = ? OR (collections.visibility = ? AND collections.class_id IN (
+			SELECT class_id FROM class_members WHERE student_id = ?
+			UNION SELECT id FROM classes WHERE teacher_id = ?
+		)))`, userID, models.VisibilityAll, models.VisibilityClass, userID, userID)
+	}
+}
+
+// CollectionSummary is a collection as listed, with who owns it and how many
+// questions it holds. Mine is set on the reader's own collections.
+type CollectionSummary struct {
+	models.Collection
+	OwnerName     string `json:"ownerName"`
+	ClassName     string `json:"className,omitempty"`
+	QuestionCount int    `json:"questionCount"`
+	Mine          bool   `json:"mine" gorm:"-"`
+}
+
+// CollectionDetail is a collection with its questions in order. They are the
+// owner's questions as they are now; trashed ones are left out.
+type CollectionDetail struct {
+	CollectionSummary
+	Questions []models.Question `json:"questions"`
+}
+
+func (db *DB) CreateCollection(ownerID string, name string, description string) (*models.Collection, error) {
+	if ownerID == "" {
+		return nil, ErrNoOwner
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: a name is required", ErrInvalidCollection)
+	}
+	now := time.Now()
+	collection := &models.Collection{
+		ID:          uuid.New().String(),
+		OwnerID:     ownerID,
+		Name:        name,
+		Description: strings.TrimSpace(description),
+		Visibility:  models.VisibilityPrivate,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := db.Create(collection).Error; err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+// UpdateCollection renames one of ownerID's collections and sets who it is
+// published to. Publishing to a class needs classID to be a class the owner
+// teaches or is in.
+func (db *DB) UpdateCollection(ownerID string, id string, name string, description string, visibility string, classID string) (*models.Collection, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: a name is required", ErrInvalidCollection)
+	}
+	collection, err := db.ownCollection(ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	collection.ClassID = nil
+	switch visibility {
+	case models.VisibilityPrivate, models.VisibilityAll:
+	case models.VisibilityClass:
+		inClass, err := db.inClass(ownerID, classID)
+		if err != nil {
+			return nil, err
+		}
+		if !inClass {
+			return nil, fmt.Errorf("%w: you can only publish to a class you teach or are in", ErrInvalidCollection)
+		}
+		collection.ClassID = &classID
+	default:
+		return nil, fmt.Errorf("%w: unknown visibility %q", ErrInvalidCollection, visibility)
+	}
+	collection.Name = name
+	collection.Description = strings.TrimSpace(description)
+	collection.Visibility = visibility
+	collection.UpdatedAt = time.Now()
+	if err := db.Save(collection).Error; err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+func (db *DB) DeleteCollection(ownerID string, id string) error {
+	if _, err := db.ownCollection(ownerID, id); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", id).Delete(&models.CollectionQuestion{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Collection{}, "id = ?", id).Error
+	})
+}
+
+// ListCollections returns the collections userID can read, their own first
+// and then the most recently changed.
+func (db *DB) ListCollections(userID string) ([]CollectionSummary, error) {
+	var rows []CollectionSummary
+	if err := db.collectionSummaries().Scopes(visibleTo(userID)).
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "collections.owner_id = ? DESC, collections.updated_at DESC",
+			Vars:               []interface{}{userID},
+			WithoutParentheses: true,
+		}}).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].Mine = rows[i].OwnerID == userID
+	}
+	return rows, nil
+}
+
+// GetCollection returns a collection userID can read, with its questions.
+func (db *DB) GetCollection(userID string, id string) (*CollectionDetail, error) {
+	var rows []CollectionSummary
+	if err := db.collectionSummaries().Scopes(visibleTo(userID)).Where("collections.id = ?", id).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	detail := &CollectionDetail{CollectionSummary: rows[0]}
+	detail.Mine = detail.OwnerID == userID
+	questions, err := db.collectionQuestions(&detail.Collection, nil)
+	if err != nil {
+		return nil, err
+	}
+	detail.Questions = questions
+	return detail, nil
+}
+
+// AddCollectionQuestions appends questions from ownerID's mistake book to one
+// of their collections. Questions already in it stay where they are.
+func (db *DB) AddCollectionQuestions(ownerID string, id string, questionIDs []string) error {
+	questionIDs = uniqueStrings(questionIDs)
+	if len(questionIDs) == 0 {
+		return fmt.Errorf("%w: no questions given", ErrInvalidCollection)
+	}
+	collection, err := db.ownCollection(ownerID, id)
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var owned int64
+		if err := tx.Model(&models.Question{}).Scopes(ownedBy(ownerID)).
+			Where("id IN ? AND deleted_at IS NULL", questionIDs).Count(&owned).Error; err != nil {
+			return err
+		}
+		if int(owned) != len(questionIDs) {
+			return gorm.ErrRecordNotFound
+		}
+		var last int
+		if err := tx.Model(&models.CollectionQuestion{}).Where("collection_id = ?", id).
+			Select("COALESCE(MAX(position), 0)").Scan(&last).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		for i, questionID := range questionIDs {
+			item := models.CollectionQuestion{CollectionID: id, QuestionID: questionID, Position: last + i + 1, CreatedAt: now}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&item).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(collection).Update("updated_at", now).Error
+	})
+}
+
+func (db *DB) RemoveCollectionQuestion(ownerID string, id string, questionID string) error {
+	collection, err := db.ownCollection(ownerID, id)
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("collection_id = ? AND question_id = ?", id, questionID).Delete(&models.CollectionQuestion{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Model(collection).Update("updated_at", time.Now()).Error
+	})
+}
+
+// CopyResult is what CopyFromCollection did. AlreadyCopied counts the
+// questions the user still had a copy of, which are not copied again.
+type CopyResult struct {
+	Copied        []models.Question `json:"copied"`
+	AlreadyCopied int               `json:"alreadyCopied"`
+}
+
+// CopyFromCollection copies questions of a collection userID can read into
+// their own mistake book, or all of its questions when questionIDs is empty.
+// A copy records the question it came from but is otherwise independent:
+// later edits to the original do not reach it.
+func (db *DB) CopyFromCollection(userID string, id string, questionIDs []string) (*CopyResult, error) {
+	if userID == "" {
+		return nil, ErrNoOwner
+	}
+	var collection models.Collection
+	if err := db.Scopes(visibleTo(userID)).First(&collection, "collections.id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	questionIDs = uniqueStrings(questionIDs)
+	result := &CopyResult{Copied: []models.Question{}}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		questions, err := (&DB{tx}).collectionQuestions(&collection, questionIDs)
+		if err != nil {
+			return err
+		}
+		if len(questionIDs) > 0 && len(questions) != len(questionIDs) {
+			return gorm.ErrRecordNotFound
+		}
+		ids := make([]string, len(questions))
+		for i, q := range questions {
+			ids[i] = q.ID
+		}
+		var copiedBefore []string
+		if err := tx.Model(&models.Question{}).Scopes(ownedBy(userID)).
+			Where("source_question_id IN ? AND deleted_at IS NULL", ids).
+			Pluck("source_question_id", &copiedBefore).Error; err != nil {
+			return err
+		}
+		skip := make(map[string]bool, len(copiedBefore))
+		for _, sourceID := range copiedBefore {
+			skip[sourceID] = true
+		}
+		for _, q := range questions {
+			if skip[q.ID] {
+				result.AlreadyCopied++
+				continue
+			}
+			copied := copyQuestion(q, userID)
+			if err := tx.Create(&copied).Error; err != nil {
+				return err
+			}
+			result.Copied = append(result.Copied, copied)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// copyQuestion is q as a new question in ownerID's book that records where
+// it came from, with no review history and no link to the source's variants.
+func copyQuestion(q models.Question, ownerID string) models.Question {
+	now := time.Now()
+	source := q.ID
+	q.ID = uuid.New().String()
+	q.OwnerID = ownerID
+	q.SourceQuestionID = &source
+	q.CopiedAt = &now
+	q.ParentID = nil
+	q.CreatedAt = now
+	q.UpdatedAt = now
+	q.LastReviewedAt = nil
+	q.NextReviewAt = nil
+	q.ReviewStreak = 0
+	q.DeletedAt = nil
+	q.Version = 1
+	return q
+}
+
+// ownCollection returns one of ownerID's collections. Collections they can
+// only read give ErrReadOnlyCollection, the rest gorm.ErrRecordNotFound.
+func (db *DB) ownCollection(ownerID string, id string) (*models.Collection, error) {
+	var collection models.Collection
+	if err := db.Scopes(visibleTo(ownerID)).First(&collection, "collections.id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	if collection.OwnerID != ownerID {
+		return nil, ErrReadOnlyCollection
+	}
+	return &collection, nil
+}
+
+// inClass reports whether userID teaches or is in classID.
+func (db *DB) inClass(userID string, classID string) (bool, error) {
+	var n int64
+	err := db.Model(&models.Class{}).
+		Where("id = ? AND (teacher_id = ? OR id IN (SELECT class_id FROM class_members WHERE student_id = ?))", classID, userID, userID).
+		Count(&n).Error
+	return n > 0, err
+}
+
+func (db *DB) collectionSummaries() *gorm.DB {
+	return db.Model(&models.Collection{}).
+		Select(`collections.*, users.username AS owner_name, classes.name AS class_name,
+			(SELECT COUNT(*) FROM collection_questions
+				JOIN questions ON questions.id = collection_questions.question_id
+				WHERE collection_questions.collection_id = collections.id
+				AND questions.owner_id = collections.owner_id AND questions.deleted_at IS NULL) AS question_count`).
+		Joins("LEFT JOIN users ON users.id = collections.owner_id").
+		Joins("LEFT JOIN classes ON classes.id = collections.class_id")
+}
+
+// collectionQuestions returns the live questions of a collection in order,
+// only those in ids when it is not empty.
+func (db *DB) collectionQuestions(collection *models.Collection, ids []string) ([]models.Question, error) {
+	query := db.Model(&models.Question{}).
+		Joins("JOIN collection_questions ON collection_questions.question_id = questions.id").
+		Where("collection_questions.collection_id = ? AND questions.owner_id = ? AND questions.deleted_at IS NULL", collection.ID, collection.OwnerID)
+	if len(ids) > 0 {
+		query = query.Where("questions.id IN ?", ids)
+	}
+	questions := []models.Question{}
+	err := query.Order("collection_questions.position").Find(&questions).Error
+	return questions, err
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"gorm.io/gorm"
+)
+
+func TestCollections_PublishAndCopy(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	teacher := newTestUser(t, db, "teacher", models.RoleTeacher)
+	alice := newTestUser(t, db, "alice", models.RoleStudent)
+	bob := newTestUser(t, db, "bob", models.RoleStudent)
+
+	class, err := db.CreateClass(teacher.ID, "7A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddClassMember(teacher.ID, class.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	q := backupQuestion("common", "a common mistake", time.Now())
+	q.ReviewStreak = 3
+	if err := db.CreateQuestion(teacher.ID, &q); err != nil {
+		t.Fatal(err)
+	}
+	collection, err := db.CreateCollection(teacher.ID, "Fractions", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddCollectionQuestions(teacher.ID, collection.ID, []string{"common", "missing"}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("adding an unknown question err = %v", err)
+	}
+	if err := db.AddCollectionQuestions(teacher.ID, collection.ID, []string{"common"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Private until published, then visible to the class only.
+	if _, err := db.GetCollection(alice.ID, collection.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("alice reads a private collection: %v", err)
+	}
+	if _, err := db.UpdateCollection(bob.ID, collection.ID, "mine", "", models.VisibilityAll, ""); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("bob published the collection: %v", err)
+	}
+	if _, err := db.UpdateCollection(bob.ID, "x", "x", "", models.VisibilityClass, class.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("updating an unknown collection err = %v", err)
+	}
+	if _, err := db.UpdateCollection(teacher.ID, collection.ID, "Fractions", "", models.VisibilityClass, "other"); !errors.Is(err, ErrInvalidCollection) {
+		t.Fatalf("publishing to a foreign class err = %v", err)
+	}
+	if _, err := db.UpdateCollection(teacher.ID, collection.ID, "Fractions", "", models.VisibilityClass, class.ID); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := db.ListCollections(bob.ID); len(list) != 0 {
+		t.Fatalf("bob sees %d collections outside his class", len(list))
+	}
+	list, err := db.ListCollections(alice.ID)
+	if err != nil || len(list) != 1 || list[0].Mine || list[0].OwnerName != "teacher" || list[0].ClassName != "7A" || list[0].QuestionCount != 1 {
+		t.Fatalf("alice's collections = %+v, %v", list, err)
+	}
+	if err := db.RemoveCollectionQuestion(alice.ID, collection.ID, "common"); !errors.Is(err, ErrReadOnlyCollection) {
+		t.Fatalf("alice changed the collection: %v", err)
+	}
+	if err := db.DeleteCollection(alice.ID, collection.ID); !errors.Is(err, ErrReadOnlyCollection) {
+		t.Fatalf("alice deleted the collection: %v", err)
+	}
+
+	// The copy records its source and does not follow later edits.
+	result, err := db.CopyFromCollection(alice.ID, collection.ID, nil)
+	if err != nil || len(result.Copied) != 1 {
+		t.Fatalf("CopyFromCollection = %+v, %v", result, err)
+	}
+	copied := result.Copied[0]
+	if copied.ID == "common" || copied.SourceQuestionID == nil || *copied.SourceQuestionID != "common" || copied.CopiedAt == nil || copied.ReviewStreak != 0 {
+		t.Fatalf("copy = %+v", copied)
+	}
+	if err := db.UpdateQuestion(teacher.ID, "common", &models.Question{Content: "edited"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetQuestionByID(alice.ID, copied.ID); got.Content != "a common mistake" {
+		t.Fatalf("copy followed the edit: %q", got.Content)
+	}
+	if detail, _ := db.GetCollection(alice.ID, collection.ID); len(detail.Questions) != 1 || detail.Questions[0].Content != "edited" {
+		t.Fatalf("collection does not show the current question: %+v", detail)
+	}
+	if again, _ := db.CopyFromCollection(alice.ID, collection.ID, []string{"common"}); len(again.Copied) != 0 || again.AlreadyCopied != 1 {
+		t.Fatalf("second copy = %+v", again)
+	}
+	if _, err := db.CopyFromCollection(bob.ID, collection.ID, nil); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("bob copied from a class collection: %v", err)
+	}
+
+	// Deleting the original or the class leaves the copy alone.
+	if err := db.HardDeleteQuestion(teacher.ID, "common"); err != nil {
+		t.Fatal(err)
+	}
+	if detail, _ := db.GetCollection(teacher.ID, collection.ID); len(detail.Questions) != 0 || detail.QuestionCount != 0 {
+		t.Fatalf("deleted question still listed: %+v", detail)
+	}
+	if err := db.DeleteClass(teacher.ID, class.ID); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := db.ListCollections(alice.ID); len(list) != 0 {
+		t.Fatalf("collection stayed published to a deleted class")
+	}
+	if _, err := db.GetQuestionByID(alice.ID, copied.ID); err != nil {
+		t.Fatalf("alice lost her copy: %v", err)
+	}
+}
@@ -2,8 +2,11 @@ package database
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"E-Bu-backend/models"
@@ -12,83 +15,110 @@ import (
 	"gorm.io/gorm"
 )
 
-func ensureQuestionColumns(db *gorm.DB) error {
-	// sqlite schema fix: older DBs may miss newly added columns.
-	// Column names follow GORM's default naming strategy (snake_case).
-	type columnSpec struct {
-		Name string
-		DDL  string
-	}
-
-	columns := []columnSpec{
-		{
-			Name: "learning_guide",
-			DDL:  "ALTER TABLE questions ADD COLUMN learning_guide TEXT NOT NULL DEFAULT ''",
-		},
-	}
-
-	for _, col := range columns {
-		if db.Migrator().HasColumn(&models.Question{}, col.Name) {
-			continue
-		}
-		if err := db.Exec(col.DDL).Error; err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 type DB struct {
 	*gorm.DB
 }
 
-func NewDB(dsn string) (*DB, error) {
+// Options tune NewDBWithOptions.
+type Options struct {
+	// SnapshotDir, when set, receives a snapshot of an existing database
+	// before pending migrations touch it.
+	SnapshotDir string
+}
+
+// Open opens the SQLite file without touching its schema. The database runs
+// in WAL mode, so a long read such as a streamed export does not block
+// writers, and every connection waits up to busyTimeout for a lock instead
+// of failing with SQLITE_BUSY at once.
+func Open(dsn string) (*DB, error) {
 	// Ensure the directory exists
 	dir := filepath.Dir(dsn)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, err
 	}
 
-	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open(withPragmas(dsn)), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
+	return &DB{db}, nil
+}
+
+// busyTimeout is how long a connection waits for another one's lock.
+const busyTimeout = 5 * time.Second
 
-	// Migrate the schema
-	err = db.AutoMigrate(&models.Question{}, &models.AIConfig{})
+// withPragmas adds the connection pragmas to dsn. They go in the DSN rather
+// than through Exec so that every pooled connection gets them.
+func withPragmas(dsn string) string {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s_pragma=journal_mode(WAL)&_pragma=busy_timeout(%d)", dsn, sep, busyTimeout.Milliseconds())
+}
+
+func NewDB(dsn string) (*DB, error) {
+	return NewDBWithOptions(dsn, Options{})
+}
+
+func NewDBWithOptions(dsn string, opts Options) (*DB, error) {
+	opened, err := Open(dsn)
 	if err != nil {
 		return nil, err
 	}
+	db := opened.DB
 
-	// Backward-compatible migrations for older sqlite DBs.
-	// Keep two layers:
-	// 1) apply migrations to latest (tracked by schema_migrations)
-	// 2) legacy safety net (ensureQuestionColumns) for very old DBs
-	if _, err := ApplyMigrationsToLatest(db); err != nil {
+	shape, err := DetectSchemaShape(db)
+	if err != nil {
 		return nil, err
 	}
-	if err := ensureQuestionColumns(db); err != nil {
-		return nil, err
+	if shape == ShapeUnversioned || shape == ShapePreBaseline {
+		log.Printf("database %s is %s; migrating it to the baseline schema and forward", dsn, shape)
 	}
 
-	// Initialize default AI config if not exists
-	var aiConfig models.AIConfig
-	if err := db.First(&aiConfig).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			aiConfig = models.AIConfig{
-				Type: models.Gemini,
+	var migrate MigrateOptions
+	if opts.SnapshotDir != "" {
+		pending, err := HasPendingMigrations(db)
+		if err != nil {
+			return nil, err
+		}
+		if pending {
+			if migrate.snapshotPath, err = takePreMigrationSnapshot(db, opts.SnapshotDir); err != nil {
+				return nil, fmt.Errorf("pre-migration snapshot failed, database left untouched: %w", err)
 			}
-			db.Create(&aiConfig)
 		}
 	}
 
+	// The versioned migrations are the only thing that changes the schema,
+	// starting with the baseline (migration 0) on new and legacy databases.
+	if _, err := ApplyMigrations(db, migrate); err != nil {
+		return nil, err
+	}
+
 	return &DB{db}, nil
 }
 
+// ErrNoOwner is returned when per-user data would be written without an
+// owner.
+var ErrNoOwner = errors.New("no owner given")
+
+// ErrVersionConflict is returned when a question is updated from a copy
+// older than the stored one.
+var ErrVersionConflict = errors.New("the question was changed since it was read")
+
+// ownedBy restricts a query to the rows of one user. Every function that
+// reads or writes questions, practice data, review logs or AI configs takes
+// the owner and goes through it; an empty ownerID matches nothing.
+func ownedBy(ownerID string) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("owner_id = ?", ownerID)
+	}
+}
+
 // Question operations
-func (db *DB) GetQuestions() ([]models.Question, error) {
+func (db *DB) GetQuestions(ownerID string) ([]models.Question, error) {
 	var questions []models.Question
-	result := db.Where("deleted_at IS NULL").Order("created_at DESC").Find(&questions)
+	result := db.Scopes(ownedBy(ownerID)).Where("deleted_at IS NULL").Order("created_at DESC").Find(&questions)
 	return questions, result.Error
 }
 
@@ -99,11 +129,11 @@ type PagedQuestions struct {
 	PageSize int               `json:"pageSize"`
 }
 
-func (db *DB) GetQuestionsPaged(tag string, page int, pageSize int) (*PagedQuestions, error) {
-	return db.GetQuestionsPagedFiltered(tag, "", "", page, pageSize)
+func (db *DB) GetQuestionsPaged(ownerID string, tag string, page int, pageSize int) (*PagedQuestions, error) {
+	return db.GetQuestionsPagedFiltered(ownerID, tag, "", "", page, pageSize)
 }
 
-func (db *DB) GetQuestionsPagedFiltered(tag string, query string, subject string, page int, pageSize int) (*PagedQuestions, error) {
+func (db *DB) GetQuestionsPagedFiltered(ownerID string, tag string, query string, subject string, page int, pageSize int) (*PagedQuestions, error) {
 	if page <= 0 {
 		page = 1
 	}
@@ -114,7 +144,26 @@ func (db *DB) GetQuestionsPagedFiltered(tag string, query string, subject string
 		pageSize = 100
 	}
 
-	base := db.Model(&models.Question{}).Where("deleted_at IS NULL")
+	base := db.Model(&models.Question{}).Scopes(ownedBy(ownerID)).Where("deleted_at IS NULL")
+	base = applyQuestionFilters(base, tag, query, subject)
+
+	var total int64
+	if err := base.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	var questions []models.Question
+	offset := (page - 1) * pageSize
+	if err := base.Order("created_at DESC").Offset(offset).Limit(pageSize).Find(&questions).Error; err != nil {
+		return nil, err
+	}
+
+	return &PagedQuestions{Items: questions, Total: total, Page: page, PageSize: pageSize}, nil
+}
+
+// applyQuestionFilters adds the tag / free-text / subject filters shared by
+// the question list, trash and practice selection queries.
+func applyQuestionFilters(base *gorm.DB, tag string, query string, subject string) *gorm.DB {
 	if tag != "" {
 		// knowledge_points is stored as JSON string, so match by substring.
 		// Stored form is like ["tag1","tag2"], so we search for "tag".
@@ -136,32 +185,20 @@ func (db *DB) GetQuestionsPagedFiltered(tag string, query string, subject string
 			like,
 		)
 	}
-
-	var total int64
-	if err := base.Count(&total).Error; err != nil {
-		return nil, err
-	}
-
-	var questions []models.Question
-	offset := (page - 1) * pageSize
-	if err := base.Order("created_at DESC").Offset(offset).Limit(pageSize).Find(&questions).Error; err != nil {
-		return nil, err
-	}
-
-	return &PagedQuestions{Items: questions, Total: total, Page: page, PageSize: pageSize}, nil
+	return base
 }
 
-func (db *DB) GetTrash() ([]models.Question, error) {
+func (db *DB) GetTrash(ownerID string) ([]models.Question, error) {
 	var questions []models.Question
-	result := db.Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&questions)
+	result := db.Scopes(ownedBy(ownerID)).Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&questions)
 	return questions, result.Error
 }
 
-func (db *DB) GetTrashPaged(tag string, page int, pageSize int) (*PagedQuestions, error) {
-	return db.GetTrashPagedFiltered(tag, "", "", page, pageSize)
+func (db *DB) GetTrashPaged(ownerID string, tag string, page int, pageSize int) (*PagedQuestions, error) {
+	return db.GetTrashPagedFiltered(ownerID, tag, "", "", page, pageSize)
 }
 
-func (db *DB) GetTrashPagedFiltered(tag string, query string, subject string, page int, pageSize int) (*PagedQuestions, error) {
+func (db *DB) GetTrashPagedFiltered(ownerID string, tag string, query string, subject string, page int, pageSize int) (*PagedQuestions, error) {
 	if page <= 0 {
 		page = 1
 	}
@@ -172,26 +209,8 @@ func (db *DB) GetTrashPagedFiltered(tag string, query string, subject string, pa
 		pageSize = 100
 	}
 
-	base := db.Model(&models.Question{}).Where("deleted_at IS NOT NULL")
-	if tag != "" {
-		base = base.Where("knowledge_points LIKE ?", "%\""+tag+"\"%")
-	}
-	if subject != "" {
-		base = base.Where("subject = ?", subject)
-	}
-	if query != "" {
-		like := "%" + query + "%"
-		base = base.Where(
-			"content LIKE ? OR analysis LIKE ? OR learning_guide LIKE ? OR diagram_description LIKE ? OR answer LIKE ? OR options LIKE ? OR knowledge_points LIKE ?",
-			like,
-			like,
-			like,
-			like,
-			like,
-			like,
-			like,
-		)
-	}
+	base := db.Model(&models.Question{}).Scopes(ownedBy(ownerID)).Where("deleted_at IS NOT NULL")
+	base = applyQuestionFilters(base, tag, query, subject)
 
 	var total int64
 	if err := base.Count(&total).Error; err != nil {
@@ -207,50 +226,263 @@ func (db *DB) GetTrashPagedFiltered(tag string, query string, subject string, pa
 	return &PagedQuestions{Items: questions, Total: total, Page: page, PageSize: pageSize}, nil
 }
 
-func (db *DB) GetQuestionByID(id string) (*models.Question, error) {
+// GetQuestionByID returns one of ownerID's questions. Other users'
+// questions are reported as gorm.ErrRecordNotFound, like missing ones.
+func (db *DB) GetQuestionByID(ownerID string, id string) (*models.Question, error) {
 	var question models.Question
-	result := db.First(&question, "id = ?", id)
+	result := db.Scopes(ownedBy(ownerID)).First(&question, "id = ?", id)
 	return &question, result.Error
 }
 
-func (db *DB) CreateQuestion(question *models.Question) error {
-	return db.Create(question).Error
+// CreateQuestion saves question as one of ownerID's. Its content is its
+// first revision, marked as the AI's when question.AIGenerated is set.
+func (db *DB) CreateQuestion(ownerID string, question *models.Question) error {
+	if ownerID == "" {
+		return ErrNoOwner
+	}
+	question.OwnerID = ownerID
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(question).Error; err != nil {
+			return err
+		}
+		return recordRevision(tx, nil, question, initialRevisionSource(question), nil)
+	})
 }
 
-func (db *DB) UpdateQuestion(id string, updates *models.Question) error {
-	// Use struct updates so GORM maps fields to snake_case columns.
-	// Also only non-zero fields are applied unless explicitly selected.
-	result := db.Model(&models.Question{}).Where("id = ?", id).Updates(updates)
-	return result.Error
+// UpdateQuestion changes one of ownerID's questions and records the new
+// content as a revision. Other users' questions are left alone. A non-zero
+// updates.Version is the version the change was made to; if the question
+// has moved on since, nothing is written and ErrVersionConflict is returned.
+func (db *DB) UpdateQuestion(ownerID string, id string, updates *models.Question) error {
+	return db.updateQuestion(ownerID, id, updates, nil)
 }
 
-func (db *DB) DeleteQuestion(id string) error {
+// PatchQuestion is UpdateQuestion for a partial update: it writes exactly
+// the given columns of patched, zero values and NULLs included, and leaves
+// the rest alone.
+func (db *DB) PatchQuestion(ownerID string, id string, patched *models.Question, columns []string) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	return db.updateQuestion(ownerID, id, patched, columns)
+}
+
+// updateQuestion writes columns of updates over one of ownerID's questions,
+// or its non-zero fields when columns is empty.
+func (db *DB) updateQuestion(ownerID string, id string, updates *models.Question, columns []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		before, err := findQuestion(tx.Scopes(ownedBy(ownerID)), id)
+		if err != nil || before == nil {
+			return err
+		}
+		if updates.Version != 0 && updates.Version != before.Version {
+			return ErrVersionConflict
+		}
+		updates.Version = before.Version + 1
+		write := tx.Model(&models.Question{}).Scopes(ownedBy(ownerID)).Where("id = ? AND version = ?", id, before.Version)
+		if len(columns) > 0 {
+			write = write.Select(append([]string{"version", "updated_at"}, columns...))
+		} else {
+			// Use struct updates so GORM maps fields to snake_case columns.
+			// Also only non-zero fields are applied unless explicitly selected.
+			write = write.Omit("owner_id")
+		}
+		result := write.Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+		after, err := findQuestion(tx, id)
+		if err != nil {
+			return err
+		}
+		return recordRevision(tx, before, after, models.RevisionEdit, nil)
+	})
+}
+
+func (db *DB) DeleteQuestion(ownerID string, id string) error {
 	// Soft delete - set deleted_at timestamp
-	return db.Model(&models.Question{}).Where("id = ?", id).Update("deleted_at", time.Now()).Error
+	return db.setDeletedAt(ownerID, id, time.Now(), AuditQuestionDelete)
+}
+
+func (db *DB) RestoreQuestion(ownerID string, id string) error {
+	return db.setDeletedAt(ownerID, id, nil, AuditQuestionRestore)
+}
+
+// setDeletedAt moves a question into the trash (deletedAt set) or out of
+// it (nil). A question that is already there is left alone, unaudited, as
+// bulk deletes and restores report it unchanged.
+func (db *DB) setDeletedAt(ownerID string, id string, deletedAt interface{}, action string) error {
+	inState := "deleted_at IS NOT NULL"
+	if deletedAt != nil {
+		inState = "deleted_at IS NULL"
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Question{}).Scopes(ownedBy(ownerID)).Where("id = ?", id).Where(inState).
+			Updates(map[string]interface{}{"deleted_at": deletedAt, "version": gorm.Expr("version + 1")})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return recordAudit(tx, action, []string{id}, nil)
+	})
+}
+
+// HardDeleteQuestion permanently deletes one of ownerID's questions and
+// everything that refers to it, like PurgeTrash and bulk hard deletes.
+func (db *DB) HardDeleteQuestion(ownerID string, id string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var owned int64
+		if err := tx.Model(&models.Question{}).Scopes(ownedBy(ownerID)).Where("id = ?", id).Count(&owned).Error; err != nil || owned == 0 {
+			return err
+		}
+		if _, err := hardDeleteQuestions(tx, []string{id}); err != nil {
+			return err
+		}
+		return recordAudit(tx, AuditQuestionHardDelete, []string{id}, nil)
+	})
 }
 
-func (db *DB) RestoreQuestion(id string) error {
-	return db.Model(&models.Question{}).Where("id = ?", id).Update("deleted_at", nil).Error
+// PurgeTrash permanently deletes the questions that went to the trash before
+// cutoff, or every trashed question when cutoff is zero. It is maintenance
+// for the command line and covers every user's trash.
+func (db *DB) PurgeTrash(cutoff time.Time) (int64, error) {
+	var purged int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		trashed := tx.Model(&models.Question{}).Where("deleted_at IS NOT NULL")
+		if !cutoff.IsZero() {
+			trashed = trashed.Where("deleted_at < ?", cutoff)
+		}
+		var ids []string
+		if err := trashed.Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		var err error
+		if purged, err = hardDeleteQuestions(tx, ids); err != nil {
+			return err
+		}
+		details := map[string]interface{}{}
+		if !cutoff.IsZero() {
+			details["cutoff"] = cutoff
+		}
+		return recordAudit(tx, AuditTrashPurge, ids, details)
+	})
+	return purged, err
 }
 
-func (db *DB) HardDeleteQuestion(id string) error {
-	return db.Delete(&models.Question{}, "id = ?", id).Error
+// hardDeleteQuestions permanently deletes the questions with the given IDs
+// along with everything that refers to them: collection and assignment
+// entries, practice attempts, review logs and revisions. It returns how many
+// questions were deleted.
+func hardDeleteQuestions(tx *gorm.DB, ids []string) (int64, error) {
+	// Variants outlive their source question; just unlink them.
+	if err := tx.Model(&models.Question{}).Where("parent_id IN ?", ids).
+		Updates(map[string]interface{}{"parent_id": nil, "version": gorm.Expr("version + 1")}).Error; err != nil {
+		return 0, err
+	}
+	var sessions []string
+	if err := tx.Model(&models.PracticeAttempt{}).Distinct("session_id").
+		Where("question_id IN ? AND session_id IS NOT NULL", ids).Pluck("session_id", &sessions).Error; err != nil {
+		return 0, err
+	}
+	for _, dependent := range []interface{}{
+		&models.CollectionQuestion{},
+		&models.AssignmentQuestion{},
+		&models.PracticeAttempt{},
+		&models.ReviewLog{},
+		&models.QuestionRevision{},
+	} {
+		if err := tx.Where("question_id IN ?", ids).Delete(dependent).Error; err != nil {
+			return 0, err
+		}
+	}
+	// Sessions that lost questions count only what is left.
+	if len(sessions) > 0 {
+		left := tx.Model(&models.PracticeAttempt{}).Select("COUNT(*)").Where("practice_attempts.session_id = practice_sessions.id")
+		if err := tx.Model(&models.PracticeSession{}).Where("id IN ?", sessions).Update("total", left).Error; err != nil {
+			return 0, err
+		}
+	}
+	result := tx.Where("id IN ?", ids).Delete(&models.Question{})
+	return result.RowsAffected, result.Error
+}
+
+// CreateVariantQuestions saves AI-generated variants as children of
+// parentID, which must be one of ownerID's questions.
+func (db *DB) CreateVariantQuestions(ownerID string, parentID string, variants []models.Question) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var parents int64
+		if err := tx.Model(&models.Question{}).Scopes(ownedBy(ownerID)).Where("id = ?", parentID).Count(&parents).Error; err != nil {
+			return err
+		}
+		if parents == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		for i := range variants {
+			variants[i].ParentID = &parentID
+			variants[i].AIGenerated = true
+			variants[i].OwnerID = ownerID
+			if err := tx.Create(&variants[i]).Error; err != nil {
+				return err
+			}
+			if err := recordRevision(tx, nil, &variants[i], models.RevisionAI, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetVariants lists the non-deleted variants generated from a question.
+func (db *DB) GetVariants(ownerID string, parentID string) ([]models.Question, error) {
+	var questions []models.Question
+	result := db.Scopes(ownedBy(ownerID)).Where("parent_id = ? AND deleted_at IS NULL", parentID).Order("created_at ASC").Find(&questions)
+	return questions, result.Error
 }
 
 // AI Config operations
-func (db *DB) GetAIConfig() (*models.AIConfig, error) {
-	var config models.AIConfig
-	result := db.First(&config)
-	return &config, result.Error
+
+// GetAIConfig returns ownerID's AI config, or the defaults if they have not
+// saved one yet.
+func (db *DB) GetAIConfig(ownerID string) (*models.AIConfig, error) {
+	var configs []models.AIConfig
+	if err := db.Scopes(ownedBy(ownerID)).Limit(1).Find(&configs).Error; err != nil {
+		return nil, err
+	}
+	if len(configs) == 0 {
+		return &models.AIConfig{Type: models.Gemini}, nil
+	}
+	return &configs[0], nil
 }
 
-func (db *DB) SaveAIConfig(config *models.AIConfig) error {
-	var existingConfig models.AIConfig
-	result := db.First(&existingConfig)
-	if result.Error != nil {
-		// If no config exists, create new one
-		return db.Create(config).Error
+func (db *DB) SaveAIConfig(ownerID string, config *models.AIConfig) error {
+	if ownerID == "" {
+		return ErrNoOwner
 	}
-	// Update existing config
-	return db.Model(&existingConfig).Updates(config).Error
+	config.ID = 0
+	config.OwnerID = &ownerID
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existingConfig models.AIConfig
+		result := tx.Scopes(ownedBy(ownerID)).First(&existingConfig)
+		if result.Error != nil {
+			// If no config exists, create new one
+			if err := tx.Create(config).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Model(&existingConfig).Updates(config).Error; err != nil {
+			// Update existing config
+			return err
+		}
+		// The API key is never logged, only whether one was given.
+		return recordAudit(tx, AuditAIConfigUpdate, nil, map[string]interface{}{
+			"type":      config.Type,
+			"modelName": config.ModelName,
+			"baseUrl":   config.BaseURL,
+			"apiKeySet": config.APIKey != "",
+		})
+	})
 }
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"E-Bu-backend/models"
+)
+
+func TestPurgeTrash(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	now := time.Now()
+
+	for _, id := range []string{"live", "old", "recent", "variant"} {
+		q := backupQuestion(id, id, now)
+		if err := db.CreateQuestion(testOwner, &q); err != nil {
+			t.Fatalf("CreateQuestion: %v", err)
+		}
+	}
+	db.Exec("UPDATE questions SET parent_id = 'old' WHERE id = 'variant'")
+	db.Exec("UPDATE questions SET deleted_at = ? WHERE id = 'old'", now.AddDate(0, 0, -40))
+	db.Exec("UPDATE questions SET deleted_at = ? WHERE id = 'recent'", now.AddDate(0, 0, -1))
+
+	purged, err := db.PurgeTrash(now.AddDate(0, 0, -30))
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeTrash(30 days) = %d, %v", purged, err)
+	}
+	if q, _ := findQuestion(db.DB, "old"); q != nil {
+		t.Fatalf("old trash survived")
+	}
+	if v, _ := db.GetQuestionByID(testOwner, "variant"); v.ParentID != nil {
+		t.Fatalf("variant still points at the purged question")
+	}
+
+	purged, err = db.PurgeTrash(time.Time{})
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeTrash(all) = %d, %v", purged, err)
+	}
+	if all, _ := db.GetQuestions(testOwner); len(all) != 2 {
+		t.Fatalf("live questions were purged: %d left", len(all))
+	}
+}
+
+func TestUpdateQuestion_Versions(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	q := backupQuestion("q1", "question", time.Now())
+	if err := db.CreateQuestion(testOwner, &q); err != nil {
+		t.Fatal(err)
+	}
+	if q.Version != 1 {
+		t.Fatalf("new question has version %d", q.Version)
+	}
+
+	if err := db.UpdateQuestion(testOwner, "q1", &models.Question{Content: "first", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateQuestion(testOwner, "q1", &models.Question{Content: "stale", Version: 1}); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("stale update err = %v", err)
+	}
+	if err := db.UpdateQuestion(testOwner, "q1", &models.Question{Content: "any version"}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := db.GetQuestionByID(testOwner, "q1")
+	if got.Content != "any version" || got.Version != 3 {
+		t.Fatalf("question = %q at version %d", got.Content, got.Version)
+	}
+
+	// Deleting, restoring and reverting are changes too.
+	db.DeleteQuestion(testOwner, "q1")
+	db.RestoreQuestion(testOwner, "q1")
+	reverted, err := db.RevertQuestion(testOwner, "q1", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted.Content != "question" || reverted.Version != 6 {
+		t.Fatalf("reverted question = %q at version %d", reverted.Content, reverted.Version)
+	}
+
+	// So is a graded answer, which moves the review schedule: a copy read
+	// before it is stale.
+	session, err := db.CreatePracticeSession(testOwner, []string{"q1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	correct := true
+	if _, _, err := db.AnswerPracticeAttempt(testOwner, session.ID, "q1", "A", nil, &correct); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetQuestionByID(testOwner, "q1"); got.Version != 7 || got.LastReviewedAt == nil {
+		t.Fatalf("answered question at version %d, last reviewed %v", got.Version, got.LastReviewedAt)
+	}
+	if err := db.UpdateQuestion(testOwner, "q1", &models.Question{Content: "stale", Version: 6}); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("update over a review err = %v", err)
+	}
+
+	// And a variant losing its source question.
+	if err := db.CreateVariantQuestions(testOwner, "q1", []models.Question{backupQuestion("v1", "variant", time.Now())}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HardDeleteQuestion(testOwner, "q1"); err != nil {
+		t.Fatal(err)
+	}
+	if variant, _ := db.GetQuestionByID(testOwner, "v1"); variant.ParentID != nil || variant.Version != 2 {
+		t.Fatalf("unlinked variant = %+v", variant)
+	}
+}
+
+func TestHardDeleteQuestion_RemovesHistory(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	for _, id := range []string{"q1", "q2"} {
+		q := backupQuestion(id, id, time.Now())
+		if err := db.CreateQuestion(testOwner, &q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session, err := db.CreatePracticeSession(testOwner, []string{"q1", "q2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	correct := true
+	if _, _, err := db.AnswerPracticeAttempt(testOwner, session.ID, "q1", "A", nil, &correct); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.HardDeleteQuestion("someone else", "q1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetQuestionByID(testOwner, "q1"); err != nil {
+		t.Fatalf("another user deleted the question: %v", err)
+	}
+	if err := db.HardDeleteQuestion(testOwner, "q1"); err != nil {
+		t.Fatal(err)
+	}
+	var attempts, reviews int64
+	db.Model(&models.PracticeAttempt{}).Where("question_id = ?", "q1").Count(&attempts)
+	db.Model(&models.ReviewLog{}).Where("question_id = ?", "q1").Count(&reviews)
+	if attempts != 0 || reviews != 0 {
+		t.Fatalf("%d attempts and %d review logs left behind", attempts, reviews)
+	}
+	var left models.PracticeSession
+	if err := db.First(&left, "id = ?", session.ID).Error; err != nil || left.Total != 1 {
+		t.Fatalf("session = %+v, %v", left, err)
+	}
+}
@@ -0,0 +1,392 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"E-Bu-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// IntegrityReport is the result of CheckIntegrity and RepairIntegrity. OK is
+// false when anything needs attention.
+type IntegrityReport struct {
+	OK bool `json:"ok"`
+	// SQLite is what PRAGMA integrity_check returned: "ok" or the problems.
+	SQLite             []string `json:"sqlite"`
+	PendingMigrations  int      `json:"pendingMigrations"`
+	ChecksumMismatches []int    `json:"checksumMismatches"`
+	// Drift is only held against the database once no migrations are
+	// pending, since pending migrations are expected to differ.
+	Drift *SchemaDrift `json:"drift"`
+
+	// Checked is the number of question rows validated. Questions lists the
+	// rows that still have problems; after a repair, only what it could not
+	// fix.
+	Checked   int               `json:"checked"`
+	Questions []QuestionProblem `json:"questions"`
+	// Repairs lists every change RepairIntegrity made.
+	Repairs []Repair `json:"repairs,omitempty"`
+}
+
+type QuestionProblem struct {
+	ID       string   `json:"id"`
+	Problems []string `json:"problems"`
+	// Repairable is true when RepairIntegrity can fix every problem.
+	Repairable bool `json:"repairable"`
+}
+
+// Repair is one column RepairIntegrity changed. From keeps the old value
+// (images shortened) so a repair never loses information silently.
+type Repair struct {
+	ID     string      `json:"id"`
+	Field  string      `json:"field"`
+	From   interface{} `json:"from"`
+	To     interface{} `json:"to"`
+	Reason string      `json:"reason"`
+}
+
+// CheckIntegrity checks the database file, the migration records, the
+// schema and every question row without changing anything.
+func (db *DB) CheckIntegrity() (*IntegrityReport, error) {
+	return db.checkIntegrity(false)
+}
+
+// RepairIntegrity runs CheckIntegrity and fixes the question rows it can:
+// malformed options/knowledgePoints JSON, unknown subjects, difficulty out of
+// range, negative review streaks and image data URLs with recoverable
+// encoding problems. All fixes are written in one transaction without
+// touching updated_at. A row changed between the check and the write is
+// left alone and reported as not repaired. Damage found by PRAGMA
+// integrity_check is not repaired; restore a snapshot instead.
+func (db *DB) RepairIntegrity() (*IntegrityReport, error) {
+	return db.checkIntegrity(true)
+}
+
+func (db *DB) checkIntegrity(repair bool) (*IntegrityReport, error) {
+	report := &IntegrityReport{ChecksumMismatches: []int{}, Questions: []QuestionProblem{}}
+
+	sums, err := MigrationChecksums()
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]bool{}
+	if db.Migrator().HasTable(&AppliedMigration{}) {
+		var records []AppliedMigration
+		if err := db.Order("version").Find(&records).Error; err != nil {
+			return nil, err
+		}
+		for _, m := range records {
+			applied[m.Version] = true
+			if want, ok := sums[m.Version]; ok && m.Checksum != "" && m.Checksum != want {
+				report.ChecksumMismatches = append(report.ChecksumMismatches, m.Version)
+			}
+		}
+	}
+	for _, mig := range migrations() {
+		if !applied[mig.Version] {
+			report.PendingMigrations++
+		}
+	}
+
+	if report.Drift, err = CheckSchemaDrift(db.DB); err != nil {
+		return nil, err
+	}
+
+	if db.Migrator().HasTable(&models.Question{}) {
+		if err := db.checkQuestions(report, repair); err != nil {
+			return nil, err
+		}
+	}
+
+	// Checked last so that CHECK constraint failures a repair fixed are not
+	// reported.
+	if err := db.Raw("PRAGMA integrity_check").Scan(&report.SQLite).Error; err != nil {
+		return nil, err
+	}
+
+	report.OK = sqliteOK(report.SQLite) && len(report.ChecksumMismatches) == 0 &&
+		(report.Drift.InSync || report.PendingMigrations > 0) && len(report.Questions) == 0
+	return report, nil
+}
+
+// questionFix is the repair of one question row, worked out from the row
+// as it was read at version.
+type questionFix struct {
+	id        string
+	version   int
+	columns   map[string]interface{}
+	repairs   []Repair
+	problems  []string
+	remaining []string
+}
+
+// checkQuestions validates every question with ValidateQuestion. Fixes are
+// collected while the rows are read and written afterwards, since SQLite
+// will not commit a write while a read is still open. Each write only
+// applies to the version that was read.
+func (db *DB) checkQuestions(report *IntegrityReport, repair bool) error {
+	rows, err := db.Model(&models.Question{}).Order("id").Rows()
+	if err != nil {
+		return err
+	}
+	var fixes []questionFix
+	for rows.Next() {
+		var q models.Question
+		if err := db.ScanRows(rows, &q); err != nil {
+			rows.Close()
+			return err
+		}
+		report.Checked++
+		problems := ValidateQuestion(&q)
+		if len(problems) == 0 {
+			continue
+		}
+
+		repairs, columns := repairQuestion(&q)
+		remaining := ValidateQuestion(&q)
+		if !repair {
+			report.Questions = append(report.Questions, QuestionProblem{ID: q.ID, Problems: problems, Repairable: len(remaining) == 0})
+			continue
+		}
+		if len(columns) > 0 {
+			fixes = append(fixes, questionFix{id: q.ID, version: q.Version, columns: columns, repairs: repairs, problems: problems, remaining: remaining})
+		} else {
+			report.Questions = append(report.Questions, QuestionProblem{ID: q.ID, Problems: remaining})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(fixes) == 0 {
+		return nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var ids []string
+		for _, fix := range fixes {
+			fix.columns["version"] = gorm.Expr("version + 1")
+			result := tx.Model(&models.Question{}).Where("id = ? AND version = ?", fix.id, fix.version).UpdateColumns(fix.columns)
+			if result.Error != nil {
+				return fmt.Errorf("repairing question %s: %w", fix.id, result.Error)
+			}
+			if result.RowsAffected == 0 {
+				// The fix was worked out from values that are gone now.
+				problems := append(fix.problems, "changed while the repair ran; not repaired")
+				report.Questions = append(report.Questions, QuestionProblem{ID: fix.id, Problems: problems})
+				continue
+			}
+			ids = append(ids, fix.id)
+			report.Repairs = append(report.Repairs, fix.repairs...)
+			if len(fix.remaining) > 0 {
+				report.Questions = append(report.Questions, QuestionProblem{ID: fix.id, Problems: fix.remaining})
+			}
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		return recordAudit(tx, AuditIntegrityRepair, ids, map[string]interface{}{"repairs": len(report.Repairs)})
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(report.Questions, func(i, j int) bool { return report.Questions[i].ID < report.Questions[j].ID })
+	for _, r := range report.Repairs {
+		log.Printf("integrity repair: question %s %s: %v -> %v (%s)", r.ID, r.Field, r.From, r.To, r.Reason)
+	}
+	return nil
+}
+
+// subjectAliases maps subject spellings seen in old data to the stored
+// value.
+var subjectAliases = map[string]models.Subject{
+	"math":        models.Math,
+	"mathematics": models.Math,
+	"physics":     models.Physics,
+	"chemistry":   models.Chemistry,
+	"biology":     models.Biology,
+	"english":     models.English,
+	"chinese":     models.Chinese,
+	"other":       models.Other,
+}
+
+// repairQuestion fixes q in place and returns what it changed, both as
+// Repair records and as the column updates to write.
+func repairQuestion(q *models.Question) ([]Repair, map[string]interface{}) {
+	var repairs []Repair
+	columns := map[string]interface{}{}
+	change := func(field string, column string, from interface{}, to interface{}, reason string) {
+		repairs = append(repairs, Repair{ID: q.ID, Field: field, From: from, To: to, Reason: reason})
+		columns[column] = to
+	}
+	changeImage := func(field string, column string, from string, to string) {
+		repairs = append(repairs, Repair{ID: q.ID, Field: field, From: shorten(from), To: shorten(to), Reason: "image data URL re-encoded"})
+		columns[column] = to
+	}
+
+	if !validSubjects[q.Subject] {
+		to, ok := subjectAliases[strings.ToLower(strings.TrimSpace(string(q.Subject)))]
+		if !ok {
+			to = models.Subject(strings.TrimSpace(string(q.Subject)))
+			if !validSubjects[to] {
+				to = models.Other
+			}
+		}
+		change("subject", "subject", q.Subject, to, "unknown subject")
+		q.Subject = to
+	}
+	if q.Difficulty < 1 || q.Difficulty > 5 {
+		to := 1
+		if q.Difficulty > 5 {
+			to = 5
+		}
+		change("difficulty", "difficulty", q.Difficulty, to, "difficulty outside 1-5")
+		q.Difficulty = to
+	}
+	if q.ReviewStreak < 0 {
+		change("reviewStreak", "review_streak", q.ReviewStreak, 0, "negative review streak")
+		q.ReviewStreak = 0
+	}
+
+	if q.KnowledgePoints == nil {
+		to := "[]"
+		change("knowledgePoints", "knowledge_points", nil, to, "missing")
+		q.KnowledgePoints = &to
+	} else if checkStringArrayJSON(*q.KnowledgePoints) != nil {
+		to := salvageStringArray(*q.KnowledgePoints)
+		change("knowledgePoints", "knowledge_points", *q.KnowledgePoints, to, "not a JSON array of strings")
+		q.KnowledgePoints = &to
+	}
+	if q.Options != nil && checkStringArrayJSON(*q.Options) != nil {
+		if s := salvageStringArray(*q.Options); s != "[]" {
+			change("options", "options", *q.Options, s, "not a JSON array of strings")
+			q.Options = &s
+		} else {
+			change("options", "options", *q.Options, nil, "no options left")
+			q.Options = nil
+		}
+	}
+
+	for _, img := range []struct {
+		field, column string
+		value         **string
+	}{
+		{"image", "image", &q.Image},
+		{"croppedDiagram", "cropped_diagram", &q.CroppedDiagram},
+	} {
+		if *img.value == nil || checkImageDataURL(**img.value) == nil {
+			continue
+		}
+		if to, ok := repairImageDataURL(**img.value); ok {
+			changeImage(img.field, img.column, **img.value, to)
+			*img.value = &to
+		}
+	}
+	return repairs, columns
+}
+
+// salvageStringArray turns a malformed options/knowledgePoints value into a
+// JSON array of strings: arrays of other JSON values are stringified, and
+// plain or JSON-quoted text is split on commas, semicolons and newlines.
+func salvageStringArray(s string) string {
+	var items []string
+	var values []interface{}
+	var text string
+	switch {
+	case json.Unmarshal([]byte(s), &values) == nil:
+		for _, v := range values {
+			switch v := v.(type) {
+			case nil:
+			case string:
+				items = append(items, v)
+			case float64, bool:
+				items = append(items, fmt.Sprint(v))
+			default:
+				encoded, _ := json.Marshal(v)
+				items = append(items, string(encoded))
+			}
+		}
+	case json.Unmarshal([]byte(s), &text) == nil:
+		items = splitList(text)
+	default:
+		items = splitList(s)
+	}
+	if items == nil {
+		items = []string{}
+	}
+	encoded, _ := json.Marshal(items)
+	return string(encoded)
+}
+
+func splitList(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return strings.ContainsRune(",，、;；\n", r)
+	})
+	var items []string
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f != "" {
+			items = append(items, f)
+		}
+	}
+	return items
+}
+
+// repairImageDataURL recovers images whose payload is intact but wrapped,
+// URL-safe or unpadded base64, or raw base64 without the data: prefix. It
+// gives up on anything that does not decode to an image.
+func repairImageDataURL(s string) (string, bool) {
+	payload := strings.TrimSpace(s)
+	mediaType := ""
+	if strings.HasPrefix(payload, "data:") {
+		comma := strings.Index(payload, ",")
+		if comma < 0 {
+			return "", false
+		}
+		header := payload[len("data:"):comma]
+		mediaType = strings.TrimSuffix(header, ";base64")
+		payload = payload[comma+1:]
+	}
+	payload = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+			return -1
+		}
+		return r
+	}, payload)
+
+	var data []byte
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := enc.DecodeString(payload); err == nil && len(decoded) > 0 {
+			data = decoded
+			break
+		}
+	}
+	if data == nil {
+		return "", false
+	}
+	if sniffed := http.DetectContentType(data); strings.HasPrefix(sniffed, "image/") {
+		mediaType = sniffed
+	}
+	if !strings.HasPrefix(mediaType, "image/") {
+		return "", false
+	}
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data), true
+}
+
+// shorten keeps image values in repair logs readable.
+func shorten(s string) string {
+	if len(s) <= 64 {
+		return s
+	}
+	return fmt.Sprintf("%s… (%d bytes)", s[:64], len(s))
+}
+
+func sqliteOK(results []string) bool {
+	return len(results) == 1 && strings.EqualFold(results[0], "ok")
+}
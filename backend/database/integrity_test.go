@@ -0,0 +1,199 @@
+package database
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"gorm.io/gorm"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	db := &DB{newTestDB(t)}
+
+	report, err := db.CheckIntegrity()
+	if err != nil {
+		t.Fatalf("CheckIntegrity: %v", err)
+	}
+	if !report.OK || report.PendingMigrations != 0 || !report.Drift.InSync {
+		t.Fatalf("healthy database reported problems: %+v", report)
+	}
+
+	db.Model(&AppliedMigration{}).Where("version = ?", 2).Update("checksum", "edited")
+	db.Exec("ALTER TABLE questions ADD COLUMN notes TEXT")
+	report, err = db.CheckIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK || len(report.ChecksumMismatches) != 1 || report.ChecksumMismatches[0] != 2 || report.Drift.InSync {
+		t.Fatalf("problems not reported: %+v", report)
+	}
+}
+
+func TestCheckIntegrity_LegacyDatabaseIsLeftAlone(t *testing.T) {
+	db, err := Open(copyFixture(t, "unversioned.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDB(t, db)
+
+	report, err := db.CheckIntegrity()
+	if err != nil {
+		t.Fatalf("CheckIntegrity: %v", err)
+	}
+	// The schema differs, but only because migrations are pending.
+	if !report.OK || report.PendingMigrations != len(migrations()) || report.Drift.InSync {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if db.Migrator().HasTable(&AppliedMigration{}) {
+		t.Fatalf("CheckIntegrity created the migrations table")
+	}
+}
+
+// insertRawQuestions writes rows the models would never produce, the way
+// old builds and hand edits left them. Old databases have no difficulty
+// CHECK constraint, so it is switched off for the insert.
+func insertRawQuestions(t *testing.T, db *DB, rows ...map[string]interface{}) {
+	t.Helper()
+	err := db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("PRAGMA ignore_check_constraints = ON").Error; err != nil {
+			return err
+		}
+		defer conn.Exec("PRAGMA ignore_check_constraints = OFF")
+		for _, row := range rows {
+			values := map[string]interface{}{
+				"content": "c", "analysis": "a", "learning_guide": "", "knowledge_points": "[]",
+				"subject": "数学", "difficulty": 1, "created_at": time.Now(), "updated_at": time.Now(), "owner_id": testOwner,
+			}
+			for k, v := range row {
+				values[k] = v
+			}
+			columns := make([]string, 0, len(values))
+			for k := range values {
+				columns = append(columns, k)
+			}
+			sort.Strings(columns)
+			args := make([]interface{}, len(columns))
+			for i, k := range columns {
+				args[i] = values[k]
+			}
+			sql := fmt.Sprintf("INSERT INTO questions (%s) VALUES (?%s)",
+				strings.Join(columns, ", "), strings.Repeat(", ?", len(columns)-1))
+			if err := conn.Exec(sql, args...).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+}
+
+func TestRepairIntegrity(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("p", 40)
+	// No data: prefix, URL-safe alphabet and no padding.
+	bare := base64.RawURLEncoding.EncodeToString([]byte(png))
+	updated := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	insertRawQuestions(t, db,
+		map[string]interface{}{"id": "ok"},
+		map[string]interface{}{"id": "bad-json", "knowledge_points": "函数，导数", "options": `[1, "B", null]`, "updated_at": updated},
+		map[string]interface{}{"id": "bad-fields", "subject": "Math", "difficulty": 9, "review_streak": -2},
+		map[string]interface{}{"id": "bad-image", "image": bare, "cropped_diagram": "data:image/png;base64,%%%"},
+		map[string]interface{}{"id": "no-content", "content": " ", "difficulty": 0},
+	)
+
+	report, err := db.CheckIntegrity()
+	if err != nil {
+		t.Fatalf("CheckIntegrity: %v", err)
+	}
+	if report.OK || report.Checked != 5 || len(report.Questions) != 4 {
+		t.Fatalf("unexpected check report: %+v", report)
+	}
+	repairable := map[string]bool{}
+	for _, p := range report.Questions {
+		repairable[p.ID] = p.Repairable
+	}
+	want := map[string]bool{"bad-json": true, "bad-fields": true, "bad-image": false, "no-content": false}
+	if !reflect.DeepEqual(repairable, want) {
+		t.Fatalf("repairable = %v, want %v", repairable, want)
+	}
+	if q, _ := db.GetQuestionByID(testOwner, "bad-fields"); q.Difficulty != 9 {
+		t.Fatalf("CheckIntegrity changed a row")
+	}
+
+	report, err = db.RepairIntegrity()
+	if err != nil {
+		t.Fatalf("RepairIntegrity: %v", err)
+	}
+	// Left: the unreadable cropped diagram and the empty content.
+	if report.OK || len(report.Questions) != 2 || len(report.Repairs) != 7 || !sqliteOK(report.SQLite) {
+		t.Fatalf("unexpected repair report: %+v", report)
+	}
+
+	q, _ := db.GetQuestionByID(testOwner, "bad-json")
+	if *q.KnowledgePoints != `["函数","导数"]` || *q.Options != `["1","B"]` || !q.UpdatedAt.Equal(updated) {
+		t.Fatalf("JSON not salvaged: kps=%s options=%s updated=%v", *q.KnowledgePoints, *q.Options, q.UpdatedAt)
+	}
+	q, _ = db.GetQuestionByID(testOwner, "bad-fields")
+	if q.Subject != models.Math || q.Difficulty != 5 || q.ReviewStreak != 0 || q.Version != 2 {
+		t.Fatalf("fields not repaired: %+v", q)
+	}
+	q, _ = db.GetQuestionByID(testOwner, "bad-image")
+	if q.Image == nil || checkImageDataURL(*q.Image) != nil || *q.CroppedDiagram != "data:image/png;base64,%%%" {
+		t.Fatalf("images not handled: image=%v cropped=%v", q.Image, q.CroppedDiagram)
+	}
+	q, _ = db.GetQuestionByID(testOwner, "no-content")
+	if q.Difficulty != 1 {
+		t.Fatalf("repairable field on an unrepairable row was not fixed")
+	}
+
+	again, err := db.RepairIntegrity()
+	if err != nil || len(again.Repairs) != 0 || len(again.Questions) != 2 {
+		t.Fatalf("second repair = %+v, %v", again, err)
+	}
+}
+
+func TestRepairIntegrity_RowChangedSinceTheCheck(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	insertRawQuestions(t, db,
+		map[string]interface{}{"id": "a-edited", "difficulty": 9},
+		map[string]interface{}{"id": "b-untouched", "subject": "Math"},
+	)
+
+	// Someone fixes a-edited by hand after the rows were read, just before
+	// the repair writes it.
+	edited := false
+	err := db.Callback().Update().Before("gorm:update").Register("test:edit_first", func(tx *gorm.DB) {
+		if edited {
+			return
+		}
+		edited = true
+		tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE questions SET difficulty = 3, version = version + 1 WHERE id = ?", "a-edited")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := db.RepairIntegrity()
+	if err != nil {
+		t.Fatalf("RepairIntegrity: %v", err)
+	}
+	if len(report.Repairs) != 1 || report.Repairs[0].ID != "b-untouched" || len(report.Questions) != 1 || report.Questions[0].ID != "a-edited" {
+		t.Fatalf("unexpected repair report: %+v", report)
+	}
+	if q, _ := db.GetQuestionByID(testOwner, "a-edited"); q.Difficulty != 3 || q.Version != 2 {
+		t.Fatalf("the hand edit was overwritten: difficulty %d, version %d", q.Difficulty, q.Version)
+	}
+	if q, _ := db.GetQuestionByID(testOwner, "b-untouched"); q.Subject != models.Math {
+		t.Fatalf("b-untouched not repaired: %+v", q)
+	}
+}
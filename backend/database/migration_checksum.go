@@ -0,0 +1,119 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var (
+	checksumOnce sync.Once
+	checksums    map[int]string
+	checksumErr  error
+)
+
+// MigrationChecksums returns the checksum of every migration in this build,
+// keyed by version. It is computed once per process.
+func MigrationChecksums() (map[int]string, error) {
+	checksumOnce.Do(func() {
+		migs, _ := sortedMigrations()
+		checksums, checksumErr = computeMigrationChecksums(migs)
+	})
+	return checksums, checksumErr
+}
+
+// computeMigrationChecksums runs migs in order on an empty scratch database
+// and hashes the statements each one executes, Up and then Down. Migrations
+// are Go functions, so what they run is the only thing that can be
+// compared; whitespace is normalized so reformatting a statement does not
+// count as a change.
+func computeMigrationChecksums(migs []Migration) (map[int]string, error) {
+	scratch, err := openScratchDB()
+	if err != nil {
+		return nil, err
+	}
+	defer closeScratchDB(scratch)
+
+	var recorded []string
+	recording := false
+	err = scratch.Callback().Raw().After("gorm:raw").Register("ebu:record_statements", func(tx *gorm.DB) {
+		if recording && tx.Error == nil {
+			sql := tx.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...)
+			recorded = append(recorded, strings.Join(strings.Fields(sql), " "))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	run := func(step func(*gorm.DB) error) ([]string, error) {
+		recorded, recording = nil, true
+		defer func() { recording = false }()
+		err := step(scratch)
+		return recorded, err
+	}
+
+	sums := make(map[int]string, len(migs))
+	for _, mig := range migs {
+		h := sha256.New()
+		up, err := run(mig.Up)
+		if err != nil {
+			return nil, fmt.Errorf("checksum of migration %d: %w", mig.Version, err)
+		}
+		fmt.Fprintf(h, "up\n%s\n", strings.Join(up, "\n"))
+		if mig.Down != nil {
+			down, err := run(mig.Down)
+			if err != nil {
+				return nil, fmt.Errorf("checksum of migration %d down: %w", mig.Version, err)
+			}
+			fmt.Fprintf(h, "down\n%s\n", strings.Join(down, "\n"))
+			// Put the migration back so the next one starts from the right
+			// schema.
+			if err := mig.Up(scratch); err != nil {
+				return nil, fmt.Errorf("checksum of migration %d: %w", mig.Version, err)
+			}
+		}
+		sums[mig.Version] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
+
+// recordMissingChecksums fills in the checksum of applied migrations that
+// predate checksums. Whatever this build would run is taken as what ran.
+func recordMissingChecksums(db *gorm.DB, sums map[int]string) error {
+	for version, sum := range sums {
+		err := db.Model(&AppliedMigration{}).
+			Where("version = ? AND (checksum IS NULL OR checksum = '')", version).
+			Update("checksum", sum).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openScratchDB opens a private in-memory database. It is limited to one
+// connection, since every connection to :memory: is a separate database.
+func openScratchDB() (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func closeScratchDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
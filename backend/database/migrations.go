@@ -1,8 +1,12 @@
 package database
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -11,15 +15,74 @@ type Migration struct {
 	Version int
 	Name    string
 	Up      func(db *gorm.DB) error
+	// Down undoes Up. Optional: migrations without it cannot be reverted.
+	Down func(db *gorm.DB) error
 }
 
 type AppliedMigration struct {
-	Version int    `gorm:"primaryKey"`
+	// Version 0 is a real migration, so it must never be left to
+	// autoincrement.
+	Version int    `gorm:"primaryKey;autoIncrement:false"`
 	Name    string `gorm:"not null"`
+	// SnapshotPath is the snapshot taken before the run that applied this
+	// migration; migrations applied together share it.
+	SnapshotPath *string    `json:"snapshotPath,omitempty"`
+	AppliedAt    *time.Time `json:"appliedAt,omitempty"`
+	// Checksum is the migration's checksum when it was applied; see
+	// MigrationChecksums.
+	Checksum string `json:"checksum,omitempty"`
+	// Reversible and ChecksumMismatch are filled in by GetMigrationStatus.
+	// A mismatch means the migration was edited after this database ran it.
+	Reversible       bool `json:"reversible" gorm:"-"`
+	ChecksumMismatch bool `json:"checksumMismatch" gorm:"-"`
+}
+
+// baselineSchema is the schema the first release created with AutoMigrate,
+// written out as DDL. Migration 1 starts from it.
+var baselineSchema = []string{
+	"CREATE TABLE IF NOT EXISTS `questions` (`id` varchar(36),`image` text,`cropped_diagram` text,`content` text NOT NULL,`options` text,`diagram_description` text,`answer` text,`analysis` text NOT NULL,`knowledge_points` text NOT NULL,`subject` text NOT NULL,`difficulty` integer NOT NULL DEFAULT 1,`created_at` datetime,`last_reviewed_at` datetime,`deleted_at` datetime,PRIMARY KEY (`id`),CONSTRAINT `chk_questions_difficulty` CHECK (difficulty >= 1 AND difficulty <= 5))",
+	"CREATE TABLE IF NOT EXISTS `ai_configs` (`id` integer PRIMARY KEY AUTOINCREMENT,`type` text DEFAULT \"GEMINI\",`api_key` text,`base_url` text,`model_name` text,`system_prompt` text,`config_data` text)",
+}
+
+// baselineColumns are the nullable baseline columns. AutoMigrate used to add
+// whichever of them a database from an older build lacked, and migration 0
+// keeps doing so.
+var baselineColumns = []struct{ table, name, ddl string }{
+	{"questions", "image", "ALTER TABLE questions ADD COLUMN image text"},
+	{"questions", "cropped_diagram", "ALTER TABLE questions ADD COLUMN cropped_diagram text"},
+	{"questions", "options", "ALTER TABLE questions ADD COLUMN options text"},
+	{"questions", "diagram_description", "ALTER TABLE questions ADD COLUMN diagram_description text"},
+	{"questions", "answer", "ALTER TABLE questions ADD COLUMN answer text"},
+	{"questions", "created_at", "ALTER TABLE questions ADD COLUMN created_at datetime"},
+	{"questions", "last_reviewed_at", "ALTER TABLE questions ADD COLUMN last_reviewed_at datetime"},
+	{"questions", "deleted_at", "ALTER TABLE questions ADD COLUMN deleted_at datetime"},
+	{"ai_configs", "api_key", "ALTER TABLE ai_configs ADD COLUMN api_key text"},
+	{"ai_configs", "base_url", "ALTER TABLE ai_configs ADD COLUMN base_url text"},
+	{"ai_configs", "model_name", "ALTER TABLE ai_configs ADD COLUMN model_name text"},
+	{"ai_configs", "system_prompt", "ALTER TABLE ai_configs ADD COLUMN system_prompt text"},
+	{"ai_configs", "config_data", "ALTER TABLE ai_configs ADD COLUMN config_data text"},
 }
 
 func migrations() []Migration {
 	return []Migration{
+		{
+			Version: 0,
+			Name:    "baseline schema",
+			Up: func(db *gorm.DB) error {
+				// Databases from before versioned migrations already have
+				// these tables; they are brought forward, not recreated.
+				if err := execAll(db, baselineSchema...); err != nil {
+					return err
+				}
+				for _, col := range baselineColumns {
+					if err := addColumnIfMissing(db, col.table, col.name, col.ddl); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			// No Down: there is no schema below the baseline.
+		},
 		{
 			Version: 1,
 			Name:    "ensure questions.learning_guide column",
@@ -31,12 +94,508 @@ func migrations() []Migration {
 				}
 				return db.Exec("ALTER TABLE questions ADD COLUMN learning_guide TEXT NOT NULL DEFAULT ''").Error
 			},
+			// No Down: learning_guide is part of the Question model itself,
+			// so dropping it would leave a database no build can use.
+		},
+		{
+			Version: 2,
+			Name:    "practice sessions and review history",
+			Up: func(db *gorm.DB) error {
+				if err := addColumnIfMissing(db, "questions", "next_review_at", "ALTER TABLE questions ADD COLUMN next_review_at DATETIME"); err != nil {
+					return err
+				}
+				if err := addColumnIfMissing(db, "questions", "review_streak", "ALTER TABLE questions ADD COLUMN review_streak INTEGER NOT NULL DEFAULT 0"); err != nil {
+					return err
+				}
+				return execAll(db,
+					`CREATE TABLE IF NOT EXISTS practice_sessions (
+						id VARCHAR(36) PRIMARY KEY,
+						status TEXT NOT NULL DEFAULT 'active',
+						total INTEGER NOT NULL DEFAULT 0,
+						created_at DATETIME,
+						finished_at DATETIME
+					)`,
+					`CREATE TABLE IF NOT EXISTS practice_attempts (
+						id VARCHAR(36) PRIMARY KEY,
+						session_id VARCHAR(36),
+						question_id VARCHAR(36) NOT NULL,
+						position INTEGER NOT NULL DEFAULT 0,
+						status TEXT NOT NULL DEFAULT 'pending',
+						answer TEXT,
+						grading TEXT NOT NULL DEFAULT '',
+						correct NUMERIC,
+						created_at DATETIME,
+						answered_at DATETIME,
+						graded_at DATETIME
+					)`,
+					"CREATE INDEX IF NOT EXISTS idx_practice_attempts_session_id ON practice_attempts(session_id)",
+					"CREATE INDEX IF NOT EXISTS idx_practice_attempts_question_id ON practice_attempts(question_id)",
+					`CREATE TABLE IF NOT EXISTS review_logs (
+						id INTEGER PRIMARY KEY AUTOINCREMENT,
+						question_id VARCHAR(36) NOT NULL,
+						session_id VARCHAR(36),
+						correct NUMERIC NOT NULL,
+						source TEXT NOT NULL DEFAULT 'practice',
+						interval_days INTEGER NOT NULL DEFAULT 0,
+						reviewed_at DATETIME
+					)`,
+					"CREATE INDEX IF NOT EXISTS idx_review_logs_question_id ON review_logs(question_id)",
+				)
+			},
+			Down: func(db *gorm.DB) error {
+				if err := execAll(db,
+					"DROP TABLE IF EXISTS review_logs",
+					"DROP TABLE IF EXISTS practice_attempts",
+					"DROP TABLE IF EXISTS practice_sessions",
+				); err != nil {
+					return err
+				}
+				return dropColumnsIfExist(db, "questions", "next_review_at", "review_streak")
+			},
+		},
+		{
+			Version: 3,
+			Name:    "AI grading verdicts on practice attempts",
+			Up: func(db *gorm.DB) error {
+				columns := []struct{ name, ddl string }{
+					{"answer_image", "ALTER TABLE practice_attempts ADD COLUMN answer_image TEXT"},
+					{"ai_verdict", "ALTER TABLE practice_attempts ADD COLUMN ai_verdict TEXT"},
+					{"ai_score", "ALTER TABLE practice_attempts ADD COLUMN ai_score INTEGER"},
+					{"ai_explanation", "ALTER TABLE practice_attempts ADD COLUMN ai_explanation TEXT"},
+					{"ai_model", "ALTER TABLE practice_attempts ADD COLUMN ai_model TEXT"},
+					{"ai_raw", "ALTER TABLE practice_attempts ADD COLUMN ai_raw TEXT"},
+				}
+				for _, col := range columns {
+					if err := addColumnIfMissing(db, "practice_attempts", col.name, col.ddl); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Down: func(db *gorm.DB) error {
+				return dropColumnsIfExist(db, "practice_attempts",
+					"answer_image", "ai_verdict", "ai_score", "ai_explanation", "ai_model", "ai_raw")
+			},
+		},
+		{
+			Version: 4,
+			Name:    "variant questions linked to their source",
+			Up: func(db *gorm.DB) error {
+				if err := addColumnIfMissing(db, "questions", "parent_id", "ALTER TABLE questions ADD COLUMN parent_id VARCHAR(36)"); err != nil {
+					return err
+				}
+				if err := addColumnIfMissing(db, "questions", "ai_generated", "ALTER TABLE questions ADD COLUMN ai_generated NUMERIC NOT NULL DEFAULT false"); err != nil {
+					return err
+				}
+				return db.Exec("CREATE INDEX IF NOT EXISTS idx_questions_parent_id ON questions(parent_id)").Error
+			},
+			Down: func(db *gorm.DB) error {
+				// SQLite refuses to drop an indexed column.
+				if err := db.Exec("DROP INDEX IF EXISTS idx_questions_parent_id").Error; err != nil {
+					return err
+				}
+				return dropColumnsIfExist(db, "questions", "parent_id", "ai_generated")
+			},
+		},
+		{
+			Version: 5,
+			Name:    "questions.updated_at for merge imports",
+			Up: func(db *gorm.DB) error {
+				if err := addColumnIfMissing(db, "questions", "updated_at", "ALTER TABLE questions ADD COLUMN updated_at DATETIME"); err != nil {
+					return err
+				}
+				return db.Exec("UPDATE questions SET updated_at = created_at WHERE updated_at IS NULL").Error
+			},
+			Down: func(db *gorm.DB) error {
+				return dropColumnsIfExist(db, "questions", "updated_at")
+			},
+		},
+		{
+			Version: 6,
+			Name:    "user accounts and login sessions",
+			Up: func(db *gorm.DB) error {
+				return execAll(db,
+					`CREATE TABLE IF NOT EXISTS users (
+						id VARCHAR(36) PRIMARY KEY,
+						username TEXT NOT NULL,
+						password_hash TEXT NOT NULL,
+						role TEXT NOT NULL DEFAULT 'user',
+						created_at DATETIME,
+						updated_at DATETIME
+					)`,
+					"CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(username)",
+					`CREATE TABLE IF NOT EXISTS user_sessions (
+						id VARCHAR(64) PRIMARY KEY,
+						user_id VARCHAR(36) NOT NULL,
+						created_at DATETIME,
+						expires_at DATETIME NOT NULL
+					)`,
+					"CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions(user_id)",
+					"CREATE INDEX IF NOT EXISTS idx_user_sessions_expires_at ON user_sessions(expires_at)",
+				)
+			},
+			Down: func(db *gorm.DB) error {
+				return execAll(db,
+					"DROP TABLE IF EXISTS user_sessions",
+					"DROP TABLE IF EXISTS users",
+				)
+			},
+		},
+		{
+			Version: 7,
+			Name:    "per-user ownership of questions, practice and AI configs",
+			Up: func(db *gorm.DB) error {
+				for _, table := range ownedTables {
+					ddl := fmt.Sprintf("ALTER TABLE %s ADD COLUMN owner_id VARCHAR(36)", table)
+					if err := addColumnIfMissing(db, table, "owner_id", ddl); err != nil {
+						return err
+					}
+					index := "CREATE INDEX IF NOT EXISTS idx_%[1]s_owner_id ON %[1]s(owner_id)"
+					if table == "ai_configs" {
+						index = "CREATE UNIQUE INDEX IF NOT EXISTS idx_%[1]s_owner_id ON %[1]s(owner_id)"
+					}
+					if err := db.Exec(fmt.Sprintf(index, table)).Error; err != nil {
+						return err
+					}
+				}
+				// Everything so far belonged to the one mistake book there
+				// was; it goes to the first admin. Without an admin yet, the
+				// rows are adopted by the account first-run setup creates.
+				var admin []string
+				if err := db.Raw("SELECT id FROM users WHERE role = 'admin' ORDER BY created_at, id LIMIT 1").Scan(&admin).Error; err != nil {
+					return err
+				}
+				if len(admin) == 0 {
+					return nil
+				}
+				return adoptUnownedRows(db, admin[0])
+			},
+			Down: func(db *gorm.DB) error {
+				for _, table := range ownedTables {
+					// SQLite refuses to drop an indexed column.
+					if err := db.Exec(fmt.Sprintf("DROP INDEX IF EXISTS idx_%s_owner_id", table)).Error; err != nil {
+						return err
+					}
+					if err := dropColumnsIfExist(db, table, "owner_id"); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
 		},
+		{
+			Version: 8,
+			Name:    "teacher, student and parent roles, classes and assignments",
+			Up: func(db *gorm.DB) error {
+				return execAll(db,
+					// Every account that was not an admin kept a mistake book
+					// of its own: a student.
+					"UPDATE users SET role = 'student' WHERE role = 'user'",
+					`CREATE TABLE IF NOT EXISTS classes (
+						id VARCHAR(36) PRIMARY KEY,
+						name TEXT NOT NULL,
+						teacher_id VARCHAR(36) NOT NULL,
+						created_at DATETIME
+					)`,
+					"CREATE INDEX IF NOT EXISTS idx_classes_teacher_id ON classes(teacher_id)",
+					`CREATE TABLE IF NOT EXISTS class_members (
+						class_id VARCHAR(36) NOT NULL,
+						student_id VARCHAR(36) NOT NULL,
+						created_at DATETIME,
+						PRIMARY KEY (class_id, student_id)
+					)`,
+					"CREATE INDEX IF NOT EXISTS idx_class_members_student_id ON class_members(student_id)",
+					`CREATE TABLE IF NOT EXISTS parent_links (
+						parent_id VARCHAR(36) NOT NULL,
+						student_id VARCHAR(36) NOT NULL,
+						created_at DATETIME,
+						PRIMARY KEY (parent_id, student_id)
+					)`,
+					"CREATE INDEX IF NOT EXISTS idx_parent_links_student_id ON parent_links(student_id)",
+					`CREATE TABLE IF NOT EXISTS assignments (
+						id VARCHAR(36) PRIMARY KEY,
+						class_id VARCHAR(36) NOT NULL,
+						teacher_id VARCHAR(36) NOT NULL,
+						title TEXT NOT NULL,
+						due_at DATETIME NOT NULL,
+						created_at DATETIME
+					)`,
+					"CREATE INDEX IF NOT EXISTS idx_assignments_class_id ON assignments(class_id)",
+					"CREATE INDEX IF NOT EXISTS idx_assignments_teacher_id ON assignments(teacher_id)",
+					`CREATE TABLE IF NOT EXISTS assignment_questions (
+						assignment_id VARCHAR(36) NOT NULL,
+						question_id VARCHAR(36) NOT NULL,
+						position INTEGER NOT NULL DEFAULT 0,
+						PRIMARY KEY (assignment_id, question_id)
+					)`,
+					`CREATE TABLE IF NOT EXISTS assignment_students (
+						assignment_id VARCHAR(36) NOT NULL,
+						student_id VARCHAR(36) NOT NULL,
+						session_id VARCHAR(36) NOT NULL,
+						PRIMARY KEY (assignment_id, student_id)
+					)`,
+					"CREATE INDEX IF NOT EXISTS idx_assignment_students_student_id ON assignment_students(student_id)",
+				)
+			},
+			Down: func(db *gorm.DB) error {
+				return execAll(db,
+					"DROP TABLE IF EXISTS assignment_students",
+					"DROP TABLE IF EXISTS assignment_questions",
+					"DROP TABLE IF EXISTS assignments",
+					"DROP TABLE IF EXISTS parent_links",
+					"DROP TABLE IF EXISTS class_members",
+					"DROP TABLE IF EXISTS classes",
+					"UPDATE users SET role = 'user' WHERE role <> 'admin'",
+				)
+			},
+		},
+		{
+			Version: 9,
+			Name:    "shared collections and question provenance",
+			Up: func(db *gorm.DB) error {
+				if err := addColumnIfMissing(db, "questions", "source_question_id", "ALTER TABLE questions ADD COLUMN source_question_id VARCHAR(36)"); err != nil {
+					return err
+				}
+				if err := addColumnIfMissing(db, "questions", "copied_at", "ALTER TABLE questions ADD COLUMN copied_at DATETIME"); err != nil {
+					return err
+				}
+				return execAll(db,
+					"CREATE INDEX IF NOT EXISTS idx_questions_source_question_id ON questions(source_question_id)",
+					`CREATE TABLE IF NOT EXISTS collections (
+						id VARCHAR(36) PRIMARY KEY,
+						owner_id VARCHAR(36) NOT NULL,
+						name TEXT NOT NULL,
+						description TEXT NOT NULL DEFAULT '',
+						visibility TEXT NOT NULL DEFAULT 'private',
+						class_id VARCHAR(36),
+						created_at DATETIME,
+						updated_at DATETIME
+					)`,
+					"CREATE INDEX IF NOT EXISTS idx_collections_owner_id ON collections(owner_id)",
+					"CREATE INDEX IF NOT EXISTS idx_collections_visibility ON collections(visibility)",
+					"CREATE INDEX IF NOT EXISTS idx_collections_class_id ON collections(class_id)",
+					`CREATE TABLE IF NOT EXISTS collection_questions (
+						collection_id VARCHAR(36) NOT NULL,
+						question_id VARCHAR(36) NOT NULL,
+						position INTEGER NOT NULL DEFAULT 0,
+						created_at DATETIME,
+						PRIMARY KEY (collection_id, question_id)
+					)`,
+					"CREATE INDEX IF NOT EXISTS idx_collection_questions_question_id ON collection_questions(question_id)",
+				)
+			},
+			Down: func(db *gorm.DB) error {
+				if err := execAll(db,
+					"DROP TABLE IF EXISTS collection_questions",
+					"DROP TABLE IF EXISTS collections",
+					// SQLite refuses to drop an indexed column.
+					"DROP INDEX IF EXISTS idx_questions_source_question_id",
+				); err != nil {
+					return err
+				}
+				return dropColumnsIfExist(db, "questions", "source_question_id", "copied_at")
+			},
+		},
+		{
+			Version: 10,
+			Name:    "personal API tokens",
+			Up: func(db *gorm.DB) error {
+				return execAll(db,
+					`CREATE TABLE IF NOT EXISTS api_tokens (
+						id VARCHAR(36) PRIMARY KEY,
+						user_id VARCHAR(36) NOT NULL,
+						name TEXT NOT NULL,
+						token_hash VARCHAR(64) NOT NULL,
+						prefix TEXT NOT NULL,
+						scopes TEXT NOT NULL,
+						expires_at DATETIME,
+						last_used_at DATETIME,
+						created_at DATETIME
+					)`,
+					"CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id)",
+					"CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_token_hash ON api_tokens(token_hash)",
+				)
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Exec("DROP TABLE IF EXISTS api_tokens").Error
+			},
+		},
+		{
+			Version: 11,
+			Name:    "audit log",
+			Up: func(db *gorm.DB) error {
+				return execAll(db,
+					`CREATE TABLE IF NOT EXISTS audit_log (
+						id INTEGER PRIMARY KEY AUTOINCREMENT,
+						at DATETIME NOT NULL,
+						actor_id VARCHAR(36),
+						actor_name TEXT,
+						ip TEXT,
+						user_agent TEXT,
+						action TEXT NOT NULL,
+						target_ids TEXT,
+						target_count INTEGER NOT NULL DEFAULT 0,
+						details TEXT
+					)`,
+					"CREATE INDEX IF NOT EXISTS idx_audit_log_at ON audit_log(at)",
+					"CREATE INDEX IF NOT EXISTS idx_audit_log_actor_id ON audit_log(actor_id)",
+					"CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action)",
+					// The log is append-only.
+					`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+					BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END`,
+					`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+					BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END`,
+				)
+			},
+			// No Down: reverting would erase the log it exists to keep.
+		},
+		{
+			Version: 12,
+			Name:    "question revisions",
+			Up: func(db *gorm.DB) error {
+				return execAll(db,
+					`CREATE TABLE IF NOT EXISTS question_revisions (
+						id VARCHAR(36) PRIMARY KEY,
+						question_id VARCHAR(36) NOT NULL,
+						number INTEGER NOT NULL,
+						owner_id VARCHAR(36) NOT NULL,
+						source TEXT NOT NULL,
+						author_id VARCHAR(36),
+						author_name TEXT,
+						reverted_from INTEGER,
+						snapshot TEXT NOT NULL,
+						created_at DATETIME
+					)`,
+					"CREATE UNIQUE INDEX IF NOT EXISTS idx_question_revisions_number ON question_revisions(question_id, number)",
+					"CREATE INDEX IF NOT EXISTS idx_question_revisions_owner_id ON question_revisions(owner_id)",
+				)
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Exec("DROP TABLE IF EXISTS question_revisions").Error
+			},
+		},
+		{
+			Version: 13,
+			Name:    "question versions",
+			Up: func(db *gorm.DB) error {
+				return addColumnIfMissing(db, "questions", "version", "ALTER TABLE questions ADD COLUMN version INTEGER NOT NULL DEFAULT 1")
+			},
+			Down: func(db *gorm.DB) error {
+				return dropColumnsIfExist(db, "questions", "version")
+			},
+		},
+	}
+}
+
+// ownedTables are the tables whose rows belong to one user, through their
+// owner_id column.
+var ownedTables = []string{"questions", "ai_configs", "practice_sessions", "practice_attempts", "review_logs"}
+
+// adoptUnownedRows gives rows that have no owner yet to ownerID. Only one AI
+// config can be adopted, and only if the owner has none.
+func adoptUnownedRows(db *gorm.DB, ownerID string) error {
+	for _, table := range ownedTables {
+		if table == "ai_configs" {
+			err := db.Exec(`UPDATE ai_configs SET owner_id = ? WHERE id = (
+				SELECT id FROM ai_configs WHERE owner_id IS NULL ORDER BY id LIMIT 1
+			) AND NOT EXISTS (SELECT 1 FROM ai_configs WHERE owner_id = ?)`, ownerID, ownerID).Error
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err := db.Exec(fmt.Sprintf("UPDATE %s SET owner_id = ? WHERE owner_id IS NULL OR owner_id = ''", table), ownerID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing keeps ALTER TABLE ... ADD COLUMN idempotent, since
+// databases from builds that ran AutoMigrate at startup may already have the
+// column.
+func addColumnIfMissing(db *gorm.DB, table string, column string, ddl string) error {
+	if db.Migrator().HasColumn(table, column) {
+		return nil
+	}
+	return db.Exec(ddl).Error
+}
+
+// dropColumnsIfExist is the Down counterpart of addColumnIfMissing.
+func dropColumnsIfExist(db *gorm.DB, table string, columns ...string) error {
+	for _, column := range columns {
+		if !db.Migrator().HasColumn(table, column) {
+			continue
+		}
+		if err := db.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func execAll(db *gorm.DB, statements ...string) error {
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func ensureMigrationsTable(db *gorm.DB) error {
-	return db.AutoMigrate(&AppliedMigration{})
+	if err := db.Exec("CREATE TABLE IF NOT EXISTS `applied_migrations` (`version` integer PRIMARY KEY,`name` text NOT NULL,`snapshot_path` text,`applied_at` datetime,`checksum` text)").Error; err != nil {
+		return err
+	}
+	// Tables created by earlier builds have only version and name.
+	for _, col := range []string{"snapshot_path text", "applied_at datetime", "checksum text"} {
+		name := strings.Fields(col)[0]
+		if err := addColumnIfMissing(db, "applied_migrations", name, "ALTER TABLE applied_migrations ADD COLUMN "+col); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Schema shapes DetectSchemaShape can find.
+const (
+	ShapeEmpty = "empty"
+	// ShapeUnversioned databases have tables but no migration records; they
+	// predate versioned migrations.
+	ShapeUnversioned = "unversioned"
+	// ShapePreBaseline databases have migration records but not the
+	// baseline migration, like every release before it was introduced.
+	ShapePreBaseline = "pre-baseline"
+	ShapeVersioned   = "versioned"
+)
+
+// DetectSchemaShape tells which kind of database db is without changing it.
+// Every shape is brought forward by the normal migration path; the shape is
+// reported so operators know what happened to an old file.
+func DetectSchemaShape(db *gorm.DB) (string, error) {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&AppliedMigration{}) {
+		if migrator.HasTable("questions") || migrator.HasTable("ai_configs") {
+			return ShapeUnversioned, nil
+		}
+		return ShapeEmpty, nil
+	}
+	var versions []int
+	if err := db.Model(&AppliedMigration{}).Pluck("version", &versions).Error; err != nil {
+		return "", err
+	}
+	if len(versions) == 0 {
+		if migrator.HasTable("questions") {
+			return ShapeUnversioned, nil
+		}
+		return ShapeEmpty, nil
+	}
+	for _, v := range versions {
+		if v == 0 {
+			return ShapeVersioned, nil
+		}
+	}
+	return ShapePreBaseline, nil
 }
 
 func LatestMigrationVersion() int {
@@ -58,11 +617,33 @@ type MigrationStatus struct {
 	Latest       int                `json:"latest"`
 	PendingCount int                `json:"pendingCount"`
 	AppliedCount int                `json:"appliedCount"`
+	// ChecksumMismatches counts applied migrations whose code has changed
+	// since they ran.
+	ChecksumMismatches int `json:"checksumMismatches"`
 }
 
 type MigrationInfo struct {
-	Version int    `json:"version"`
-	Name    string `json:"name"`
+	Version    int    `json:"version"`
+	Name       string `json:"name"`
+	Reversible bool   `json:"reversible"`
+}
+
+func (m Migration) info() MigrationInfo {
+	return MigrationInfo{Version: m.Version, Name: m.Name, Reversible: m.Down != nil}
+}
+
+// sortedMigrations returns migrations() in ascending version order, keyed
+// by version as well.
+func sortedMigrations() ([]Migration, map[int]Migration) {
+	migs := migrations()
+	sort.Slice(migs, func(i, j int) bool {
+		return migs[i].Version < migs[j].Version
+	})
+	byVersion := make(map[int]Migration, len(migs))
+	for _, mig := range migs {
+		byVersion[mig.Version] = mig
+	}
+	return migs, byVersion
 }
 
 func GetMigrationStatus(db *gorm.DB, dbPath string) (*MigrationStatus, error) {
@@ -74,26 +655,33 @@ func GetMigrationStatus(db *gorm.DB, dbPath string) (*MigrationStatus, error) {
 	if err := db.Order("version ASC").Find(&applied).Error; err != nil {
 		return nil, err
 	}
+	migs, byVersion := sortedMigrations()
+	sums, err := MigrationChecksums()
+	if err != nil {
+		return nil, err
+	}
+
 	appliedSet := map[int]bool{}
 	current := 0
-	for _, a := range applied {
+	mismatches := 0
+	for i, a := range applied {
 		appliedSet[a.Version] = true
 		if a.Version > current {
 			current = a.Version
 		}
+		applied[i].Reversible = byVersion[a.Version].Down != nil
+		if want, ok := sums[a.Version]; ok && a.Checksum != "" && a.Checksum != want {
+			applied[i].ChecksumMismatch = true
+			mismatches++
+		}
 	}
 
-	migs := migrations()
-	sort.Slice(migs, func(i, j int) bool {
-		return migs[i].Version < migs[j].Version
-	})
-
 	pending := make([]MigrationInfo, 0)
 	for _, mig := range migs {
 		if appliedSet[mig.Version] {
 			continue
 		}
-		pending = append(pending, MigrationInfo{Version: mig.Version, Name: mig.Name})
+		pending = append(pending, mig.info())
 	}
 
 	latest := LatestMigrationVersion()
@@ -105,48 +693,208 @@ func GetMigrationStatus(db *gorm.DB, dbPath string) (*MigrationStatus, error) {
 		Latest:       latest,
 		PendingCount: len(pending),
 		AppliedCount: len(applied),
+
+		ChecksumMismatches: mismatches,
 	}, nil
 }
 
+var ErrIrreversibleMigration = errors.New("migration cannot be reverted")
+
+type MigrateOptions struct {
+	// SnapshotDir, when set, receives a snapshot of the database before the
+	// first migration runs, in either direction.
+	SnapshotDir string
+	// snapshotPath is set when the caller already took the snapshot.
+	snapshotPath string
+}
+
+type MigrateResult struct {
+	Applied  []MigrationInfo `json:"applied"`
+	Reverted []MigrationInfo `json:"reverted"`
+	// SnapshotPath is the snapshot taken before this run, if any.
+	SnapshotPath string `json:"snapshotPath,omitempty"`
+}
+
 func ApplyMigrationsToLatest(db *gorm.DB) ([]MigrationInfo, error) {
-	if err := ensureMigrationsTable(db); err != nil {
+	return ApplyMigrations(db, MigrateOptions{})
+}
+
+// ApplyMigrations applies every pending migration, each in its own
+// transaction, and records the pre-migration snapshot path on each.
+func ApplyMigrations(db *gorm.DB, opts MigrateOptions) ([]MigrationInfo, error) {
+	result, err := MigrateTo(db, LatestMigrationVersion(), opts)
+	if result == nil {
 		return nil, err
 	}
+	return result.Applied, err
+}
 
-	// Ensure we still apply legacy one-off sqlite fixes for installations
-	// that never created schema_migrations before.
-	migs := migrations()
-	sort.Slice(migs, func(i, j int) bool {
-		return migs[i].Version < migs[j].Version
-	})
+// MigrateTo moves the schema to version target: pending migrations up to
+// target are applied in ascending order, applied migrations above it are
+// reverted in descending order. Every step runs in its own transaction. A
+// downgrade is refused up front, before anything runs, when a migration in
+// the way has no Down.
+func MigrateTo(db *gorm.DB, target int, opts MigrateOptions) (*MigrateResult, error) {
+	latest := LatestMigrationVersion()
+	if target < 0 || target > latest {
+		return nil, fmt.Errorf("target version %d is outside 0-%d", target, latest)
+	}
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+	sums, err := MigrationChecksums()
+	if err != nil {
+		return nil, err
+	}
+	if err := recordMissingChecksums(db, sums); err != nil {
+		return nil, err
+	}
 
-	appliedSet := map[int]bool{}
+	migs, byVersion := sortedMigrations()
 	var applied []AppliedMigration
-	if err := db.Find(&applied).Error; err != nil {
+	if err := db.Order("version DESC").Find(&applied).Error; err != nil {
 		return nil, err
 	}
+	appliedSet := map[int]bool{}
+	var down []Migration
 	for _, a := range applied {
 		appliedSet[a.Version] = true
+		if a.Version <= target {
+			continue
+		}
+		mig, ok := byVersion[a.Version]
+		if !ok {
+			return nil, fmt.Errorf("%w: migration %d is not known to this build", ErrIrreversibleMigration, a.Version)
+		}
+		if mig.Down == nil {
+			return nil, fmt.Errorf("%w: migration %d (%s) has no down step", ErrIrreversibleMigration, mig.Version, mig.Name)
+		}
+		down = append(down, mig)
 	}
-
-	appliedNow := make([]MigrationInfo, 0)
+	var up []Migration
 	for _, mig := range migs {
-		if appliedSet[mig.Version] {
-			continue
+		if mig.Version <= target && !appliedSet[mig.Version] {
+			up = append(up, mig)
 		}
+	}
 
+	result := &MigrateResult{Applied: make([]MigrationInfo, 0), Reverted: make([]MigrationInfo, 0), SnapshotPath: opts.snapshotPath}
+	if len(up)+len(down) == 0 {
+		return result, nil
+	}
+	if result.SnapshotPath == "" && opts.SnapshotDir != "" {
+		path, err := takePreMigrationSnapshot(db, opts.SnapshotDir)
+		if err != nil {
+			return result, fmt.Errorf("pre-migration snapshot failed, no migration was run: %w", err)
+		}
+		result.SnapshotPath = path
+	}
+
+	for _, mig := range down {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := mig.Down(tx); err != nil {
+				return err
+			}
+			if err := tx.Delete(&AppliedMigration{}, mig.Version).Error; err != nil {
+				return err
+			}
+			return recordMigration(tx, AuditMigrationRevert, mig)
+		})
+		if err != nil {
+			return result, fmt.Errorf("reverting migration %d failed: %w", mig.Version, err)
+		}
+		result.Reverted = append(result.Reverted, mig.info())
+	}
+
+	for _, mig := range up {
 		err := db.Transaction(func(tx *gorm.DB) error {
 			if err := mig.Up(tx); err != nil {
 				return err
 			}
-			return tx.Create(&AppliedMigration{Version: mig.Version, Name: mig.Name}).Error
+			now := time.Now()
+			record := &AppliedMigration{Version: mig.Version, Name: mig.Name, AppliedAt: &now, Checksum: sums[mig.Version]}
+			if result.SnapshotPath != "" {
+				record.SnapshotPath = &result.SnapshotPath
+			}
+			if err := tx.Create(record).Error; err != nil {
+				return err
+			}
+			return recordMigration(tx, AuditMigrationApply, mig)
 		})
 		if err != nil {
-			return appliedNow, fmt.Errorf("migration %d failed: %w", mig.Version, err)
+			return result, fmt.Errorf("migration %d failed: %w", mig.Version, err)
+		}
+		result.Applied = append(result.Applied, mig.info())
+	}
+
+	return result, nil
+}
+
+// recordMigration audits a migration step in its transaction. Steps taken
+// while the database has no audit log, before migration 11 or after it was
+// reverted, go unrecorded.
+func recordMigration(tx *gorm.DB, action string, mig Migration) error {
+	if !tx.Migrator().HasTable("audit_log") {
+		return nil
+	}
+	return recordAudit(tx, action, nil, map[string]interface{}{"version": mig.Version, "name": mig.Name})
+}
+
+// HasPendingMigrations reports whether any migration has not been applied.
+func HasPendingMigrations(db *gorm.DB) (bool, error) {
+	if !db.Migrator().HasTable(&AppliedMigration{}) {
+		return true, nil
+	}
+	var versions []int
+	if err := db.Model(&AppliedMigration{}).Pluck("version", &versions).Error; err != nil {
+		return false, err
+	}
+	applied := map[int]bool{}
+	for _, v := range versions {
+		applied[v] = true
+	}
+	for _, mig := range migrations() {
+		if !applied[mig.Version] {
+			return true, nil
 		}
+	}
+	return false, nil
+}
 
-		appliedNow = append(appliedNow, MigrationInfo{Version: mig.Version, Name: mig.Name})
+// PreMigrationSnapshot finds the applied migration whose pre-migration
+// snapshot should be restored: version, or the newest one with a snapshot
+// when version is 0. It fails with ErrSnapshotNotFound when there is none or
+// the file is gone.
+func PreMigrationSnapshot(db *gorm.DB, version int) (*AppliedMigration, error) {
+	if !db.Migrator().HasTable(&AppliedMigration{}) {
+		return nil, ErrSnapshotNotFound
+	}
+	query := db.Where("snapshot_path IS NOT NULL AND snapshot_path <> ''")
+	if version > 0 {
+		query = query.Where("version = ?", version)
 	}
+	var found []AppliedMigration
+	if err := query.Order("version DESC").Limit(1).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, ErrSnapshotNotFound
+	}
+	if !fileExists(*found[0].SnapshotPath) {
+		return nil, fmt.Errorf("%w: %s was deleted", ErrSnapshotNotFound, *found[0].SnapshotPath)
+	}
+	return &found[0], nil
+}
 
-	return appliedNow, nil
+// takePreMigrationSnapshot snapshots the database into dir and returns the
+// snapshot's path. A brand-new database has nothing to protect and returns "".
+func takePreMigrationSnapshot(db *gorm.DB, dir string) (string, error) {
+	if !db.Migrator().HasTable("questions") {
+		return "", nil
+	}
+	snap, err := (&DB{db}).CreateSnapshot(dir, SnapshotPreMigration)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, snap.Name), nil
 }
@@ -1,12 +1,21 @@
 package database
 
 import (
+	"errors"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// testOwner owns the questions tests create. owner_id is not a foreign key,
+// so no user row is needed.
+const testOwner = "test-owner"
+
 func newTestDB(t *testing.T) *gorm.DB {
 	t.Helper()
 	dsn := filepath.Join(t.TempDir(), "test.db")
@@ -47,3 +56,468 @@ func TestGetMigrationStatus(t *testing.T) {
 		t.Fatalf("expected dbPath propagated")
 	}
 }
+
+func TestPreMigrationSnapshot_RecordedAndRestorable(t *testing.T) {
+	dir := t.TempDir()
+	dsn := filepath.Join(dir, "ebu.db")
+	snapDir := filepath.Join(dir, "snapshots")
+
+	// A fresh database has nothing to protect.
+	first, err := NewDBWithOptions(dsn, Options{SnapshotDir: snapDir})
+	if err != nil {
+		t.Fatalf("NewDBWithOptions: %v", err)
+	}
+	if snaps, _ := ListSnapshots(snapDir); len(snaps) != 0 {
+		t.Fatalf("fresh database should not be snapshotted, got %d", len(snaps))
+	}
+	q := backupQuestion("before", "before", time.Now())
+	if err := first.CreateQuestion(testOwner, &q); err != nil {
+		t.Fatalf("CreateQuestion: %v", err)
+	}
+	// Pretend the latest migration has not been applied yet.
+	latest := LatestMigrationVersion()
+	first.Where("version = ?", latest).Delete(&AppliedMigration{})
+	closeDB(t, first)
+
+	db, err := NewDBWithOptions(dsn, Options{SnapshotDir: snapDir})
+	if err != nil {
+		t.Fatalf("NewDBWithOptions with pending migration: %v", err)
+	}
+	var record AppliedMigration
+	db.First(&record, "version = ?", latest)
+	if record.SnapshotPath == nil {
+		t.Fatalf("migration %d has no snapshot recorded", latest)
+	}
+	snaps, _ := ListSnapshots(snapDir)
+	if len(snaps) != 1 || snaps[0].Kind != SnapshotPreMigration || snaps[0].SchemaVersion != latest-1 {
+		t.Fatalf("unexpected snapshots: %+v", snaps)
+	}
+
+	// Write after the migration, then roll the run back.
+	after := backupQuestion("after", "after", time.Now())
+	if err := db.CreateQuestion(testOwner, &after); err != nil {
+		t.Fatalf("CreateQuestion: %v", err)
+	}
+	migration, safety, err := NewSnapshotter(db, SnapshotConfig{Dir: snapDir}).RestoreBeforeMigration(0)
+	if err != nil {
+		t.Fatalf("RestoreBeforeMigration: %v", err)
+	}
+	if migration.Version != latest || safety.Kind != SnapshotPreRestore {
+		t.Fatalf("restored the wrong snapshot: %+v / %+v", migration, safety)
+	}
+	if all, _ := db.GetQuestions(testOwner); len(all) != 1 || all[0].ID != "before" {
+		t.Fatalf("database not rolled back: %d questions", len(all))
+	}
+	if pending, _ := HasPendingMigrations(db.DB); !pending {
+		t.Fatalf("migration %d should be pending again after the restore", latest)
+	}
+	if restores, _ := db.ListAuditEntries(AuditFilter{Action: AuditMigrationRestore}, 1, 50); restores.Total != 1 ||
+		restores.Items[0].Details["version"] != float64(latest) {
+		t.Fatalf("restore not audited: %+v", restores.Items)
+	}
+	if _, err := PreMigrationSnapshot(db.DB, 0); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Fatalf("restored database predates the snapshot record, got %v", err)
+	}
+}
+
+func closeDB(t *testing.T, db *DB) {
+	t.Helper()
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+}
+
+// schemaShape lists every table's sorted columns and every index name, so
+// two databases can be compared regardless of column order.
+func schemaShape(t *testing.T, db *gorm.DB) map[string]string {
+	t.Helper()
+	var objects []schemaObject
+	if err := db.Raw(`SELECT type, name, tbl_name, sql FROM sqlite_master
+		WHERE name NOT LIKE 'sqlite_%' AND name != 'applied_migrations'`).Scan(&objects).Error; err != nil {
+		t.Fatal(err)
+	}
+	shape := map[string]string{}
+	for _, obj := range objects {
+		if obj.Type != "table" {
+			shape[obj.Type+" "+obj.Name] = obj.TblName
+			continue
+		}
+		var columns []string
+		if err := db.Raw("SELECT name FROM pragma_table_info(?) ORDER BY name", obj.Name).Scan(&columns).Error; err != nil {
+			t.Fatal(err)
+		}
+		shape["table "+obj.Name] = strings.Join(columns, ",")
+	}
+	return shape
+}
+
+func TestMigrations_DownAndUpOnScratchDB(t *testing.T) {
+	// Migration 11 (the audit log) cannot be reverted, so the down steps are
+	// checked above it on a current database and below it on one that was
+	// only migrated to 10.
+	below, err := Open(filepath.Join(t.TempDir(), "below.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateTo(below.DB, 10, MigrateOptions{}); err != nil {
+		t.Fatalf("MigrateTo(10): %v", err)
+	}
+	if err := below.Exec(`INSERT INTO questions (id, content, analysis, learning_guide, knowledge_points, subject, difficulty, created_at, updated_at)
+		VALUES ('kept', 'kept', 'a', 'l', '[]', '数学', 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`).Error; err != nil {
+		t.Fatal(err)
+	}
+	above := newTestDB(t)
+	q := backupQuestion("kept", "kept", time.Now())
+	if err := (&DB{above}).CreateQuestion(testOwner, &q); err != nil {
+		t.Fatalf("CreateQuestion: %v", err)
+	}
+
+	for _, stretch := range []struct {
+		db          *gorm.DB
+		top, bottom int
+	}{
+		{above, LatestMigrationVersion(), 11},
+		{below.DB, 10, 1},
+	} {
+		db := stretch.db
+		original := schemaShape(t, db)
+
+		// Step down one migration at a time; each down step must leave a
+		// schema that differs from the one above it and keep the data
+		// readable.
+		previous := original
+		for version := stretch.top - 1; version >= stretch.bottom; version-- {
+			result, err := MigrateTo(db, version, MigrateOptions{})
+			if err != nil {
+				t.Fatalf("MigrateTo(%d): %v", version, err)
+			}
+			if len(result.Reverted) != 1 || result.Reverted[0].Version != version+1 || len(result.Applied) != 0 {
+				t.Fatalf("MigrateTo(%d) = %+v", version, result)
+			}
+			status, err := GetMigrationStatus(db, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Current != version || len(status.Pending) != LatestMigrationVersion()-version {
+				t.Fatalf("after MigrateTo(%d): current %d, %d pending", version, status.Current, len(status.Pending))
+			}
+			shape := schemaShape(t, db)
+			if reflect.DeepEqual(shape, previous) {
+				t.Fatalf("reverting migration %d left the schema unchanged", version+1)
+			}
+			previous = shape
+
+			var count int64
+			if err := db.Table("questions").Where("id = ?", "kept").Count(&count).Error; err != nil || count != 1 {
+				t.Fatalf("question lost after reverting to %d: count=%d err=%v", version, count, err)
+			}
+		}
+
+		// Back up in one run restores exactly the schema the chain built.
+		result, err := MigrateTo(db, stretch.top, MigrateOptions{})
+		if err != nil {
+			t.Fatalf("MigrateTo(%d): %v", stretch.top, err)
+		}
+		if len(result.Applied) != stretch.top-stretch.bottom {
+			t.Fatalf("expected %d migrations applied, got %+v", stretch.top-stretch.bottom, result.Applied)
+		}
+		if shape := schemaShape(t, db); !reflect.DeepEqual(shape, original) {
+			t.Fatalf("schema after down/up differs:\n got %v\nwant %v", shape, original)
+		}
+	}
+
+	// The audit log stays, whatever asks for it to go.
+	if _, err := MigrateTo(above, 10, MigrateOptions{}); !errors.Is(err, ErrIrreversibleMigration) {
+		t.Fatalf("MigrateTo(10) error = %v, want ErrIrreversibleMigration", err)
+	}
+}
+
+func TestMigrateTo_RefusesIrreversibleDowngrade(t *testing.T) {
+	db := newTestDB(t)
+	before := schemaShape(t, db)
+
+	status, err := GetMigrationStatus(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range status.Applied {
+		if want := m.Version > 1 && m.Version != 11; m.Reversible != want {
+			t.Fatalf("migration %d reversible = %v, want %v", m.Version, m.Reversible, want)
+		}
+	}
+
+	if _, err := MigrateTo(db, 0, MigrateOptions{}); !errors.Is(err, ErrIrreversibleMigration) {
+		t.Fatalf("MigrateTo(0) error = %v, want ErrIrreversibleMigration", err)
+	}
+	if _, err := MigrateTo(db, LatestMigrationVersion()+1, MigrateOptions{}); err == nil {
+		t.Fatalf("MigrateTo past the latest version should fail")
+	}
+	if shape := schemaShape(t, db); !reflect.DeepEqual(shape, before) {
+		t.Fatalf("refused downgrade changed the schema")
+	}
+	if after, _ := GetMigrationStatus(db, ""); after.Current != status.Current {
+		t.Fatalf("refused downgrade changed the version: %d -> %d", status.Current, after.Current)
+	}
+}
+
+func TestMigrationChecksums_RecordedAndMismatchFlagged(t *testing.T) {
+	db := newTestDB(t)
+	sums, err := MigrationChecksums()
+	if err != nil {
+		t.Fatalf("MigrationChecksums: %v", err)
+	}
+
+	status, err := GetMigrationStatus(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range status.Applied {
+		if m.Checksum == "" || m.Checksum != sums[m.Version] || m.ChecksumMismatch {
+			t.Fatalf("migration %d checksum %q, want %q", m.Version, m.Checksum, sums[m.Version])
+		}
+	}
+
+	// Simulate migration 3 having been edited after it ran, and migration 4
+	// having been applied before checksums existed.
+	db.Model(&AppliedMigration{}).Where("version = ?", 3).Update("checksum", "edited")
+	db.Model(&AppliedMigration{}).Where("version = ?", 4).Update("checksum", "")
+	status, _ = GetMigrationStatus(db, "")
+	if status.ChecksumMismatches != 1 {
+		t.Fatalf("expected 1 mismatch, got %d", status.ChecksumMismatches)
+	}
+	for _, m := range status.Applied {
+		if m.ChecksumMismatch != (m.Version == 3) {
+			t.Fatalf("migration %d mismatch = %v", m.Version, m.ChecksumMismatch)
+		}
+	}
+
+	// Migrating records missing checksums but leaves mismatches for a human.
+	if _, err := ApplyMigrationsToLatest(db); err != nil {
+		t.Fatal(err)
+	}
+	var edited, backfilled AppliedMigration
+	db.First(&edited, "version = ?", 3)
+	db.First(&backfilled, "version = ?", 4)
+	if edited.Checksum != "edited" || backfilled.Checksum != sums[4] {
+		t.Fatalf("unexpected checksums after migrate: %q, %q", edited.Checksum, backfilled.Checksum)
+	}
+}
+
+func TestComputeMigrationChecksums_TracksStatements(t *testing.T) {
+	migs, _ := sortedMigrations()
+	base, err := computeMigrationChecksums(migs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withUp := func(version int, up func(db *gorm.DB) error) []Migration {
+		edited := append([]Migration(nil), migs...)
+		for i := range edited {
+			if edited[i].Version == version {
+				edited[i].Up = up
+			}
+		}
+		return edited
+	}
+	// Migration 5 stands in for any migration: the edits below are variants
+	// of its Up.
+	const edited = 5
+
+	reformatted, err := computeMigrationChecksums(withUp(edited, func(db *gorm.DB) error {
+		if err := addColumnIfMissing(db, "questions", "updated_at", "ALTER TABLE questions\n\tADD COLUMN updated_at DATETIME"); err != nil {
+			return err
+		}
+		return db.Exec("UPDATE questions   SET updated_at = created_at WHERE updated_at IS NULL").Error
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reformatted[edited] != base[edited] {
+		t.Fatalf("whitespace-only edit changed the checksum")
+	}
+
+	changed, err := computeMigrationChecksums(withUp(edited, func(db *gorm.DB) error {
+		return addColumnIfMissing(db, "questions", "updated_at", "ALTER TABLE questions ADD COLUMN updated_at TEXT")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed[edited] == base[edited] {
+		t.Fatalf("edited migration kept its checksum")
+	}
+	for _, mig := range migs {
+		if mig.Version != edited && changed[mig.Version] != base[mig.Version] {
+			t.Fatalf("editing migration %d changed the checksum of %d", edited, mig.Version)
+		}
+	}
+}
+
+func TestCheckSchemaDrift(t *testing.T) {
+	db := newTestDB(t)
+
+	drift, err := CheckSchemaDrift(db)
+	if err != nil {
+		t.Fatalf("CheckSchemaDrift: %v", err)
+	}
+	if !drift.InSync {
+		t.Fatalf("fresh database drifted: %+v", drift)
+	}
+
+	if err := execAll(db,
+		"ALTER TABLE questions ADD COLUMN notes TEXT",
+		"DROP INDEX idx_questions_parent_id",
+		"ALTER TABLE review_logs DROP COLUMN interval_days",
+		"CREATE TABLE scratch (id INTEGER)",
+	); err != nil {
+		t.Fatal(err)
+	}
+	drift, err = CheckSchemaDrift(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &SchemaDrift{
+		MissingTables: []string{},
+		ExtraTables:   []string{"scratch"},
+		Tables: []TableDrift{
+			{Table: "questions", ExtraColumns: []string{"notes"}, MissingIndexes: []string{"idx_questions_parent_id"}},
+			{Table: "review_logs", MissingColumns: []string{"interval_days"}},
+		},
+	}
+	if !reflect.DeepEqual(drift, want) {
+		t.Fatalf("drift = %+v, want %+v", drift, want)
+	}
+}
+
+// copyFixture copies a database from testdata/legacy into a temp dir, so
+// tests never migrate the checked-in file.
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "legacy", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// The fixtures are databases as older builds left them:
+//   - unversioned.db: from before versioned migrations; no learning_guide,
+//     no ai_configs.config_data and no applied_migrations table.
+//   - release-20260106.db: written by the 20260105/20260106 releases
+//     (AutoMigrate plus migration 1), with one question in the trash.
+//   - automigrate-v5.db: written by the last build that still ran
+//     AutoMigrate at startup, at migration 5.
+func TestNewDB_BringsLegacyDatabasesForward(t *testing.T) {
+	cases := []struct {
+		file       string
+		shape      string
+		questionID string
+		guide      string
+		model      string
+		trash      int
+		// backfilled is set when updated_at comes from migration 5.
+		backfilled bool
+	}{
+		{"unversioned.db", ShapeUnversioned, "q-unversioned-1", "", "gpt-4o-mini", 0, true},
+		{"release-20260106.db", ShapePreBaseline, "q-release-1", "先通分", "gemini-1.5-flash", 1, true},
+		{"automigrate-v5.db", ShapePreBaseline, "q-v5-1", "l", "", 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.file, func(t *testing.T) {
+			path := copyFixture(t, tc.file)
+			raw, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if shape, err := DetectSchemaShape(raw.DB); err != nil || shape != tc.shape {
+				t.Fatalf("DetectSchemaShape = %q, %v; want %q", shape, err, tc.shape)
+			}
+			closeDB(t, raw)
+
+			snapDir := filepath.Join(t.TempDir(), "snapshots")
+			db, err := NewDBWithOptions(path, Options{SnapshotDir: snapDir})
+			if err != nil {
+				t.Fatalf("NewDBWithOptions: %v", err)
+			}
+			if shape, _ := DetectSchemaShape(db.DB); shape != ShapeVersioned {
+				t.Fatalf("shape after migrating = %q", shape)
+			}
+			status, err := GetMigrationStatus(db.DB, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Current != LatestMigrationVersion() || status.PendingCount != 0 || status.ChecksumMismatches != 0 {
+				t.Fatalf("unexpected status: %+v", status)
+			}
+			drift, err := CheckSchemaDrift(db.DB)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !drift.InSync {
+				t.Fatalf("schema drift after migrating: %+v", drift)
+			}
+			if snaps, _ := ListSnapshots(snapDir); len(snaps) != 1 || snaps[0].Kind != SnapshotPreMigration {
+				t.Fatalf("expected one pre-migration snapshot, got %+v", snaps)
+			}
+
+			// Rows from before accounts belong to whoever completes setup.
+			admin, err := db.CreateFirstAdmin("admin", "correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := db.GetQuestionByID(admin.ID, tc.questionID)
+			if err != nil {
+				t.Fatalf("question %s lost: %v", tc.questionID, err)
+			}
+			if q.LearningGuide != tc.guide || q.UpdatedAt.IsZero() || q.UpdatedAt.Equal(q.CreatedAt) != tc.backfilled {
+				t.Fatalf("question not brought forward: %+v", q)
+			}
+			if trash, _ := db.GetTrash(admin.ID); len(trash) != tc.trash {
+				t.Fatalf("expected %d questions in the trash, got %d", tc.trash, len(trash))
+			}
+			if config, err := db.GetAIConfig(admin.ID); err != nil || config.ModelName != tc.model {
+				t.Fatalf("AI config not kept: %+v, %v", config, err)
+			}
+
+			// A migrated database opens as-is the next time.
+			closeDB(t, db)
+			again, err := NewDBWithOptions(path, Options{SnapshotDir: snapDir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer closeDB(t, again)
+			if snaps, _ := ListSnapshots(snapDir); len(snaps) != 1 {
+				t.Fatalf("reopening a current database took another snapshot")
+			}
+		})
+	}
+}
+
+func TestNewDB_FreshDatabaseUsesMigrationsOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fresh.db")
+	raw, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shape, _ := DetectSchemaShape(raw.DB); shape != ShapeEmpty {
+		t.Fatalf("new file shape = %q", shape)
+	}
+	closeDB(t, raw)
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var baseline AppliedMigration
+	if err := db.First(&baseline, "version = ?", 0).Error; err != nil || baseline.Name != "baseline schema" {
+		t.Fatalf("baseline migration not recorded: %+v, %v", baseline, err)
+	}
+	if drift, err := CheckSchemaDrift(db.DB); err != nil || !drift.InSync {
+		t.Fatalf("fresh database drifted: %+v, %v", drift, err)
+	}
+}
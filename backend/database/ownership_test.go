@@ -0,0 +1,155 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"gorm.io/gorm"
+)
+
+func TestOwnership_OtherUsersDataIsInvisible(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	const alice, bob = "alice", "bob"
+
+	q := backupQuestion("q-alice", "alice's question", time.Now())
+	if err := db.CreateQuestion(alice, &q); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateQuestion("", &models.Question{Content: "nobody's"}); !errors.Is(err, ErrNoOwner) {
+		t.Fatalf("CreateQuestion without an owner err = %v", err)
+	}
+	if err := db.CreateVariantQuestions(alice, q.ID, []models.Question{backupQuestion("", "variant", time.Now())}); err != nil {
+		t.Fatal(err)
+	}
+
+	if all, _ := db.GetQuestions(bob); len(all) != 0 {
+		t.Fatalf("bob sees %d of alice's questions", len(all))
+	}
+	if page, _ := db.GetQuestionsPagedFiltered(bob, "", "alice", "", 1, 20); page.Total != 0 {
+		t.Fatalf("bob's search found alice's questions: %+v", page)
+	}
+	if _, err := db.GetQuestionByID(bob, q.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("bob reads alice's question: %v", err)
+	}
+	if variants, _ := db.GetVariants(bob, q.ID); len(variants) != 0 {
+		t.Fatalf("bob sees alice's variants")
+	}
+	if err := db.CreateVariantQuestions(bob, q.ID, []models.Question{{Content: "hijack"}}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("bob added variants to alice's question: %v", err)
+	}
+
+	db.UpdateQuestion(bob, q.ID, &models.Question{Content: "bob was here"})
+	db.DeleteQuestion(bob, q.ID)
+	db.HardDeleteQuestion(bob, q.ID)
+	got, err := db.GetQuestionByID(alice, q.ID)
+	if err != nil || got.Content != "alice's question" || got.DeletedAt != nil {
+		t.Fatalf("bob changed alice's question: %+v, %v", got, err)
+	}
+	if variants, _ := db.GetVariants(alice, q.ID); len(variants) != 1 {
+		t.Fatalf("alice lost her variant")
+	}
+
+	if _, err := db.CreatePracticeSession(bob, []string{q.ID}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("bob practised alice's question: %v", err)
+	}
+	session, err := db.CreatePracticeSession(alice, []string{q.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetPracticeSession(bob, session.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("bob reads alice's session: %v", err)
+	}
+	correct := true
+	if _, _, err := db.AnswerPracticeAttempt(bob, session.ID, q.ID, "x", nil, &correct); err == nil {
+		t.Fatalf("bob answered in alice's session")
+	}
+	if _, _, err := db.AnswerPracticeAttempt(alice, session.ID, q.ID, "x", nil, &correct); err != nil {
+		t.Fatal(err)
+	}
+	if logs, _ := db.GetReviewLogs(bob, q.ID); len(logs) != 0 {
+		t.Fatalf("bob sees alice's review log")
+	}
+	if logs, _ := db.GetReviewLogs(alice, q.ID); len(logs) != 1 {
+		t.Fatalf("alice has %d review logs, want 1", len(logs))
+	}
+
+	if err := db.SaveAIConfig(alice, &models.AIConfig{Type: models.OpenAI, ModelName: "alice-model"}); err != nil {
+		t.Fatal(err)
+	}
+	if config, _ := db.GetAIConfig(bob); config.ModelName != "" || config.Type != models.Gemini {
+		t.Fatalf("bob got alice's AI config: %+v", config)
+	}
+}
+
+func TestOwnership_ImportAndExport(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	const alice, bob = "alice", "bob"
+	q := backupQuestion("shared-id", "alice's question", time.Now())
+	if err := db.CreateQuestion(alice, &q); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if n, err := db.ExportBackupJSON(bob, &buf); err != nil || n != 0 || strings.Contains(buf.String(), "alice") {
+		t.Fatalf("bob's export holds alice's data: %d, %v", n, err)
+	}
+
+	// Merging a record with alice's ID inserts a copy for bob instead of
+	// overwriting alice's question, and replace mode leaves her alone.
+	incoming := []models.Question{backupQuestion("shared-id", "bob's question", time.Now().Add(time.Hour))}
+	preview, err := db.PreviewImport(bob, incoming, ImportOptions{Mode: ImportReplace})
+	if err != nil || preview.WouldDelete != 0 {
+		t.Fatalf("preview for bob would delete alice's data: %+v, %v", preview, err)
+	}
+	report, err := db.ImportQuestions(bob, incoming, ImportOptions{Mode: ImportMerge, Conflict: ConflictOverwrite})
+	if err != nil || report.Inserted != 1 {
+		t.Fatalf("merge import: %+v, %v", report, err)
+	}
+	if _, err := db.ImportQuestions(bob, incoming, ImportOptions{Mode: ImportReplace}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetQuestionByID(alice, "shared-id"); got.Content != "alice's question" {
+		t.Fatalf("bob's import changed alice's question to %q", got.Content)
+	}
+	mine, _ := db.GetQuestions(bob)
+	if len(mine) != 1 || mine[0].ID == "shared-id" || mine[0].Content != "bob's question" {
+		t.Fatalf("unexpected questions for bob: %+v", mine)
+	}
+	if _, err := db.ImportQuestions("", incoming, ImportOptions{Mode: ImportMerge}); !errors.Is(err, ErrNoOwner) {
+		t.Fatalf("import without an owner err = %v", err)
+	}
+}
+
+func TestMigration7_AssignsRowsToFirstAdmin(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "v6.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateTo(db.DB, 6, MigrateOptions{}); err != nil {
+		t.Fatalf("MigrateTo(6): %v", err)
+	}
+	if err := execAll(db.DB,
+		`INSERT INTO users (id, username, password_hash, role, created_at) VALUES ('a1', 'admin', 'x', 'admin', CURRENT_TIMESTAMP)`,
+		`INSERT INTO questions (id, content, analysis, learning_guide, knowledge_points, subject, difficulty, created_at, updated_at)
+			VALUES ('old', 'from before accounts', 'a', 'l', '[]', '数学', 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		`INSERT INTO ai_configs (type, model_name) VALUES ('OPENAI', 'old-model')`,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateTo(db.DB, LatestMigrationVersion(), MigrateOptions{}); err != nil {
+		t.Fatalf("MigrateTo(latest): %v", err)
+	}
+
+	if _, err := db.GetQuestionByID("a1", "old"); err != nil {
+		t.Fatalf("existing question not assigned to the admin: %v", err)
+	}
+	if config, _ := db.GetAIConfig("a1"); config.ModelName != "old-model" {
+		t.Fatalf("existing AI config not assigned to the admin: %+v", config)
+	}
+}
@@ -27,11 +27,11 @@ func TestGetQuestionsPagedFiltered_TagMatchesJSON(t *testing.T) {
 		Difficulty:      3,
 		CreatedAt:       time.Now(),
 	}
-	if err := api.CreateQuestion(q); err != nil {
+	if err := api.CreateQuestion(testOwner, q); err != nil {
 		t.Fatalf("CreateQuestion: %v", err)
 	}
 
-	paged, err := api.GetQuestionsPagedFiltered("基本不等式", "", "", 1, 20)
+	paged, err := api.GetQuestionsPagedFiltered(testOwner, "基本不等式", "", "", 1, 20)
 	if err != nil {
 		t.Fatalf("GetQuestionsPagedFiltered: %v", err)
 	}
@@ -0,0 +1,401 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSessionFinished         = errors.New("practice session is already finished")
+	ErrAttemptAlreadyGraded    = errors.New("question has already been graded in this session")
+	ErrAttemptNotAwaitingGrade = errors.New("question is not waiting for a self-grade")
+)
+
+// PracticeSelection describes the question set a practice session is built
+// from: either explicit IDs, or the same filters as the question list.
+type PracticeSelection struct {
+	QuestionIDs []string
+	// ParentID selects the variants generated from a question.
+	ParentID string
+	Tag      string
+	Query    string
+	Subject  string
+	DueOnly  bool
+	Limit    int
+	Shuffle  bool
+}
+
+func (db *DB) SelectPracticeQuestions(ownerID string, sel PracticeSelection) ([]models.Question, error) {
+	limit := sel.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	if len(sel.QuestionIDs) > 0 {
+		var found []models.Question
+		if err := db.Scopes(ownedBy(ownerID)).Where("deleted_at IS NULL AND id IN ?", sel.QuestionIDs).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		byID := make(map[string]models.Question, len(found))
+		for _, q := range found {
+			byID[q.ID] = q
+		}
+		// Keep the caller's order and drop duplicates / unknown IDs.
+		questions := make([]models.Question, 0, len(found))
+		for _, id := range sel.QuestionIDs {
+			q, ok := byID[id]
+			if !ok {
+				continue
+			}
+			delete(byID, id)
+			questions = append(questions, q)
+			if len(questions) == limit {
+				break
+			}
+		}
+		return questions, nil
+	}
+
+	base := db.Model(&models.Question{}).Scopes(ownedBy(ownerID)).Where("deleted_at IS NULL")
+	base = applyQuestionFilters(base, sel.Tag, sel.Query, sel.Subject)
+	if sel.ParentID != "" {
+		base = base.Where("parent_id = ?", sel.ParentID)
+	}
+	if sel.DueOnly {
+		base = base.Where("next_review_at IS NULL OR next_review_at <= ?", time.Now())
+	}
+	if sel.Shuffle {
+		base = base.Order("RANDOM()")
+	} else {
+		// Never-reviewed questions first, then the most overdue.
+		base = base.Order("next_review_at IS NOT NULL").Order("next_review_at ASC").Order("created_at DESC")
+	}
+
+	var questions []models.Question
+	if err := base.Limit(limit).Find(&questions).Error; err != nil {
+		return nil, err
+	}
+	return questions, nil
+}
+
+// CreatePracticeSession starts a session for ownerID over questionIDs, which
+// must all be ownerID's questions.
+func (db *DB) CreatePracticeSession(ownerID string, questionIDs []string) (*models.PracticeSession, error) {
+	var session *models.PracticeSession
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		session, err = createPracticeSession(tx, ownerID, questionIDs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func createPracticeSession(tx *gorm.DB, ownerID string, questionIDs []string) (*models.PracticeSession, error) {
+	if ownerID == "" {
+		return nil, ErrNoOwner
+	}
+	now := time.Now()
+	session := &models.PracticeSession{
+		ID:        uuid.New().String(),
+		Status:    models.PracticeActive,
+		Total:     len(questionIDs),
+		CreatedAt: now,
+		OwnerID:   ownerID,
+	}
+
+	var owned int64
+	if err := tx.Model(&models.Question{}).Scopes(ownedBy(ownerID)).Where("id IN ?", questionIDs).Count(&owned).Error; err != nil {
+		return nil, err
+	}
+	if int(owned) != len(questionIDs) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if err := tx.Create(session).Error; err != nil {
+		return nil, err
+	}
+	for i, qid := range questionIDs {
+		attempt := models.PracticeAttempt{
+			ID:         uuid.New().String(),
+			SessionID:  &session.ID,
+			QuestionID: qid,
+			Position:   i + 1,
+			Status:     models.AttemptPending,
+			CreatedAt:  now,
+			OwnerID:    ownerID,
+		}
+		if err := tx.Create(&attempt).Error; err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+func (db *DB) GetPracticeSession(ownerID string, id string) (*models.PracticeSession, error) {
+	var session models.PracticeSession
+	result := db.Scopes(ownedBy(ownerID)).First(&session, "id = ?", id)
+	return &session, result.Error
+}
+
+func (db *DB) GetPracticeAttempts(ownerID string, sessionID string) ([]models.PracticeAttempt, error) {
+	var attempts []models.PracticeAttempt
+	result := db.Scopes(ownedBy(ownerID)).Where("session_id = ?", sessionID).Order("position ASC").Find(&attempts)
+	return attempts, result.Error
+}
+
+// NextPracticeAttempt returns the first unanswered question of the session,
+// or gorm.ErrRecordNotFound when everything has been answered.
+func (db *DB) NextPracticeAttempt(ownerID string, sessionID string) (*models.PracticeAttempt, error) {
+	var attempt models.PracticeAttempt
+	result := db.Scopes(ownedBy(ownerID)).Where("session_id = ? AND status = ?", sessionID, models.AttemptPending).Order("position ASC").First(&attempt)
+	return &attempt, result.Error
+}
+
+func (db *DB) GetPracticeAttempt(ownerID string, sessionID string, questionID string) (*models.PracticeAttempt, error) {
+	var attempt models.PracticeAttempt
+	result := db.Scopes(ownedBy(ownerID)).Where("session_id = ? AND question_id = ?", sessionID, questionID).First(&attempt)
+	return &attempt, result.Error
+}
+
+// AnswerPracticeAttempt stores the student's answer. When correct is known
+// (auto-graded multiple choice) the attempt is graded and the review schedule
+// updated in the same transaction; otherwise it waits for a self-grade.
+func (db *DB) AnswerPracticeAttempt(ownerID string, sessionID string, questionID string, answer string, answerImage *string, correct *bool) (*models.PracticeAttempt, *ReviewResult, error) {
+	var attempt models.PracticeAttempt
+	var review *ReviewResult
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureSessionActive(tx, ownerID, sessionID); err != nil {
+			return err
+		}
+		if err := tx.Scopes(ownedBy(ownerID)).Where("session_id = ? AND question_id = ?", sessionID, questionID).First(&attempt).Error; err != nil {
+			return err
+		}
+		if attempt.Status == models.AttemptGraded {
+			return ErrAttemptAlreadyGraded
+		}
+
+		now := time.Now()
+		attempt.Answer = &answer
+		attempt.AnswerImage = answerImage
+		attempt.AnsweredAt = &now
+		if correct == nil {
+			attempt.Status = models.AttemptAwaitingSelfGrade
+			return tx.Save(&attempt).Error
+		}
+
+		attempt.Status = models.AttemptGraded
+		attempt.Grading = models.GradingAuto
+		attempt.Correct = correct
+		attempt.GradedAt = &now
+		if err := tx.Save(&attempt).Error; err != nil {
+			return err
+		}
+		var err error
+		review, err = recordReview(tx, ownerID, questionID, &sessionID, *correct, "practice", now)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &attempt, review, nil
+}
+
+// SelfGradePracticeAttempt records the student's own verdict for an open
+// question that was answered but could not be checked automatically.
+func (db *DB) SelfGradePracticeAttempt(ownerID string, sessionID string, questionID string, correct bool) (*models.PracticeAttempt, *ReviewResult, error) {
+	var attempt models.PracticeAttempt
+	var review *ReviewResult
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureSessionActive(tx, ownerID, sessionID); err != nil {
+			return err
+		}
+		if err := tx.Scopes(ownedBy(ownerID)).Where("session_id = ? AND question_id = ?", sessionID, questionID).First(&attempt).Error; err != nil {
+			return err
+		}
+		if attempt.Status != models.AttemptAwaitingSelfGrade {
+			return ErrAttemptNotAwaitingGrade
+		}
+
+		now := time.Now()
+		attempt.Status = models.AttemptGraded
+		attempt.Grading = models.GradingSelf
+		attempt.Correct = &correct
+		attempt.GradedAt = &now
+		if err := tx.Save(&attempt).Error; err != nil {
+			return err
+		}
+		var err error
+		review, err = recordReview(tx, ownerID, questionID, &sessionID, correct, "practice", now)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &attempt, review, nil
+}
+
+func (db *DB) FinishPracticeSession(ownerID string, id string) (*models.PracticeSession, error) {
+	session, err := db.GetPracticeSession(ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status == models.PracticeFinished {
+		return session, nil
+	}
+	now := time.Now()
+	session.Status = models.PracticeFinished
+	session.FinishedAt = &now
+	if err := db.Save(session).Error; err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+type PracticeSummary struct {
+	models.PracticeSession
+	Answered          int                      `json:"answered"`
+	Correct           int                      `json:"correct"`
+	Incorrect         int                      `json:"incorrect"`
+	AwaitingSelfGrade int                      `json:"awaitingSelfGrade"`
+	Pending           int                      `json:"pending"`
+	Attempts          []models.PracticeAttempt `json:"attempts"`
+}
+
+func (db *DB) GetPracticeSummary(ownerID string, id string) (*PracticeSummary, error) {
+	session, err := db.GetPracticeSession(ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	attempts, err := db.GetPracticeAttempts(ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &PracticeSummary{PracticeSession: *session, Attempts: attempts}
+	for _, a := range attempts {
+		switch a.Status {
+		case models.AttemptPending:
+			summary.Pending++
+			continue
+		case models.AttemptAwaitingSelfGrade:
+			summary.AwaitingSelfGrade++
+		case models.AttemptGraded:
+			if a.Correct != nil && *a.Correct {
+				summary.Correct++
+			} else {
+				summary.Incorrect++
+			}
+		}
+		summary.Answered++
+	}
+	return summary, nil
+}
+
+func ensureSessionActive(tx *gorm.DB, ownerID string, sessionID string) error {
+	var session models.PracticeSession
+	if err := tx.Scopes(ownedBy(ownerID)).Select("id", "status").First(&session, "id = ?", sessionID).Error; err != nil {
+		return err
+	}
+	if session.Status != models.PracticeActive {
+		return ErrSessionFinished
+	}
+	return nil
+}
+
+// AIGrade is a provider verdict to be stored with an attempt.
+type AIGrade struct {
+	Verdict     string
+	Score       int
+	Explanation string
+	Model       string
+	Raw         string
+	Correct     bool
+}
+
+// GradeAttemptWithAI stores an AI verdict. With a session ID the verdict is
+// attached to that session's attempt for the question; without one a
+// standalone attempt is recorded. Either way the review schedule moves.
+func (db *DB) GradeAttemptWithAI(ownerID string, sessionID *string, questionID string, answer *string, answerImage *string, grade AIGrade) (*models.PracticeAttempt, *ReviewResult, error) {
+	var attempt models.PracticeAttempt
+	var review *ReviewResult
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		isNew := sessionID == nil
+		if isNew {
+			attempt = models.PracticeAttempt{
+				ID:         uuid.New().String(),
+				QuestionID: questionID,
+				CreatedAt:  now,
+				OwnerID:    ownerID,
+			}
+		} else {
+			if err := ensureSessionActive(tx, ownerID, *sessionID); err != nil {
+				return err
+			}
+			if err := tx.Scopes(ownedBy(ownerID)).Where("session_id = ? AND question_id = ?", *sessionID, questionID).First(&attempt).Error; err != nil {
+				return err
+			}
+			if attempt.Status == models.AttemptGraded {
+				return ErrAttemptAlreadyGraded
+			}
+		}
+
+		if answer != nil {
+			attempt.Answer = answer
+		}
+		if answerImage != nil {
+			attempt.AnswerImage = answerImage
+		}
+		if attempt.AnsweredAt == nil {
+			attempt.AnsweredAt = &now
+		}
+		attempt.Status = models.AttemptGraded
+		attempt.Grading = models.GradingAI
+		attempt.Correct = &grade.Correct
+		attempt.GradedAt = &now
+		attempt.AIVerdict = &grade.Verdict
+		attempt.AIScore = &grade.Score
+		attempt.AIExplanation = &grade.Explanation
+		attempt.AIModel = &grade.Model
+		attempt.AIRaw = &grade.Raw
+
+		// recordReview fails for questions ownerID does not own, which
+		// rolls back a standalone attempt too.
+		var err error
+		if isNew {
+			err = tx.Create(&attempt).Error
+		} else {
+			err = tx.Save(&attempt).Error
+		}
+		if err != nil {
+			return err
+		}
+		review, err = recordReview(tx, ownerID, questionID, sessionID, grade.Correct, "ai", now)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &attempt, review, nil
+}
+
+// GetQuestionAttempts lists every answered attempt of a question across
+// sessions and standalone retries, newest first.
+func (db *DB) GetQuestionAttempts(ownerID string, questionID string) ([]models.PracticeAttempt, error) {
+	var attempts []models.PracticeAttempt
+	result := db.Scopes(ownedBy(ownerID)).Where("question_id = ? AND status <> ?", questionID, models.AttemptPending).Order("answered_at DESC").Find(&attempts)
+	return attempts, result.Error
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"time"
+
+	"E-Bu-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// reviewIntervals is a Leitner-style schedule in days: each consecutive
+// correct review pushes the next one further out, a wrong answer starts over.
+var reviewIntervals = []int{1, 2, 4, 7, 15, 30}
+
+func reviewIntervalDays(streak int) int {
+	if streak <= 0 {
+		return reviewIntervals[0]
+	}
+	if streak > len(reviewIntervals) {
+		return reviewIntervals[len(reviewIntervals)-1]
+	}
+	return reviewIntervals[streak-1]
+}
+
+type ReviewResult struct {
+	Correct      bool      `json:"correct"`
+	Streak       int       `json:"streak"`
+	IntervalDays int       `json:"intervalDays"`
+	NextReviewAt time.Time `json:"nextReviewAt"`
+}
+
+// recordReview appends a review log entry and moves the question's due date.
+// It must run inside the caller's transaction, and fails with
+// gorm.ErrRecordNotFound unless the question is ownerID's.
+func recordReview(tx *gorm.DB, ownerID string, questionID string, sessionID *string, correct bool, source string, now time.Time) (*ReviewResult, error) {
+	var question models.Question
+	if err := tx.Scopes(ownedBy(ownerID)).Select("id", "review_streak").First(&question, "id = ?", questionID).Error; err != nil {
+		return nil, err
+	}
+
+	streak := 0
+	if correct {
+		streak = question.ReviewStreak + 1
+	}
+	interval := reviewIntervalDays(streak)
+	next := now.AddDate(0, 0, interval)
+
+	if err := tx.Model(&models.Question{}).Where("id = ?", questionID).Updates(map[string]interface{}{
+		"last_reviewed_at": now,
+		"next_review_at":   next,
+		"review_streak":    streak,
+		"version":          gorm.Expr("version + 1"),
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	log := models.ReviewLog{
+		QuestionID:   questionID,
+		SessionID:    sessionID,
+		Correct:      correct,
+		Source:       source,
+		IntervalDays: interval,
+		ReviewedAt:   now,
+		OwnerID:      ownerID,
+	}
+	if err := tx.Create(&log).Error; err != nil {
+		return nil, err
+	}
+
+	return &ReviewResult{Correct: correct, Streak: streak, IntervalDays: interval, NextReviewAt: next}, nil
+}
+
+// GetReviewLogs returns the review history of a question, newest first.
+func (db *DB) GetReviewLogs(ownerID string, questionID string) ([]models.ReviewLog, error) {
+	var logs []models.ReviewLog
+	result := db.Scopes(ownedBy(ownerID)).Where("question_id = ?", questionID).Order("reviewed_at DESC, id DESC").Find(&logs)
+	return logs, result.Error
+}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"reflect"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// revisionColumns are the question columns a revision holds.
+var revisionColumns = []string{
+	"image", "cropped_diagram", "content", "options", "diagram_description", "answer",
+	"analysis", "learning_guide", "knowledge_points", "subject", "difficulty",
+}
+
+// RevisionSummary describes a revision without its content, for listing a
+// question's history. ChangedFields are the fields it changed compared with
+// the revision before it.
+type RevisionSummary struct {
+	ID            string    `json:"id"`
+	Number        int       `json:"number"`
+	Source        string    `json:"source"`
+	AuthorID      string    `json:"authorId,omitempty"`
+	AuthorName    string    `json:"authorName,omitempty"`
+	RevertedFrom  *int      `json:"revertedFrom,omitempty"`
+	ChangedFields []string  `json:"changedFields"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// RevisionDiff is the field-level difference between two revisions of a
+// question. Images are compared by hash, as in a backup preview.
+type RevisionDiff struct {
+	QuestionID string        `json:"questionId"`
+	From       int           `json:"from"`
+	To         int           `json:"to"`
+	Changes    []FieldChange `json:"changes"`
+}
+
+// recordRevision records the content after has through tx, authored by the
+// actor tx acts for. before is the state it replaced, nil for a new
+// question; when the content did not change nothing is recorded. A question
+// whose history is still empty first gets before as its baseline.
+func recordRevision(tx *gorm.DB, before *models.Question, after *models.Question, source string, revertedFrom *int) error {
+	snapshot := models.SnapshotOf(after)
+	var latest int
+	if err := tx.Model(&models.QuestionRevision{}).Where("question_id = ?", after.ID).
+		Select("COALESCE(MAX(number), 0)").Scan(&latest).Error; err != nil {
+		return err
+	}
+	if before != nil {
+		previous := models.SnapshotOf(before)
+		if reflect.DeepEqual(previous, snapshot) {
+			return nil
+		}
+		if latest == 0 {
+			latest++
+			baseline := models.QuestionRevision{
+				ID:         uuid.New().String(),
+				QuestionID: before.ID,
+				Number:     latest,
+				OwnerID:    before.OwnerID,
+				Source:     models.RevisionBaseline,
+				Snapshot:   previous,
+				CreatedAt:  lastModified(before),
+			}
+			if err := tx.Create(&baseline).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	actor, _ := tx.Statement.Context.Value(actorKey{}).(Actor)
+	revision := models.QuestionRevision{
+		ID:           uuid.New().String(),
+		QuestionID:   after.ID,
+		Number:       latest + 1,
+		OwnerID:      after.OwnerID,
+		Source:       source,
+		AuthorID:     actor.UserID,
+		AuthorName:   actor.Username,
+		RevertedFrom: revertedFrom,
+		Snapshot:     snapshot,
+		CreatedAt:    time.Now(),
+	}
+	return tx.Create(&revision).Error
+}
+
+// initialRevisionSource is where the content of a new question came from.
+func initialRevisionSource(q *models.Question) string {
+	if q.AIGenerated {
+		return models.RevisionAI
+	}
+	return models.RevisionManual
+}
+
+// ListRevisions returns the history of one of ownerID's questions, oldest
+// first. Questions not edited since revisions were introduced have none.
+func (db *DB) ListRevisions(ownerID string, questionID string) ([]RevisionSummary, error) {
+	if _, err := db.GetQuestionByID(ownerID, questionID); err != nil {
+		return nil, err
+	}
+	var revisions []models.QuestionRevision
+	if err := db.Scopes(ownedBy(ownerID)).Where("question_id = ?", questionID).Order("number ASC").Find(&revisions).Error; err != nil {
+		return nil, err
+	}
+	summaries := make([]RevisionSummary, len(revisions))
+	for i, r := range revisions {
+		summaries[i] = RevisionSummary{
+			ID:            r.ID,
+			Number:        r.Number,
+			Source:        r.Source,
+			AuthorID:      r.AuthorID,
+			AuthorName:    r.AuthorName,
+			RevertedFrom:  r.RevertedFrom,
+			ChangedFields: []string{},
+			CreatedAt:     r.CreatedAt,
+		}
+		if i > 0 {
+			for _, change := range diffSnapshots(revisions[i-1].Snapshot, r.Snapshot) {
+				summaries[i].ChangedFields = append(summaries[i].ChangedFields, change.Field)
+			}
+		}
+	}
+	return summaries, nil
+}
+
+// GetRevision returns one revision of one of ownerID's questions.
+func (db *DB) GetRevision(ownerID string, questionID string, number int) (*models.QuestionRevision, error) {
+	var revision models.QuestionRevision
+	err := db.Scopes(ownedBy(ownerID)).Where("question_id = ? AND number = ?", questionID, number).First(&revision).Error
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// DiffRevisions compares two revisions of one of ownerID's questions.
+func (db *DB) DiffRevisions(ownerID string, questionID string, from int, to int) (*RevisionDiff, error) {
+	older, err := db.GetRevision(ownerID, questionID, from)
+	if err != nil {
+		return nil, err
+	}
+	newer, err := db.GetRevision(ownerID, questionID, to)
+	if err != nil {
+		return nil, err
+	}
+	changes := diffSnapshots(older.Snapshot, newer.Snapshot)
+	if changes == nil {
+		changes = []FieldChange{}
+	}
+	return &RevisionDiff{QuestionID: questionID, From: from, To: to, Changes: changes}, nil
+}
+
+// RevertQuestion puts the content of revision number back on one of
+// ownerID's questions. The revert is recorded as a new revision, so it can
+// be undone in turn. If ifMatch is not 0 and the question is no longer at
+// that version, nothing is written and ErrVersionConflict is returned. A
+// question that already has the revision's content is returned unchanged.
+func (db *DB) RevertQuestion(ownerID string, questionID string, number int, ifMatch int) (*models.Question, error) {
+	var question *models.Question
+	err := db.Transaction(func(tx *gorm.DB) error {
+		before, err := findQuestion(tx.Scopes(ownedBy(ownerID)), questionID)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return gorm.ErrRecordNotFound
+		}
+		var revision models.QuestionRevision
+		if err := tx.Scopes(ownedBy(ownerID)).Where("question_id = ? AND number = ?", questionID, number).First(&revision).Error; err != nil {
+			return err
+		}
+		if ifMatch != 0 && ifMatch != before.Version {
+			return ErrVersionConflict
+		}
+
+		after := *before
+		revision.Snapshot.ApplyTo(&after)
+		if len(diffQuestions(before, &after)) == 0 {
+			question = before
+			return nil
+		}
+		after.UpdatedAt = time.Now()
+		after.Version = before.Version + 1
+		columns := append([]string{"updated_at", "version"}, revisionColumns...)
+		result := tx.Model(&after).Where("version = ?", before.Version).Select(columns).Updates(&after)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+		question = &after
+		return recordRevision(tx, before, &after, models.RevisionRevert, &number)
+	})
+	return question, err
+}
+
+func diffSnapshots(older models.QuestionSnapshot, newer models.QuestionSnapshot) []FieldChange {
+	var from, to models.Question
+	older.ApplyTo(&from)
+	newer.ApplyTo(&to)
+	return diffQuestions(&from, &to)
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"gorm.io/gorm"
+)
+
+func TestRevisions_RecordDiffAndRevert(t *testing.T) {
+	base := &DB{newTestDB(t)}
+	db := base.As(Actor{UserID: "u1", Username: "alice"})
+
+	q := backupQuestion("q1", "2 + 2 = ?", time.Now())
+	q.AIGenerated = true
+	if err := db.CreateQuestion("u1", &q); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateQuestion("u1", "q1", &models.Question{Analysis: "carefully corrected"}); err != nil {
+		t.Fatal(err)
+	}
+	// Saving the same content again is not a revision.
+	if err := db.UpdateQuestion("u1", "q1", &models.Question{Analysis: "carefully corrected"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateQuestion("u1", "q1", &models.Question{Analysis: "mangled", Difficulty: 5}); err != nil {
+		t.Fatal(err)
+	}
+	// Another user's update changes nothing and records nothing.
+	if err := db.UpdateQuestion("u2", "q1", &models.Question{Analysis: "not mine"}); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := db.ListRevisions("u1", "q1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("revisions = %+v", revisions)
+	}
+	if revisions[0].Source != models.RevisionAI || len(revisions[0].ChangedFields) != 0 {
+		t.Fatalf("initial revision = %+v", revisions[0])
+	}
+	if r := revisions[2]; r.Source != models.RevisionEdit || r.AuthorName != "alice" || len(r.ChangedFields) != 2 {
+		t.Fatalf("last edit = %+v", r)
+	}
+	if _, err := db.ListRevisions("u2", "q1"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("u2 listed u1's revisions: %v", err)
+	}
+
+	diff, err := db.DiffRevisions("u1", "q1", 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Changes) != 2 || diff.Changes[0].Field != "analysis" || diff.Changes[0].Old != "carefully corrected" || diff.Changes[0].New != "mangled" {
+		t.Fatalf("diff = %+v", diff)
+	}
+	if _, err := db.DiffRevisions("u1", "q1", 1, 9); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("diff with an unknown revision err = %v", err)
+	}
+
+	reverted, err := db.RevertQuestion("u1", "q1", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted.Analysis != "carefully corrected" || reverted.Difficulty != q.Difficulty {
+		t.Fatalf("reverted question = %+v", reverted)
+	}
+	if got, _ := db.GetQuestionByID("u1", "q1"); got.Analysis != "carefully corrected" {
+		t.Fatalf("stored analysis = %q", got.Analysis)
+	}
+	latest, err := db.GetRevision("u1", "q1", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Source != models.RevisionRevert || latest.RevertedFrom == nil || *latest.RevertedFrom != 2 || latest.Snapshot.Analysis != "carefully corrected" {
+		t.Fatalf("revert revision = %+v", latest)
+	}
+	// Reverting to the content the question already has changes nothing.
+	again, err := db.RevertQuestion("u1", "q1", 2, 0)
+	if err != nil || again.Version != reverted.Version {
+		t.Fatalf("no-op revert = %+v, %v", again, err)
+	}
+	if _, err := db.GetRevision("u1", "q1", 5); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("no-op revert recorded a revision: %v", err)
+	}
+	// Nor is a stale copy reverted.
+	if _, err := db.RevertQuestion("u1", "q1", 3, reverted.Version-1); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("stale revert err = %v", err)
+	}
+	if got, _ := db.GetQuestionByID("u1", "q1"); got.Analysis != "carefully corrected" || got.Version != reverted.Version {
+		t.Fatalf("stale revert changed the question: %+v", got)
+	}
+	if _, err := db.RevertQuestion("u2", "q1", 1, 0); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("u2 reverted u1's question: %v", err)
+	}
+
+	if err := db.HardDeleteQuestion("u1", "q1"); err != nil {
+		t.Fatal(err)
+	}
+	var left int64
+	base.Model(&models.QuestionRevision{}).Count(&left)
+	if left != 0 {
+		t.Fatalf("%d revisions outlived their question", left)
+	}
+}
+
+func TestRevisions_BaselineForQuestionsWithoutHistory(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	if _, err := db.ImportQuestions("u1", []models.Question{backupQuestion("q1", "imported", time.Now())}, ImportOptions{Mode: ImportMerge}); err != nil {
+		t.Fatal(err)
+	}
+	if revisions, _ := db.ListRevisions("u1", "q1"); len(revisions) != 0 {
+		t.Fatalf("imported question has revisions: %+v", revisions)
+	}
+
+	if err := db.UpdateQuestion("u1", "q1", &models.Question{Content: "edited"}); err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := db.ListRevisions("u1", "q1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Source != models.RevisionBaseline || revisions[1].ChangedFields[0] != "content" {
+		t.Fatalf("revisions = %+v", revisions)
+	}
+	baseline, _ := db.GetRevision("u1", "q1", 1)
+	if baseline.Snapshot.Content != "imported" {
+		t.Fatalf("baseline content = %q", baseline.Snapshot.Content)
+	}
+
+	// Importing over the question is recorded too.
+	newer := backupQuestion("q1", "from the backup", time.Now().Add(time.Hour))
+	if _, err := db.ImportQuestions("u1", []models.Question{newer}, ImportOptions{Mode: ImportMerge, Conflict: ConflictOverwrite}); err != nil {
+		t.Fatal(err)
+	}
+	if imported, err := db.GetRevision("u1", "q1", 3); err != nil || imported.Source != models.RevisionImport {
+		t.Fatalf("import revision = %+v, %v", imported, err)
+	}
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"sort"
+
+	"E-Bu-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// schemaModels are the models whose tables make up the schema the code
+// expects.
+var schemaModels = []interface{}{
+	&models.Question{},
+	&models.AIConfig{},
+	&models.PracticeSession{},
+	&models.PracticeAttempt{},
+	&models.ReviewLog{},
+	&models.User{},
+	&models.UserSession{},
+	&models.APIToken{},
+	&models.Class{},
+	&models.ClassMember{},
+	&models.ParentLink{},
+	&models.Assignment{},
+	&models.AssignmentQuestion{},
+	&models.AssignmentStudent{},
+	&models.Collection{},
+	&models.CollectionQuestion{},
+	&models.AuditEntry{},
+	&models.QuestionRevision{},
+	&AppliedMigration{},
+}
+
+// TableSchema is the part of a table the drift check compares: column and
+// index names. Types and constraints are left alone, since SQLite keeps
+// whatever DDL created a column and equivalent columns rarely match
+// textually.
+type TableSchema struct {
+	Columns []string `json:"columns"`
+	Indexes []string `json:"indexes"`
+}
+
+type TableDrift struct {
+	Table          string   `json:"table"`
+	MissingColumns []string `json:"missingColumns,omitempty"`
+	ExtraColumns   []string `json:"extraColumns,omitempty"`
+	MissingIndexes []string `json:"missingIndexes,omitempty"`
+	ExtraIndexes   []string `json:"extraIndexes,omitempty"`
+}
+
+// SchemaDrift lists the differences between the live schema and the one the
+// models describe.
+type SchemaDrift struct {
+	InSync        bool         `json:"inSync"`
+	MissingTables []string     `json:"missingTables"`
+	ExtraTables   []string     `json:"extraTables"`
+	Tables        []TableDrift `json:"tables"`
+}
+
+// ExpectedSchema builds the models' tables in a scratch database and reads
+// them back.
+func ExpectedSchema() (map[string]TableSchema, error) {
+	scratch, err := openScratchDB()
+	if err != nil {
+		return nil, err
+	}
+	defer closeScratchDB(scratch)
+
+	if err := scratch.AutoMigrate(schemaModels...); err != nil {
+		return nil, err
+	}
+	return readSchema(scratch)
+}
+
+// readSchema returns every table in db with its sorted columns and indexes.
+// SQLite's own tables and automatic indexes are left out.
+func readSchema(db *gorm.DB) (map[string]TableSchema, error) {
+	var objects []schemaObject
+	if err := db.Raw(`SELECT type, name, tbl_name, sql FROM sqlite_master
+		WHERE type IN ('table', 'index') AND name NOT LIKE 'sqlite_%'
+		ORDER BY name`).Scan(&objects).Error; err != nil {
+		return nil, err
+	}
+
+	schema := map[string]TableSchema{}
+	for _, obj := range objects {
+		if obj.Type != "table" {
+			continue
+		}
+		table := TableSchema{Columns: []string{}, Indexes: []string{}}
+		if err := db.Raw("SELECT name FROM pragma_table_info(?) ORDER BY name", obj.Name).Scan(&table.Columns).Error; err != nil {
+			return nil, err
+		}
+		schema[obj.Name] = table
+	}
+	for _, obj := range objects {
+		if obj.Type != "index" {
+			continue
+		}
+		if table, ok := schema[obj.TblName]; ok {
+			table.Indexes = append(table.Indexes, obj.Name)
+			schema[obj.TblName] = table
+		}
+	}
+	return schema, nil
+}
+
+// CheckSchemaDrift compares the live schema with ExpectedSchema and reports
+// missing and extra tables, columns and indexes.
+func CheckSchemaDrift(db *gorm.DB) (*SchemaDrift, error) {
+	expected, err := ExpectedSchema()
+	if err != nil {
+		return nil, err
+	}
+	live, err := readSchema(db)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := &SchemaDrift{MissingTables: []string{}, ExtraTables: []string{}, Tables: []TableDrift{}}
+	for name := range live {
+		if _, ok := expected[name]; !ok {
+			drift.ExtraTables = append(drift.ExtraTables, name)
+		}
+	}
+	for name, want := range expected {
+		got, ok := live[name]
+		if !ok {
+			drift.MissingTables = append(drift.MissingTables, name)
+			continue
+		}
+		diff := TableDrift{
+			Table:          name,
+			MissingColumns: difference(want.Columns, got.Columns),
+			ExtraColumns:   difference(got.Columns, want.Columns),
+			MissingIndexes: difference(want.Indexes, got.Indexes),
+			ExtraIndexes:   difference(got.Indexes, want.Indexes),
+		}
+		if len(diff.MissingColumns)+len(diff.ExtraColumns)+len(diff.MissingIndexes)+len(diff.ExtraIndexes) > 0 {
+			drift.Tables = append(drift.Tables, diff)
+		}
+	}
+	sort.Strings(drift.MissingTables)
+	sort.Strings(drift.ExtraTables)
+	sort.Slice(drift.Tables, func(i, j int) bool {
+		return drift.Tables[i].Table < drift.Tables[j].Table
+	})
+	drift.InSync = len(drift.MissingTables)+len(drift.ExtraTables)+len(drift.Tables) == 0
+	return drift, nil
+}
+
+// difference returns the entries of a that are not in b.
+func difference(a []string, b []string) []string {
+	seen := make(map[string]bool, len(b))
+	for _, s := range b {
+		seen[s] = true
+	}
+	var out []string
+	for _, s := range a {
+		if !seen[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,342 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Snapshot kinds. Only scheduled snapshots are pruned by retention.
+const (
+	SnapshotScheduled  = "scheduled"
+	SnapshotManual     = "manual"
+	SnapshotPreRestore = "pre-restore"
+	// SnapshotPreMigration is taken before pending migrations run; its path
+	// is recorded in applied_migrations.
+	SnapshotPreMigration = "pre-migration"
+)
+
+var (
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	ErrSnapshotCorrupt  = errors.New("snapshot failed its integrity check")
+	// ErrSnapshotTooNew is returned for a snapshot whose schema has
+	// migrations this build does not know.
+	ErrSnapshotTooNew = errors.New("snapshot is from a newer schema than this build")
+)
+
+// Snapshot describes one database copy in the snapshot directory. The
+// metadata lives next to the file in <name>.json.
+type Snapshot struct {
+	Name          string    `json:"name"`
+	Kind          string    `json:"kind"`
+	CreatedAt     time.Time `json:"createdAt"`
+	Size          int64     `json:"size"`
+	Integrity     string    `json:"integrity"` // "ok" or the first problem found
+	SchemaVersion int       `json:"schemaVersion"`
+	QuestionCount int64     `json:"questionCount"`
+}
+
+// CreateSnapshot writes a consistent copy of the live database into dir with
+// VACUUM INTO, checks the copy's integrity and records its metadata. A copy
+// that fails the check is deleted.
+func (db *DB) CreateSnapshot(dir string, kind string) (*Snapshot, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	base := fmt.Sprintf("ebu-%s-%s", now.Format("20060102-150405"), kind)
+	name := base + ".db"
+	for i := 2; fileExists(filepath.Join(dir, name)); i++ {
+		name = fmt.Sprintf("%s-%d.db", base, i)
+	}
+	path := filepath.Join(dir, name)
+
+	// VACUUM INTO refuses to overwrite, and a half-written file must never
+	// look like a snapshot, so write under a temporary name first.
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := db.Exec("VACUUM INTO ?", tmp).Error; err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("snapshot failed: %w", err)
+	}
+
+	snap := &Snapshot{Name: name, Kind: kind, CreatedAt: now}
+	if err := inspectSnapshot(tmp, snap); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if snap.Integrity != "ok" {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotCorrupt, snap.Integrity)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if info, err := os.Stat(path); err == nil {
+		snap.Size = info.Size()
+	}
+	if err := writeSnapshotMeta(dir, snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// inspectSnapshot opens a snapshot file on its own connection and fills in
+// its integrity, schema version and question count.
+func inspectSnapshot(path string, snap *Snapshot) error {
+	conn, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return err
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	var results []string
+	if err := conn.Raw("PRAGMA integrity_check").Scan(&results).Error; err != nil {
+		snap.Integrity = err.Error()
+		return nil
+	}
+	snap.Integrity = strings.Join(results, "; ")
+
+	if conn.Migrator().HasTable(&AppliedMigration{}) {
+		conn.Model(&AppliedMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&snap.SchemaVersion)
+	}
+	if conn.Migrator().HasTable(&models.Question{}) {
+		conn.Model(&models.Question{}).Count(&snap.QuestionCount)
+	}
+	return nil
+}
+
+func writeSnapshotMeta(dir string, snap *Snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, snap.Name+".json"), data, 0644)
+}
+
+// ListSnapshots returns the snapshots in dir, newest first. Database files
+// without metadata (copied in by hand) are listed as manual snapshots.
+func ListSnapshots(dir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snaps := make([]Snapshot, 0)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".db") {
+			continue
+		}
+		snap := Snapshot{Name: entry.Name(), Kind: SnapshotManual}
+		if data, err := os.ReadFile(filepath.Join(dir, entry.Name()+".json")); err == nil {
+			json.Unmarshal(data, &snap)
+			snap.Name = entry.Name()
+		}
+		if info, err := entry.Info(); err == nil {
+			snap.Size = info.Size()
+			if snap.CreatedAt.IsZero() {
+				snap.CreatedAt = info.ModTime()
+			}
+		}
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].CreatedAt.After(snaps[j].CreatedAt)
+	})
+	return snaps, nil
+}
+
+// PruneSnapshots applies retention to scheduled snapshots: the newest one of
+// each of the last keepDaily days and of each of the last keepWeekly ISO
+// weeks survive, as does the newest snapshot overall. It returns the names
+// of the deleted snapshots.
+func PruneSnapshots(dir string, keepDaily int, keepWeekly int) ([]string, error) {
+	snaps, err := ListSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var scheduled []Snapshot
+	for _, s := range snaps {
+		if s.Kind == SnapshotScheduled {
+			scheduled = append(scheduled, s)
+		}
+	}
+	keep := map[string]bool{}
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	for i, s := range scheduled {
+		if i == 0 {
+			keep[s.Name] = true
+		}
+		t := s.CreatedAt.Local()
+		day := t.Format("2006-01-02")
+		year, week := t.ISOWeek()
+		weekKey := fmt.Sprintf("%d-W%02d", year, week)
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep[s.Name] = true
+		}
+		if !weeks[weekKey] && len(weeks) < keepWeekly {
+			weeks[weekKey] = true
+			keep[s.Name] = true
+		}
+	}
+
+	removed := make([]string, 0)
+	for _, s := range scheduled {
+		if keep[s.Name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, s.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, err
+		}
+		os.Remove(filepath.Join(dir, s.Name+".json"))
+		removed = append(removed, s.Name)
+	}
+	return removed, nil
+}
+
+// SnapshotPath resolves a snapshot name inside dir, refusing anything that
+// is not a plain snapshot file name.
+func SnapshotPath(dir string, name string) (string, error) {
+	if name == "" || filepath.Base(name) != name || !strings.HasSuffix(name, ".db") {
+		return "", ErrSnapshotNotFound
+	}
+	path := filepath.Join(dir, name)
+	if !fileExists(path) {
+		return "", ErrSnapshotNotFound
+	}
+	return path, nil
+}
+
+// RestoreFrom replaces the live database's schema and data with the contents
+// of the SQLite file at path and applies the migrations the snapshot is
+// missing, in a single transaction on one connection, so the server keeps
+// running on the schema it was built for and a failed restore changes
+// nothing. The audit log is not part of the restore: the live log is kept,
+// and the restore is recorded in it.
+func (db *DB) RestoreFrom(path string) error {
+	return db.restoreFrom(path, true, AuditSnapshotRestore, nil)
+}
+
+// restoreFrom is RestoreFrom, recorded as action with details; without
+// migrate the restored database keeps the snapshot's schema version, which
+// only a stopped server can work with.
+func (db *DB) restoreFrom(path string, migrate bool, action string, details map[string]interface{}) error {
+	check := Snapshot{}
+	if err := inspectSnapshot(path, &check); err != nil {
+		return err
+	}
+	if check.Integrity != "ok" {
+		return fmt.Errorf("%w: %s", ErrSnapshotCorrupt, check.Integrity)
+	}
+	if latest := LatestMigrationVersion(); check.SchemaVersion > latest {
+		return fmt.Errorf("%w: schema version %d, this build knows up to %d", ErrSnapshotTooNew, check.SchemaVersion, latest)
+	}
+
+	// ATTACH is per connection, so everything runs on one pinned connection.
+	return db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("ATTACH DATABASE ? AS snap", path).Error; err != nil {
+			return err
+		}
+		defer conn.Exec("DETACH DATABASE snap")
+
+		return conn.Transaction(func(tx *gorm.DB) error {
+			var live []schemaObject
+			if err := tx.Raw(`SELECT type, name, tbl_name, sql FROM main.sqlite_master
+				WHERE type IN ('table', 'view', 'trigger') AND name NOT LIKE 'sqlite_%'`).Scan(&live).Error; err != nil {
+				return err
+			}
+			// Views and triggers first; dropping a table drops its indexes.
+			sort.SliceStable(live, func(i, j int) bool {
+				return live[i].Type != "table" && live[j].Type == "table"
+			})
+			keepAudit := false
+			for _, obj := range live {
+				if obj.TblName == auditTable {
+					keepAudit = true
+					continue
+				}
+				if err := tx.Exec(fmt.Sprintf("DROP %s IF EXISTS main.%s", strings.ToUpper(obj.Type), quoteIdent(obj.Name))).Error; err != nil {
+					return err
+				}
+			}
+
+			var objects []schemaObject
+			if err := tx.Raw(`SELECT type, name, tbl_name, sql FROM snap.sqlite_master
+				WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+				ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 1 ELSE 2 END`).Scan(&objects).Error; err != nil {
+				return err
+			}
+			for _, obj := range objects {
+				if keepAudit && obj.TblName == auditTable {
+					continue
+				}
+				if err := tx.Exec(obj.SQL).Error; err != nil {
+					return fmt.Errorf("recreating %s %s: %w", obj.Type, obj.Name, err)
+				}
+				if obj.Type != "table" {
+					continue
+				}
+				copyRows := fmt.Sprintf("INSERT INTO main.%s SELECT * FROM snap.%s", quoteIdent(obj.Name), quoteIdent(obj.Name))
+				if err := tx.Exec(copyRows).Error; err != nil {
+					return fmt.Errorf("copying %s: %w", obj.Name, err)
+				}
+			}
+			if migrate {
+				if _, err := MigrateTo(tx, LatestMigrationVersion(), MigrateOptions{}); err != nil {
+					return fmt.Errorf("migrating the restored database: %w", err)
+				}
+			}
+			if !tx.Migrator().HasTable(auditTable) {
+				return nil
+			}
+			entry := map[string]interface{}{"snapshot": filepath.Base(path), "schemaVersion": check.SchemaVersion}
+			for k, v := range details {
+				entry[k] = v
+			}
+			return recordAudit(tx, action, nil, entry)
+		})
+	})
+}
+
+// auditTable is the audit log's table, which restores leave alone: it is
+// append-only and the live copy is always the longer one.
+const auditTable = "audit_log"
+
+type schemaObject struct {
+	Type    string `gorm:"column:type"`
+	Name    string `gorm:"column:name"`
+	TblName string `gorm:"column:tbl_name"`
+	SQL     string `gorm:"column:sql"`
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
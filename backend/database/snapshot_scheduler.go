@@ -0,0 +1,159 @@
+package database
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+type SnapshotConfig struct {
+	Dir string
+	// Interval between scheduled snapshots; 0 disables the scheduler but
+	// manual snapshots and restores still work.
+	Interval   time.Duration
+	KeepDaily  int
+	KeepWeekly int
+}
+
+// Snapshotter takes scheduled snapshots and serializes every snapshot and
+// restore so two of them never run against the database at once.
+type Snapshotter struct {
+	DB     *DB
+	Config SnapshotConfig
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewSnapshotter(db *DB, cfg SnapshotConfig) *Snapshotter {
+	return &Snapshotter{DB: db, Config: cfg}
+}
+
+// Start runs the scheduler in the background. The first snapshot is taken
+// as soon as the newest scheduled one is older than the interval, so
+// restarts do not push snapshots back indefinitely.
+func (s *Snapshotter) Start() {
+	if s.Config.Interval <= 0 || s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		for {
+			timer := time.NewTimer(s.untilNext())
+			select {
+			case <-s.stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+				s.runScheduled()
+			}
+		}
+	}()
+}
+
+// Stop ends the scheduler and waits for a running snapshot to finish.
+func (s *Snapshotter) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+func (s *Snapshotter) untilNext() time.Duration {
+	snaps, err := ListSnapshots(s.Config.Dir)
+	if err != nil {
+		return s.Config.Interval
+	}
+	for _, snap := range snaps {
+		if snap.Kind == SnapshotScheduled {
+			if wait := s.Config.Interval - time.Since(snap.CreatedAt); wait > 0 {
+				return wait
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+func (s *Snapshotter) runScheduled() {
+	snap, err := s.Take(SnapshotScheduled)
+	if err != nil {
+		log.Printf("scheduled snapshot failed: %v", err)
+		return
+	}
+	log.Printf("snapshot %s written (%d bytes, %d questions)", snap.Name, snap.Size, snap.QuestionCount)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed, err := PruneSnapshots(s.Config.Dir, s.Config.KeepDaily, s.Config.KeepWeekly)
+	if err != nil {
+		log.Printf("snapshot retention failed: %v", err)
+	}
+	for _, name := range removed {
+		log.Printf("snapshot %s removed by retention", name)
+	}
+}
+
+func (s *Snapshotter) Take(kind string) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.DB.CreateSnapshot(s.Config.Dir, kind)
+}
+
+func (s *Snapshotter) List() ([]Snapshot, error) {
+	return ListSnapshots(s.Config.Dir)
+}
+
+// Restore replaces the live database with the named snapshot and migrates it
+// to this build's schema, recording actor in the audit log. The current
+// state is snapshotted first (kind pre-restore) and returned, so a restore
+// can itself be undone.
+func (s *Snapshotter) Restore(name string, actor Actor) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := SnapshotPath(s.Config.Dir, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.restore(s.DB.As(actor), path, true, AuditSnapshotRestore, nil)
+}
+
+// RestoreBeforeMigration restores the snapshot recorded when migration
+// version was applied; version 0 picks the newest migration that has one.
+// Migrations applied in the same run share a snapshot, so this rolls back
+// the whole run. The restored database has those migrations pending again,
+// so this is only for the command line while the server is stopped.
+func (s *Snapshotter) RestoreBeforeMigration(version int) (*AppliedMigration, *Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	migration, err := PreMigrationSnapshot(s.DB.DB, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	details := map[string]interface{}{"version": migration.Version, "name": migration.Name}
+	safety, err := s.restore(s.DB, *migration.SnapshotPath, false, AuditMigrationRestore, details)
+	return migration, safety, err
+}
+
+func (s *Snapshotter) restore(db *DB, path string, migrate bool, action string, details map[string]interface{}) (*Snapshot, error) {
+	safety, err := s.DB.CreateSnapshot(s.Config.Dir, SnapshotPreRestore)
+	if err != nil {
+		return nil, err
+	}
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["safetySnapshot"] = safety.Name
+	if err := db.restoreFrom(path, migrate, action, details); err != nil {
+		return safety, err
+	}
+	return safety, nil
+}
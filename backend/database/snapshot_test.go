@@ -0,0 +1,177 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"E-Bu-backend/models"
+)
+
+func TestSnapshot_CreateAndRestore(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	now := time.Now()
+
+	original := backupQuestion("q1", "original", now)
+	if err := db.CreateQuestion(testOwner, &original); err != nil {
+		t.Fatalf("CreateQuestion: %v", err)
+	}
+	snaps := NewSnapshotter(db, SnapshotConfig{Dir: dir})
+	snap, err := snaps.Take(SnapshotManual)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if snap.Integrity != "ok" || snap.QuestionCount != 1 || snap.SchemaVersion != LatestMigrationVersion() {
+		t.Fatalf("unexpected snapshot metadata: %+v", snap)
+	}
+
+	later := backupQuestion("q2", "added later", now)
+	if err := db.CreateQuestion(testOwner, &later); err != nil {
+		t.Fatalf("CreateQuestion: %v", err)
+	}
+	db.Model(&original).Update("content", "edited")
+	if err := db.SaveAIConfig(testOwner, &models.AIConfig{Type: models.OpenAI, ModelName: "m"}); err != nil {
+		t.Fatal(err)
+	}
+
+	safety, err := snaps.Restore(snap.Name, Actor{Username: "admin"})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	// The audit log is not rolled back, and the restore is in it.
+	entries, _ := db.ListAuditEntries(AuditFilter{}, 1, 2)
+	if len(entries.Items) != 2 || entries.Items[1].Action != AuditAIConfigUpdate {
+		t.Fatalf("audit entries after restore = %+v", entries.Items)
+	}
+	if e := entries.Items[0]; e.Action != AuditSnapshotRestore || e.ActorName != "admin" ||
+		e.Details["snapshot"] != snap.Name || e.Details["safetySnapshot"] != safety.Name {
+		t.Fatalf("restore entry = %+v", e)
+	}
+	if safety.Kind != SnapshotPreRestore || safety.QuestionCount != 2 {
+		t.Fatalf("unexpected safety snapshot: %+v", safety)
+	}
+	all, _ := db.GetQuestions(testOwner)
+	if len(all) != 1 || all[0].Content != "original" {
+		t.Fatalf("restore did not bring back the snapshot: %+v", all)
+	}
+
+	// The restored database is fully usable, indexes included.
+	if err := db.CreateQuestion(testOwner, &later); err != nil {
+		t.Fatalf("CreateQuestion after restore: %v", err)
+	}
+	var indexes int64
+	db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_questions_parent_id'").Scan(&indexes)
+	if indexes != 1 {
+		t.Fatalf("indexes were not restored")
+	}
+
+	listed, err := snaps.List()
+	if err != nil || len(listed) != 2 {
+		t.Fatalf("List = %d, %v", len(listed), err)
+	}
+	if _, err := snaps.Restore("../test.db", Actor{}); err != ErrSnapshotNotFound {
+		t.Fatalf("path outside the snapshot dir should be refused, got %v", err)
+	}
+}
+
+func TestSnapshot_RestoreMigratesToThisBuild(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// A snapshot from before question revisions and versions existed.
+	old, err := Open(filepath.Join(dir, "old.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateTo(old.DB, 11, MigrateOptions{}); err != nil {
+		t.Fatalf("MigrateTo(11): %v", err)
+	}
+	if err := old.Exec(`INSERT INTO questions (id, content, analysis, learning_guide, knowledge_points, subject, difficulty, owner_id, created_at, updated_at)
+		VALUES ('q1', 'old', 'a', 'l', '[]', 'Math', 2, ?, ?, ?)`, testOwner, time.Now(), time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	closeDB(t, old)
+
+	snaps := NewSnapshotter(db, SnapshotConfig{Dir: dir})
+	if _, err := snaps.Restore("old.db", Actor{}); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if pending, _ := HasPendingMigrations(db.DB); pending {
+		t.Fatalf("restored database was left with pending migrations")
+	}
+	q, err := db.GetQuestionByID(testOwner, "q1")
+	if err != nil || q.Version != 1 {
+		t.Fatalf("restored question = %+v, %v", q, err)
+	}
+	q.Content = "edited"
+	if err := db.UpdateQuestion(testOwner, "q1", q); err != nil {
+		t.Fatalf("UpdateQuestion after restore: %v", err)
+	}
+
+	// A snapshot from a newer build is refused and changes nothing.
+	if err := db.Create(&AppliedMigration{Version: LatestMigrationVersion() + 1, Name: "future"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	future, err := snaps.Take(SnapshotManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Delete(&AppliedMigration{}, LatestMigrationVersion()+1)
+	if _, err := snaps.Restore(future.Name, Actor{}); !errors.Is(err, ErrSnapshotTooNew) {
+		t.Fatalf("restoring a newer snapshot: %v", err)
+	}
+	if pending, _ := HasPendingMigrations(db.DB); pending {
+		t.Fatalf("refused restore changed the database")
+	}
+}
+
+func TestPruneSnapshots_KeepsDailyAndWeekly(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2026, 3, 31, 3, 0, 0, 0, time.Local) // a Tuesday
+
+	// Two scheduled snapshots a day for four weeks, plus one manual
+	// snapshot that retention must not touch.
+	for day := 0; day < 28; day++ {
+		for _, hour := range []int{0, 12} {
+			at := base.AddDate(0, 0, -day).Add(time.Duration(hour) * time.Hour)
+			writeFakeSnapshot(t, dir, &Snapshot{Name: "s-" + at.Format("0102-15") + ".db", Kind: SnapshotScheduled, CreatedAt: at})
+		}
+	}
+	writeFakeSnapshot(t, dir, &Snapshot{Name: "manual.db", Kind: SnapshotManual, CreatedAt: base.AddDate(0, -1, 0)})
+
+	if _, err := PruneSnapshots(dir, 3, 3); err != nil {
+		t.Fatalf("PruneSnapshots: %v", err)
+	}
+	left, _ := ListSnapshots(dir)
+	var names []string
+	for _, s := range left {
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+
+	// Newest per day for 3/31, 3/30 and 3/29. Weekly picks the newest of
+	// ISO weeks 14 (3/31) and 13 (Sunday 3/29), already kept, and 12
+	// (Sunday 3/22).
+	want := []string{"manual.db", "s-0322-15.db", "s-0329-15.db", "s-0330-15.db", "s-0331-15.db"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("retention kept %v, want %v", names, want)
+	}
+}
+
+func writeFakeSnapshot(t *testing.T, dir string, snap *Snapshot) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, snap.Name), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSnapshotMeta(dir, snap); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidToken wraps what is wrong with a new API token's name, scopes or
+// expiry.
+var ErrInvalidToken = errors.New("invalid API token")
+
+// apiTokenPrefix starts every API token, so leaked tokens are easy to spot.
+const apiTokenPrefix = "ebu_"
+
+// tokenUseInterval is how often a token's last use is written down; a script
+// making many requests does not cause a write on each.
+const tokenUseInterval = time.Minute
+
+// CreateAPIToken creates a token for userID and returns it. The token itself
+// is only ever returned here; the database keeps its hash. A nil expiresAt
+// never expires.
+func (db *DB) CreateAPIToken(userID string, name string, scopes []string, expiresAt *time.Time) (string, *models.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, fmt.Errorf("%w: a name is required", ErrInvalidToken)
+	}
+	scopes = uniqueStrings(scopes)
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidToken)
+	}
+	for _, scope := range scopes {
+		if !models.ValidScope(scope) {
+			return "", nil, fmt.Errorf("%w: unknown scope %q", ErrInvalidToken, scope)
+		}
+	}
+	now := time.Now()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return "", nil, fmt.Errorf("%w: the expiry is in the past", ErrInvalidToken)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	apiToken := &models.APIToken{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashSessionToken(token),
+		Prefix:    token[:len(apiTokenPrefix)+6],
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+	if err := db.Where("user_id = ? AND expires_at <= ?", userID, now).Delete(&models.APIToken{}).Error; err != nil {
+		return "", nil, err
+	}
+	if err := db.Create(apiToken).Error; err != nil {
+		return "", nil, err
+	}
+	return token, apiToken, nil
+}
+
+// ListAPITokens returns userID's tokens, newest first.
+func (db *DB) ListAPITokens(userID string) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeAPIToken deletes one of userID's tokens; it stops working at once.
+func (db *DB) RevokeAPIToken(userID string, id string) error {
+	result := db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.APIToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetTokenUser returns the account an API token belongs to and the token's
+// record, or gorm.ErrRecordNotFound if the token is unknown, revoked or
+// expired. It notes when the token was used.
+func (db *DB) GetTokenUser(token string) (*models.User, *models.APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	now := time.Now()
+	var tokens []models.APIToken
+	err := db.Where("token_hash = ? AND (expires_at IS NULL OR expires_at > ?)", hashSessionToken(token), now).
+		Limit(1).Find(&tokens).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	apiToken := &tokens[0]
+	user, err := db.GetUserByID(apiToken.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) >= tokenUseInterval {
+		if err := db.Model(apiToken).Update("last_used_at", now).Error; err != nil {
+			return nil, nil, err
+		}
+		apiToken.LastUsedAt = &now
+	}
+	return user, apiToken, nil
+}
@@ -0,0 +1,402 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"E-Bu-backend/models"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUsernameTaken      = errors.New("username is already taken")
+	ErrSetupDone          = errors.New("an account already exists")
+	ErrLastAdmin          = errors.New("the last admin account cannot be removed")
+)
+
+// MinPasswordLength is the shortest password accepted. bcrypt ignores
+// everything after 72 bytes, so longer passwords are refused rather than
+// silently truncated.
+const (
+	MinPasswordLength = 8
+	maxPasswordBytes  = 72
+)
+
+// dummyHash is compared against when a username does not exist, so a failed
+// login takes as long whether or not the account exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("ebu-no-such-user"), bcrypt.DefaultCost)
+
+// NormalizeUsername trims the name and lowercases it; usernames are unique
+// case-insensitively.
+func NormalizeUsername(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// ValidateCredentials checks a normalized username and a password against
+// the account rules.
+func ValidateCredentials(username string, password string) error {
+	if username == "" || utf8.RuneCountInString(username) > 64 {
+		return fmt.Errorf("username must be 1-64 characters")
+	}
+	if strings.IndexFunc(username, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return fmt.Errorf("username must not contain spaces")
+	}
+	return ValidatePassword(password)
+}
+
+func ValidatePassword(password string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordBytes)
+	}
+	return nil
+}
+
+func (db *DB) CountUsers() (int64, error) {
+	var n int64
+	err := db.Model(&models.User{}).Count(&n).Error
+	return n, err
+}
+
+func (db *DB) ListUsers() ([]models.User, error) {
+	var users []models.User
+	err := db.Order("username").Find(&users).Error
+	return users, err
+}
+
+func (db *DB) GetUserByID(id string) (*models.User, error) {
+	var user models.User
+	result := db.First(&user, "id = ?", id)
+	return &user, result.Error
+}
+
+func (db *DB) GetUserByUsername(username string) (*models.User, error) {
+	var user models.User
+	result := db.First(&user, "username = ?", NormalizeUsername(username))
+	return &user, result.Error
+}
+
+// CreateUser adds an account. The username is normalized and the password
+// hashed with bcrypt.
+func (db *DB) CreateUser(username string, password string, role string) (*models.User, error) {
+	user, err := newUser(username, password, role)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.GetUserByUsername(user.Username); err == nil {
+		return nil, ErrUsernameTaken
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, AuditUserCreate, []string{user.ID}, map[string]interface{}{"username": user.Username, "role": user.Role})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// CreateFirstAdmin creates the admin account during first-run setup. The
+// insert only happens while the users table is empty, in a single
+// statement, so two concurrent setups cannot both succeed. The new admin
+// adopts the data that predates accounts.
+func (db *DB) CreateFirstAdmin(username string, password string) (*models.User, error) {
+	user, err := newUser(username, password, models.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(`INSERT INTO users (id, username, password_hash, role, created_at, updated_at)
+			SELECT ?, ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM users)`,
+			user.ID, user.Username, user.PasswordHash, user.Role, user.CreatedAt, user.UpdatedAt)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrSetupDone
+		}
+		if err := adoptUnownedRows(tx, user.ID); err != nil {
+			return err
+		}
+		return recordAudit(tx, AuditUserCreate, []string{user.ID}, map[string]interface{}{"username": user.Username, "role": user.Role, "setup": true})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func newUser(username string, password string, role string) (*models.User, error) {
+	username = NormalizeUsername(username)
+	if err := ValidateCredentials(username, password); err != nil {
+		return nil, err
+	}
+	if !models.ValidRole(role) {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &models.User{
+		ID:           uuid.New().String(),
+		Username:     username,
+		PasswordHash: string(hash),
+		Role:         role,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}, nil
+}
+
+// Authenticate returns the account if the password matches, and
+// ErrInvalidCredentials otherwise, without saying which part was wrong.
+func (db *DB) Authenticate(username string, password string) (*models.User, error) {
+	user, err := db.GetUserByUsername(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// SetPassword replaces a user's password and ends all of their sessions.
+func (db *DB) SetPassword(userID string, password string) error {
+	if err := ValidatePassword(password); err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"password_hash": string(hash), "updated_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.UserSession{}).Error
+	})
+}
+
+// DeleteUser removes an account, its sessions and API tokens, its class
+// memberships and parent links, and everything it owns: questions with their
+// practice and review history, collections, the AI config and, for a
+// teacher, classes with their assignments. Nothing would be able to reach
+// that data again. The last admin cannot be removed, since nobody could
+// manage accounts afterwards.
+func (db *DB) DeleteUser(id string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := ensureOtherAdmin(tx, &user); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.UserSession{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.APIToken{}).Error; err != nil {
+			return err
+		}
+		if err := unlinkRelations(tx, id, ""); err != nil {
+			return err
+		}
+		collections := tx.Model(&models.Collection{}).Select("id").Where("owner_id = ?", id)
+		if err := tx.Where("collection_id IN (?)", collections).Delete(&models.CollectionQuestion{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("owner_id = ?", id).Delete(&models.Collection{}).Error; err != nil {
+			return err
+		}
+		var questions []string
+		if err := tx.Model(&models.Question{}).Where("owner_id = ?", id).Pluck("id", &questions).Error; err != nil {
+			return err
+		}
+		if len(questions) > 0 {
+			if _, err := hardDeleteQuestions(tx, questions); err != nil {
+				return err
+			}
+		}
+		for _, owned := range []interface{}{
+			&models.PracticeAttempt{},
+			&models.PracticeSession{},
+			&models.ReviewLog{},
+			&models.QuestionRevision{},
+			&models.AIConfig{},
+		} {
+			if err := tx.Where("owner_id = ?", id).Delete(owned).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("student_id = ?", id).Delete(&models.AssignmentStudent{}).Error; err != nil {
+			return err
+		}
+		var classes []string
+		if err := tx.Model(&models.Class{}).Where("teacher_id = ?", id).Pluck("id", &classes).Error; err != nil {
+			return err
+		}
+		for _, classID := range classes {
+			if err := clearClass(tx, classID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("teacher_id = ?", id).Delete(&models.Class{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&user).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, AuditUserDelete, []string{id}, map[string]interface{}{
+			"username": user.Username, "role": user.Role, "questions": len(questions),
+		})
+	})
+}
+
+// SetUserRole changes an account's role. Class memberships and parent links
+// that do not fit the new role are removed; the last admin keeps the role.
+func (db *DB) SetUserRole(id string, role string) (*models.User, error) {
+	if !models.ValidRole(role) {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+	var user models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if user.Role == role {
+			return nil
+		}
+		if err := ensureOtherAdmin(tx, &user); err != nil {
+			return err
+		}
+		if err := unlinkRelations(tx, id, role); err != nil {
+			return err
+		}
+		from := user.Role
+		user.Role = role
+		user.UpdatedAt = time.Now()
+		if err := tx.Model(&user).Updates(map[string]interface{}{"role": role, "updated_at": user.UpdatedAt}).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, AuditUserRole, []string{id}, map[string]interface{}{"username": user.Username, "from": from, "to": role})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ensureOtherAdmin returns ErrLastAdmin if user is the only admin.
+func ensureOtherAdmin(tx *gorm.DB, user *models.User) error {
+	if user.Role != models.RoleAdmin {
+		return nil
+	}
+	var admins int64
+	if err := tx.Model(&models.User{}).Where("role = ?", models.RoleAdmin).Count(&admins).Error; err != nil {
+		return err
+	}
+	if admins <= 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// unlinkRelations removes the class memberships and parent links of userID
+// that an account with role cannot have; an empty role removes them all.
+func unlinkRelations(tx *gorm.DB, userID string, role string) error {
+	if role != models.RoleStudent {
+		if err := tx.Where("student_id = ?", userID).Delete(&models.ClassMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("student_id = ?", userID).Delete(&models.ParentLink{}).Error; err != nil {
+			return err
+		}
+	}
+	if role != models.RoleParent {
+		return tx.Where("parent_id = ?", userID).Delete(&models.ParentLink{}).Error
+	}
+	return nil
+}
+
+// CreateUserSession starts a session for userID and returns the token for
+// the session cookie. Only its hash is stored. Expired sessions are cleaned
+// up on the way.
+func (db *DB) CreateUserSession(userID string, ttl time.Duration) (string, *models.UserSession, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	now := time.Now()
+	session := &models.UserSession{
+		ID:        hashSessionToken(token),
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := db.Where("expires_at <= ?", now).Delete(&models.UserSession{}).Error; err != nil {
+		return "", nil, err
+	}
+	if err := db.Create(session).Error; err != nil {
+		return "", nil, err
+	}
+	return token, session, nil
+}
+
+// GetSessionUser returns the account a session token belongs to, or
+// gorm.ErrRecordNotFound if the token is unknown or expired.
+func (db *DB) GetSessionUser(token string) (*models.User, error) {
+	if token == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var users []models.User
+	err := db.Joins("JOIN user_sessions ON user_sessions.user_id = users.id").
+		Where("user_sessions.id = ? AND user_sessions.expires_at > ?", hashSessionToken(token), time.Now()).
+		Limit(1).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &users[0], nil
+}
+
+// DeleteUserSession ends the session a token belongs to, if any.
+func (db *DB) DeleteUserSession(token string) error {
+	return db.Where("id = ?", hashSessionToken(token)).Delete(&models.UserSession{}).Error
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
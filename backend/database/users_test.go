@@ -0,0 +1,218 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"E-Bu-backend/models"
+
+	"gorm.io/gorm"
+)
+
+func TestCreateFirstAdmin_OnlyOnce(t *testing.T) {
+	db := &DB{newTestDB(t)}
+
+	if _, err := db.CreateFirstAdmin("admin", "short"); err == nil {
+		t.Fatalf("short password accepted")
+	}
+	admin, err := db.CreateFirstAdmin("  Admin ", "correct horse")
+	if err != nil {
+		t.Fatalf("CreateFirstAdmin: %v", err)
+	}
+	if admin.Username != "admin" || admin.Role != models.RoleAdmin || admin.PasswordHash == "correct horse" {
+		t.Fatalf("unexpected admin: %+v", admin)
+	}
+	if _, err := db.CreateFirstAdmin("other", "correct horse"); !errors.Is(err, ErrSetupDone) {
+		t.Fatalf("second setup err = %v, want ErrSetupDone", err)
+	}
+	if _, err := db.CreateUser("ADMIN", "correct horse", models.RoleStudent); !errors.Is(err, ErrUsernameTaken) {
+		t.Fatalf("duplicate username err = %v, want ErrUsernameTaken", err)
+	}
+}
+
+func TestAuthenticateAndSessions(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	user, err := db.CreateUser("student", "correct horse", models.RoleStudent)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	for _, tc := range []struct{ username, password string }{
+		{"student", "wrong horse"},
+		{"nobody", "correct horse"},
+	} {
+		if _, err := db.Authenticate(tc.username, tc.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Authenticate(%q, %q) err = %v", tc.username, tc.password, err)
+		}
+	}
+	if got, err := db.Authenticate("Student", "correct horse"); err != nil || got.ID != user.ID {
+		t.Fatalf("Authenticate = %v, %v", got, err)
+	}
+
+	token, _, err := db.CreateUserSession(user.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateUserSession: %v", err)
+	}
+	if got, err := db.GetSessionUser(token); err != nil || got.ID != user.ID {
+		t.Fatalf("GetSessionUser = %v, %v", got, err)
+	}
+	var stored models.UserSession
+	db.First(&stored)
+	if stored.ID == token {
+		t.Fatalf("session token stored in plain text")
+	}
+
+	expired, _, err := db.CreateUserSession(user.ID, -time.Minute)
+	if err != nil {
+		t.Fatalf("CreateUserSession: %v", err)
+	}
+	if _, err := db.GetSessionUser(expired); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expired session err = %v", err)
+	}
+
+	// A new password signs the account out everywhere.
+	if err := db.SetPassword(user.ID, "battery staple"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	if _, err := db.GetSessionUser(token); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("session survived a password change: %v", err)
+	}
+	if _, err := db.Authenticate("student", "battery staple"); err != nil {
+		t.Fatalf("new password rejected: %v", err)
+	}
+}
+
+func TestDeleteUser_KeepsLastAdmin(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	admin, _ := db.CreateFirstAdmin("admin", "correct horse")
+	second, _ := db.CreateUser("second", "correct horse", models.RoleAdmin)
+
+	if err := db.DeleteUser(second.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if err := db.DeleteUser(admin.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("deleting the last admin err = %v, want ErrLastAdmin", err)
+	}
+	if err := db.DeleteUser(second.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("deleting a missing user err = %v", err)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	user := newTestUser(t, db, "script", models.RoleStudent)
+	other := newTestUser(t, db, "other", models.RoleStudent)
+
+	past := time.Now().Add(-time.Hour)
+	for _, tc := range []struct {
+		name   string
+		scopes []string
+		expiry *time.Time
+	}{
+		{" ", []string{models.ScopeQuestionsRead}, nil},
+		{"nightly", nil, nil},
+		{"nightly", []string{"everything"}, nil},
+		{"nightly", []string{models.ScopeAdmin}, &past},
+	} {
+		if _, _, err := db.CreateAPIToken(user.ID, tc.name, tc.scopes, tc.expiry); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("CreateAPIToken(%q, %v, %v) err = %v", tc.name, tc.scopes, tc.expiry, err)
+		}
+	}
+
+	token, created, err := db.CreateAPIToken(user.ID, "nightly", []string{models.ScopeAdmin, models.ScopeQuestionsRead, models.ScopeAdmin}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	if created.Scopes != "admin questions:read" || !strings.HasPrefix(token, created.Prefix) || created.TokenHash == token {
+		t.Fatalf("created token = %+v", created)
+	}
+	got, apiToken, err := db.GetTokenUser(token)
+	if err != nil || got.ID != user.ID || !apiToken.HasScope(models.ScopeAdmin) || apiToken.HasScope(models.ScopeAnalyze) {
+		t.Fatalf("GetTokenUser = %+v, %+v, %v", got, apiToken, err)
+	}
+	if tokens, _ := db.ListAPITokens(user.ID); len(tokens) != 1 || tokens[0].LastUsedAt == nil {
+		t.Fatalf("last use not recorded: %+v", tokens)
+	}
+	if _, _, err := db.GetTokenUser(token + "x"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("unknown token err = %v", err)
+	}
+
+	if err := db.RevokeAPIToken(other.ID, created.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("another user revoked the token: %v", err)
+	}
+	if err := db.RevokeAPIToken(user.ID, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.GetTokenUser(token); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("revoked token err = %v", err)
+	}
+
+	soon := time.Now().Add(50 * time.Millisecond)
+	expiring, _, err := db.CreateAPIToken(user.ID, "short", []string{models.ScopeQuestionsRead}, &soon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, _, err := db.GetTokenUser(expiring); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expired token err = %v", err)
+	}
+}
+
+func TestDeleteUser_RemovesOwnedData(t *testing.T) {
+	db := &DB{newTestDB(t)}
+	db.CreateFirstAdmin("admin", "correct horse")
+	teacher := newTestUser(t, db, "teacher", models.RoleTeacher)
+	alice := newTestUser(t, db, "alice", models.RoleStudent)
+	class, _ := db.CreateClass(teacher.ID, "7A")
+	if _, err := db.AddClassMember(teacher.ID, class.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	q := backupQuestion("t1", "question one", time.Now())
+	if err := db.CreateQuestion(teacher.ID, &q); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateAssignment(teacher.ID, class.ID, "homework", time.Now().Add(time.Hour), []string{"t1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveAIConfig(alice.ID, &models.AIConfig{Type: models.OpenAI, ModelName: "m"}); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(model interface{}, query string, args ...interface{}) int64 {
+		t.Helper()
+		var n int64
+		if err := db.Model(model).Where(query, args...).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if err := db.DeleteUser(teacher.ID); err != nil {
+		t.Fatalf("DeleteUser(teacher): %v", err)
+	}
+	if n := count(&models.Question{}, "owner_id = ?", teacher.ID); n != 0 {
+		t.Fatalf("teacher still owns %d questions", n)
+	}
+	if n := count(&models.Class{}, "teacher_id = ?", teacher.ID) + count(&models.Assignment{}, "teacher_id = ?", teacher.ID) +
+		count(&models.ClassMember{}, "class_id = ?", class.ID) + count(&models.AssignmentStudent{}, "student_id = ?", alice.ID); n != 0 {
+		t.Fatalf("%d class rows left behind", n)
+	}
+	// Alice keeps her copies of the assignment; they are hers.
+	if n := count(&models.Question{}, "owner_id = ?", alice.ID); n != 1 {
+		t.Fatalf("alice owns %d questions, want 1", n)
+	}
+
+	if err := db.DeleteUser(alice.ID); err != nil {
+		t.Fatalf("DeleteUser(alice): %v", err)
+	}
+	for _, model := range []interface{}{
+		&models.Question{}, &models.PracticeSession{}, &models.PracticeAttempt{},
+		&models.QuestionRevision{}, &models.AIConfig{},
+	} {
+		if n := count(model, "owner_id = ?", alice.ID); n != 0 {
+			t.Fatalf("%T: %d rows of alice left behind", model, n)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"E-Bu-backend/models"
+)
+
+var validSubjects = map[models.Subject]bool{
+	models.Math:      true,
+	models.Physics:   true,
+	models.Chemistry: true,
+	models.Biology:   true,
+	models.English:   true,
+	models.Chinese:   true,
+	models.Other:     true,
+}
+
+// ValidateQuestion checks a question record before it is written (imports)
+// or when auditing stored rows. It returns human-readable reasons, empty
+// when the record is valid.
+func ValidateQuestion(q *models.Question) []string {
+	var reasons []string
+
+	if len(q.ID) > 36 {
+		reasons = append(reasons, "id is longer than 36 characters")
+	}
+	if strings.TrimSpace(q.Content) == "" {
+		reasons = append(reasons, "content is empty")
+	}
+	if !validSubjects[q.Subject] {
+		reasons = append(reasons, fmt.Sprintf("unknown subject %q", q.Subject))
+	}
+	if q.Difficulty < 1 || q.Difficulty > 5 {
+		reasons = append(reasons, fmt.Sprintf("difficulty %d is outside 1-5", q.Difficulty))
+	}
+	if q.KnowledgePoints == nil {
+		reasons = append(reasons, "knowledgePoints is missing")
+	} else if err := checkStringArrayJSON(*q.KnowledgePoints); err != nil {
+		reasons = append(reasons, "knowledgePoints "+err.Error())
+	}
+	if q.Options != nil {
+		if err := checkStringArrayJSON(*q.Options); err != nil {
+			reasons = append(reasons, "options "+err.Error())
+		}
+	}
+	if q.Image != nil {
+		if err := checkImageDataURL(*q.Image); err != nil {
+			reasons = append(reasons, "image "+err.Error())
+		}
+	}
+	if q.CroppedDiagram != nil {
+		if err := checkImageDataURL(*q.CroppedDiagram); err != nil {
+			reasons = append(reasons, "croppedDiagram "+err.Error())
+		}
+	}
+	if q.ParentID != nil && len(*q.ParentID) > 36 {
+		reasons = append(reasons, "parentId is longer than 36 characters")
+	}
+	if q.ReviewStreak < 0 {
+		reasons = append(reasons, "reviewStreak is negative")
+	}
+
+	return reasons
+}
+
+// checkStringArrayJSON accepts the JSON-string encoding used for options and
+// knowledge points: a JSON array of strings (or null).
+func checkStringArrayJSON(s string) error {
+	var items []string
+	if err := json.Unmarshal([]byte(s), &items); err != nil {
+		return fmt.Errorf("is not a JSON array of strings: %v", err)
+	}
+	return nil
+}
+
+// checkImageDataURL accepts "data:image/...;base64,..." with a payload that
+// decodes cleanly. Empty strings are treated like a missing image.
+func checkImageDataURL(s string) error {
+	if s == "" {
+		return nil
+	}
+	if !strings.HasPrefix(s, "data:image/") {
+		return fmt.Errorf("is not a data:image URL")
+	}
+	comma := strings.Index(s, ",")
+	if comma < 0 || !strings.HasSuffix(s[:comma], ";base64") {
+		return fmt.Errorf("is not base64 encoded")
+	}
+	if _, err := base64.StdEncoding.DecodeString(s[comma+1:]); err != nil {
+		return fmt.Errorf("has invalid base64 data: %v", err)
+	}
+	return nil
+}
@@ -19,7 +19,7 @@ func NewAIConfigHandler(db *database.DB) *AIConfigHandler {
 
 // GetAIConfig retrieves the current AI configuration
 func (h *AIConfigHandler) GetAIConfig(c *gin.Context) {
-	config, err := h.DB.GetAIConfig()
+	config, err := h.DB.GetAIConfig(currentUserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch AI config"})
 		return
@@ -55,7 +55,7 @@ func (h *AIConfigHandler) SaveAIConfig(c *gin.Context) {
 	}
 
 	// Save the config
-	if err := h.DB.SaveAIConfig(&req); err != nil {
+	if err := h.DB.As(auditActor(c)).SaveAIConfig(currentUserID(c), &req); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save AI config"})
 		return
 	}
@@ -79,7 +79,7 @@ func (h *AIConfigHandler) AnalyzeImage(c *gin.Context) {
 	}
 
 	// Get the AI config to determine which provider to use
-	_, err := h.DB.GetAIConfig()
+	_, err := h.DB.GetAIConfig(currentUserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get AI config"})
 		return
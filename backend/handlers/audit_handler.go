@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"E-Bu-backend/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditHandler struct {
+	DB *database.DB
+}
+
+func NewAuditHandler(db *database.DB) *AuditHandler {
+	return &AuditHandler{DB: db}
+}
+
+// ListAuditEntries returns a page of the audit log, newest first, filtered
+// by action, actorId, targetId and the RFC 3339 times since and until.
+func (h *AuditHandler) ListAuditEntries(c *gin.Context) {
+	filter := database.AuditFilter{
+		Action:   c.Query("action"),
+		ActorID:  c.Query("actorId"),
+		TargetID: c.Query("targetId"),
+	}
+	for _, bound := range []struct {
+		param string
+		dest  *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": bound.param + " must be an RFC 3339 time"})
+			return
+		}
+		*bound.dest = t
+	}
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("pageSize"))
+	entries, err := h.DB.ListAuditEntries(filter, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read the audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,579 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"E-Bu-backend/database"
+	"E-Bu-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SessionCookie is the cookie holding the session token.
+const SessionCookie = "ebu_session"
+
+// contextUserKey is where RequireAuth stores the signed-in user.
+const contextUserKey = "user"
+
+// contextTokenKey is where RequireAuth stores the API token a request was
+// made with; it is unset for session logins.
+const contextTokenKey = "apiToken"
+
+type AuthHandler struct {
+	DB         *database.DB
+	SessionTTL time.Duration
+	// SetupCode, when set, must be sent with the first-run setup request, so
+	// that whoever reaches the server first cannot claim the admin account.
+	// The server prints it to the log at startup.
+	SetupCode string
+
+	limiter        *loginLimiter
+	trustedProxies []*net.IPNet
+}
+
+func NewAuthHandler(db *database.DB) *AuthHandler {
+	return &AuthHandler{
+		DB:         db,
+		SessionTTL: 30 * 24 * time.Hour,
+		limiter:    newLoginLimiter(5, 15*time.Minute),
+	}
+}
+
+// SetTrustedProxies sets the addresses or CIDRs whose X-Forwarded-Proto is
+// believed when deciding whether the session cookie is Secure. Pass the same
+// list as to the gin engine's SetTrustedProxies; by default nobody is
+// trusted.
+func (h *AuthHandler) SetTrustedProxies(proxies []string) error {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			proxy = fmt.Sprintf("%s/%d", proxy, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	h.trustedProxies = nets
+	return nil
+}
+
+// fromTrustedProxy reports whether the request's connection comes from one
+// of the trusted proxies.
+func (h *AuthHandler) fromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range h.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type credentials struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// CurrentUser returns the user RequireAuth signed in for this request.
+func CurrentUser(c *gin.Context) *models.User {
+	if v, ok := c.Get(contextUserKey); ok {
+		if user, ok := v.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// currentUserID is the owner of everything a request reads or writes. It is
+// empty, which matches no data, if nobody is signed in.
+func currentUserID(c *gin.Context) string {
+	if user := CurrentUser(c); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// auditActor is who the audit log records for the changes this request
+// makes.
+func auditActor(c *gin.Context) database.Actor {
+	actor := database.Actor{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+	if user := CurrentUser(c); user != nil {
+		actor.UserID, actor.Username = user.ID, user.Username
+	}
+	return actor
+}
+
+// currentToken returns the API token of this request, or nil for a session
+// login.
+func currentToken(c *gin.Context) *models.APIToken {
+	if v, ok := c.Get(contextTokenKey); ok {
+		if token, ok := v.(*models.APIToken); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+// RequireAuth rejects requests with 401 unless they carry a valid session
+// cookie or an API token in "Authorization: Bearer <token>". A request with
+// a bearer token is judged by the token alone.
+func (h *AuthHandler) RequireAuth(c *gin.Context) {
+	var user *models.User
+	var err error
+	if bearer, ok := bearerToken(c); ok {
+		var apiToken *models.APIToken
+		user, apiToken, err = h.DB.GetTokenUser(bearer)
+		if err == nil {
+			c.Set(contextTokenKey, apiToken)
+		}
+	} else {
+		token, _ := c.Cookie(SessionCookie)
+		user, err = h.DB.GetSessionUser(token)
+	}
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check session"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	c.Set(contextUserKey, user)
+	c.Next()
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[len("Bearer "):]), true
+}
+
+// RequireScope rejects requests made with an API token that lacks scope
+// with 403. Session logins may do anything their role allows. It must run
+// after RequireAuth.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := currentToken(c); token != nil && !token.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This API token lacks the " + scope + " scope"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession rejects requests made with an API token with 403, for the
+// routes that manage the account itself. It must run after RequireAuth.
+func RequireSession(c *gin.Context) {
+	if currentToken(c) != nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API tokens cannot do this; sign in instead"})
+		return
+	}
+	c.Next()
+}
+
+// RequireAdmin rejects signed-in users who are not admins with 403. It must
+// run after RequireAuth.
+func RequireAdmin(c *gin.Context) {
+	if user := CurrentUser(c); user == nil || user.Role != models.RoleAdmin {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+	c.Next()
+}
+
+// RequireRole rejects signed-in users whose role is not one of roles with
+// 403. It must run after RequireAuth.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if user := CurrentUser(c); user != nil {
+			for _, role := range roles {
+				if user.Role == role {
+					c.Next()
+					return
+				}
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Your role cannot do this"})
+	}
+}
+
+// Status tells the client whether first-run setup is needed and who is
+// signed in, if anyone. It never fails for missing credentials.
+func (h *AuthHandler) Status(c *gin.Context) {
+	n, err := h.DB.CountUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count users"})
+		return
+	}
+	var user *models.User
+	if token, err := c.Cookie(SessionCookie); err == nil {
+		user, _ = h.DB.GetSessionUser(token)
+	}
+	c.JSON(http.StatusOK, gin.H{"setupRequired": n == 0, "user": user})
+}
+
+// Setup creates the admin account on a server without accounts and signs it
+// in. Once any account exists it answers 409.
+func (h *AuthHandler) Setup(c *gin.Context) {
+	var req struct {
+		credentials
+		SetupCode string `json:"setupCode"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if h.SetupCode != "" && subtle.ConstantTimeCompare([]byte(req.SetupCode), []byte(h.SetupCode)) != 1 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid setup code; it is printed in the server log"})
+		return
+	}
+
+	user, err := h.DB.As(auditActor(c)).CreateFirstAdmin(req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, database.ErrSetupDone) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Setup has already been completed"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.startSession(c, user, http.StatusCreated)
+}
+
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req credentials
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key := c.ClientIP() + "\x00" + database.NormalizeUsername(req.Username)
+	if retry := h.limiter.blocked(key); retry > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins; try again later"})
+		return
+	}
+	user, err := h.DB.Authenticate(req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidCredentials) {
+			h.limiter.fail(key)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+	h.limiter.reset(key)
+	h.startSession(c, user, http.StatusOK)
+}
+
+// Logout ends the current session, if there is one, and clears the cookie.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	if token, err := c.Cookie(SessionCookie); err == nil {
+		if err := h.DB.DeleteUserSession(token); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out"})
+			return
+		}
+	}
+	h.setCookie(c, "", -1)
+	c.JSON(http.StatusOK, gin.H{"message": "Signed out"})
+}
+
+func (h *AuthHandler) Me(c *gin.Context) {
+	c.JSON(http.StatusOK, CurrentUser(c))
+}
+
+// ChangePassword sets a new password for the signed-in user. All of their
+// sessions end, and this one is replaced with a fresh session.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"currentPassword" binding:"required"`
+		NewPassword     string `json:"newPassword" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := CurrentUser(c)
+	if _, err := h.DB.Authenticate(user.Username, req.CurrentPassword); err != nil {
+		if errors.Is(err, database.ErrInvalidCredentials) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Current password is wrong"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check password"})
+		return
+	}
+	if err := database.ValidatePassword(req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.DB.SetPassword(user.ID, req.NewPassword); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+	h.startSession(c, user, http.StatusOK)
+}
+
+// ListUsers, CreateUser, DeleteUser, SetUserRole, LinkChild and UnlinkChild
+// manage accounts; they are admin-only.
+func (h *AuthHandler) ListUsers(c *gin.Context) {
+	users, err := h.DB.ListUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list users"})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+func (h *AuthHandler) CreateUser(c *gin.Context) {
+	var req struct {
+		credentials
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.RoleStudent
+	}
+	user, err := h.DB.As(auditActor(c)).CreateUser(req.Username, req.Password, req.Role)
+	if err != nil {
+		if errors.Is(err, database.ErrUsernameTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+func (h *AuthHandler) DeleteUser(c *gin.Context) {
+	err := h.DB.As(auditActor(c)).DeleteUser(c.Param("id"))
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, database.ErrLastAdmin):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+	}
+}
+
+// SetUserRole changes an account's role; the last admin keeps theirs.
+func (h *AuthHandler) SetUserRole(c *gin.Context) {
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+	user, err := h.DB.As(auditActor(c)).SetUserRole(c.Param("id"), req.Role)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, database.ErrLastAdmin):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change role"})
+	default:
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// LinkChild lets the parent account :id follow a student.
+func (h *AuthHandler) LinkChild(c *gin.Context) {
+	var req struct {
+		StudentID string `json:"studentId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := h.DB.LinkParent(c.Param("id"), req.StudentID)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, database.ErrNotParent), errors.Is(err, database.ErrNotStudent):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link child"})
+	default:
+		c.JSON(http.StatusCreated, gin.H{"message": "Child linked"})
+	}
+}
+
+func (h *AuthHandler) UnlinkChild(c *gin.Context) {
+	err := h.DB.UnlinkParent(c.Param("id"), c.Param("studentId"))
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink child"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Child unlinked"})
+	}
+}
+
+// ListTokens lists the signed-in user's API tokens, without the tokens
+// themselves.
+func (h *AuthHandler) ListTokens(c *gin.Context) {
+	tokens, err := h.DB.ListAPITokens(currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// CreateToken creates an API token. The response is the only time the
+// token is shown.
+func (h *AuthHandler) CreateToken(c *gin.Context) {
+	var req struct {
+		Name      string     `json:"name" binding:"required"`
+		Scopes    []string   `json:"scopes" binding:"required"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	token, apiToken, err := h.DB.CreateAPIToken(currentUserID(c), req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API token"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": token, "apiToken": apiToken})
+}
+
+func (h *AuthHandler) RevokeToken(c *gin.Context) {
+	err := h.DB.RevokeAPIToken(currentUserID(c), c.Param("id"))
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "API token not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API token"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "API token revoked"})
+	}
+}
+
+func (h *AuthHandler) startSession(c *gin.Context, user *models.User, status int) {
+	token, session, err := h.DB.CreateUserSession(user.ID, h.SessionTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start session"})
+		return
+	}
+	h.setCookie(c, token, int(time.Until(session.ExpiresAt).Seconds()))
+	c.JSON(status, gin.H{"user": user, "expiresAt": session.ExpiresAt})
+}
+
+// setCookie writes the session cookie: HttpOnly, SameSite=Lax so other sites
+// cannot send it with their requests, and Secure whenever the request came
+// in over HTTPS (directly or through a trusted proxy that says so).
+func (h *AuthHandler) setCookie(c *gin.Context, token string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil || (h.fromTrustedProxy(c) && c.GetHeader("X-Forwarded-Proto") == "https"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// loginLimiter blocks a client from guessing a username's password after
+// too many failures in a row, until the window has passed.
+type loginLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	failures map[string]*loginFailures
+}
+
+type loginFailures struct {
+	count int
+	first time.Time
+}
+
+func newLoginLimiter(max int, window time.Duration) *loginLimiter {
+	return &loginLimiter{max: max, window: window, failures: map[string]*loginFailures{}}
+}
+
+// blocked returns how long key has to wait, or 0 if it may try.
+func (l *loginLimiter) blocked(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.failures[key]
+	if !ok {
+		return 0
+	}
+	wait := time.Until(f.first.Add(l.window))
+	if wait <= 0 {
+		delete(l.failures, key)
+		return 0
+	}
+	if f.count < l.max {
+		return 0
+	}
+	return wait
+}
+
+func (l *loginLimiter) fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for k, f := range l.failures {
+		if now.Sub(f.first) >= l.window {
+			delete(l.failures, k)
+		}
+	}
+	if f, ok := l.failures[key]; ok {
+		f.count++
+		return
+	}
+	l.failures[key] = &loginFailures{count: 1, first: now}
+}
+
+func (l *loginLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
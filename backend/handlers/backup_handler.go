@@ -1,74 +1,231 @@
 package handlers
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
-	"time"
+	"os"
+	"strconv"
+	"strings"
 
 	"E-Bu-backend/database"
-	"E-Bu-backend/models"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+// DefaultMaxImportBytes caps the size of an uploaded backup unless
+// BackupHandler.MaxImportBytes says otherwise.
+const DefaultMaxImportBytes int64 = 512 << 20
+
 type BackupHandler struct {
 	DB *database.DB
+	// MaxImportBytes caps the request body of an import; 0 means
+	// DefaultMaxImportBytes.
+	MaxImportBytes int64
+	progress       *importTracker
 }
 
 func NewBackupHandler(db *database.DB) *BackupHandler {
-	return &BackupHandler{DB: db}
+	return &BackupHandler{DB: db, MaxImportBytes: DefaultMaxImportBytes, progress: newImportTracker()}
 }
 
-// ExportBackup exports all questions as a JSON backup
+// ExportBackup streams the signed-in user's questions (including deleted
+// ones) straight from the database cursor. format=json (default) writes a
+// BackupData document with inline images; format=zip writes a .ebu.zip
+// archive.
 func (h *BackupHandler) ExportBackup(c *gin.Context) {
-	// Get all questions (including deleted ones)
-	var allQuestions []models.Question
-	result := h.DB.Find(&allQuestions)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch questions for backup"})
+	export := h.DB.ExportBackupJSON
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.Header("Content-Type", "application/json")
+		c.Header("Content-Disposition", "attachment; filename=E-Bu_backup.json")
+	case "zip":
+		export = h.DB.ExportBackupZip
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", "attachment; filename="+database.ZipBackupFileName)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or zip"})
 		return
 	}
+	c.Status(http.StatusOK)
 
-	backupData := models.BackupData{
-		Version:    "1.2.0",
-		ExportedAt: time.Now().Unix(),
-		Data:       allQuestions,
+	count, err := export(currentUserID(c), c.Writer)
+	if err != nil {
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Disposition")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch questions for backup"})
+			return
+		}
+		// Headers are gone; the truncated document fails to parse on import.
+		log.Printf("export aborted after %d questions: %v", count, err)
+		c.Abort()
 	}
-
-	c.Header("Content-Type", "application/json")
-	c.Header("Content-Disposition", "attachment; filename=E-Bu_backup.json")
-	c.JSON(http.StatusOK, backupData)
 }
 
-// ImportBackup imports questions from a JSON backup
+// ImportBackup imports questions from a JSON backup or a .ebu.zip archive;
+// the format is detected from the upload itself.
+// Query parameters:
+//   - mode: merge (default), replace or append
+//   - conflict: keep-newer (default), skip or overwrite; merge mode only
+//   - onError: abort (default) rolls everything back on the first bad record,
+//     skip leaves failing records out and imports the rest
+//   - dryRun: validate and diff against the database without writing
+//   - progressId: ID to poll GET /import/progress/:id with while the upload
+//     runs; one is generated when omitted and returned with the result
+//
+// The body may not exceed MaxImportBytes. It is received in full before the
+// import starts writing, then decoded one record at a time.
 func (h *BackupHandler) ImportBackup(c *gin.Context) {
-	var backupData models.BackupData
-	if err := c.ShouldBindJSON(&backupData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backup file format"})
+	opts := database.ImportOptions{
+		Mode:     c.Query("mode"),
+		Conflict: c.Query("conflict"),
+		OnError:  c.Query("onError"),
+	}
+	if err := opts.Normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
 
-	// Clear existing data (optional - you might want to merge instead)
-	var existingQuestions []models.Question
-	h.DB.Find(&existingQuestions)
-	for _, question := range existingQuestions {
-		h.DB.Delete(&question)
+	progressID := c.Query("progressId")
+	if progressID == "" {
+		progressID = uuid.New().String()
+	} else if len(progressID) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "progressId is too long"})
+		return
 	}
 
-	// Import new data
-	for i := range backupData.Data {
-		// Generate new IDs for imported questions to avoid conflicts
-		backupData.Data[i].ID = ""
+	limit := h.MaxImportBytes
+	if limit <= 0 {
+		limit = DefaultMaxImportBytes
+	}
+	body := &countingReader{r: http.MaxBytesReader(c.Writer, c.Request.Body, limit)}
+	ownerID := currentUserID(c)
+	job, ok := h.progress.start(ownerID, progressID, body, c.Request.ContentLength, dryRun)
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "An import with this progressId is already running"})
+		return
+	}
+	src, cleanup, err := h.openImportSource(body)
+	if err != nil {
+		h.progress.finish(job, nil, err)
+		h.importFailed(c, err, nil, "Failed to read backup")
+		return
+	}
+	defer cleanup()
+
+	if dryRun {
+		preview, err := h.DB.PreviewImportStream(ownerID, src, opts)
+		h.progress.finish(job, nil, err)
+		if err != nil {
+			h.importFailed(c, err, nil, "Failed to preview import")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"dryRun": true, "preview": preview, "progressId": progressID})
+		return
 	}
 
-	for i := range backupData.Data {
-		// Generate a new ID for each question
-		backupData.Data[i].ID = uuid.New().String()
-		if err := h.DB.Create(&backupData.Data[i]).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import questions"})
+	report, err := h.DB.As(auditActor(c)).ImportQuestionStream(ownerID, src, opts, func(r *database.ImportReport) {
+		h.progress.update(job, r)
+	})
+	h.progress.finish(job, report, err)
+	if err != nil {
+		if errors.Is(err, database.ErrImportAborted) {
+			// Name the first offending record instead of a generic failure.
+			msg := err.Error()
+			if len(report.Errors) > 0 {
+				first := report.Errors[0]
+				msg = fmt.Sprintf("%s: record %d (%s): %s", msg, first.Index, first.ID, first.Reason)
+			}
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": msg, "report": report})
 			return
 		}
+		h.importFailed(c, err, report, "Failed to import questions")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Backup imported successfully",
+		"count":      report.Inserted + report.Updated,
+		"report":     report,
+		"progressId": progressID,
+	})
+}
+
+// openImportSource spools the upload to a temporary file, so the import's
+// write transaction never waits on a slow client, and sniffs it: ZIP
+// archives are read with random access, JSON is decoded from the file one
+// record at a time.
+func (h *BackupHandler) openImportSource(body io.Reader) (database.QuestionSource, func(), error) {
+	tmp, err := os.CreateTemp("", "ebu-import-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	size, err := io.Copy(tmp, body)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	head := make([]byte, 4)
+	n, _ := tmp.ReadAt(head, 0)
+	if !database.IsZipBackup(head[:n]) {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		return database.NewBackupDecoder(bufio.NewReader(tmp)), cleanup, nil
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Backup imported successfully", "count": len(backupData.Data)})
-}
\ No newline at end of file
+	archive, err := database.OpenZipBackup(tmp, size)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return archive, func() {
+		archive.Close()
+		cleanup()
+	}, nil
+}
+
+// importFailed maps errors from reading the upload to client errors and
+// everything else to fallback.
+func (h *BackupHandler) importFailed(c *gin.Context, err error, report *database.ImportReport, fallback string) {
+	var tooLarge *http.MaxBytesError
+	resp := gin.H{}
+	if report != nil {
+		resp["report"] = report
+	}
+	switch {
+	case errors.As(err, &tooLarge):
+		resp["error"] = fmt.Sprintf("Backup exceeds the %d byte import limit", tooLarge.Limit)
+		c.JSON(http.StatusRequestEntityTooLarge, resp)
+	case errors.Is(err, database.ErrUnsupportedBackupVersion):
+		resp["error"] = err.Error()
+		c.JSON(http.StatusBadRequest, resp)
+	case errors.Is(err, database.ErrInvalidBackup):
+		resp["error"] = "Invalid backup file format: " + strings.TrimPrefix(err.Error(), database.ErrInvalidBackup.Error()+": ")
+		c.JSON(http.StatusBadRequest, resp)
+	default:
+		resp["error"] = fallback
+		c.JSON(http.StatusInternalServerError, resp)
+	}
+}
+
+// GetImportProgress reports how far a running (or recently finished) import
+// of the signed-in user's has got.
+func (h *BackupHandler) GetImportProgress(c *gin.Context) {
+	progress, ok := h.progress.get(currentUserID(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+	c.JSON(http.StatusOK, progress)
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"E-Bu-backend/database"
+	"E-Bu-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newBackupTestRouter(t *testing.T) (*gin.Engine, *database.DB) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "ebu.db"))
+	if err != nil {
+		t.Fatalf("NewDB failed: %v", err)
+	}
+
+	r := gin.New()
+	r.Use(signedIn)
+	bh := NewBackupHandler(db)
+	api := r.Group("/api")
+	api.GET("/export", bh.ExportBackup)
+	api.POST("/import", bh.ImportBackup)
+	api.GET("/import/progress/:id", bh.GetImportProgress)
+
+	return r, db
+}
+
+func TestImportDryRunAndValidation(t *testing.T) {
+	r, db := newBackupTestRouter(t)
+
+	kps := `["kp"]`
+	backup := models.BackupData{
+		Version: "1.2.0",
+		Data: []models.Question{
+			{ID: "q1", Content: "c", KnowledgePoints: &kps, Subject: models.Math, Difficulty: 3, CreatedAt: time.Now()},
+			{ID: "q2", Content: "c", KnowledgePoints: &kps, Subject: models.Math, Difficulty: 0, CreatedAt: time.Now()},
+		},
+	}
+
+	w := doJSON(t, r, http.MethodPost, "/api/import?dryRun=true", backup)
+	if w.Code != http.StatusOK {
+		t.Fatalf("dry run = %d, body=%s", w.Code, w.Body.String())
+	}
+	var dry struct {
+		Preview database.ImportPreview `json:"preview"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &dry)
+	if dry.Preview.New != 1 || dry.Preview.Invalid != 1 {
+		t.Fatalf("unexpected preview: %+v", dry.Preview)
+	}
+	if all, _ := db.GetQuestions(testUserID); len(all) != 0 {
+		t.Fatalf("dry run wrote %d rows", len(all))
+	}
+
+	w = doJSON(t, r, http.MethodPost, "/api/import", backup)
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "difficulty 0 is outside 1-5") {
+		t.Fatalf("invalid import = %d, body=%s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, r, http.MethodPost, "/api/import?onError=skip", backup)
+	if w.Code != http.StatusOK {
+		t.Fatalf("import with onError=skip = %d, body=%s", w.Code, w.Body.String())
+	}
+	var result struct {
+		Count  int                   `json:"count"`
+		Report database.ImportReport `json:"report"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.Count != 1 || result.Report.Failed != 1 {
+		t.Fatalf("unexpected import result: %s", w.Body.String())
+	}
+}
+
+func TestImportStreamingLimitAndProgress(t *testing.T) {
+	r, db := newBackupTestRouter(t)
+
+	kps := `["kp"]`
+	for _, id := range []string{"q1", "q2"} {
+		q := models.Question{ID: id, Content: "c", KnowledgePoints: &kps, Subject: models.Math, Difficulty: 3}
+		if err := db.CreateQuestion(testUserID, &q); err != nil {
+			t.Fatalf("CreateQuestion: %v", err)
+		}
+	}
+
+	w := doJSON(t, r, http.MethodGet, "/api/export", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export = %d", w.Code)
+	}
+	exported := w.Body.Bytes()
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/import?mode=append&progressId=job-1", bytes.NewReader(exported))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("import = %d, body=%s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, r, http.MethodGet, "/api/import/progress/job-1", nil)
+	var progress ImportProgress
+	json.Unmarshal(w.Body.Bytes(), &progress)
+	if w.Code != http.StatusOK || progress.State != ImportDone || progress.Inserted != 2 || progress.BytesRead != int64(len(exported)) {
+		t.Fatalf("unexpected progress %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, r, http.MethodPost, "/api/import", map[string]interface{}{"data": "nope"})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("malformed backup = %d, body=%s", w.Code, w.Body.String())
+	}
+}
+
+// slowUpload is an upload body that arrives a byte at a time and runs hook
+// three quarters of the way in, as another user's write during a slow
+// upload.
+type slowUpload struct {
+	data []byte
+	read int
+	hook func() error
+	err  error
+	hit  bool
+}
+
+func (b *slowUpload) Read(p []byte) (int, error) {
+	if b.read == len(b.data) {
+		return 0, io.EOF
+	}
+	if !b.hit && b.read >= len(b.data)*3/4 {
+		b.hit = true
+		b.err = b.hook()
+	}
+	n := copy(p[:1], b.data[b.read:])
+	b.read += n
+	return n, nil
+}
+
+func TestImportDoesNotBlockWritersWhileUploading(t *testing.T) {
+	r, db := newBackupTestRouter(t)
+	kps := `["kp"]`
+	other := models.Question{ID: "other", Content: "c", KnowledgePoints: &kps, Subject: models.Math, Difficulty: 3}
+	if err := db.CreateQuestion("someone-else", &other); err != nil {
+		t.Fatal(err)
+	}
+	backup := `{"version":"1.3.0","data":[{"id":"q1","content":"c","analysis":"a","learningGuide":"l","knowledgePoints":"[\"kp\"]","subject":"数学","difficulty":3},` +
+		`{"id":"q2","content":"c","analysis":"a","learningGuide":"l","knowledgePoints":"[\"kp\"]","subject":"数学","difficulty":3}]}`
+	body := &slowUpload{data: []byte(backup), hook: func() error {
+		return db.Model(&models.Question{}).Where("id = ?", "other").Update("analysis", "edited").Error
+	}}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/import?mode=append", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("import = %d, body=%s", w.Code, w.Body.String())
+	}
+	if !body.hit || body.err != nil {
+		t.Fatalf("write during the upload: %v", body.err)
+	}
+}
+
+func TestImportRejectsOversizedBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "ebu.db"))
+	if err != nil {
+		t.Fatalf("NewDB failed: %v", err)
+	}
+	bh := NewBackupHandler(db)
+	bh.MaxImportBytes = 64
+	r := gin.New()
+	r.Use(signedIn)
+	r.POST("/api/import", bh.ImportBackup)
+
+	body := `{"data":[{"id":"q1","content":"` + strings.Repeat("x", 200) + `"}]}`
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/import", strings.NewReader(body)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized import = %d, body=%s", w.Code, w.Body.String())
+	}
+	if all, _ := db.GetQuestions(testUserID); len(all) != 0 {
+		t.Fatalf("oversized import wrote %d rows", len(all))
+	}
+}
+
+func TestExportImportZipFormat(t *testing.T) {
+	r, db := newBackupTestRouter(t)
+
+	kps := `["kp"]`
+	q := models.Question{ID: "q1", Content: "c", KnowledgePoints: &kps, Subject: models.Math, Difficulty: 3}
+	if err := db.CreateQuestion(testUserID, &q); err != nil {
+		t.Fatalf("CreateQuestion: %v", err)
+	}
+
+	w := doJSON(t, r, http.MethodGet, "/api/export?format=zip", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("zip export = %d (%s)", w.Code, w.Header().Get("Content-Type"))
+	}
+	archive := w.Body.Bytes()
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/import?mode=append", bytes.NewReader(archive)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"inserted":1`) {
+		t.Fatalf("zip import = %d, body=%s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/import", bytes.NewReader(archive[:len(archive)/2])))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("truncated zip import = %d, body=%s", w.Code, w.Body.String())
+	}
+}
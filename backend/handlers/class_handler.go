@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"E-Bu-backend/database"
+	"E-Bu-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ClassHandler serves classes and assignments, and the read-only views
+// teachers and parents get of a student's progress.
+type ClassHandler struct {
+	DB *database.DB
+}
+
+func NewClassHandler(db *database.DB) *ClassHandler {
+	return &ClassHandler{DB: db}
+}
+
+// ListClasses returns the classes a teacher (or admin) teaches, and for
+// everyone else the classes they are in.
+func (h *ClassHandler) ListClasses(c *gin.Context) {
+	user := CurrentUser(c)
+	var classes []models.Class
+	var err error
+	if user.Role == models.RoleTeacher || user.Role == models.RoleAdmin {
+		classes, err = h.DB.ListTeacherClasses(user.ID)
+	} else {
+		classes, err = h.DB.ListStudentClasses(user.ID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list classes"})
+		return
+	}
+	c.JSON(http.StatusOK, classes)
+}
+
+func (h *ClassHandler) CreateClass(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	class, err := h.DB.CreateClass(currentUserID(c), req.Name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, class)
+}
+
+func (h *ClassHandler) DeleteClass(c *gin.Context) {
+	if err := h.DB.DeleteClass(currentUserID(c), c.Param("id")); err != nil {
+		classError(c, err, "Failed to delete class")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Class deleted"})
+}
+
+func (h *ClassHandler) ListMembers(c *gin.Context) {
+	members, err := h.DB.ListClassMembers(currentUserID(c), c.Param("id"))
+	if err != nil {
+		classError(c, err, "Failed to list class members")
+		return
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+// AddMember puts a student, by username, in the class.
+func (h *ClassHandler) AddMember(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	student, err := h.DB.AddClassMember(currentUserID(c), c.Param("id"), req.Username)
+	if err != nil {
+		classError(c, err, "Failed to add class member")
+		return
+	}
+	c.JSON(http.StatusCreated, student)
+}
+
+func (h *ClassHandler) RemoveMember(c *gin.Context) {
+	if err := h.DB.RemoveClassMember(currentUserID(c), c.Param("id"), c.Param("studentId")); err != nil {
+		classError(c, err, "Failed to remove class member")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Student removed from class"})
+}
+
+// CreateAssignment sends questions from the teacher's own mistake book to
+// every student in the class.
+func (h *ClassHandler) CreateAssignment(c *gin.Context) {
+	var req struct {
+		Title       string    `json:"title" binding:"required"`
+		DueAt       time.Time `json:"dueAt" binding:"required"`
+		QuestionIDs []string  `json:"questionIds" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	assignment, err := h.DB.CreateAssignment(currentUserID(c), c.Param("id"), req.Title, req.DueAt, req.QuestionIDs)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Class or question not found"})
+			return
+		}
+		classError(c, err, "Failed to create assignment")
+		return
+	}
+	c.JSON(http.StatusCreated, assignment)
+}
+
+func (h *ClassHandler) ListClassAssignments(c *gin.Context) {
+	assignments, err := h.DB.ListClassAssignments(currentUserID(c), c.Param("id"))
+	if err != nil {
+		classError(c, err, "Failed to list assignments")
+		return
+	}
+	c.JSON(http.StatusOK, assignments)
+}
+
+// GetAssignmentReport shows the teacher every student's completion and
+// score.
+func (h *ClassHandler) GetAssignmentReport(c *gin.Context) {
+	report, err := h.DB.GetAssignmentReport(currentUserID(c), c.Param("id"))
+	if err != nil {
+		classError(c, err, "Failed to fetch assignment report")
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// MyAssignments lists the signed-in student's assignments; each one is
+// worked on through the practice session in progress.sessionId.
+func (h *ClassHandler) MyAssignments(c *gin.Context) {
+	assignments, err := h.DB.ListStudentAssignments(currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list assignments"})
+		return
+	}
+	c.JSON(http.StatusOK, assignments)
+}
+
+// ListChildren lists the students the signed-in parent follows.
+func (h *ClassHandler) ListChildren(c *gin.Context) {
+	children, err := h.DB.ListChildren(currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list children"})
+		return
+	}
+	c.JSON(http.StatusOK, children)
+}
+
+// GetStudentStats, GetStudentAssignments and GetStudentQuestions are the
+// read-only views of a student. Teachers see the students in their classes,
+// parents their children; the question bank is for teachers only.
+func (h *ClassHandler) GetStudentStats(c *gin.Context) {
+	studentID, ok := h.viewStudent(c, true)
+	if !ok {
+		return
+	}
+	stats, err := h.DB.GetStudentStats(studentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stats"})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+func (h *ClassHandler) GetStudentAssignments(c *gin.Context) {
+	studentID, ok := h.viewStudent(c, true)
+	if !ok {
+		return
+	}
+	assignments, err := h.DB.ListStudentAssignments(studentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list assignments"})
+		return
+	}
+	c.JSON(http.StatusOK, assignments)
+}
+
+func (h *ClassHandler) GetStudentQuestions(c *gin.Context) {
+	studentID, ok := h.viewStudent(c, false)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("pageSize"))
+	paged, err := h.DB.GetQuestionsPagedFiltered(studentID, c.Query("tag"), c.Query("q"), c.Query("subject"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch questions"})
+		return
+	}
+	c.JSON(http.StatusOK, paged)
+}
+
+// viewStudent checks that the signed-in user may look at the student in the
+// :id parameter. Students nobody is allowed to see answer 404, as if they
+// did not exist.
+func (h *ClassHandler) viewStudent(c *gin.Context, parentsToo bool) (string, bool) {
+	user := CurrentUser(c)
+	studentID := c.Param("id")
+	var allowed bool
+	var err error
+	switch user.Role {
+	case models.RoleTeacher, models.RoleAdmin:
+		allowed, err = h.DB.TeachesStudent(user.ID, studentID)
+	case models.RoleParent:
+		if parentsToo {
+			allowed, err = h.DB.IsParentOf(user.ID, studentID)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check access"})
+		return "", false
+	}
+	if !allowed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Student not found"})
+		return "", false
+	}
+	return studentID, true
+}
+
+func classError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+	case errors.Is(err, database.ErrNotStudent), errors.Is(err, database.ErrNotParent), errors.Is(err, database.ErrInvalidAssignment):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
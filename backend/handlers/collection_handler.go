@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"E-Bu-backend/database"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CollectionHandler serves shared collections: question sets users publish,
+// read-only, to a class or to everyone, and copy into their own mistake book.
+type CollectionHandler struct {
+	DB *database.DB
+}
+
+func NewCollectionHandler(db *database.DB) *CollectionHandler {
+	return &CollectionHandler{DB: db}
+}
+
+// ListCollections returns the signed-in user's collections and the ones
+// shared with them.
+func (h *CollectionHandler) ListCollections(c *gin.Context) {
+	collections, err := h.DB.ListCollections(currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list collections"})
+		return
+	}
+	c.JSON(http.StatusOK, collections)
+}
+
+func (h *CollectionHandler) CreateCollection(c *gin.Context) {
+	var req struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	collection, err := h.DB.CreateCollection(currentUserID(c), req.Name, req.Description)
+	if err != nil {
+		collectionError(c, err, "Failed to create collection")
+		return
+	}
+	c.JSON(http.StatusCreated, collection)
+}
+
+func (h *CollectionHandler) GetCollection(c *gin.Context) {
+	collection, err := h.DB.GetCollection(currentUserID(c), c.Param("id"))
+	if err != nil {
+		collectionError(c, err, "Failed to fetch collection")
+		return
+	}
+	c.JSON(http.StatusOK, collection)
+}
+
+// UpdateCollection renames a collection and publishes it: visibility is
+// "private", "class" (with classId) or "all".
+func (h *CollectionHandler) UpdateCollection(c *gin.Context) {
+	var req struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+		Visibility  string `json:"visibility" binding:"required"`
+		ClassID     string `json:"classId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	collection, err := h.DB.UpdateCollection(currentUserID(c), c.Param("id"), req.Name, req.Description, req.Visibility, req.ClassID)
+	if err != nil {
+		collectionError(c, err, "Failed to update collection")
+		return
+	}
+	c.JSON(http.StatusOK, collection)
+}
+
+func (h *CollectionHandler) DeleteCollection(c *gin.Context) {
+	if err := h.DB.DeleteCollection(currentUserID(c), c.Param("id")); err != nil {
+		collectionError(c, err, "Failed to delete collection")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Collection deleted"})
+}
+
+// AddQuestions puts questions from the owner's mistake book in the
+// collection.
+func (h *CollectionHandler) AddQuestions(c *gin.Context) {
+	var req struct {
+		QuestionIDs []string `json:"questionIds" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.DB.AddCollectionQuestions(currentUserID(c), c.Param("id"), req.QuestionIDs); err != nil {
+		collectionError(c, err, "Failed to add questions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Questions added"})
+}
+
+func (h *CollectionHandler) RemoveQuestion(c *gin.Context) {
+	if err := h.DB.RemoveCollectionQuestion(currentUserID(c), c.Param("id"), c.Param("questionId")); err != nil {
+		collectionError(c, err, "Failed to remove question")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Question removed from collection"})
+}
+
+// CopyToMyBook copies questions of the collection, or all of them when
+// questionIds is empty, into the signed-in user's mistake book.
+func (h *CollectionHandler) CopyToMyBook(c *gin.Context) {
+	var req struct {
+		QuestionIDs []string `json:"questionIds"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	result, err := h.DB.CopyFromCollection(currentUserID(c), c.Param("id"), req.QuestionIDs)
+	if err != nil {
+		collectionError(c, err, "Failed to copy questions")
+		return
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+func collectionError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collection or question not found"})
+	case errors.Is(err, database.ErrReadOnlyCollection):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can change this collection"})
+	case errors.Is(err, database.ErrInvalidCollection):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"E-Bu-backend/database"
+)
+
+// Finished imports stay pollable for this long.
+const importProgressTTL = time.Hour
+
+// Import progress states.
+const (
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+type ImportProgress struct {
+	ID     string `json:"id"`
+	State  string `json:"state"`
+	DryRun bool   `json:"dryRun"`
+	// BytesRead against TotalBytes (the Content-Length, -1 when unknown)
+	// gives a percentage while records are still being decoded.
+	BytesRead  int64      `json:"bytesRead"`
+	TotalBytes int64      `json:"totalBytes"`
+	Processed  int        `json:"processed"`
+	Inserted   int        `json:"inserted"`
+	Updated    int        `json:"updated"`
+	Skipped    int        `json:"skipped"`
+	Failed     int        `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	body *countingReader
+}
+
+// importTracker keeps progress for imports in memory; it is per process and
+// forgets everything on restart. Jobs are kept per user, so nobody can poll
+// (or block) someone else's import by guessing its ID.
+type importTracker struct {
+	mu   sync.Mutex
+	jobs map[importKey]*ImportProgress
+}
+
+type importKey struct {
+	owner string
+	id    string
+}
+
+func newImportTracker() *importTracker {
+	return &importTracker{jobs: map[importKey]*ImportProgress{}}
+}
+
+// start registers a new import of ownerID's. It fails when an import with the
+// same ID is still running.
+func (t *importTracker) start(ownerID string, id string, body *countingReader, totalBytes int64, dryRun bool) (*ImportProgress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for key, job := range t.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > importProgressTTL {
+			delete(t.jobs, key)
+		}
+	}
+	key := importKey{ownerID, id}
+	if job, ok := t.jobs[key]; ok && job.State == ImportRunning {
+		return nil, false
+	}
+
+	job := &ImportProgress{ID: id, State: ImportRunning, DryRun: dryRun, TotalBytes: totalBytes, StartedAt: now, body: body}
+	t.jobs[key] = job
+	return job, true
+}
+
+func (t *importTracker) update(job *ImportProgress, report *database.ImportReport) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job.Processed = report.Total
+	job.Inserted = report.Inserted
+	job.Updated = report.Updated
+	job.Skipped = report.Skipped
+	job.Failed = report.Failed
+}
+
+func (t *importTracker) finish(job *ImportProgress, report *database.ImportReport, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if report != nil {
+		job.Processed = report.Total
+		job.Inserted = report.Inserted
+		job.Updated = report.Updated
+		job.Skipped = report.Skipped
+		job.Failed = report.Failed
+	}
+	now := time.Now()
+	job.FinishedAt = &now
+	job.State = ImportDone
+	if err != nil {
+		job.State = ImportFailed
+		job.Error = err.Error()
+	}
+	job.BytesRead = job.body.Count()
+	job.body = nil
+}
+
+// get returns a copy that is safe to serialize while the import goes on.
+func (t *importTracker) get(ownerID string, id string) (ImportProgress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[importKey{ownerID, id}]
+	if !ok {
+		return ImportProgress{}, false
+	}
+	snapshot := *job
+	if job.body != nil {
+		snapshot.BytesRead = job.body.Count()
+	}
+	snapshot.body = nil
+	return snapshot, true
+}
+
+// countingReader counts bytes read so another goroutine can report upload
+// progress.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func (c *countingReader) Count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
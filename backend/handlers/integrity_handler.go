@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+
+	"E-Bu-backend/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+type IntegrityHandler struct {
+	DB *database.DB
+}
+
+func NewIntegrityHandler(db *database.DB) *IntegrityHandler {
+	return &IntegrityHandler{DB: db}
+}
+
+// CheckIntegrity reports problems in the database file, migrations, schema
+// and question rows without changing anything.
+func (h *IntegrityHandler) CheckIntegrity(c *gin.Context) {
+	report, err := h.DB.CheckIntegrity()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// RepairIntegrity fixes the question rows that can be fixed and returns the
+// changes it made along with whatever is still wrong.
+func (h *IntegrityHandler) RepairIntegrity(c *gin.Context) {
+	report, err := h.DB.As(auditActor(c)).RepairIntegrity()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"E-Bu-backend/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIntegrityEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "ebu.db"))
+	if err != nil {
+		t.Fatalf("NewDB failed: %v", err)
+	}
+	r := gin.New()
+	ih := NewIntegrityHandler(db)
+	api := r.Group("/api")
+	api.GET("/db/integrity", ih.CheckIntegrity)
+	api.POST("/db/integrity/repair", ih.RepairIntegrity)
+
+	seedQuestion(t, db, "q1", []string{"A", "B"}, "A")
+	if err := db.Exec("UPDATE questions SET options = ? WHERE id = ?", "A; B", "q1").Error; err != nil {
+		t.Fatalf("corrupt options: %v", err)
+	}
+
+	w := doJSON(t, r, http.MethodGet, "/api/db/integrity", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report database.IntegrityReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if report.OK || len(report.Questions) != 1 || !report.Questions[0].Repairable {
+		t.Fatalf("unexpected report: %s", w.Body.String())
+	}
+
+	w = doJSON(t, r, http.MethodPost, "/api/db/integrity/repair", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	report = database.IntegrityReport{}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !report.OK || len(report.Repairs) != 1 || report.Repairs[0].To != `["A","B"]` {
+		t.Fatalf("unexpected repair report: %s", w.Body.String())
+	}
+}
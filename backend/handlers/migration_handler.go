@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"E-Bu-backend/database"
 
@@ -11,10 +13,14 @@ import (
 type MigrationHandler struct {
 	DB     *database.DB
 	DBPath string
+	// Snapshots, when set, snapshots the database before migrations run.
+	// Restoring those snapshots is left to the command line, with the
+	// server stopped.
+	Snapshots *database.Snapshotter
 }
 
-func NewMigrationHandler(db *database.DB, dbPath string) *MigrationHandler {
-	return &MigrationHandler{DB: db, DBPath: dbPath}
+func NewMigrationHandler(db *database.DB, dbPath string, snapshots *database.Snapshotter) *MigrationHandler {
+	return &MigrationHandler{DB: db, DBPath: dbPath, Snapshots: snapshots}
 }
 
 func (h *MigrationHandler) GetMigrations(c *gin.Context) {
@@ -26,15 +32,53 @@ func (h *MigrationHandler) GetMigrations(c *gin.Context) {
 	c.JSON(http.StatusOK, status)
 }
 
-func (h *MigrationHandler) ApplyMigrations(c *gin.Context) {
-	applied, err := database.ApplyMigrationsToLatest(h.DB.DB)
+// GetSchemaDrift compares the live schema with the one the models expect.
+func (h *MigrationHandler) GetSchemaDrift(c *gin.Context) {
+	drift, err := database.CheckSchemaDrift(h.DB.DB)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, drift)
+}
+
+// ApplyMigrations applies pending migrations up to the latest version.
+// ?to=N is accepted only for N = latest: the running server was built for
+// the latest schema, so downgrades are left to `migrate down` and
+// `migrate to` on the command line, with the server stopped.
+func (h *MigrationHandler) ApplyMigrations(c *gin.Context) {
+	target := database.LatestMigrationVersion()
+	if v := c.Query("to"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > target {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("to must be a version between 0 and %d", target)})
+			return
+		}
+		if n < target {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("cannot migrate below version %d while the server is running; stop it and use `migrate down` or `migrate to`", target)})
+			return
+		}
+	}
+
+	var opts database.MigrateOptions
+	if h.Snapshots != nil {
+		opts.SnapshotDir = h.Snapshots.Config.Dir
+	}
+	result, err := database.MigrateTo(h.DB.As(auditActor(c)).DB, target, opts)
+	if err != nil {
+		resp := gin.H{"error": err.Error()}
+		if result != nil {
+			resp["applied"] = result.Applied
+		}
+		c.JSON(http.StatusInternalServerError, resp)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"applied": applied,
-		"count":   len(applied),
+		"applied":      result.Applied,
+		"reverted":     result.Reverted,
+		"count":        len(result.Applied) + len(result.Reverted),
+		"version":      target,
+		"snapshotPath": result.SnapshotPath,
 	})
 }
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
+	"strconv"
 	"testing"
 
 	"E-Bu-backend/database"
@@ -23,6 +24,12 @@ type migrateResponse struct {
 	Count int `json:"count"`
 }
 
+type migrateToResponse struct {
+	Version  int                      `json:"version"`
+	Applied  []database.MigrationInfo `json:"applied"`
+	Reverted []database.MigrationInfo `json:"reverted"`
+}
+
 func newTestRouter(t *testing.T) (*gin.Engine, string) {
 	t.Helper()
 
@@ -35,10 +42,11 @@ func newTestRouter(t *testing.T) (*gin.Engine, string) {
 	}
 
 	r := gin.New()
-	mh := NewMigrationHandler(db, dsn)
+	mh := NewMigrationHandler(db, dsn, nil)
 	api := r.Group("/api")
 	api.GET("/db/migrations", mh.GetMigrations)
 	api.POST("/db/migrate", mh.ApplyMigrations)
+	api.GET("/db/schema/drift", mh.GetSchemaDrift)
 
 	return r, dsn
 }
@@ -81,3 +89,59 @@ func TestMigrationEndpoints(t *testing.T) {
 		t.Fatalf("unexpected migrate count: %d", migrate.Count)
 	}
 }
+
+func TestApplyMigrations_ToVersion(t *testing.T) {
+	r, dsn := newTestRouter(t)
+	latest := database.LatestMigrationVersion()
+
+	// Downgrades are refused over HTTP, whether or not they are reversible.
+	for _, to := range []string{"11", "10", "0"} {
+		if w := doJSON(t, r, http.MethodPost, "/api/db/migrate?to="+to, nil); w.Code != http.StatusBadRequest {
+			t.Fatalf("downgrade to %s = %d, want 400", to, w.Code)
+		}
+	}
+	for _, to := range []string{"abc", "-1", "999"} {
+		if w := doJSON(t, r, http.MethodPost, "/api/db/migrate?to="+to, nil); w.Code != http.StatusBadRequest {
+			t.Fatalf("to=%s = %d, want 400", to, w.Code)
+		}
+	}
+	var status migrationsStatusResponse
+	json.Unmarshal(doJSON(t, r, http.MethodGet, "/api/db/migrations", nil).Body.Bytes(), &status)
+	if status.Current != latest {
+		t.Fatalf("refused downgrade changed the schema: current = %d, want %d", status.Current, latest)
+	}
+
+	// A database left behind by `migrate to` is brought forward again.
+	db, err := database.Open(dsn)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := database.MigrateTo(db.DB, 11, database.MigrateOptions{}); err != nil {
+		t.Fatalf("MigrateTo(11) failed: %v", err)
+	}
+	sqlDB, _ := db.DB.DB()
+	sqlDB.Close()
+
+	var resp migrateToResponse
+	w := doJSON(t, r, http.MethodPost, "/api/db/migrate?to="+strconv.Itoa(latest), nil)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Version != latest || len(resp.Applied) != latest-11 || len(resp.Reverted) != 0 {
+		t.Fatalf("migrate up = %d, body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestSchemaDriftEndpoint(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	w := doJSON(t, r, http.MethodGet, "/api/db/schema/drift", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/db/schema/drift = %d, body=%s", w.Code, w.Body.String())
+	}
+	var drift database.SchemaDrift
+	if err := json.Unmarshal(w.Body.Bytes(), &drift); err != nil {
+		t.Fatalf("unmarshal drift failed: %v", err)
+	}
+	if !drift.InSync {
+		t.Fatalf("fresh database reported drift: %s", w.Body.String())
+	}
+}
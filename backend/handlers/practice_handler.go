@@ -0,0 +1,465 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"E-Bu-backend/ai"
+	"E-Bu-backend/database"
+	"E-Bu-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PracticeHandler struct {
+	DB *database.DB
+	// NewAIClient builds the provider client from the stored AI config.
+	// Tests replace it with a stub.
+	NewAIClient func(cfg *models.AIConfig) (ai.Client, error)
+}
+
+func NewPracticeHandler(db *database.DB) *PracticeHandler {
+	return &PracticeHandler{DB: db, NewAIClient: ai.NewClientFromConfig}
+}
+
+// PracticeQuestion is a question as shown during practice: everything needed
+// to attempt it, but without the answer, analysis or learning guide.
+type PracticeQuestion struct {
+	SessionID          string         `json:"sessionId"`
+	QuestionID         string         `json:"questionId"`
+	Position           int            `json:"position"`
+	Total              int            `json:"total"`
+	Kind               string         `json:"kind"` // "choice" or "open"
+	Image              *string        `json:"image,omitempty"`
+	CroppedDiagram     *string        `json:"croppedDiagram,omitempty"`
+	Content            string         `json:"content"`
+	Options            []string       `json:"options"`
+	DiagramDescription *string        `json:"diagramDescription,omitempty"`
+	KnowledgePoints    []string       `json:"knowledgePoints"`
+	Subject            models.Subject `json:"subject"`
+	Difficulty         int            `json:"difficulty"`
+}
+
+// StartSession creates a practice session from explicit question IDs or from
+// the same filters as the question list.
+func (h *PracticeHandler) StartSession(c *gin.Context) {
+	var req struct {
+		QuestionIDs []string `json:"questionIds"`
+		VariantsOf  string   `json:"variantsOf"`
+		Tag         string   `json:"tag"`
+		Q           string   `json:"q"`
+		Subject     string   `json:"subject"`
+		DueOnly     bool     `json:"dueOnly"`
+		Limit       int      `json:"limit"`
+		Shuffle     bool     `json:"shuffle"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	questions, err := h.DB.SelectPracticeQuestions(currentUserID(c), database.PracticeSelection{
+		QuestionIDs: req.QuestionIDs,
+		ParentID:    req.VariantsOf,
+		Tag:         req.Tag,
+		Query:       req.Q,
+		Subject:     req.Subject,
+		DueOnly:     req.DueOnly,
+		Limit:       req.Limit,
+		Shuffle:     req.Shuffle,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to select questions"})
+		return
+	}
+	if len(questions) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No questions match the practice set"})
+		return
+	}
+
+	ids := make([]string, len(questions))
+	for i, q := range questions {
+		ids[i] = q.ID
+	}
+	session, err := h.DB.CreatePracticeSession(currentUserID(c), ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create practice session"})
+		return
+	}
+
+	summary, err := h.DB.GetPracticeSummary(currentUserID(c), session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch practice session"})
+		return
+	}
+	c.JSON(http.StatusCreated, summary)
+}
+
+// GetSession returns the session with per-question progress
+func (h *PracticeHandler) GetSession(c *gin.Context) {
+	summary, err := h.DB.GetPracticeSummary(currentUserID(c), c.Param("id"))
+	if err != nil {
+		practiceError(c, err, "Failed to fetch practice session")
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// NextQuestion returns the next unanswered question with the answer hidden
+func (h *PracticeHandler) NextQuestion(c *gin.Context) {
+	sessionID := c.Param("id")
+	session, err := h.DB.GetPracticeSession(currentUserID(c), sessionID)
+	if err != nil {
+		practiceError(c, err, "Failed to fetch practice session")
+		return
+	}
+
+	attempt, err := h.DB.NextPracticeAttempt(currentUserID(c), sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusOK, gin.H{"done": true})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch next question"})
+		return
+	}
+
+	question, err := h.DB.GetQuestionByID(currentUserID(c), attempt.QuestionID)
+	if err != nil {
+		practiceError(c, err, "Failed to fetch question")
+		return
+	}
+
+	options := jsonStringToOptionsSlice(question.Options)
+	c.JSON(http.StatusOK, gin.H{
+		"done": false,
+		"question": PracticeQuestion{
+			SessionID:          session.ID,
+			QuestionID:         question.ID,
+			Position:           attempt.Position,
+			Total:              session.Total,
+			Kind:               questionKind(question),
+			Image:              question.Image,
+			CroppedDiagram:     question.CroppedDiagram,
+			Content:            question.Content,
+			Options:            options,
+			DiagramDescription: question.DiagramDescription,
+			KnowledgePoints:    jsonStringToSlice(question.KnowledgePoints),
+			Subject:            question.Subject,
+			Difficulty:         question.Difficulty,
+		},
+	})
+}
+
+// SubmitAnswer checks a multiple-choice answer immediately, or asks the
+// student to grade themselves against the reference answer for open questions.
+func (h *PracticeHandler) SubmitAnswer(c *gin.Context) {
+	sessionID := c.Param("id")
+	var req struct {
+		QuestionID  string  `json:"questionId" binding:"required"`
+		Answer      string  `json:"answer"`
+		AnswerImage *string `json:"answerImage"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	question, err := h.DB.GetQuestionByID(currentUserID(c), req.QuestionID)
+	if err != nil {
+		practiceError(c, err, "Failed to fetch question")
+		return
+	}
+
+	var correct *bool
+	kind := questionKind(question)
+	if kind == "choice" && req.AnswerImage == nil {
+		ok := choiceAnswerMatches(req.Answer, *question.Answer, jsonStringToOptionsSlice(question.Options))
+		correct = &ok
+	}
+
+	attempt, review, err := h.DB.AnswerPracticeAttempt(currentUserID(c), sessionID, req.QuestionID, req.Answer, req.AnswerImage, correct)
+	if err != nil {
+		practiceError(c, err, "Failed to save answer")
+		return
+	}
+
+	resp := gin.H{
+		"questionId":      question.ID,
+		"kind":            kind,
+		"attempt":         attempt,
+		"referenceAnswer": question.Answer,
+		"analysis":        question.Analysis,
+		"learningGuide":   question.LearningGuide,
+	}
+	if correct != nil {
+		resp["correct"] = *correct
+		resp["review"] = review
+	} else {
+		resp["needsSelfGrade"] = true
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// SelfGrade records the student's own verdict for an open question
+func (h *PracticeHandler) SelfGrade(c *gin.Context) {
+	var req struct {
+		Correct *bool `json:"correct" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	attempt, review, err := h.DB.SelfGradePracticeAttempt(currentUserID(c), c.Param("id"), c.Param("questionId"), *req.Correct)
+	if err != nil {
+		practiceError(c, err, "Failed to save grade")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"attempt": attempt, "review": review})
+}
+
+// FinishSession closes the session; unanswered questions are left unreviewed
+func (h *PracticeHandler) FinishSession(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := h.DB.FinishPracticeSession(currentUserID(c), id); err != nil {
+		practiceError(c, err, "Failed to finish practice session")
+		return
+	}
+	summary, err := h.DB.GetPracticeSummary(currentUserID(c), id)
+	if err != nil {
+		practiceError(c, err, "Failed to fetch practice session")
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// GradeAnswer sends a free-text or handwritten answer to the configured AI
+// provider together with the reference answer and analysis, and stores the
+// verdict with the attempt.
+func (h *PracticeHandler) GradeAnswer(c *gin.Context) {
+	questionID := c.Param("id")
+	var req struct {
+		Answer      *string `json:"answer"`
+		AnswerImage *string `json:"answerImage"`
+		SessionID   *string `json:"sessionId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	question, err := h.DB.GetQuestionByID(currentUserID(c), questionID)
+	if err != nil {
+		practiceError(c, err, "Failed to fetch question")
+		return
+	}
+
+	// Inside a session the answer may already have been submitted; fall back
+	// to it, and refuse before spending an AI call if it is already graded.
+	answer, answerImage := req.Answer, req.AnswerImage
+	if req.SessionID != nil {
+		attempt, err := h.DB.GetPracticeAttempt(currentUserID(c), *req.SessionID, questionID)
+		if err != nil {
+			practiceError(c, err, "Failed to fetch attempt")
+			return
+		}
+		if attempt.Status == models.AttemptGraded {
+			practiceError(c, database.ErrAttemptAlreadyGraded, "")
+			return
+		}
+		if answer == nil {
+			answer = attempt.Answer
+		}
+		if answerImage == nil {
+			answerImage = attempt.AnswerImage
+		}
+	}
+	if (answer == nil || strings.TrimSpace(*answer) == "") && (answerImage == nil || *answerImage == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "answer or answerImage is required"})
+		return
+	}
+	if question.Answer == nil || strings.TrimSpace(*question.Answer) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question has no reference answer to grade against"})
+		return
+	}
+
+	config, err := h.DB.GetAIConfig(currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get AI config"})
+		return
+	}
+	client, err := h.NewAIClient(config)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	in := ai.GradeInput{
+		Subject:         string(question.Subject),
+		Content:         question.Content,
+		Options:         jsonStringToOptionsSlice(question.Options),
+		ReferenceAnswer: *question.Answer,
+		Analysis:        question.Analysis,
+	}
+	if answer != nil {
+		in.StudentAnswer = *answer
+	}
+	if answerImage != nil {
+		in.StudentImage = *answerImage
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Minute)
+	defer cancel()
+	result, err := ai.Grade(ctx, client, in)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	attempt, review, err := h.DB.GradeAttemptWithAI(currentUserID(c), req.SessionID, questionID, answer, answerImage, database.AIGrade{
+		Verdict:     result.Verdict,
+		Score:       result.Score,
+		Explanation: result.Explanation,
+		Model:       client.Model(),
+		Raw:         result.Raw,
+		Correct:     result.Verdict == ai.VerdictCorrect,
+	})
+	if err != nil {
+		practiceError(c, err, "Failed to save grade")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"verdict":         result.Verdict,
+		"score":           result.Score,
+		"explanation":     result.Explanation,
+		"referenceAnswer": question.Answer,
+		"analysis":        question.Analysis,
+		"attempt":         attempt,
+		"review":          review,
+	})
+}
+
+// GetAttempts lists answered attempts of a question, including AI verdicts
+func (h *PracticeHandler) GetAttempts(c *gin.Context) {
+	attempts, err := h.DB.GetQuestionAttempts(currentUserID(c), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attempts"})
+		return
+	}
+	c.JSON(http.StatusOK, attempts)
+}
+
+// GetReviewHistory lists the review log of a question
+func (h *PracticeHandler) GetReviewHistory(c *gin.Context) {
+	logs, err := h.DB.GetReviewLogs(currentUserID(c), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch review history"})
+		return
+	}
+	c.JSON(http.StatusOK, logs)
+}
+
+func practiceError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+	case errors.Is(err, database.ErrSessionFinished),
+		errors.Is(err, database.ErrAttemptAlreadyGraded),
+		errors.Is(err, database.ErrAttemptNotAwaitingGrade):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// questionKind reports "choice" when the stored answer can be checked
+// against the options automatically, "open" otherwise.
+func questionKind(q *models.Question) string {
+	options := jsonStringToOptionsSlice(q.Options)
+	if len(options) == 0 || q.Answer == nil {
+		return "open"
+	}
+	if choiceKeys(*q.Answer, options) == "" {
+		return "open"
+	}
+	return "choice"
+}
+
+var (
+	// "A. 9", "(B) 10", "C、11", "D：12"
+	choiceLabelPattern = regexp.MustCompile(`^\s*[(（]?([A-Za-z])\s*[)）.．、:：\s]`)
+	// "A", "ac", "A, C", "A、C"
+	choiceLettersPattern = regexp.MustCompile(`^[A-Za-z](\s*[,，、\s]?\s*[A-Za-z])*$`)
+)
+
+func optionLabel(option string, index int) string {
+	if m := choiceLabelPattern.FindStringSubmatch(option); m != nil {
+		return strings.ToUpper(m[1])
+	}
+	return string(rune('A' + index))
+}
+
+func normalizeChoiceText(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), ""))
+}
+
+// choiceKeys resolves an answer to the sorted set of option labels it
+// selects ("C", "AC"), or "" when it cannot be mapped onto the options.
+func choiceKeys(answer string, options []string) string {
+	s := strings.TrimSpace(answer)
+	if s == "" {
+		return ""
+	}
+
+	labels := make(map[string]bool, len(options))
+	norm := normalizeChoiceText(s)
+	for i, opt := range options {
+		label := optionLabel(opt, i)
+		labels[label] = true
+		body := opt
+		if loc := choiceLabelPattern.FindStringIndex(opt); loc != nil {
+			body = opt[loc[1]:]
+		}
+		if norm == normalizeChoiceText(opt) || norm == normalizeChoiceText(body) {
+			return label
+		}
+	}
+
+	var picked []string
+	if choiceLettersPattern.MatchString(s) {
+		for _, r := range strings.ToUpper(s) {
+			if r >= 'A' && r <= 'Z' {
+				picked = append(picked, string(r))
+			}
+		}
+	} else if m := choiceLabelPattern.FindStringSubmatch(s); m != nil {
+		picked = []string{strings.ToUpper(m[1])}
+	}
+
+	seen := map[string]bool{}
+	keys := make([]string, 0, len(picked))
+	for _, p := range picked {
+		if !labels[p] {
+			return ""
+		}
+		if !seen[p] {
+			seen[p] = true
+			keys = append(keys, p)
+		}
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "")
+}
+
+func choiceAnswerMatches(given string, expected string, options []string) bool {
+	want := choiceKeys(expected, options)
+	return want != "" && choiceKeys(given, options) == want
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"E-Bu-backend/ai"
+	"E-Bu-backend/database"
+	"E-Bu-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newPracticeTestRouter(t *testing.T) (*gin.Engine, *database.DB) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "ebu.db"))
+	if err != nil {
+		t.Fatalf("NewDB failed: %v", err)
+	}
+
+	r := gin.New()
+	r.Use(signedIn)
+	ph := NewPracticeHandler(db)
+	api := r.Group("/api")
+	api.GET("/questions/:id/reviews", ph.GetReviewHistory)
+	api.POST("/practice/sessions", ph.StartSession)
+	api.GET("/practice/sessions/:id", ph.GetSession)
+	api.GET("/practice/sessions/:id/next", ph.NextQuestion)
+	api.POST("/practice/sessions/:id/answers", ph.SubmitAnswer)
+	api.POST("/practice/sessions/:id/answers/:questionId/self-grade", ph.SelfGrade)
+	api.POST("/practice/sessions/:id/finish", ph.FinishSession)
+
+	return r, db
+}
+
+// testUserID owns everything the handler tests create; signedIn stands in
+// for RequireAuth and signs every request in as that user.
+const testUserID = "test-user"
+
+func signedIn(c *gin.Context) {
+	c.Set(contextUserKey, &models.User{ID: testUserID, Username: "tester", Role: models.RoleAdmin})
+	c.Next()
+}
+
+func doJSON(t *testing.T, r *gin.Engine, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func seedQuestion(t *testing.T, db *database.DB, id string, options []string, answer string) {
+	t.Helper()
+	q := &models.Question{
+		ID:              id,
+		Content:         "content " + id,
+		Analysis:        "analysis",
+		LearningGuide:   "guide",
+		KnowledgePoints: stringSliceToJSONString([]string{"kp"}),
+		Options:         stringSliceToJSONString(options),
+		Answer:          &answer,
+		Subject:         models.Math,
+		Difficulty:      2,
+		CreatedAt:       time.Now(),
+	}
+	if err := db.CreateQuestion(testUserID, q); err != nil {
+		t.Fatalf("CreateQuestion: %v", err)
+	}
+}
+
+func TestPracticeSessionFlow(t *testing.T) {
+	r, db := newPracticeTestRouter(t)
+	seedQuestion(t, db, "mc", []string{"A. 9", "B. 10", "C. 11", "D. 12"}, "C. 11")
+	seedQuestion(t, db, "open", nil, "x = 3")
+
+	w := doJSON(t, r, http.MethodPost, "/api/practice/sessions", gin.H{"questionIds": []string{"mc", "open", "missing"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("start session = %d, body=%s", w.Code, w.Body.String())
+	}
+	var session database.PracticeSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil {
+		t.Fatalf("unmarshal session: %v", err)
+	}
+	if session.Total != 2 {
+		t.Fatalf("expected 2 questions in session, got %d", session.Total)
+	}
+
+	w = doJSON(t, r, http.MethodGet, "/api/practice/sessions/"+session.ID+"/next", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("next = %d, body=%s", w.Code, w.Body.String())
+	}
+	if bytes.Contains(w.Body.Bytes(), []byte("analysis")) || bytes.Contains(w.Body.Bytes(), []byte(`"answer"`)) {
+		t.Fatalf("next question must not reveal answer or analysis: %s", w.Body.String())
+	}
+	var next struct {
+		Question PracticeQuestion `json:"question"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &next); err != nil {
+		t.Fatalf("unmarshal next: %v", err)
+	}
+	if next.Question.QuestionID != "mc" || next.Question.Kind != "choice" {
+		t.Fatalf("unexpected next question: %+v", next.Question)
+	}
+
+	w = doJSON(t, r, http.MethodPost, "/api/practice/sessions/"+session.ID+"/answers", gin.H{"questionId": "mc", "answer": "c"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("answer mc = %d, body=%s", w.Code, w.Body.String())
+	}
+	var mcResult struct {
+		Correct bool `json:"correct"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &mcResult)
+	if !mcResult.Correct {
+		t.Fatalf("expected choice answer to be correct: %s", w.Body.String())
+	}
+
+	w = doJSON(t, r, http.MethodPost, "/api/practice/sessions/"+session.ID+"/answers", gin.H{"questionId": "mc", "answer": "A"})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("re-answering a graded question = %d, want 409", w.Code)
+	}
+
+	w = doJSON(t, r, http.MethodPost, "/api/practice/sessions/"+session.ID+"/answers", gin.H{"questionId": "open", "answer": "x = 3"})
+	var openResult struct {
+		NeedsSelfGrade bool `json:"needsSelfGrade"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &openResult)
+	if w.Code != http.StatusOK || !openResult.NeedsSelfGrade {
+		t.Fatalf("answer open = %d, body=%s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, r, http.MethodPost, "/api/practice/sessions/"+session.ID+"/answers/open/self-grade", gin.H{"correct": false})
+	if w.Code != http.StatusOK {
+		t.Fatalf("self-grade = %d, body=%s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, r, http.MethodPost, "/api/practice/sessions/"+session.ID+"/finish", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil {
+		t.Fatalf("unmarshal finish: %v", err)
+	}
+	if session.Status != models.PracticeFinished || session.Correct != 1 || session.Incorrect != 1 {
+		t.Fatalf("unexpected summary: %+v", session)
+	}
+
+	mc, _ := db.GetQuestionByID(testUserID, "mc")
+	if mc.ReviewStreak != 1 || mc.NextReviewAt == nil || mc.LastReviewedAt == nil {
+		t.Fatalf("expected review schedule on mc, got streak=%d next=%v", mc.ReviewStreak, mc.NextReviewAt)
+	}
+
+	w = doJSON(t, r, http.MethodGet, "/api/questions/open/reviews", nil)
+	var logs []models.ReviewLog
+	json.Unmarshal(w.Body.Bytes(), &logs)
+	if len(logs) != 1 || logs[0].Correct {
+		t.Fatalf("expected one incorrect review log, got %+v", logs)
+	}
+}
+
+func TestChoiceAnswerMatches(t *testing.T) {
+	options := []string{"A. 9", "B. 10", "C. 11", "D. 12"}
+	cases := []struct {
+		given string
+		want  bool
+	}{
+		{"C", true},
+		{"c", true},
+		{"C. 11", true},
+		{"11", true},
+		{"（C）", true},
+		{"B", false},
+		{"", false},
+	}
+	for _, tc := range cases {
+		if got := choiceAnswerMatches(tc.given, "C. 11", options); got != tc.want {
+			t.Errorf("choiceAnswerMatches(%q) = %v, want %v", tc.given, got, tc.want)
+		}
+	}
+
+	if !choiceAnswerMatches("C, A", "AC", options) {
+		t.Errorf("expected multi-select answers to match regardless of order")
+	}
+}
+
+type stubAIClient struct {
+	reply string
+	calls int
+}
+
+func (s *stubAIClient) Complete(ctx context.Context, req ai.Request) (string, error) {
+	s.calls++
+	return s.reply, nil
+}
+
+func (s *stubAIClient) Model() string { return "stub/model" }
+
+func TestGradeAnswerStoresVerdict(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "ebu.db"))
+	if err != nil {
+		t.Fatalf("NewDB failed: %v", err)
+	}
+	seedQuestion(t, db, "open", nil, "x = 3")
+
+	stub := &stubAIClient{reply: "```json\n{\"verdict\":\"partial\",\"score\":60,\"explanation\":\"移项时符号错误\"}\n```"}
+	ph := NewPracticeHandler(db)
+	ph.NewAIClient = func(cfg *models.AIConfig) (ai.Client, error) { return stub, nil }
+
+	r := gin.New()
+	r.Use(signedIn)
+	r.POST("/api/questions/:id/grade", ph.GradeAnswer)
+	r.GET("/api/questions/:id/attempts", ph.GetAttempts)
+
+	w := doJSON(t, r, http.MethodPost, "/api/questions/open/grade", gin.H{})
+	if w.Code != http.StatusBadRequest || stub.calls != 0 {
+		t.Fatalf("grading without an answer = %d (calls=%d), want 400 without AI call", w.Code, stub.calls)
+	}
+
+	w = doJSON(t, r, http.MethodPost, "/api/questions/open/grade", gin.H{"answer": "x = -3"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("grade = %d, body=%s", w.Code, w.Body.String())
+	}
+	var graded struct {
+		Verdict string `json:"verdict"`
+		Score   int    `json:"score"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &graded)
+	if graded.Verdict != ai.VerdictPartial || graded.Score != 60 {
+		t.Fatalf("unexpected verdict: %s", w.Body.String())
+	}
+
+	w = doJSON(t, r, http.MethodGet, "/api/questions/open/attempts", nil)
+	var attempts []models.PracticeAttempt
+	json.Unmarshal(w.Body.Bytes(), &attempts)
+	if len(attempts) != 1 {
+		t.Fatalf("expected 1 stored attempt, got %d", len(attempts))
+	}
+	a := attempts[0]
+	if a.Grading != models.GradingAI || a.AIVerdict == nil || *a.AIVerdict != ai.VerdictPartial || a.AIModel == nil || *a.AIModel != "stub/model" || a.AIRaw == nil {
+		t.Fatalf("AI verdict not stored for audit: %+v", a)
+	}
+	if a.Correct == nil || *a.Correct {
+		t.Fatalf("partial verdict must not count as correct")
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"E-Bu-backend/database"
+	"E-Bu-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BulkQuestions runs one operation over many questions, given as ids or as
+// a filter, in one transaction, and reports what happened to each. A hard
+// delete first answers 428 with the confirmToken to send back.
+func (h *QuestionHandler) BulkQuestions(c *gin.Context) {
+	var req struct {
+		Operation  string               `json:"operation" binding:"required"`
+		IDs        []string             `json:"ids"`
+		Filter     *database.BulkFilter `json:"filter"`
+		Subject    string               `json:"subject"`
+		Difficulty int                  `json:"difficulty"`
+		Tags       []string             `json:"tags"`
+		Confirm    string               `json:"confirm"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.DB.As(auditActor(c)).BulkQuestions(currentUserID(c), database.BulkRequest{
+		Operation:  req.Operation,
+		IDs:        req.IDs,
+		Filter:     req.Filter,
+		Subject:    models.Subject(req.Subject),
+		Difficulty: req.Difficulty,
+		Tags:       req.Tags,
+		Confirm:    req.Confirm,
+	})
+	switch {
+	case errors.Is(err, database.ErrBulkUnconfirmed):
+		c.JSON(http.StatusPreconditionRequired, gin.H{
+			"error":        "Hard-deleting questions in bulk needs confirmation: send the request again with confirm set to confirmToken",
+			"confirmToken": result.ConfirmToken,
+			"matched":      result.Matched,
+			"results":      result.Results,
+		})
+	case errors.Is(err, database.ErrInvalidBulk):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run the bulk operation"})
+	default:
+		c.JSON(http.StatusOK, result)
+	}
+}
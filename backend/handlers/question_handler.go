@@ -1,24 +1,30 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"E-Bu-backend/ai"
 	"E-Bu-backend/database"
 	"E-Bu-backend/models"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type QuestionHandler struct {
-	DB *database.DB
+	DB          *database.DB
+	NewAIClient func(cfg *models.AIConfig) (ai.Client, error)
 }
 
 func NewQuestionHandler(db *database.DB) *QuestionHandler {
-	return &QuestionHandler{DB: db}
+	return &QuestionHandler{DB: db, NewAIClient: ai.NewClientFromConfig}
 }
 
 // GetQuestions retrieves non-deleted questions (supports paging + tag filter)
@@ -32,7 +38,7 @@ func (h *QuestionHandler) GetQuestions(c *gin.Context) {
 	// Backward compatible: if no paging params are provided and no filters,
 	// return the legacy array response.
 	if c.Query("page") == "" && c.Query("pageSize") == "" && tag == "" && q == "" && subject == "" {
-		questions, err := h.DB.GetQuestions()
+		questions, err := h.DB.GetQuestions(currentUserID(c))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch questions"})
 			return
@@ -41,7 +47,7 @@ func (h *QuestionHandler) GetQuestions(c *gin.Context) {
 		return
 	}
 
-	paged, err := h.DB.GetQuestionsPagedFiltered(tag, q, subject, page, pageSize)
+	paged, err := h.DB.GetQuestionsPagedFiltered(currentUserID(c), tag, q, subject, page, pageSize)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch questions"})
 		return
@@ -60,7 +66,7 @@ func (h *QuestionHandler) GetTrash(c *gin.Context) {
 	// Backward compatible: if no paging params are provided and no filters,
 	// return the legacy array response.
 	if c.Query("page") == "" && c.Query("pageSize") == "" && tag == "" && q == "" && subject == "" {
-		questions, err := h.DB.GetTrash()
+		questions, err := h.DB.GetTrash(currentUserID(c))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
 			return
@@ -69,7 +75,7 @@ func (h *QuestionHandler) GetTrash(c *gin.Context) {
 		return
 	}
 
-	paged, err := h.DB.GetTrashPagedFiltered(tag, q, subject, page, pageSize)
+	paged, err := h.DB.GetTrashPagedFiltered(currentUserID(c), tag, q, subject, page, pageSize)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
 		return
@@ -77,6 +83,22 @@ func (h *QuestionHandler) GetTrash(c *gin.Context) {
 	c.JSON(http.StatusOK, paged)
 }
 
+// GetQuestion returns one question with its version as ETag. A matching
+// If-None-Match gets 304 Not Modified.
+func (h *QuestionHandler) GetQuestion(c *gin.Context) {
+	question, err := h.DB.GetQuestionByID(currentUserID(c), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+		return
+	}
+	setQuestionETag(c, question)
+	if match := c.GetHeader("If-None-Match"); match != "" && strings.TrimPrefix(match, "W/") == questionETag(question) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.JSON(http.StatusOK, question)
+}
+
 // CreateQuestion creates a new question
 func (h *QuestionHandler) CreateQuestion(c *gin.Context) {
 	var req struct {
@@ -91,6 +113,9 @@ func (h *QuestionHandler) CreateQuestion(c *gin.Context) {
 		KnowledgePoints    []string `json:"knowledgePoints" binding:"required"`
 		Subject            string   `json:"subject" binding:"required"`
 		Difficulty         int      `json:"difficulty" binding:"min=1,max=5"`
+		// AIGenerated marks content saved as /api/analyze returned it, so
+		// its first revision is recorded as the AI's.
+		AIGenerated bool `json:"aiGenerated"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -134,20 +159,28 @@ func (h *QuestionHandler) CreateQuestion(c *gin.Context) {
 		KnowledgePoints:    jsonStringPtr(string(kpJSON)),
 		Subject:            subject,
 		Difficulty:         req.Difficulty,
+		AIGenerated:        req.AIGenerated,
 		CreatedAt:          time.Now(),
 	}
 
-	if err := h.DB.CreateQuestion(question); err != nil {
+	if err := h.DB.As(auditActor(c)).CreateQuestion(currentUserID(c), question); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create question"})
 		return
 	}
 
+	setQuestionETag(c, question)
 	c.JSON(http.StatusCreated, question)
 }
 
-// UpdateQuestion updates an existing question
+// UpdateQuestion updates an existing question. With If-Match, the update
+// is only made to the version given; a stale copy gets 409 Conflict with the
+// current question.
 func (h *QuestionHandler) UpdateQuestion(c *gin.Context) {
 	id := c.Param("id")
+	ifMatch, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
 
 	// Bind into a typed struct so field names are consistent and
 	// GORM can map struct fields to snake_case columns.
@@ -174,13 +207,20 @@ func (h *QuestionHandler) UpdateQuestion(c *gin.Context) {
 
 	// Load existing question so we can update only provided fields,
 	// while also supporting clearing fields via explicit null.
-	existing, err := h.DB.GetQuestionByID(id)
+	existing, err := h.DB.GetQuestionByID(currentUserID(c), id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
 		return
 	}
+	if ifMatch != 0 && ifMatch != existing.Version {
+		h.versionConflict(c, id)
+		return
+	}
 
-	updates := &models.Question{}
+	// The fields not in the request are filled in from existing, so the
+	// write is made to its version: a change saved in between is a conflict
+	// rather than silently overwritten.
+	updates := &models.Question{Version: existing.Version}
 
 	if req.Image != nil {
 		updates.Image = req.Image
@@ -274,23 +314,29 @@ func (h *QuestionHandler) UpdateQuestion(c *gin.Context) {
 		updates.LastReviewedAt = existing.LastReviewedAt
 	}
 
-	if req.DeletedAt != nil {
-		updates.DeletedAt = req.DeletedAt
-	} else {
-		updates.DeletedAt = existing.DeletedAt
+	// Only the trash routes move a question in and out of the trash.
+	if req.DeletedAt != nil && (existing.DeletedAt == nil || !req.DeletedAt.Equal(*existing.DeletedAt)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errTrashField.Error()})
+		return
 	}
+	updates.DeletedAt = existing.DeletedAt
 
-	if err := h.DB.UpdateQuestion(id, updates); err != nil {
+	if err := h.DB.As(auditActor(c)).UpdateQuestion(currentUserID(c), id, updates); err != nil {
+		if errors.Is(err, database.ErrVersionConflict) {
+			h.versionConflict(c, id)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update question"})
 		return
 	}
 
-	question, err := h.DB.GetQuestionByID(id)
+	question, err := h.DB.GetQuestionByID(currentUserID(c), id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated question"})
 		return
 	}
 
+	setQuestionETag(c, question)
 	c.JSON(http.StatusOK, question)
 }
 
@@ -298,7 +344,7 @@ func (h *QuestionHandler) UpdateQuestion(c *gin.Context) {
 func (h *QuestionHandler) DeleteQuestion(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := h.DB.DeleteQuestion(id); err != nil {
+	if err := h.DB.As(auditActor(c)).DeleteQuestion(currentUserID(c), id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete question"})
 		return
 	}
@@ -310,7 +356,7 @@ func (h *QuestionHandler) DeleteQuestion(c *gin.Context) {
 func (h *QuestionHandler) RestoreQuestion(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := h.DB.RestoreQuestion(id); err != nil {
+	if err := h.DB.As(auditActor(c)).RestoreQuestion(currentUserID(c), id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore question"})
 		return
 	}
@@ -322,7 +368,7 @@ func (h *QuestionHandler) RestoreQuestion(c *gin.Context) {
 func (h *QuestionHandler) HardDeleteQuestion(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := h.DB.HardDeleteQuestion(id); err != nil {
+	if err := h.DB.As(auditActor(c)).HardDeleteQuestion(currentUserID(c), id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to permanently delete question"})
 		return
 	}
@@ -330,6 +376,132 @@ func (h *QuestionHandler) HardDeleteQuestion(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Question permanently deleted"})
 }
 
+// GenerateVariants asks the AI provider for new questions on the same
+// knowledge points and saves them as AI-generated children of the original
+func (h *QuestionHandler) GenerateVariants(c *gin.Context) {
+	id := c.Param("id")
+	var req struct {
+		Count int `json:"count"`
+	}
+	// Body is optional; default to 3 variants.
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Count <= 0 {
+		req.Count = 3
+	}
+	if req.Count > 10 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "count must be at most 10"})
+		return
+	}
+
+	parent, err := h.DB.GetQuestionByID(currentUserID(c), id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch question"})
+		return
+	}
+
+	config, err := h.DB.GetAIConfig(currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get AI config"})
+		return
+	}
+	client, err := h.NewAIClient(config)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	knowledgePoints := jsonStringToSlice(parent.KnowledgePoints)
+	in := ai.VariantInput{
+		Subject:         string(parent.Subject),
+		Content:         parent.Content,
+		Options:         jsonStringToOptionsSlice(parent.Options),
+		Analysis:        parent.Analysis,
+		KnowledgePoints: knowledgePoints,
+		Difficulty:      parent.Difficulty,
+		Count:           req.Count,
+	}
+	if parent.Answer != nil {
+		in.Answer = *parent.Answer
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 3*time.Minute)
+	defer cancel()
+	generated, _, err := ai.GenerateVariants(ctx, client, in)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	variants := make([]models.Question, 0, req.Count)
+	now := time.Now()
+	for _, g := range generated {
+		if len(variants) == req.Count {
+			break
+		}
+		// Skip incomplete items rather than saving a question without an answer.
+		if strings.TrimSpace(g.Content) == "" || strings.TrimSpace(g.Answer) == "" || strings.TrimSpace(g.Analysis) == "" {
+			continue
+		}
+		answer := g.Answer
+		variants = append(variants, models.Question{
+			ID:              uuid.New().String(),
+			Content:         g.Content,
+			Options:         stringSliceToJSONString(g.Options),
+			Answer:          &answer,
+			Analysis:        g.Analysis,
+			LearningGuide:   g.LearningGuide,
+			KnowledgePoints: parent.KnowledgePoints,
+			Subject:         parent.Subject,
+			Difficulty:      variantDifficulty(g.Difficulty, parent.Difficulty),
+			CreatedAt:       now,
+		})
+	}
+	if len(variants) == 0 {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "AI returned no usable variant questions"})
+		return
+	}
+
+	if err := h.DB.As(auditActor(c)).CreateVariantQuestions(currentUserID(c), parent.ID, variants); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save variant questions"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"parentId": parent.ID, "items": variants, "count": len(variants)})
+}
+
+// GetVariants lists the variants generated from a question
+func (h *QuestionHandler) GetVariants(c *gin.Context) {
+	variants, err := h.DB.GetVariants(currentUserID(c), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch variants"})
+		return
+	}
+	c.JSON(http.StatusOK, variants)
+}
+
+// variantDifficulty keeps a variant within one level of the original
+func variantDifficulty(got int, parent int) int {
+	if got < parent-1 || got > parent+1 {
+		got = parent
+	}
+	if got < 1 {
+		got = 1
+	}
+	if got > 5 {
+		got = 5
+	}
+	return got
+}
+
 // Helper function to convert string to *string
 func jsonStringPtr(s string) *string {
 	if s == "" {
@@ -371,3 +543,46 @@ func stringSliceToJSONString(slice []string) *string {
 	result := string(jsonBytes)
 	return &result
 }
+
+// questionETag is the entity tag of a question: its version, quoted.
+func questionETag(q *models.Question) string {
+	return `"` + strconv.Itoa(q.Version) + `"`
+}
+
+func setQuestionETag(c *gin.Context, q *models.Question) {
+	c.Header("ETag", questionETag(q))
+}
+
+// ifMatchVersion reads the question version from the If-Match header. It
+// returns 0 when there is none or it is "*"; anything else that is not one
+// of our ETags gets 400 Bad Request.
+func ifMatchVersion(c *gin.Context) (int, bool) {
+	match := strings.TrimSpace(c.GetHeader("If-Match"))
+	if match == "" || match == "*" {
+		return 0, true
+	}
+	unquoted, err := strconv.Unquote(strings.TrimPrefix(match, "W/"))
+	if err == nil {
+		if version, err := strconv.Atoi(unquoted); err == nil && version > 0 {
+			return version, true
+		}
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "If-Match must be an ETag returned for the question"})
+	return 0, false
+}
+
+// versionConflict answers an update made to a stale copy with the current
+// question and its version.
+func (h *QuestionHandler) versionConflict(c *gin.Context, id string) {
+	current, err := h.DB.GetQuestionByID(currentUserID(c), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+		return
+	}
+	setQuestionETag(c, current)
+	c.JSON(http.StatusConflict, gin.H{
+		"error":          "The question was changed since it was read",
+		"currentVersion": current.Version,
+		"question":       current,
+	})
+}
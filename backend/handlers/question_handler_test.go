@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"E-Bu-backend/ai"
+	"E-Bu-backend/database"
+	"E-Bu-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newQuestionTestRouter(t *testing.T) (*gin.Engine, *QuestionHandler) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "ebu.db"))
+	if err != nil {
+		t.Fatalf("NewDB failed: %v", err)
+	}
+
+	r := gin.New()
+	r.Use(signedIn)
+	qh := NewQuestionHandler(db)
+	api := r.Group("/api")
+	api.GET("/questions", qh.GetQuestions)
+	api.POST("/questions", qh.CreateQuestion)
+	api.PUT("/questions/:id", qh.UpdateQuestion)
+	api.PATCH("/questions/:id", qh.PatchQuestion)
+	api.DELETE("/questions/:id/hard", qh.HardDeleteQuestion)
+	api.GET("/questions/:id/variants", qh.GetVariants)
+	api.POST("/questions/:id/variants", qh.GenerateVariants)
+
+	return r, qh
+}
+
+func TestGenerateVariants(t *testing.T) {
+	r, qh := newQuestionTestRouter(t)
+	seedQuestion(t, qh.DB, "parent", []string{"A. 1", "B. 2"}, "B")
+
+	stub := &stubAIClient{reply: `{"questions": [
+		{"content": "变式 1", "options": ["A. 3", "B. 4"], "answer": "A", "analysis": "解析", "learningGuide": "建议", "knowledgePoints": ["别的"], "difficulty": 5},
+		{"content": "缺答案", "analysis": "解析"}
+	]}`}
+	qh.NewAIClient = func(cfg *models.AIConfig) (ai.Client, error) { return stub, nil }
+
+	w := doJSON(t, r, http.MethodPost, "/api/questions/parent/variants", gin.H{"count": 2})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("generate variants = %d, body=%s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, r, http.MethodGet, "/api/questions/parent/variants", nil)
+	var variants []models.Question
+	if err := json.Unmarshal(w.Body.Bytes(), &variants); err != nil {
+		t.Fatalf("unmarshal variants: %v", err)
+	}
+	if len(variants) != 1 {
+		t.Fatalf("expected the incomplete variant to be dropped, got %d", len(variants))
+	}
+	v := variants[0]
+	if v.ParentID == nil || *v.ParentID != "parent" || !v.AIGenerated {
+		t.Fatalf("variant not linked / marked: %+v", v)
+	}
+	if kps := jsonStringToSlice(v.KnowledgePoints); len(kps) != 1 || kps[0] != "kp" {
+		t.Fatalf("variant must keep the original knowledge points, got %v", kps)
+	}
+	if v.Subject != models.Math || v.Difficulty != 2 {
+		t.Fatalf("variant subject/difficulty = %s/%d, want 数学/2", v.Subject, v.Difficulty)
+	}
+
+	w = doJSON(t, r, http.MethodDelete, "/api/questions/parent/hard", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("hard delete parent = %d", w.Code)
+	}
+	orphan, err := qh.DB.GetQuestionByID(testUserID, v.ID)
+	if err != nil || orphan.ParentID != nil {
+		t.Fatalf("variant should survive unlinked after parent deletion: %v %+v", err, orphan)
+	}
+}
+
+// seedFullQuestion creates a question with every patchable field set.
+func seedFullQuestion(t *testing.T, db *database.DB, id string) *models.Question {
+	t.Helper()
+	image := "data:image/png;base64,aGVsbG8="
+	diagram := "data:image/png;base64,ZGlhZ3JhbQ=="
+	description := "a triangle"
+	answer := "B"
+	reviewed := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+	next := reviewed.AddDate(0, 0, 3)
+	deleted := reviewed.AddDate(0, 0, 1)
+	q := &models.Question{
+		ID:                 id,
+		Image:              &image,
+		CroppedDiagram:     &diagram,
+		Content:            "content " + id,
+		Options:            stringSliceToJSONString([]string{"A. 1", "B. 2"}),
+		DiagramDescription: &description,
+		Answer:             &answer,
+		Analysis:           "analysis",
+		LearningGuide:      "guide",
+		KnowledgePoints:    stringSliceToJSONString([]string{"kp"}),
+		Subject:            models.Math,
+		Difficulty:         2,
+		CreatedAt:          reviewed.AddDate(0, -1, 0),
+		LastReviewedAt:     &reviewed,
+		NextReviewAt:       &next,
+		DeletedAt:          &deleted,
+		ReviewStreak:       2,
+	}
+	if err := db.CreateQuestion(testUserID, q); err != nil {
+		t.Fatalf("CreateQuestion: %v", err)
+	}
+	stored, err := db.GetQuestionByID(testUserID, id)
+	if err != nil {
+		t.Fatalf("GetQuestionByID: %v", err)
+	}
+	return stored
+}
+
+func TestPatchQuestion(t *testing.T) {
+	r, qh := newQuestionTestRouter(t)
+
+	str := func(field string, get func(q *models.Question) *string, want *string) func(q *models.Question) error {
+		return func(q *models.Question) error {
+			got := get(q)
+			if (got == nil) != (want == nil) || (got != nil && *got != *want) {
+				return fmt.Errorf("%s = %v, want %v", field, strOrNil(got), strOrNil(want))
+			}
+			return nil
+		}
+	}
+	ptr := func(s string) *string { return &s }
+	tm := func(field string, get func(q *models.Question) *time.Time, want string) func(q *models.Question) error {
+		return func(q *models.Question) error {
+			got := get(q)
+			if want == "" {
+				if got != nil {
+					return fmt.Errorf("%s = %v, want null", field, got)
+				}
+				return nil
+			}
+			if got == nil || got.Format(time.RFC3339) != want {
+				return fmt.Errorf("%s = %v, want %s", field, got, want)
+			}
+			return nil
+		}
+	}
+	image := func(q *models.Question) *string { return q.Image }
+	diagram := func(q *models.Question) *string { return q.CroppedDiagram }
+	options := func(q *models.Question) *string { return q.Options }
+	description := func(q *models.Question) *string { return q.DiagramDescription }
+	answer := func(q *models.Question) *string { return q.Answer }
+	kps := func(q *models.Question) *string { return q.KnowledgePoints }
+	content := func(q *models.Question) *string { return &q.Content }
+	analysis := func(q *models.Question) *string { return &q.Analysis }
+	guide := func(q *models.Question) *string { return &q.LearningGuide }
+	reviewed := func(q *models.Question) *time.Time { return q.LastReviewedAt }
+	next := func(q *models.Question) *time.Time { return q.NextReviewAt }
+
+	cases := []struct {
+		name  string
+		patch string
+		// check is nil for patches that must be rejected with 400.
+		check func(q *models.Question) error
+	}{
+		{"image set", `{"image": "data:image/png;base64,aGk="}`, str("image", image, ptr("data:image/png;base64,aGk="))},
+		{"image null", `{"image": null}`, str("image", image, nil)},
+		{"image not a data URL", `{"image": "http://example.com/a.png"}`, nil},
+		{"image wrong type", `{"image": 5}`, nil},
+		{"croppedDiagram null", `{"croppedDiagram": null}`, str("croppedDiagram", diagram, nil)},
+		{"croppedDiagram invalid", `{"croppedDiagram": "data:image/png;base64,!!"}`, nil},
+		{"content set", `{"content": "new content"}`, str("content", content, ptr("new content"))},
+		{"content null", `{"content": null}`, nil},
+		{"content empty", `{"content": "  "}`, nil},
+		{"options set", `{"options": ["A. 3", "B. 4"]}`, str("options", options, ptr(`["A. 3","B. 4"]`))},
+		{"options empty", `{"options": []}`, str("options", options, ptr("[]"))},
+		{"options null", `{"options": null}`, str("options", options, nil)},
+		{"options not strings", `{"options": [1, 2]}`, nil},
+		{"diagramDescription set", `{"diagramDescription": "a square"}`, str("diagramDescription", description, ptr("a square"))},
+		{"diagramDescription null", `{"diagramDescription": null}`, str("diagramDescription", description, nil)},
+		{"answer set", `{"answer": "A"}`, str("answer", answer, ptr("A"))},
+		{"answer null", `{"answer": null}`, str("answer", answer, nil)},
+		{"answer empty", `{"answer": ""}`, str("answer", answer, ptr(""))},
+		{"analysis set", `{"analysis": "better"}`, str("analysis", analysis, ptr("better"))},
+		{"analysis null", `{"analysis": null}`, str("analysis", analysis, ptr(""))},
+		{"analysis wrong type", `{"analysis": {"text": "x"}}`, nil},
+		{"learningGuide set", `{"learningGuide": "practise"}`, str("learningGuide", guide, ptr("practise"))},
+		{"learningGuide null", `{"learningGuide": null}`, str("learningGuide", guide, ptr(""))},
+		{"knowledgePoints set", `{"knowledgePoints": ["fractions"]}`, str("knowledgePoints", kps, ptr(`["fractions"]`))},
+		{"knowledgePoints empty", `{"knowledgePoints": []}`, str("knowledgePoints", kps, ptr("[]"))},
+		{"knowledgePoints null", `{"knowledgePoints": null}`, str("knowledgePoints", kps, ptr("[]"))},
+		{"knowledgePoints not a list", `{"knowledgePoints": "fractions"}`, nil},
+		{"subject set", `{"subject": "物理"}`, func(q *models.Question) error {
+			if q.Subject != models.Physics {
+				return fmt.Errorf("subject = %s", q.Subject)
+			}
+			return nil
+		}},
+		{"subject unknown", `{"subject": "Physics"}`, nil},
+		{"subject null", `{"subject": null}`, nil},
+		{"difficulty set", `{"difficulty": 5}`, func(q *models.Question) error {
+			if q.Difficulty != 5 {
+				return fmt.Errorf("difficulty = %d", q.Difficulty)
+			}
+			return nil
+		}},
+		{"difficulty out of range", `{"difficulty": 6}`, nil},
+		{"difficulty null", `{"difficulty": null}`, nil},
+		{"difficulty not a number", `{"difficulty": "hard"}`, nil},
+		{"lastReviewedAt set", `{"lastReviewedAt": "2024-07-01T10:00:00Z"}`, tm("lastReviewedAt", reviewed, "2024-07-01T10:00:00Z")},
+		{"lastReviewedAt null", `{"lastReviewedAt": null}`, tm("lastReviewedAt", reviewed, "")},
+		{"lastReviewedAt not a time", `{"lastReviewedAt": "yesterday"}`, nil},
+		{"deletedAt set", `{"deletedAt": "2024-07-02T10:00:00Z"}`, nil},
+		{"deletedAt null", `{"deletedAt": null}`, nil},
+		{"nextReviewAt set", `{"nextReviewAt": "2024-07-03T10:00:00Z"}`, tm("nextReviewAt", next, "2024-07-03T10:00:00Z")},
+		{"nextReviewAt null", `{"nextReviewAt": null}`, tm("nextReviewAt", next, "")},
+		{"reviewStreak set", `{"reviewStreak": 7}`, func(q *models.Question) error {
+			if q.ReviewStreak != 7 {
+				return fmt.Errorf("reviewStreak = %d", q.ReviewStreak)
+			}
+			return nil
+		}},
+		{"reviewStreak null", `{"reviewStreak": null}`, func(q *models.Question) error {
+			if q.ReviewStreak != 0 {
+				return fmt.Errorf("reviewStreak = %d", q.ReviewStreak)
+			}
+			return nil
+		}},
+		{"reviewStreak negative", `{"reviewStreak": -1}`, nil},
+		{"id unchanged", `{"id": "%s"}`, func(q *models.Question) error { return nil }},
+		{"id changed", `{"id": "other"}`, nil},
+		{"createdAt changed", `{"createdAt": "2020-01-01T00:00:00Z"}`, nil},
+		{"updatedAt changed", `{"updatedAt": "2020-01-01T00:00:00Z"}`, nil},
+		{"parentId changed", `{"parentId": "other"}`, nil},
+		{"aiGenerated unchanged", `{"aiGenerated": false}`, func(q *models.Question) error { return nil }},
+		{"aiGenerated changed", `{"aiGenerated": true}`, nil},
+		{"sourceQuestionId changed", `{"sourceQuestionId": "other"}`, nil},
+		{"copiedAt changed", `{"copiedAt": "2020-01-01T00:00:00Z"}`, nil},
+		{"version unchanged", `{"version": 1}`, func(q *models.Question) error { return nil }},
+		{"version changed", `{"version": 7}`, nil},
+		{"unknown field", `{"colour": "red"}`, nil},
+		{"not an object", `["content"]`, nil},
+		{"null body", `null`, nil},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			id := fmt.Sprintf("q%d", i)
+			seeded := seedFullQuestion(t, qh.DB, id)
+			patch := tc.patch
+			if strings.Contains(patch, "%s") {
+				patch = fmt.Sprintf(patch, id)
+			}
+
+			w := doJSON(t, r, http.MethodPatch, "/api/questions/"+id, json.RawMessage(patch))
+			got, err := qh.DB.GetQuestionByID(testUserID, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.check == nil {
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("status %d, want 400: %s", w.Code, w.Body.String())
+				}
+				if got.Version != seeded.Version {
+					t.Fatalf("rejected patch was written")
+				}
+				return
+			}
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body.String())
+			}
+			if err := tc.check(got); err != nil {
+				t.Fatal(err)
+			}
+
+			// Every field the patch left out is untouched.
+			var named map[string]json.RawMessage
+			json.Unmarshal([]byte(patch), &named)
+			before, after := questionFields(t, seeded), questionFields(t, got)
+			for field, value := range before {
+				if _, patched := named[field]; patched || field == "updatedAt" || field == "version" {
+					continue
+				}
+				if !reflect.DeepEqual(value, after[field]) {
+					t.Fatalf("%s changed from %v to %v", field, value, after[field])
+				}
+			}
+		})
+	}
+}
+
+// TestPatchQuestion_CoversEveryField keeps the merge patch in step with
+// models.Question: every JSON field is either patchable or read-only.
+func TestPatchQuestion_CoversEveryField(t *testing.T) {
+	questionType := reflect.TypeOf(models.Question{})
+	for i := 0; i < questionType.NumField(); i++ {
+		name := strings.Split(questionType.Field(i).Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if _, ok := questionPatchFields[name]; !ok && !readOnlyQuestionFields[name] {
+			t.Errorf("field %s is neither patchable nor read-only", name)
+		}
+	}
+}
+
+func TestPatchQuestion_IfMatch(t *testing.T) {
+	r, qh := newQuestionTestRouter(t)
+	seedFullQuestion(t, qh.DB, "q1")
+
+	w := doJSON(t, r, http.MethodPatch, "/api/questions/q1", gin.H{"answer": "A"})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("patch: status %d, ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+	req := httptest.NewRequest(http.MethodPatch, "/api/questions/q1", strings.NewReader(`{"answer": "C"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"1"`)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("stale patch: status %d, want 409", w.Code)
+	}
+	if got, _ := qh.DB.GetQuestionByID(testUserID, "q1"); *got.Answer != "A" {
+		t.Fatalf("stale patch was written: answer %q", *got.Answer)
+	}
+	if w := doJSON(t, r, http.MethodPatch, "/api/questions/missing", gin.H{"answer": "A"}); w.Code != http.StatusNotFound {
+		t.Fatalf("patching a missing question: status %d, want 404", w.Code)
+	}
+}
+
+func questionFields(t *testing.T, q *models.Question) map[string]interface{} {
+	t.Helper()
+	encoded, err := json.Marshal(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(encoded, &fields)
+	return fields
+}
+
+func strOrNil(s *string) interface{} {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
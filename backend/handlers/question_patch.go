@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"E-Bu-backend/database"
+	"E-Bu-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PatchQuestion applies an RFC 7396 JSON merge patch to a question: fields
+// left out are untouched, null clears a field and [] empties a list. Like
+// UpdateQuestion it honours If-Match.
+func (h *QuestionHandler) PatchQuestion(c *gin.Context) {
+	id := c.Param("id")
+	ifMatch, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The body must be a JSON merge patch object"})
+		return
+	}
+
+	existing, err := h.DB.GetQuestionByID(currentUserID(c), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+		return
+	}
+	if ifMatch != 0 && ifMatch != existing.Version {
+		h.versionConflict(c, id)
+		return
+	}
+
+	patched := *existing
+	columns, err := applyQuestionPatch(&patched, existing, patch)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if reasons := database.ValidateQuestion(&patched); len(reasons) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid question: " + strings.Join(reasons, "; ")})
+		return
+	}
+
+	if err := h.DB.As(auditActor(c)).PatchQuestion(currentUserID(c), id, &patched, columns); err != nil {
+		if errors.Is(err, database.ErrVersionConflict) {
+			h.versionConflict(c, id)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update question"})
+		return
+	}
+
+	question, err := h.DB.GetQuestionByID(currentUserID(c), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated question"})
+		return
+	}
+	setQuestionETag(c, question)
+	c.JSON(http.StatusOK, question)
+}
+
+// patchField is a question field a merge patch may set: its column and how
+// a value, or null, is applied.
+type patchField struct {
+	column string
+	apply  func(q *models.Question, raw json.RawMessage) error
+}
+
+var questionPatchFields = map[string]patchField{
+	"image":              {"image", patchNullableString(func(q *models.Question, v *string) { q.Image = v })},
+	"croppedDiagram":     {"cropped_diagram", patchNullableString(func(q *models.Question, v *string) { q.CroppedDiagram = v })},
+	"content":            {"content", patchString(func(q *models.Question, v string) { q.Content = v })},
+	"options":            {"options", patchStringList(true, func(q *models.Question, v *string) { q.Options = v })},
+	"diagramDescription": {"diagram_description", patchNullableString(func(q *models.Question, v *string) { q.DiagramDescription = v })},
+	"answer":             {"answer", patchNullableString(func(q *models.Question, v *string) { q.Answer = v })},
+	"analysis":           {"analysis", patchString(func(q *models.Question, v string) { q.Analysis = v })},
+	"learningGuide":      {"learning_guide", patchString(func(q *models.Question, v string) { q.LearningGuide = v })},
+	"knowledgePoints":    {"knowledge_points", patchStringList(false, func(q *models.Question, v *string) { q.KnowledgePoints = v })},
+	"subject":            {"subject", patchSubject},
+	"difficulty":         {"difficulty", patchDifficulty},
+	"lastReviewedAt":     {"last_reviewed_at", patchTime(func(q *models.Question, v *time.Time) { q.LastReviewedAt = v })},
+	"nextReviewAt":       {"next_review_at", patchTime(func(q *models.Question, v *time.Time) { q.NextReviewAt = v })},
+	"reviewStreak":       {"review_streak", patchReviewStreak},
+}
+
+// readOnlyQuestionFields are the question fields a patch may repeat but not
+// change, so a client can send back a whole question it read.
+var readOnlyQuestionFields = map[string]bool{
+	"id":               true,
+	"createdAt":        true,
+	"updatedAt":        true,
+	"parentId":         true,
+	"aiGenerated":      true,
+	"sourceQuestionId": true,
+	"copiedAt":         true,
+	"version":          true,
+	"deletedAt":        true,
+}
+
+// errTrashField is the answer to an update that tries to move a question to
+// or from the trash, which only the trash routes do, so that it is audited.
+var errTrashField = errors.New("deletedAt cannot be changed here; use DELETE /api/questions/:id to move a question to the trash and PATCH /api/questions/:id/restore to bring it back")
+
+// applyQuestionPatch merges patch into q, which starts out as a copy of
+// existing, and returns the columns it set.
+func applyQuestionPatch(q *models.Question, existing *models.Question, patch map[string]json.RawMessage) ([]string, error) {
+	var current map[string]interface{}
+	if encoded, err := json.Marshal(existing); err == nil {
+		json.Unmarshal(encoded, &current)
+	}
+
+	var columns []string
+	for name, raw := range patch {
+		if readOnlyQuestionFields[name] {
+			var value interface{}
+			if err := json.Unmarshal(raw, &value); err != nil || !reflect.DeepEqual(value, current[name]) {
+				if name == "deletedAt" {
+					return nil, errTrashField
+				}
+				return nil, fmt.Errorf("%s cannot be changed", name)
+			}
+			continue
+		}
+		field, ok := questionPatchFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		if err := field.apply(q, raw); err != nil {
+			return nil, fmt.Errorf("%s %v", name, err)
+		}
+		columns = append(columns, field.column)
+	}
+	return columns, nil
+}
+
+func isJSONNull(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
+
+// patchNullableString sets a string field that null clears.
+func patchNullableString(set func(q *models.Question, v *string)) func(*models.Question, json.RawMessage) error {
+	return func(q *models.Question, raw json.RawMessage) error {
+		if isJSONNull(raw) {
+			set(q, nil)
+			return nil
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return errors.New("must be a string or null")
+		}
+		set(q, &s)
+		return nil
+	}
+}
+
+// patchString sets a string field that is never NULL; null empties it.
+func patchString(set func(q *models.Question, v string)) func(*models.Question, json.RawMessage) error {
+	return func(q *models.Question, raw json.RawMessage) error {
+		var s string
+		if !isJSONNull(raw) {
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return errors.New("must be a string or null")
+			}
+		}
+		set(q, s)
+		return nil
+	}
+}
+
+// patchStringList sets a list stored as a JSON string. [] stores an empty
+// list; null clears the field, or empties it when the column is never NULL.
+func patchStringList(nullable bool, set func(q *models.Question, v *string)) func(*models.Question, json.RawMessage) error {
+	return func(q *models.Question, raw json.RawMessage) error {
+		items := []string{}
+		if isJSONNull(raw) {
+			if nullable {
+				set(q, nil)
+				return nil
+			}
+		} else if err := json.Unmarshal(raw, &items); err != nil {
+			return errors.New("must be an array of strings or null")
+		}
+		encoded, _ := json.Marshal(items)
+		set(q, jsonStringPtr(string(encoded)))
+		return nil
+	}
+}
+
+// patchTime sets an RFC 3339 time that null clears.
+func patchTime(set func(q *models.Question, v *time.Time)) func(*models.Question, json.RawMessage) error {
+	return func(q *models.Question, raw json.RawMessage) error {
+		if isJSONNull(raw) {
+			set(q, nil)
+			return nil
+		}
+		var t time.Time
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return errors.New("must be an RFC 3339 time or null")
+		}
+		set(q, &t)
+		return nil
+	}
+}
+
+func patchSubject(q *models.Question, raw json.RawMessage) error {
+	var subject models.Subject
+	if isJSONNull(raw) || json.Unmarshal(raw, &subject) != nil {
+		return errors.New("must be a subject")
+	}
+	q.Subject = subject
+	return nil
+}
+
+func patchDifficulty(q *models.Question, raw json.RawMessage) error {
+	var difficulty int
+	if isJSONNull(raw) || json.Unmarshal(raw, &difficulty) != nil {
+		return errors.New("must be a number from 1 to 5")
+	}
+	q.Difficulty = difficulty
+	return nil
+}
+
+// patchReviewStreak sets the review streak; null resets it to 0.
+func patchReviewStreak(q *models.Question, raw json.RawMessage) error {
+	streak := 0
+	if !isJSONNull(raw) && json.Unmarshal(raw, &streak) != nil {
+		return errors.New("must be a whole number or null")
+	}
+	q.ReviewStreak = streak
+	return nil
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"E-Bu-backend/database"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListRevisions returns the history of a question, oldest first.
+func (h *QuestionHandler) ListRevisions(c *gin.Context) {
+	revisions, err := h.DB.ListRevisions(currentUserID(c), c.Param("id"))
+	if err != nil {
+		revisionError(c, err, "Failed to list revisions")
+		return
+	}
+	c.JSON(http.StatusOK, revisions)
+}
+
+// GetRevision returns one revision of a question with its full content.
+func (h *QuestionHandler) GetRevision(c *gin.Context) {
+	number, ok := revisionNumber(c, c.Param("number"))
+	if !ok {
+		return
+	}
+	revision, err := h.DB.GetRevision(currentUserID(c), c.Param("id"), number)
+	if err != nil {
+		revisionError(c, err, "Failed to fetch revision")
+		return
+	}
+	c.JSON(http.StatusOK, revision)
+}
+
+// DiffRevisions compares the revisions given as from and to field by field.
+func (h *QuestionHandler) DiffRevisions(c *gin.Context) {
+	from, ok := revisionNumber(c, c.Query("from"))
+	if !ok {
+		return
+	}
+	to, ok := revisionNumber(c, c.Query("to"))
+	if !ok {
+		return
+	}
+	diff, err := h.DB.DiffRevisions(currentUserID(c), c.Param("id"), from, to)
+	if err != nil {
+		revisionError(c, err, "Failed to compare revisions")
+		return
+	}
+	c.JSON(http.StatusOK, diff)
+}
+
+// RevertQuestion puts the content of an earlier revision back; the revert
+// is itself recorded as a new revision. Like updates, it honours If-Match.
+func (h *QuestionHandler) RevertQuestion(c *gin.Context) {
+	number, ok := revisionNumber(c, c.Param("number"))
+	if !ok {
+		return
+	}
+	ifMatch, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+	id := c.Param("id")
+	question, err := h.DB.As(auditActor(c)).RevertQuestion(currentUserID(c), id, number, ifMatch)
+	if err != nil {
+		if errors.Is(err, database.ErrVersionConflict) {
+			h.versionConflict(c, id)
+			return
+		}
+		revisionError(c, err, "Failed to revert question")
+		return
+	}
+	setQuestionETag(c, question)
+	c.JSON(http.StatusOK, question)
+}
+
+func revisionNumber(c *gin.Context, value string) (int, bool) {
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Revision numbers are positive integers"})
+		return 0, false
+	}
+	return number, true
+}
+
+func revisionError(c *gin.Context, err error, fallback string) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question or revision not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+}
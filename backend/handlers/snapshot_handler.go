@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"E-Bu-backend/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SnapshotHandler struct {
+	Snapshots *database.Snapshotter
+}
+
+func NewSnapshotHandler(snapshots *database.Snapshotter) *SnapshotHandler {
+	return &SnapshotHandler{Snapshots: snapshots}
+}
+
+// ListSnapshots lists database snapshots, newest first, with the schedule
+// and retention settings.
+func (h *SnapshotHandler) ListSnapshots(c *gin.Context) {
+	snaps, err := h.Snapshots.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list snapshots"})
+		return
+	}
+	cfg := h.Snapshots.Config
+	c.JSON(http.StatusOK, gin.H{
+		"snapshots":  snaps,
+		"count":      len(snaps),
+		"interval":   cfg.Interval.String(),
+		"keepDaily":  cfg.KeepDaily,
+		"keepWeekly": cfg.KeepWeekly,
+	})
+}
+
+// CreateSnapshot takes a manual snapshot now. Manual snapshots are never
+// removed by retention.
+func (h *SnapshotHandler) CreateSnapshot(c *gin.Context) {
+	snap, err := h.Snapshots.Take(database.SnapshotManual)
+	if err != nil {
+		snapshotError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, snap)
+}
+
+// RestoreSnapshot replaces the live database with a snapshot after saving
+// the current state as a pre-restore snapshot.
+func (h *SnapshotHandler) RestoreSnapshot(c *gin.Context) {
+	name := c.Param("name")
+	safety, err := h.Snapshots.Restore(name, auditActor(c))
+	if err != nil {
+		resp := gin.H{}
+		if safety != nil {
+			resp["safetySnapshot"] = safety
+		}
+		snapshotError(c, err, resp)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Snapshot restored",
+		"restored":       name,
+		"safetySnapshot": safety,
+	})
+}
+
+func snapshotError(c *gin.Context, err error, extra ...gin.H) {
+	resp := gin.H{"error": err.Error()}
+	for _, e := range extra {
+		for k, v := range e {
+			resp[k] = v
+		}
+	}
+	switch {
+	case errors.Is(err, database.ErrSnapshotNotFound):
+		c.JSON(http.StatusNotFound, resp)
+	case errors.Is(err, database.ErrSnapshotCorrupt):
+		c.JSON(http.StatusUnprocessableEntity, resp)
+	case errors.Is(err, database.ErrSnapshotTooNew):
+		c.JSON(http.StatusConflict, resp)
+	default:
+		c.JSON(http.StatusInternalServerError, resp)
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"E-Bu-backend/database"
+	"E-Bu-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSnapshotEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	db, err := database.NewDB(filepath.Join(dir, "ebu.db"))
+	if err != nil {
+		t.Fatalf("NewDB failed: %v", err)
+	}
+	sh := NewSnapshotHandler(database.NewSnapshotter(db, database.SnapshotConfig{Dir: filepath.Join(dir, "snapshots")}))
+	r := gin.New()
+	api := r.Group("/api")
+	api.GET("/db/snapshots", sh.ListSnapshots)
+	api.POST("/db/snapshots", sh.CreateSnapshot)
+	api.POST("/db/snapshots/:name/restore", sh.RestoreSnapshot)
+
+	seedQuestion(t, db, "q1", nil, "A")
+
+	w := doJSON(t, r, http.MethodPost, "/api/db/snapshots", nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create snapshot = %d, body=%s", w.Code, w.Body.String())
+	}
+	var snap database.Snapshot
+	json.Unmarshal(w.Body.Bytes(), &snap)
+
+	db.Delete(&models.Question{}, "id = ?", "q1")
+
+	w = doJSON(t, r, http.MethodPost, "/api/db/snapshots/"+snap.Name+"/restore", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("restore = %d, body=%s", w.Code, w.Body.String())
+	}
+	if q, err := db.GetQuestionByID(testUserID, "q1"); err != nil || q.ID != "q1" {
+		t.Fatalf("question not restored: %v", err)
+	}
+
+	w = doJSON(t, r, http.MethodGet, "/api/db/snapshots", nil)
+	var list struct {
+		Count int `json:"count"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if w.Code != http.StatusOK || list.Count != 2 {
+		t.Fatalf("list = %d, body=%s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, r, http.MethodPost, "/api/db/snapshots/missing.db/restore", nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("restore missing = %d", w.Code)
+	}
+}
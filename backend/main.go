@@ -1,38 +1,45 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"E-Bu-backend/database"
-	"E-Bu-backend/handlers"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// serve runs the HTTP server until it fails.
+func serve() int {
 	// Set up Gin
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
 
-	// Enable CORS
-	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Requested-With")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		c.Next()
-	})
+	// Client IPs, which the login throttle and the audit log go by, are
+	// taken from X-Forwarded-For only on connections from TRUSTED_PROXIES
+	// (comma-separated addresses or CIDRs); by default from nobody. The
+	// same proxies are believed when X-Forwarded-Proto says HTTPS.
+	trustedProxies := splitList(os.Getenv("TRUSTED_PROXIES"))
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
+	// Cross-origin requests are only allowed from CORS_ORIGINS
+	// (comma-separated).
+	r.Use(corsMiddleware(splitList(os.Getenv("CORS_ORIGINS"))))
 
 	// Initialize database
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = "/app/ebu.db" // Default path in container
-	}
+	dbPath := databasePath()
 
 	// Ensure the directory exists
 	dir := filepath.Dir(dbPath)
@@ -40,41 +47,44 @@ func main() {
 		log.Fatal("Failed to create database directory:", err)
 	}
 
-	db, err := database.NewDB(dbPath)
+	// Existing databases are snapshotted before pending migrations run.
+	snapshotCfg := snapshotConfig(dir)
+	db, err := database.NewDBWithOptions(dbPath, database.Options{SnapshotDir: snapshotCfg.Dir})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	// Initialize handlers
-	questionHandler := handlers.NewQuestionHandler(db)
-	aiConfigHandler := handlers.NewAIConfigHandler(db)
-	backupHandler := handlers.NewBackupHandler(db)
-	migrationHandler := handlers.NewMigrationHandler(db, dbPath)
+	snapshots := database.NewSnapshotter(db, snapshotCfg)
+	snapshots.Start()
 
-	// API routes
-	api := r.Group("/api")
-	{
-		// Question routes
-		api.GET("/questions", questionHandler.GetQuestions)
-		api.GET("/trash", questionHandler.GetTrash)
-		api.POST("/questions", questionHandler.CreateQuestion)
-		api.PUT("/questions/:id", questionHandler.UpdateQuestion)
-		api.DELETE("/questions/:id", questionHandler.DeleteQuestion)
-		api.PATCH("/questions/:id/restore", questionHandler.RestoreQuestion)
-		api.DELETE("/questions/:id/hard", questionHandler.HardDeleteQuestion)
-
-		// AI Config routes
-		api.GET("/config", aiConfigHandler.GetAIConfig)
-		api.PUT("/config", aiConfigHandler.SaveAIConfig)
-		api.POST("/analyze", aiConfigHandler.AnalyzeImage)
+	var maxImportBytes int64
+	if limit := os.Getenv("IMPORT_MAX_BYTES"); limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n <= 0 {
+			log.Fatal("Invalid IMPORT_MAX_BYTES:", limit)
+		}
+		maxImportBytes = n
+	}
 
-		// Backup routes
-		api.GET("/export", backupHandler.ExportBackup)
-		api.POST("/import", backupHandler.ImportBackup)
+	// Until the admin account exists, setup needs a one-time code from the
+	// log, so nobody else on the network can claim the server first.
+	var setupCode string
+	if n, err := db.CountUsers(); err != nil {
+		log.Fatal("Failed to count users:", err)
+	} else if n == 0 {
+		setupCode = newSetupCode()
+		log.Printf("No accounts yet. Create the admin account with setup code %s", setupCode)
+	}
 
-		// Database migrations
-		api.GET("/db/migrations", migrationHandler.GetMigrations)
-		api.POST("/db/migrate", migrationHandler.ApplyMigrations)
+	err = registerRoutes(r, db, routeConfig{
+		DBPath:         dbPath,
+		Snapshots:      snapshots,
+		MaxImportBytes: maxImportBytes,
+		SetupCode:      setupCode,
+		TrustedProxies: trustedProxies,
+	})
+	if err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
 	}
 
 	// Serve static files from frontend if available
@@ -115,5 +125,70 @@ func main() {
 		port = "8080"
 	}
 	log.Printf("Server starting on port %s", port)
-	r.Run(":" + port)
+	if err := r.Run(":" + port); err != nil {
+		log.Println("Server stopped:", err)
+		return 1
+	}
+	return 0
+}
+
+// newSetupCode returns a short random code that is easy to copy from the log.
+func newSetupCode() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("Failed to generate setup code:", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func databasePath() string {
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "/app/ebu.db" // Default path in container
+	}
+	return dbPath
+}
+
+// snapshotConfig reads the snapshot settings from the environment. Snapshots
+// go to SNAPSHOT_DIR (default: "snapshots" next to the database).
+func snapshotConfig(dbDir string) database.SnapshotConfig {
+	cfg := database.SnapshotConfig{
+		Dir:        os.Getenv("SNAPSHOT_DIR"),
+		Interval:   24 * time.Hour,
+		KeepDaily:  7,
+		KeepWeekly: 4,
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = filepath.Join(dbDir, "snapshots")
+	}
+	if v := os.Getenv("SNAPSHOT_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval < 0 {
+			log.Fatal("Invalid SNAPSHOT_INTERVAL:", v)
+		}
+		cfg.Interval = interval
+	}
+	for env, target := range map[string]*int{
+		"SNAPSHOT_KEEP_DAILY":  &cfg.KeepDaily,
+		"SNAPSHOT_KEEP_WEEKLY": &cfg.KeepWeekly,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				log.Fatalf("Invalid %s: %s", env, v)
+			}
+			*target = n
+		}
+	}
+	return cfg
 }
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditEntry records one destructive or administrative action: who did it,
+// from where, and what it touched. The log is append-only; the database
+// refuses to change or delete entries. Entries without an actor were made
+// by the server itself or from the command line (see UserAgent).
+type AuditEntry struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	At        time.Time `json:"at" gorm:"not null;index"`
+	ActorID   string    `json:"actorId,omitempty" gorm:"column:actor_id;type:varchar(36);index"`
+	ActorName string    `json:"actorName,omitempty" gorm:"column:actor_name"`
+	IP        string    `json:"ip,omitempty" gorm:"column:ip"`
+	UserAgent string    `json:"userAgent,omitempty" gorm:"column:user_agent"`
+	Action    string    `json:"action" gorm:"not null;index"`
+	// TargetIDs lists at most the first 100 IDs the action touched;
+	// TargetCount is how many there were.
+	TargetIDs   []string               `json:"targetIds" gorm:"column:target_ids;type:text;serializer:json"`
+	TargetCount int                    `json:"targetCount" gorm:"column:target_count;not null;default:0"`
+	Details     map[string]interface{} `json:"details,omitempty" gorm:"type:text;serializer:json"`
+}
+
+// TableName overrides the table name
+func (AuditEntry) TableName() string {
+	return "audit_log"
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+)
+
+// Class is a teacher's group of students.
+type Class struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	Name      string    `json:"name" gorm:"not null"`
+	TeacherID string    `json:"teacherId" gorm:"column:teacher_id;type:varchar(36);not null;index"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+}
+
+// ClassMember puts a student in a class.
+type ClassMember struct {
+	ClassID   string    `json:"classId" gorm:"primaryKey;column:class_id;type:varchar(36)"`
+	StudentID string    `json:"studentId" gorm:"primaryKey;column:student_id;type:varchar(36);index"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+}
+
+// ParentLink lets a parent follow one of their children.
+type ParentLink struct {
+	ParentID  string    `json:"parentId" gorm:"primaryKey;column:parent_id;type:varchar(36)"`
+	StudentID string    `json:"studentId" gorm:"primaryKey;column:student_id;type:varchar(36);index"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+}
+
+// Assignment is a question set a teacher sent to a class. Every student in
+// the class, including those who join before the due date, gets a copy of
+// the questions in their own mistake book and a practice session over them
+// (AssignmentStudent); their progress in that session is their progress on
+// the assignment.
+type Assignment struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	ClassID   string    `json:"classId" gorm:"column:class_id;type:varchar(36);not null;index"`
+	TeacherID string    `json:"teacherId" gorm:"column:teacher_id;type:varchar(36);not null;index"`
+	Title     string    `json:"title" gorm:"not null"`
+	DueAt     time.Time `json:"dueAt" gorm:"column:due_at;not null"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+}
+
+// AssignmentQuestion is one of the teacher's questions in an assignment.
+type AssignmentQuestion struct {
+	AssignmentID string `json:"assignmentId" gorm:"primaryKey;column:assignment_id;type:varchar(36)"`
+	QuestionID   string `json:"questionId" gorm:"primaryKey;column:question_id;type:varchar(36)"`
+	Position     int    `json:"position" gorm:"not null;default:0"`
+}
+
+// AssignmentStudent is the practice session a student works on an
+// assignment in.
+type AssignmentStudent struct {
+	AssignmentID string `json:"assignmentId" gorm:"primaryKey;column:assignment_id;type:varchar(36)"`
+	StudentID    string `json:"studentId" gorm:"primaryKey;column:student_id;type:varchar(36);index"`
+	SessionID    string `json:"sessionId" gorm:"column:session_id;type:varchar(36);not null"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// Who can see a collection besides its owner.
+const (
+	VisibilityPrivate = "private"
+	// VisibilityClass shares the collection with one class: its teacher and
+	// every student in it.
+	VisibilityClass = "class"
+	// VisibilityAll shares the collection with every signed-in user.
+	VisibilityAll = "all"
+)
+
+// Collection is a named set of questions from its owner's mistake book that
+// can be published, read-only, to a class or to everyone. Readers see the
+// owner's questions as they are now and can copy them into their own book.
+type Collection struct {
+	ID          string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	OwnerID     string    `json:"ownerId" gorm:"column:owner_id;type:varchar(36);not null;index"`
+	Name        string    `json:"name" gorm:"not null"`
+	Description string    `json:"description" gorm:"not null;default:''"`
+	Visibility  string    `json:"visibility" gorm:"not null;default:private;index"`
+	ClassID     *string   `json:"classId,omitempty" gorm:"column:class_id;type:varchar(36);index"`
+	CreatedAt   time.Time `json:"createdAt" gorm:"column:created_at"`
+	UpdatedAt   time.Time `json:"updatedAt" gorm:"column:updated_at"`
+}
+
+// CollectionQuestion puts one of the owner's questions in a collection.
+type CollectionQuestion struct {
+	CollectionID string    `json:"collectionId" gorm:"primaryKey;column:collection_id;type:varchar(36)"`
+	QuestionID   string    `json:"questionId" gorm:"primaryKey;column:question_id;type:varchar(36);index"`
+	Position     int       `json:"position" gorm:"not null;default:0"`
+	CreatedAt    time.Time `json:"createdAt" gorm:"column:created_at"`
+}
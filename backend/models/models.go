@@ -33,6 +33,7 @@ type AIConfig struct {
 	ModelName    string         `json:"modelName,omitempty" gorm:"column:model_name"`
 	SystemPrompt string         `json:"systemPrompt,omitempty" gorm:"column:system_prompt"`
 	ConfigData   string         `json:"configData,omitempty" gorm:"column:config_data;type:text"` // New: Stores full JSON config
+	OwnerID      *string        `json:"-" gorm:"column:owner_id;type:varchar(36);uniqueIndex"` // One config per user
 }
 
 type Question struct {
@@ -49,8 +50,17 @@ type Question struct {
 	Subject           Subject   `json:"subject" gorm:"not null"`
 	Difficulty        int       `json:"difficulty" gorm:"not null;default:1;check:difficulty >= 1 AND difficulty <= 5"`
 	CreatedAt         time.Time `json:"createdAt" gorm:"column:created_at"`
+	UpdatedAt         time.Time `json:"updatedAt" gorm:"column:updated_at"`
 	LastReviewedAt    *time.Time `json:"lastReviewedAt,omitempty" gorm:"column:last_reviewed_at"`
 	DeletedAt         *time.Time `json:"deletedAt,omitempty" gorm:"column:deleted_at"`
+	NextReviewAt      *time.Time `json:"nextReviewAt,omitempty" gorm:"column:next_review_at"`
+	ReviewStreak      int        `json:"reviewStreak" gorm:"column:review_streak;not null;default:0"`
+	ParentID          *string    `json:"parentId,omitempty" gorm:"column:parent_id;type:varchar(36);index"` // Set on variants generated from another question
+	AIGenerated       bool       `json:"aiGenerated" gorm:"column:ai_generated;not null;default:false"`
+	OwnerID           string     `json:"-" gorm:"column:owner_id;type:varchar(36);index"` // The user whose mistake book this is; never part of backups
+	SourceQuestionID  *string    `json:"sourceQuestionId,omitempty" gorm:"column:source_question_id;type:varchar(36);index"` // Set on copies of another user's question
+	CopiedAt          *time.Time `json:"copiedAt,omitempty" gorm:"column:copied_at"`
+	Version           int        `json:"version,omitempty" gorm:"column:version;not null;default:1"` // Bumped by every edit; the question's ETag
 }
 
 // TableName overrides the table name
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	PracticeActive   = "active"
+	PracticeFinished = "finished"
+)
+
+// Attempt status inside a practice session.
+const (
+	AttemptPending           = "pending"
+	AttemptAwaitingSelfGrade = "awaiting_self_grade"
+	AttemptGraded            = "graded"
+)
+
+// How an attempt was graded.
+const (
+	GradingAuto = "auto"
+	GradingSelf = "self"
+	GradingAI   = "ai"
+)
+
+type PracticeSession struct {
+	ID         string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	Status     string     `json:"status" gorm:"not null;default:active"`
+	Total      int        `json:"total" gorm:"not null;default:0"`
+	CreatedAt  time.Time  `json:"createdAt" gorm:"column:created_at"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty" gorm:"column:finished_at"`
+	OwnerID    string     `json:"-" gorm:"column:owner_id;type:varchar(36);index"`
+}
+
+func (PracticeSession) TableName() string {
+	return "practice_sessions"
+}
+
+// PracticeAttempt is one question inside a practice session. Rows are created
+// up front when the session starts (status pending) and filled in as the
+// student answers.
+type PracticeAttempt struct {
+	ID          string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	SessionID   *string    `json:"sessionId,omitempty" gorm:"column:session_id;type:varchar(36);index"`
+	QuestionID  string     `json:"questionId" gorm:"column:question_id;type:varchar(36);not null;index"`
+	Position    int        `json:"position" gorm:"not null;default:0"`
+	Status      string     `json:"status" gorm:"not null;default:pending"`
+	Answer      *string    `json:"answer,omitempty" gorm:"column:answer;type:text"`
+	AnswerImage *string    `json:"answerImage,omitempty" gorm:"column:answer_image;type:text"`
+	Grading     string     `json:"grading,omitempty" gorm:"not null;default:''"`
+	Correct     *bool      `json:"correct,omitempty" gorm:"column:correct"`
+	CreatedAt   time.Time  `json:"createdAt" gorm:"column:created_at"`
+	AnsweredAt  *time.Time `json:"answeredAt,omitempty" gorm:"column:answered_at"`
+	GradedAt    *time.Time `json:"gradedAt,omitempty" gorm:"column:graded_at"`
+	OwnerID     string     `json:"-" gorm:"column:owner_id;type:varchar(36);index"`
+
+	// AI grading verdict, kept with the attempt so teachers can audit it.
+	AIVerdict     *string `json:"aiVerdict,omitempty" gorm:"column:ai_verdict"`
+	AIScore       *int    `json:"aiScore,omitempty" gorm:"column:ai_score"`
+	AIExplanation *string `json:"aiExplanation,omitempty" gorm:"column:ai_explanation;type:text"`
+	AIModel       *string `json:"aiModel,omitempty" gorm:"column:ai_model"`
+	AIRaw         *string `json:"aiRaw,omitempty" gorm:"column:ai_raw;type:text"`
+}
+
+func (PracticeAttempt) TableName() string {
+	return "practice_attempts"
+}
+
+// ReviewLog is the review history of a question. Every graded practice
+// attempt appends one row.
+type ReviewLog struct {
+	ID           uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	QuestionID   string    `json:"questionId" gorm:"column:question_id;type:varchar(36);not null;index"`
+	SessionID    *string   `json:"sessionId,omitempty" gorm:"column:session_id;type:varchar(36)"`
+	Correct      bool      `json:"correct" gorm:"not null"`
+	Source       string    `json:"source" gorm:"not null;default:practice"`
+	IntervalDays int       `json:"intervalDays" gorm:"column:interval_days;not null;default:0"`
+	ReviewedAt   time.Time `json:"reviewedAt" gorm:"column:reviewed_at"`
+	OwnerID      string    `json:"-" gorm:"column:owner_id;type:varchar(36);index"`
+}
+
+func (ReviewLog) TableName() string {
+	return "review_logs"
+}
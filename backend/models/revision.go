@@ -0,0 +1,85 @@
+package models
+
+import (
+	"time"
+)
+
+// Where a question revision came from.
+const (
+	// RevisionAI is content the AI produced: a generated variant, or a
+	// question saved as /api/analyze returned it.
+	RevisionAI = "ai"
+	// RevisionManual is content a user typed in when creating the question.
+	RevisionManual = "manual"
+	RevisionEdit   = "edit"
+	RevisionRevert = "revert"
+	// RevisionImport is content a backup import wrote over the question.
+	RevisionImport = "import"
+	// RevisionBaseline records the state a question had before its first
+	// recorded edit, for questions created before revisions were kept and
+	// for imported or copied ones.
+	RevisionBaseline = "baseline"
+)
+
+// QuestionSnapshot is the editable content of a question, as kept in its
+// revisions. Review progress and bookkeeping are not part of it.
+type QuestionSnapshot struct {
+	Image              *string `json:"image,omitempty"`
+	CroppedDiagram     *string `json:"croppedDiagram,omitempty"`
+	Content            string  `json:"content"`
+	Options            *string `json:"options,omitempty"`
+	DiagramDescription *string `json:"diagramDescription,omitempty"`
+	Answer             *string `json:"answer,omitempty"`
+	Analysis           string  `json:"analysis"`
+	LearningGuide      string  `json:"learningGuide"`
+	KnowledgePoints    *string `json:"knowledgePoints"`
+	Subject            Subject `json:"subject"`
+	Difficulty         int     `json:"difficulty"`
+}
+
+// SnapshotOf returns the editable content of q.
+func SnapshotOf(q *Question) QuestionSnapshot {
+	return QuestionSnapshot{
+		Image:              q.Image,
+		CroppedDiagram:     q.CroppedDiagram,
+		Content:            q.Content,
+		Options:            q.Options,
+		DiagramDescription: q.DiagramDescription,
+		Answer:             q.Answer,
+		Analysis:           q.Analysis,
+		LearningGuide:      q.LearningGuide,
+		KnowledgePoints:    q.KnowledgePoints,
+		Subject:            q.Subject,
+		Difficulty:         q.Difficulty,
+	}
+}
+
+// ApplyTo overwrites the editable content of q with the snapshot.
+func (s QuestionSnapshot) ApplyTo(q *Question) {
+	q.Image = s.Image
+	q.CroppedDiagram = s.CroppedDiagram
+	q.Content = s.Content
+	q.Options = s.Options
+	q.DiagramDescription = s.DiagramDescription
+	q.Answer = s.Answer
+	q.Analysis = s.Analysis
+	q.LearningGuide = s.LearningGuide
+	q.KnowledgePoints = s.KnowledgePoints
+	q.Subject = s.Subject
+	q.Difficulty = s.Difficulty
+}
+
+// QuestionRevision is one recorded state of a question's content. Revisions
+// are numbered from 1 per question; the highest number is the current state.
+type QuestionRevision struct {
+	ID           string           `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	QuestionID   string           `json:"questionId" gorm:"column:question_id;type:varchar(36);not null;uniqueIndex:idx_question_revisions_number,priority:1"`
+	Number       int              `json:"number" gorm:"not null;uniqueIndex:idx_question_revisions_number,priority:2"`
+	OwnerID      string           `json:"-" gorm:"column:owner_id;type:varchar(36);not null;index"`
+	Source       string           `json:"source" gorm:"not null"`
+	AuthorID     string           `json:"authorId,omitempty" gorm:"column:author_id;type:varchar(36)"`
+	AuthorName   string           `json:"authorName,omitempty" gorm:"column:author_name"`
+	RevertedFrom *int             `json:"revertedFrom,omitempty" gorm:"column:reverted_from"` // The revision a revert went back to
+	Snapshot     QuestionSnapshot `json:"snapshot" gorm:"type:text;not null;serializer:json"`
+	CreatedAt    time.Time        `json:"createdAt" gorm:"column:created_at"`
+}
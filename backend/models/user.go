@@ -0,0 +1,92 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Account roles. Admins manage accounts and the database itself. Teachers
+// run classes and hand out assignments, students keep their own mistake
+// book, and parents follow the progress of their children.
+const (
+	RoleAdmin   = "admin"
+	RoleTeacher = "teacher"
+	RoleStudent = "student"
+	RoleParent  = "parent"
+)
+
+// ValidRole reports whether role is one of the account roles.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleTeacher, RoleStudent, RoleParent:
+		return true
+	}
+	return false
+}
+
+// User is a local account. PasswordHash is a bcrypt hash and is never sent
+// to clients.
+type User struct {
+	ID           string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	Username     string    `json:"username" gorm:"not null;uniqueIndex"`
+	PasswordHash string    `json:"-" gorm:"column:password_hash;not null"`
+	Role         string    `json:"role" gorm:"not null;default:student"`
+	CreatedAt    time.Time `json:"createdAt" gorm:"column:created_at"`
+	UpdatedAt    time.Time `json:"updatedAt" gorm:"column:updated_at"`
+}
+
+// UserSession is a login. ID is the SHA-256 of the token in the session
+// cookie, so the database alone cannot be used to hijack a session.
+type UserSession struct {
+	ID        string    `json:"-" gorm:"primaryKey;type:varchar(64)"`
+	UserID    string    `json:"userId" gorm:"column:user_id;type:varchar(36);not null;index"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+	ExpiresAt time.Time `json:"expiresAt" gorm:"column:expires_at;not null;index"`
+}
+
+// API token scopes. A token can only reach the routes its scopes cover;
+// session logins are not limited.
+const (
+	ScopeQuestionsRead  = "questions:read"
+	ScopeQuestionsWrite = "questions:write"
+	// ScopeAnalyze covers the routes that call the AI provider.
+	ScopeAnalyze = "analyze"
+	// ScopeAdmin covers backups (export and import) and, on admin
+	// accounts, the admin routes.
+	ScopeAdmin = "admin"
+)
+
+// ValidScope reports whether scope is one of the API token scopes.
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeQuestionsRead, ScopeQuestionsWrite, ScopeAnalyze, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// APIToken is a personal token scripts send as "Authorization: Bearer
+// <token>". Like a session, only the SHA-256 of the token is stored; Prefix
+// keeps its first characters so users can tell their tokens apart. Scopes
+// is space-separated.
+type APIToken struct {
+	ID         string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID     string     `json:"userId" gorm:"column:user_id;type:varchar(36);not null;index"`
+	Name       string     `json:"name" gorm:"not null"`
+	TokenHash  string     `json:"-" gorm:"column:token_hash;type:varchar(64);not null;uniqueIndex"`
+	Prefix     string     `json:"prefix" gorm:"not null"`
+	Scopes     string     `json:"scopes" gorm:"not null"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty" gorm:"column:expires_at"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" gorm:"column:last_used_at"`
+	CreatedAt  time.Time  `json:"createdAt" gorm:"column:created_at"`
+}
+
+// HasScope reports whether the token was given scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range strings.Fields(t.Scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
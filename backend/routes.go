@@ -0,0 +1,213 @@
+package main
+
+import (
+	"E-Bu-backend/database"
+	"E-Bu-backend/handlers"
+	"E-Bu-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routeConfig is what registerRoutes needs besides the database.
+type routeConfig struct {
+	DBPath         string
+	Snapshots      *database.Snapshotter
+	MaxImportBytes int64
+	// SetupCode is required by first-run setup; see AuthHandler.SetupCode.
+	SetupCode string
+	// TrustedProxies are the proxies whose X-Forwarded-Proto is believed;
+	// the same list the engine trusts with X-Forwarded-For.
+	TrustedProxies []string
+}
+
+// registerRoutes adds the /api routes to r. Only the auth endpoints are open;
+// everything else needs a session or an API token with the right scope,
+// class management needs a teacher, and the account and database
+// administration routes need an admin.
+func registerRoutes(r *gin.Engine, db *database.DB, cfg routeConfig) error {
+	authHandler := handlers.NewAuthHandler(db)
+	authHandler.SetupCode = cfg.SetupCode
+	if err := authHandler.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return err
+	}
+	questionHandler := handlers.NewQuestionHandler(db)
+	aiConfigHandler := handlers.NewAIConfigHandler(db)
+	backupHandler := handlers.NewBackupHandler(db)
+	if cfg.MaxImportBytes > 0 {
+		backupHandler.MaxImportBytes = cfg.MaxImportBytes
+	}
+	migrationHandler := handlers.NewMigrationHandler(db, cfg.DBPath, cfg.Snapshots)
+	practiceHandler := handlers.NewPracticeHandler(db)
+	snapshotHandler := handlers.NewSnapshotHandler(cfg.Snapshots)
+	integrityHandler := handlers.NewIntegrityHandler(db)
+	auditHandler := handlers.NewAuditHandler(db)
+	classHandler := handlers.NewClassHandler(db)
+	collectionHandler := handlers.NewCollectionHandler(db)
+
+	// Auth routes
+	public := r.Group("/api/auth")
+	{
+		public.GET("/status", authHandler.Status)
+		public.POST("/setup", authHandler.Setup)
+		public.POST("/login", authHandler.Login)
+		public.POST("/logout", authHandler.Logout)
+	}
+
+	api := r.Group("/api", authHandler.RequireAuth)
+	api.GET("/auth/me", authHandler.Me)
+
+	// Managing the account itself needs a session; API tokens cannot
+	// change the password or create more tokens.
+	account := api.Group("/auth", handlers.RequireSession)
+	{
+		account.PUT("/password", authHandler.ChangePassword)
+		account.GET("/tokens", authHandler.ListTokens)
+		account.POST("/tokens", authHandler.CreateToken)
+		account.DELETE("/tokens/:id", authHandler.RevokeToken)
+	}
+
+	// The groups below are split by the scope an API token needs.
+	read := api.Group("", handlers.RequireScope(models.ScopeQuestionsRead))
+	{
+		read.GET("/questions", questionHandler.GetQuestions)
+		read.GET("/questions/:id", questionHandler.GetQuestion)
+		read.GET("/trash", questionHandler.GetTrash)
+		read.GET("/questions/:id/variants", questionHandler.GetVariants)
+		read.GET("/questions/:id/reviews", practiceHandler.GetReviewHistory)
+		read.GET("/questions/:id/attempts", practiceHandler.GetAttempts)
+		read.GET("/questions/:id/revisions", questionHandler.ListRevisions)
+		read.GET("/questions/:id/revisions/:number", questionHandler.GetRevision)
+		read.GET("/questions/:id/diff", questionHandler.DiffRevisions)
+
+		read.GET("/practice/sessions/:id", practiceHandler.GetSession)
+		read.GET("/practice/sessions/:id/next", practiceHandler.NextQuestion)
+
+		read.GET("/config", aiConfigHandler.GetAIConfig)
+
+		// Classes, assignments and the views of a student's progress;
+		// the handlers check who may see which student.
+		read.GET("/classes", classHandler.ListClasses)
+		read.GET("/assignments", classHandler.MyAssignments)
+		read.GET("/children", classHandler.ListChildren)
+		read.GET("/students/:id/stats", classHandler.GetStudentStats)
+		read.GET("/students/:id/assignments", classHandler.GetStudentAssignments)
+		read.GET("/students/:id/questions", classHandler.GetStudentQuestions)
+
+		// Shared collections; only their owner changes them, everyone
+		// they are published to can read and copy.
+		read.GET("/collections", collectionHandler.ListCollections)
+		read.GET("/collections/:id", collectionHandler.GetCollection)
+	}
+
+	write := api.Group("", handlers.RequireScope(models.ScopeQuestionsWrite))
+	{
+		write.POST("/questions", questionHandler.CreateQuestion)
+		write.POST("/questions/bulk", questionHandler.BulkQuestions)
+		write.PUT("/questions/:id", questionHandler.UpdateQuestion)
+		write.PATCH("/questions/:id", questionHandler.PatchQuestion)
+		write.DELETE("/questions/:id", questionHandler.DeleteQuestion)
+		write.PATCH("/questions/:id/restore", questionHandler.RestoreQuestion)
+		write.DELETE("/questions/:id/hard", questionHandler.HardDeleteQuestion)
+		write.POST("/questions/:id/revisions/:number/revert", questionHandler.RevertQuestion)
+		write.POST("/questions/:id/grade", practiceHandler.GradeAnswer)
+
+		write.POST("/practice/sessions", practiceHandler.StartSession)
+		write.POST("/practice/sessions/:id/answers", practiceHandler.SubmitAnswer)
+		write.POST("/practice/sessions/:id/answers/:questionId/self-grade", practiceHandler.SelfGrade)
+		write.POST("/practice/sessions/:id/finish", practiceHandler.FinishSession)
+
+		write.PUT("/config", aiConfigHandler.SaveAIConfig)
+
+		write.POST("/collections", collectionHandler.CreateCollection)
+		write.PUT("/collections/:id", collectionHandler.UpdateCollection)
+		write.DELETE("/collections/:id", collectionHandler.DeleteCollection)
+		write.POST("/collections/:id/questions", collectionHandler.AddQuestions)
+		write.DELETE("/collections/:id/questions/:questionId", collectionHandler.RemoveQuestion)
+		write.POST("/collections/:id/copy", collectionHandler.CopyToMyBook)
+	}
+
+	analyze := api.Group("", handlers.RequireScope(models.ScopeAnalyze))
+	{
+		analyze.POST("/analyze", aiConfigHandler.AnalyzeImage)
+		analyze.POST("/questions/:id/variants", questionHandler.GenerateVariants)
+	}
+
+	backup := api.Group("", handlers.RequireScope(models.ScopeAdmin))
+	{
+		backup.GET("/export", backupHandler.ExportBackup)
+		backup.POST("/import", backupHandler.ImportBackup)
+		backup.GET("/import/progress/:id", backupHandler.GetImportProgress)
+	}
+
+	teacherOnly := handlers.RequireRole(models.RoleTeacher, models.RoleAdmin)
+	teacherRead := read.Group("", teacherOnly)
+	{
+		teacherRead.GET("/classes/:id/members", classHandler.ListMembers)
+		teacherRead.GET("/classes/:id/assignments", classHandler.ListClassAssignments)
+		teacherRead.GET("/assignments/:id/report", classHandler.GetAssignmentReport)
+	}
+	teacherWrite := write.Group("", teacherOnly)
+	{
+		teacherWrite.POST("/classes", classHandler.CreateClass)
+		teacherWrite.DELETE("/classes/:id", classHandler.DeleteClass)
+		teacherWrite.POST("/classes/:id/members", classHandler.AddMember)
+		teacherWrite.DELETE("/classes/:id/members/:studentId", classHandler.RemoveMember)
+		teacherWrite.POST("/classes/:id/assignments", classHandler.CreateAssignment)
+	}
+
+	admin := api.Group("", handlers.RequireAdmin, handlers.RequireScope(models.ScopeAdmin))
+	{
+		// Accounts
+		admin.GET("/users", authHandler.ListUsers)
+		admin.POST("/users", authHandler.CreateUser)
+		admin.DELETE("/users/:id", authHandler.DeleteUser)
+		admin.PUT("/users/:id/role", authHandler.SetUserRole)
+		admin.POST("/users/:id/children", authHandler.LinkChild)
+		admin.DELETE("/users/:id/children/:studentId", authHandler.UnlinkChild)
+
+		// Database migrations
+		admin.GET("/db/migrations", migrationHandler.GetMigrations)
+		admin.POST("/db/migrate", migrationHandler.ApplyMigrations)
+		admin.GET("/db/schema/drift", migrationHandler.GetSchemaDrift)
+
+		// Database snapshots
+		admin.GET("/db/snapshots", snapshotHandler.ListSnapshots)
+		admin.POST("/db/snapshots", snapshotHandler.CreateSnapshot)
+		admin.POST("/db/snapshots/:name/restore", snapshotHandler.RestoreSnapshot)
+
+		// Integrity check and repair
+		admin.GET("/db/integrity", integrityHandler.CheckIntegrity)
+		admin.POST("/db/integrity/repair", integrityHandler.RepairIntegrity)
+
+		// Audit log
+		admin.GET("/audit", auditHandler.ListAuditEntries)
+	}
+	return nil
+}
+
+// corsMiddleware answers cross-origin requests from the listed origins only.
+// The web app is served from the same origin (directly or through the Vite
+// proxy), so by default no CORS headers are sent at all; a wildcard would
+// not work with the session cookie anyway.
+func corsMiddleware(origins []string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[o] = true
+	}
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin != "" && allowed[origin] {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Allow-Credentials", "true")
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Requested-With, If-Match, If-None-Match")
+			c.Header("Access-Control-Expose-Headers", "ETag")
+			c.Header("Vary", "Origin")
+		}
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+		c.Next()
+	}
+}
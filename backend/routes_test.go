@@ -0,0 +1,750 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"E-Bu-backend/database"
+	"E-Bu-backend/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// publicRoutes are the only routes that answer without a session.
+var publicRoutes = map[string]bool{
+	"GET /api/auth/status":  true,
+	"POST /api/auth/setup":  true,
+	"POST /api/auth/login":  true,
+	"POST /api/auth/logout": true,
+}
+
+func newTestServer(t *testing.T) *gin.Engine {
+	t.Helper()
+	return newTestServerBehind(t, nil)
+}
+
+// newTestServerBehind is newTestServer with TRUSTED_PROXIES set to proxies.
+func newTestServerBehind(t *testing.T, proxies []string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "ebu.db")
+	db, err := database.NewDB(dbPath)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	r := gin.New()
+	r.SetTrustedProxies(proxies)
+	err = registerRoutes(r, db, routeConfig{
+		DBPath:         dbPath,
+		Snapshots:      database.NewSnapshotter(db, database.SnapshotConfig{Dir: filepath.Join(dir, "snapshots")}),
+		SetupCode:      "code",
+		TrustedProxies: proxies,
+	})
+	if err != nil {
+		t.Fatalf("registerRoutes: %v", err)
+	}
+	return r
+}
+
+// request sends a JSON request with the given session cookie (if any) and
+// returns the response.
+func request(r *gin.Engine, method string, path string, cookie *http.Cookie, body interface{}) *httptest.ResponseRecorder {
+	return requestWithHeaders(r, method, path, cookie, nil, body)
+}
+
+// requestWithHeaders is request with extra request headers.
+func requestWithHeaders(r *gin.Engine, method string, path string, cookie *http.Cookie, headers map[string]string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func sessionCookie(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, c := range w.Result().Cookies() {
+		if c.Name == handlers.SessionCookie && c.Value != "" {
+			if !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+				t.Fatalf("session cookie is not HttpOnly and SameSite=Lax: %+v", c)
+			}
+			return c
+		}
+	}
+	t.Fatalf("no session cookie in %d response: %s", w.Code, w.Body.String())
+	return nil
+}
+
+func TestRoutes_RejectUnauthenticated(t *testing.T) {
+	r := newTestServer(t)
+
+	routes := r.Routes()
+	if len(routes) < 30 {
+		t.Fatalf("only %d routes registered", len(routes))
+	}
+	forged := &http.Cookie{Name: handlers.SessionCookie, Value: "forged"}
+	for _, route := range routes {
+		if publicRoutes[route.Method+" "+route.Path] {
+			continue
+		}
+		path := strings.NewReplacer(":id", "x", ":name", "x", ":questionId", "x", ":studentId", "x").Replace(route.Path)
+		for _, cookie := range []*http.Cookie{nil, forged} {
+			w := request(r, route.Method, path, cookie, map[string]string{})
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("%s %s with cookie %v: status %d, want 401", route.Method, route.Path, cookie, w.Code)
+			}
+		}
+	}
+}
+
+func TestAuthFlow(t *testing.T) {
+	r := newTestServer(t)
+	admin := map[string]string{"username": "admin", "password": "correct horse"}
+
+	var status struct {
+		SetupRequired bool `json:"setupRequired"`
+	}
+	json.Unmarshal(request(r, http.MethodGet, "/api/auth/status", nil, nil).Body.Bytes(), &status)
+	if !status.SetupRequired {
+		t.Fatalf("fresh server does not ask for setup")
+	}
+
+	if w := request(r, http.MethodPost, "/api/auth/setup", nil, admin); w.Code != http.StatusForbidden {
+		t.Fatalf("setup without the code: status %d, want 403", w.Code)
+	}
+	w := request(r, http.MethodPost, "/api/auth/setup", nil, map[string]string{"username": "admin", "password": "correct horse", "setupCode": "code"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("setup: status %d: %s", w.Code, w.Body.String())
+	}
+	adminCookie := sessionCookie(t, w)
+	w = request(r, http.MethodPost, "/api/auth/setup", nil, map[string]string{"username": "again", "password": "correct horse", "setupCode": "code"})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("second setup: status %d, want 409", w.Code)
+	}
+
+	if w := request(r, http.MethodGet, "/api/questions", adminCookie, nil); w.Code != http.StatusOK {
+		t.Fatalf("questions as admin: status %d", w.Code)
+	}
+	student := map[string]string{"username": "student", "password": "battery staple"}
+	if w := request(r, http.MethodPost, "/api/users", adminCookie, student); w.Code != http.StatusCreated {
+		t.Fatalf("create user: status %d: %s", w.Code, w.Body.String())
+	}
+
+	w = request(r, http.MethodPost, "/api/auth/login", nil, student)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body.String())
+	}
+	studentCookie := sessionCookie(t, w)
+	if w := request(r, http.MethodGet, "/api/questions", studentCookie, nil); w.Code != http.StatusOK {
+		t.Fatalf("questions as user: status %d", w.Code)
+	}
+	for _, path := range []string{"/api/users", "/api/db/migrations", "/api/db/snapshots", "/api/db/integrity"} {
+		if w := request(r, http.MethodGet, path, studentCookie, nil); w.Code != http.StatusForbidden {
+			t.Fatalf("%s as user: status %d, want 403", path, w.Code)
+		}
+	}
+
+	if w := request(r, http.MethodPost, "/api/auth/logout", studentCookie, nil); w.Code != http.StatusOK {
+		t.Fatalf("logout: status %d", w.Code)
+	}
+	if w := request(r, http.MethodGet, "/api/questions", studentCookie, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("questions after logout: status %d, want 401", w.Code)
+	}
+}
+
+func TestSessionCookie_SecureOnlyFromTrustedProxies(t *testing.T) {
+	setup := map[string]string{"username": "admin", "password": "correct horse", "setupCode": "code"}
+	https := map[string]string{"X-Forwarded-Proto": "https"}
+
+	// Any client can send the header, so without trusted proxies it is
+	// ignored.
+	w := requestWithHeaders(newTestServer(t), http.MethodPost, "/api/auth/setup", nil, https, setup)
+	if c := sessionCookie(t, w); c.Secure {
+		t.Fatalf("cookie is Secure on a client's say-so")
+	}
+	w = requestWithHeaders(newTestServerBehind(t, []string{"198.51.100.0/24"}), http.MethodPost, "/api/auth/setup", nil, https, setup)
+	if c := sessionCookie(t, w); c.Secure {
+		t.Fatalf("cookie is Secure on an untrusted client's say-so")
+	}
+	// httptest requests come from 192.0.2.1.
+	w = requestWithHeaders(newTestServerBehind(t, []string{"192.0.2.1"}), http.MethodPost, "/api/auth/setup", nil, https, setup)
+	if c := sessionCookie(t, w); !c.Secure {
+		t.Fatalf("cookie is not Secure behind a trusted HTTPS proxy")
+	}
+}
+
+func TestLogin_Throttled(t *testing.T) {
+	r := newTestServer(t)
+	w := request(r, http.MethodPost, "/api/auth/setup", nil, map[string]string{"username": "admin", "password": "correct horse", "setupCode": "code"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("setup: status %d", w.Code)
+	}
+
+	adminCookie := sessionCookie(t, w)
+
+	// A made-up X-Forwarded-For on every try does not get around the
+	// throttle: without trusted proxies the header is ignored.
+	spoofed := func(i int) map[string]string {
+		return map[string]string{"X-Forwarded-For": fmt.Sprintf("203.0.113.%d", i)}
+	}
+	wrong := map[string]string{"username": "admin", "password": "wrong horse"}
+	for i := 0; i < 5; i++ {
+		if w := requestWithHeaders(r, http.MethodPost, "/api/auth/login", nil, spoofed(i), wrong); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d, want 401", i+1, w.Code)
+		}
+	}
+	w = requestWithHeaders(r, http.MethodPost, "/api/auth/login", nil, spoofed(99), map[string]string{"username": "admin", "password": "correct horse"})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("login after 5 failures: status %d, want 429 with Retry-After", w.Code)
+	}
+
+	// Nor does it end up in the audit log.
+	student := map[string]string{"username": "student", "password": "battery staple"}
+	if w := requestWithHeaders(r, http.MethodPost, "/api/users", adminCookie, spoofed(7), student); w.Code != http.StatusCreated {
+		t.Fatalf("create user: status %d", w.Code)
+	}
+	var audit struct {
+		Items []struct {
+			IP string `json:"ip"`
+		} `json:"items"`
+	}
+	w = request(r, http.MethodGet, "/api/audit", adminCookie, nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &audit); err != nil || len(audit.Items) == 0 {
+		t.Fatalf("audit: status %d, %s", w.Code, w.Body.String())
+	}
+	if ip := audit.Items[0].IP; ip != "192.0.2.1" {
+		t.Fatalf("audit IP = %q, want the connection's address", ip)
+	}
+}
+
+func TestRoutes_UsersOnlySeeTheirOwnData(t *testing.T) {
+	r := newTestServer(t)
+	w := request(r, http.MethodPost, "/api/auth/setup", nil, map[string]string{"username": "admin", "password": "correct horse", "setupCode": "code"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("setup: status %d", w.Code)
+	}
+	adminCookie := sessionCookie(t, w)
+	student := map[string]string{"username": "student", "password": "battery staple"}
+	if w := request(r, http.MethodPost, "/api/users", adminCookie, student); w.Code != http.StatusCreated {
+		t.Fatalf("create user: status %d", w.Code)
+	}
+	studentCookie := sessionCookie(t, request(r, http.MethodPost, "/api/auth/login", nil, student))
+
+	const secret = "the admin's own question"
+	w = request(r, http.MethodPost, "/api/questions", adminCookie, map[string]interface{}{
+		"content": secret, "analysis": "a", "learningGuide": "l", "knowledgePoints": []string{"kp"},
+		"subject": "数学", "difficulty": 2, "options": []string{"A. 1", "B. 2"}, "answer": "A",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create question: status %d: %s", w.Code, w.Body.String())
+	}
+	var question struct{ ID string }
+	json.Unmarshal(w.Body.Bytes(), &question)
+	w = request(r, http.MethodPost, "/api/practice/sessions", adminCookie, map[string]interface{}{"questionIds": []string{question.ID}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("start session: status %d: %s", w.Code, w.Body.String())
+	}
+	var session struct{ ID string }
+	json.Unmarshal(w.Body.Bytes(), &session)
+	if w := request(r, http.MethodPut, "/api/config", adminCookie, map[string]string{"type": "OPENAI", "modelName": "admin-model"}); w.Code != http.StatusOK {
+		t.Fatalf("save config: status %d", w.Code)
+	}
+
+	q := "/api/questions/" + question.ID
+	s := "/api/practice/sessions/" + session.ID
+	for _, tc := range []struct {
+		method, path string
+		body         interface{}
+		want         int
+	}{
+		{http.MethodGet, "/api/questions", nil, http.StatusOK},
+		{http.MethodGet, "/api/questions?page=1&q=admin", nil, http.StatusOK},
+		{http.MethodGet, "/api/trash", nil, http.StatusOK},
+		{http.MethodPut, q, map[string]string{"content": "changed"}, http.StatusNotFound},
+		{http.MethodDelete, q, nil, http.StatusOK},
+		{http.MethodDelete, q + "/hard", nil, http.StatusOK},
+		{http.MethodGet, q + "/variants", nil, http.StatusOK},
+		{http.MethodPost, q + "/variants", map[string]int{"count": 1}, http.StatusNotFound},
+		{http.MethodGet, q + "/reviews", nil, http.StatusOK},
+		{http.MethodGet, q + "/attempts", nil, http.StatusOK},
+		{http.MethodPost, q + "/grade", map[string]string{"answer": "A"}, http.StatusNotFound},
+		{http.MethodPost, "/api/practice/sessions", map[string]interface{}{"questionIds": []string{question.ID}}, http.StatusBadRequest},
+		{http.MethodGet, s, nil, http.StatusNotFound},
+		{http.MethodGet, s + "/next", nil, http.StatusNotFound},
+		{http.MethodPost, s + "/answers", map[string]string{"questionId": question.ID, "answer": "A"}, http.StatusNotFound},
+		{http.MethodPost, s + "/finish", nil, http.StatusNotFound},
+		{http.MethodGet, "/api/config", nil, http.StatusOK},
+		{http.MethodGet, "/api/export", nil, http.StatusOK},
+		{http.MethodGet, "/api/export?format=zip", nil, http.StatusOK},
+	} {
+		w := request(r, tc.method, tc.path, studentCookie, tc.body)
+		if w.Code != tc.want {
+			t.Errorf("%s %s as another user: status %d, want %d: %s", tc.method, tc.path, w.Code, tc.want, w.Body.String())
+		}
+		for _, leak := range []string{secret, question.ID, "admin-model"} {
+			if strings.Contains(w.Body.String(), leak) {
+				t.Errorf("%s %s as another user leaks %q", tc.method, tc.path, leak)
+			}
+		}
+	}
+
+	// The admin's data is untouched.
+	w = request(r, http.MethodGet, s, adminCookie, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin's session: status %d", w.Code)
+	}
+	w = request(r, http.MethodGet, "/api/questions", adminCookie, nil)
+	if !strings.Contains(w.Body.String(), secret) {
+		t.Fatalf("admin's question changed or gone: %s", w.Body.String())
+	}
+}
+
+func TestRoutes_ClassesAndAssignments(t *testing.T) {
+	r := newTestServer(t)
+	w := request(r, http.MethodPost, "/api/auth/setup", nil, map[string]string{"username": "admin", "password": "correct horse", "setupCode": "code"})
+	adminCookie := sessionCookie(t, w)
+	login := map[string]*http.Cookie{}
+	ids := map[string]string{}
+	for name, role := range map[string]string{"teacher": "teacher", "other": "teacher", "alice": "student", "mum": "parent"} {
+		w := request(r, http.MethodPost, "/api/users", adminCookie, map[string]string{"username": name, "password": "correct horse", "role": role})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create %s: status %d: %s", name, w.Code, w.Body.String())
+		}
+		var user struct{ ID string }
+		json.Unmarshal(w.Body.Bytes(), &user)
+		ids[name] = user.ID
+		login[name] = sessionCookie(t, request(r, http.MethodPost, "/api/auth/login", nil, map[string]string{"username": name, "password": "correct horse"}))
+	}
+	if w := request(r, http.MethodPost, "/api/users/"+ids["mum"]+"/children", adminCookie, map[string]string{"studentId": ids["alice"]}); w.Code != http.StatusCreated {
+		t.Fatalf("link child: status %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := request(r, http.MethodPost, "/api/classes", login["alice"], map[string]string{"name": "7A"}); w.Code != http.StatusForbidden {
+		t.Fatalf("student creating a class: status %d, want 403", w.Code)
+	}
+	w = request(r, http.MethodPost, "/api/classes", login["teacher"], map[string]string{"name": "7A"})
+	var class struct{ ID string }
+	json.Unmarshal(w.Body.Bytes(), &class)
+	if w := request(r, http.MethodPost, "/api/classes/"+class.ID+"/members", login["teacher"], map[string]string{"username": "alice"}); w.Code != http.StatusCreated {
+		t.Fatalf("add member: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(r, http.MethodPost, "/api/classes/"+class.ID+"/members", login["other"], map[string]string{"username": "alice"}); w.Code != http.StatusNotFound {
+		t.Fatalf("another teacher's class: status %d, want 404", w.Code)
+	}
+
+	w = request(r, http.MethodPost, "/api/questions", login["teacher"], map[string]interface{}{
+		"content": "2 + 2 = ?", "analysis": "a", "learningGuide": "l", "knowledgePoints": []string{"kp"},
+		"subject": "数学", "difficulty": 1, "options": []string{"A. 4", "B. 5"}, "answer": "A",
+	})
+	var question struct{ ID string }
+	json.Unmarshal(w.Body.Bytes(), &question)
+	w = request(r, http.MethodPost, "/api/classes/"+class.ID+"/assignments", login["teacher"], map[string]interface{}{
+		"title": "homework", "dueAt": "2099-01-01T00:00:00Z", "questionIds": []string{question.ID},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create assignment: status %d: %s", w.Code, w.Body.String())
+	}
+	var assignment struct{ ID string }
+	json.Unmarshal(w.Body.Bytes(), &assignment)
+
+	// Alice answers through the practice session she got.
+	var mine []struct {
+		Progress struct{ SessionID string }
+	}
+	json.Unmarshal(request(r, http.MethodGet, "/api/assignments", login["alice"], nil).Body.Bytes(), &mine)
+	if len(mine) != 1 {
+		t.Fatalf("alice has %d assignments", len(mine))
+	}
+	session := "/api/practice/sessions/" + mine[0].Progress.SessionID
+	var next struct {
+		Question struct{ QuestionID string }
+	}
+	json.Unmarshal(request(r, http.MethodGet, session+"/next", login["alice"], nil).Body.Bytes(), &next)
+	if w := request(r, http.MethodPost, session+"/answers", login["alice"], map[string]string{"questionId": next.Question.QuestionID, "answer": "A"}); w.Code != http.StatusOK {
+		t.Fatalf("answer: status %d: %s", w.Code, w.Body.String())
+	}
+
+	var report struct {
+		Students []struct {
+			Completed bool
+			Score     int
+		}
+	}
+	w = request(r, http.MethodGet, "/api/assignments/"+assignment.ID+"/report", login["teacher"], nil)
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if len(report.Students) != 1 || !report.Students[0].Completed || report.Students[0].Score != 100 {
+		t.Fatalf("report: %s", w.Body.String())
+	}
+	if w := request(r, http.MethodGet, "/api/assignments/"+assignment.ID+"/report", login["other"], nil); w.Code != http.StatusNotFound {
+		t.Fatalf("another teacher's report: status %d, want 404", w.Code)
+	}
+
+	student := "/api/students/" + ids["alice"]
+	for _, tc := range []struct {
+		who, path string
+		want      int
+	}{
+		{"teacher", student + "/questions", http.StatusOK},
+		{"teacher", student + "/stats", http.StatusOK},
+		{"mum", student + "/stats", http.StatusOK},
+		{"mum", student + "/assignments", http.StatusOK},
+		{"mum", student + "/questions", http.StatusNotFound},
+		{"other", student + "/stats", http.StatusNotFound},
+		{"alice", student + "/stats", http.StatusNotFound},
+	} {
+		if w := request(r, http.MethodGet, tc.path, login[tc.who], nil); w.Code != tc.want {
+			t.Errorf("%s GET %s: status %d, want %d", tc.who, tc.path, w.Code, tc.want)
+		}
+	}
+	// The views are read-only: there is nothing to write to.
+	if w := request(r, http.MethodPut, student+"/questions", login["teacher"], nil); w.Code != http.StatusNotFound {
+		t.Errorf("PUT on a student view: status %d", w.Code)
+	}
+}
+
+func TestRoutes_SharedCollections(t *testing.T) {
+	r := newTestServer(t)
+	w := request(r, http.MethodPost, "/api/auth/setup", nil, map[string]string{"username": "admin", "password": "correct horse", "setupCode": "code"})
+	adminCookie := sessionCookie(t, w)
+	if w := request(r, http.MethodPost, "/api/users", adminCookie, map[string]string{"username": "alice", "password": "correct horse", "role": "student"}); w.Code != http.StatusCreated {
+		t.Fatalf("create alice: status %d: %s", w.Code, w.Body.String())
+	}
+	alice := sessionCookie(t, request(r, http.MethodPost, "/api/auth/login", nil, map[string]string{"username": "alice", "password": "correct horse"}))
+
+	w = request(r, http.MethodPost, "/api/questions", adminCookie, map[string]interface{}{
+		"content": "a common mistake", "analysis": "a", "learningGuide": "l", "knowledgePoints": []string{"kp"}, "subject": "数学", "difficulty": 1,
+	})
+	var question struct{ ID string }
+	json.Unmarshal(w.Body.Bytes(), &question)
+	w = request(r, http.MethodPost, "/api/collections", adminCookie, map[string]string{"name": "Common mistakes"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create collection: status %d: %s", w.Code, w.Body.String())
+	}
+	var collection struct{ ID string }
+	json.Unmarshal(w.Body.Bytes(), &collection)
+	path := "/api/collections/" + collection.ID
+	if w := request(r, http.MethodPost, path+"/questions", adminCookie, map[string]interface{}{"questionIds": []string{question.ID}}); w.Code != http.StatusOK {
+		t.Fatalf("add question: status %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := request(r, http.MethodGet, path, alice, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("private collection for alice: status %d, want 404", w.Code)
+	}
+	if w := request(r, http.MethodPut, path, adminCookie, map[string]string{"name": "Common mistakes", "visibility": "everyone"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown visibility: status %d, want 400", w.Code)
+	}
+	if w := request(r, http.MethodPut, path, adminCookie, map[string]string{"name": "Common mistakes", "visibility": "all"}); w.Code != http.StatusOK {
+		t.Fatalf("publish: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(r, http.MethodGet, path, alice, nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "a common mistake") {
+		t.Fatalf("published collection for alice: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(r, http.MethodDelete, path+"/questions/"+question.ID, alice, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("alice changing the collection: status %d, want 403", w.Code)
+	}
+
+	w = request(r, http.MethodPost, path+"/copy", alice, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("copy: status %d: %s", w.Code, w.Body.String())
+	}
+	var result struct {
+		Copied []struct {
+			ID               string
+			SourceQuestionID string
+		}
+	}
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if len(result.Copied) != 1 || result.Copied[0].SourceQuestionID != question.ID {
+		t.Fatalf("copy result: %s", w.Body.String())
+	}
+	if w := request(r, http.MethodGet, "/api/questions", alice, nil); !strings.Contains(w.Body.String(), result.Copied[0].ID) {
+		t.Fatalf("copy not in alice's book: %s", w.Body.String())
+	}
+}
+
+func TestRoutes_APITokens(t *testing.T) {
+	r := newTestServer(t)
+	w := request(r, http.MethodPost, "/api/auth/setup", nil, map[string]string{"username": "admin", "password": "correct horse", "setupCode": "code"})
+	adminCookie := sessionCookie(t, w)
+
+	w = request(r, http.MethodPost, "/api/auth/tokens", adminCookie, map[string]interface{}{"name": "nightly export", "scopes": []string{"questions:read", "admin"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create token: status %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Token    string
+		APIToken struct{ ID string }
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	withToken := func(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(body)
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/questions", http.StatusOK},
+		{http.MethodGet, "/api/export", http.StatusOK},
+		{http.MethodGet, "/api/users", http.StatusOK},
+		{http.MethodPost, "/api/questions", http.StatusForbidden},
+		{http.MethodPost, "/api/analyze", http.StatusForbidden},
+		{http.MethodPost, "/api/auth/tokens", http.StatusForbidden},
+		{http.MethodPut, "/api/auth/password", http.StatusForbidden},
+	} {
+		if w := withToken(tc.method, tc.path, created.Token, map[string]string{}); w.Code != tc.want {
+			t.Errorf("%s %s with the token: status %d, want %d: %s", tc.method, tc.path, w.Code, tc.want, w.Body.String())
+		}
+	}
+	if w := withToken(http.MethodGet, "/api/questions", "ebu_forged", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("forged token: status %d, want 401", w.Code)
+	}
+
+	w = request(r, http.MethodGet, "/api/auth/tokens", adminCookie, nil)
+	if strings.Contains(w.Body.String(), created.Token) || !strings.Contains(w.Body.String(), "nightly export") {
+		t.Fatalf("token list: %s", w.Body.String())
+	}
+	if w := request(r, http.MethodDelete, "/api/auth/tokens/"+created.APIToken.ID, adminCookie, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke: status %d", w.Code)
+	}
+	if w := withToken(http.MethodGet, "/api/questions", created.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked token: status %d, want 401", w.Code)
+	}
+}
+
+func TestRoutes_AuditLog(t *testing.T) {
+	r := newTestServer(t)
+	w := request(r, http.MethodPost, "/api/auth/setup", nil, map[string]string{"username": "admin", "password": "correct horse", "setupCode": "code"})
+	adminCookie := sessionCookie(t, w)
+	request(r, http.MethodPost, "/api/users", adminCookie, map[string]string{"username": "alice", "password": "correct horse", "role": "student"})
+	alice := sessionCookie(t, request(r, http.MethodPost, "/api/auth/login", nil, map[string]string{"username": "alice", "password": "correct horse"}))
+
+	w = request(r, http.MethodPost, "/api/questions", alice, map[string]interface{}{
+		"content": "q", "analysis": "a", "learningGuide": "l", "knowledgePoints": []string{"kp"}, "subject": "数学", "difficulty": 1,
+	})
+	var question struct{ ID string }
+	json.Unmarshal(w.Body.Bytes(), &question)
+	if w := request(r, http.MethodDelete, "/api/questions/"+question.ID+"/hard", alice, nil); w.Code != http.StatusOK {
+		t.Fatalf("hard delete: status %d", w.Code)
+	}
+
+	if w := request(r, http.MethodGet, "/api/audit", alice, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("audit log for a student: status %d, want 403", w.Code)
+	}
+	if w := request(r, http.MethodGet, "/api/audit?since=yesterday", adminCookie, nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad since: status %d, want 400", w.Code)
+	}
+	w = request(r, http.MethodGet, "/api/audit?action=question&targetId="+question.ID, adminCookie, nil)
+	var page struct {
+		Total int
+		Items []struct {
+			Action    string
+			ActorName string
+			IP        string
+			TargetIDs []string
+		}
+	}
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if page.Total != 1 || page.Items[0].Action != "question.hard_delete" || page.Items[0].ActorName != "alice" || page.Items[0].IP == "" {
+		t.Fatalf("audit log: %s", w.Body.String())
+	}
+}
+
+func TestRoutes_QuestionRevisions(t *testing.T) {
+	r := newTestServer(t)
+	w := request(r, http.MethodPost, "/api/auth/setup", nil, map[string]string{"username": "admin", "password": "correct horse", "setupCode": "code"})
+	admin := sessionCookie(t, w)
+	request(r, http.MethodPost, "/api/users", admin, map[string]string{"username": "alice", "password": "correct horse", "role": "student"})
+	alice := sessionCookie(t, request(r, http.MethodPost, "/api/auth/login", nil, map[string]string{"username": "alice", "password": "correct horse"}))
+
+	w = request(r, http.MethodPost, "/api/questions", admin, map[string]interface{}{
+		"content": "q", "analysis": "from the AI", "learningGuide": "l", "knowledgePoints": []string{"kp"}, "subject": "数学", "difficulty": 1, "aiGenerated": true,
+	})
+	var question struct{ ID string }
+	json.Unmarshal(w.Body.Bytes(), &question)
+	path := "/api/questions/" + question.ID
+	if w := request(r, http.MethodPut, path, admin, map[string]interface{}{"analysis": "corrected"}); w.Code != http.StatusOK {
+		t.Fatalf("update: status %d", w.Code)
+	}
+
+	w = request(r, http.MethodGet, path+"/revisions", admin, nil)
+	var revisions []struct {
+		Number        int
+		Source        string
+		AuthorName    string
+		ChangedFields []string
+	}
+	json.Unmarshal(w.Body.Bytes(), &revisions)
+	if len(revisions) != 2 || revisions[0].Source != "ai" || revisions[1].Source != "edit" || revisions[1].AuthorName != "admin" {
+		t.Fatalf("revisions: %s", w.Body.String())
+	}
+	w = request(r, http.MethodGet, path+"/diff?from=1&to=2", admin, nil)
+	var diff struct {
+		Changes []struct{ Field, Old, New string }
+	}
+	json.Unmarshal(w.Body.Bytes(), &diff)
+	if len(diff.Changes) != 1 || diff.Changes[0].Old != "from the AI" || diff.Changes[0].New != "corrected" {
+		t.Fatalf("diff: %s", w.Body.String())
+	}
+	if w := request(r, http.MethodGet, path+"/diff?from=1", admin, nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("diff without to: status %d, want 400", w.Code)
+	}
+	if w := request(r, http.MethodGet, path+"/revisions/1", alice, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("another user's revision: status %d, want 404", w.Code)
+	}
+	if w := request(r, http.MethodPost, path+"/revisions/1/revert", alice, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("reverting another user's question: status %d, want 404", w.Code)
+	}
+
+	w = request(r, http.MethodPost, path+"/revisions/1/revert", admin, nil)
+	var reverted struct{ Analysis string }
+	json.Unmarshal(w.Body.Bytes(), &reverted)
+	if w.Code != http.StatusOK || reverted.Analysis != "from the AI" {
+		t.Fatalf("revert: %d %s", w.Code, w.Body.String())
+	}
+	w = request(r, http.MethodGet, path+"/revisions/3", admin, nil)
+	var revision struct {
+		Source       string
+		RevertedFrom int
+		Snapshot     struct{ Analysis string }
+	}
+	json.Unmarshal(w.Body.Bytes(), &revision)
+	if revision.Source != "revert" || revision.RevertedFrom != 1 || revision.Snapshot.Analysis != "from the AI" {
+		t.Fatalf("revert revision: %s", w.Body.String())
+	}
+	// The revert moved the question on, so a copy read before it is stale.
+	if w := requestWithHeaders(r, http.MethodPost, path+"/revisions/2/revert", admin, map[string]string{"If-Match": `"2"`}, nil); w.Code != http.StatusConflict {
+		t.Fatalf("stale revert: status %d, want 409", w.Code)
+	}
+}
+
+func TestRoutes_QuestionVersions(t *testing.T) {
+	r := newTestServer(t)
+	admin := sessionCookie(t, request(r, http.MethodPost, "/api/auth/setup", nil, map[string]string{"username": "admin", "password": "correct horse", "setupCode": "code"}))
+
+	w := request(r, http.MethodPost, "/api/questions", admin, map[string]interface{}{
+		"content": "q", "analysis": "a", "learningGuide": "l", "knowledgePoints": []string{"kp"}, "subject": "数学", "difficulty": 1,
+	})
+	var question struct {
+		ID      string
+		Version int
+	}
+	json.Unmarshal(w.Body.Bytes(), &question)
+	if question.Version != 1 || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("created version %d, ETag %q", question.Version, w.Header().Get("ETag"))
+	}
+	path := "/api/questions/" + question.ID
+
+	w = request(r, http.MethodGet, path, admin, nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag != `"1"` {
+		t.Fatalf("get: status %d, ETag %q", w.Code, etag)
+	}
+	if w := requestWithHeaders(r, http.MethodGet, path, admin, map[string]string{"If-None-Match": etag}, nil); w.Code != http.StatusNotModified {
+		t.Fatalf("get with a matching If-None-Match: status %d, want 304", w.Code)
+	}
+
+	// The first device saves; the second one's copy is now stale.
+	w = requestWithHeaders(r, http.MethodPut, path, admin, map[string]string{"If-Match": etag}, map[string]interface{}{"analysis": "first device"})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("update: status %d, ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+	w = requestWithHeaders(r, http.MethodPut, path, admin, map[string]string{"If-Match": etag}, map[string]interface{}{"analysis": "second device"})
+	var conflict struct {
+		CurrentVersion int
+		Question       struct{ Analysis string }
+	}
+	json.Unmarshal(w.Body.Bytes(), &conflict)
+	if w.Code != http.StatusConflict || conflict.CurrentVersion != 2 || conflict.Question.Analysis != "first device" || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("stale update: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := requestWithHeaders(r, http.MethodPut, path, admin, map[string]string{"If-Match": "yesterday"}, map[string]interface{}{"analysis": "x"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad If-Match: status %d, want 400", w.Code)
+	}
+	// Without If-Match the update is made to whatever is current.
+	if w := request(r, http.MethodPut, path, admin, map[string]interface{}{"analysis": "no precondition"}); w.Code != http.StatusOK || w.Header().Get("ETag") != `"3"` {
+		t.Fatalf("unconditional update: status %d, ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+	// Only the trash routes, which are audited, move it to the trash.
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		if w := request(r, method, path, admin, map[string]interface{}{"deletedAt": "2024-07-02T10:00:00Z"}); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s with deletedAt: status %d, want 400", method, w.Code)
+		}
+	}
+	request(r, http.MethodDelete, path, admin, nil)
+	if w := requestWithHeaders(r, http.MethodPut, path, admin, map[string]string{"If-Match": `W/"3"`}, map[string]interface{}{"analysis": "x"}); w.Code != http.StatusConflict {
+		t.Fatalf("update after a delete: status %d, want 409", w.Code)
+	}
+}
+
+func TestRoutes_BulkQuestions(t *testing.T) {
+	r := newTestServer(t)
+	admin := sessionCookie(t, request(r, http.MethodPost, "/api/auth/setup", nil, map[string]string{"username": "admin", "password": "correct horse", "setupCode": "code"}))
+
+	var ids []string
+	for _, content := range []string{"first", "second"} {
+		w := request(r, http.MethodPost, "/api/questions", admin, map[string]interface{}{
+			"content": content, "analysis": "a", "learningGuide": "l", "knowledgePoints": []string{"kp"}, "subject": "数学", "difficulty": 1,
+		})
+		var question struct{ ID string }
+		json.Unmarshal(w.Body.Bytes(), &question)
+		ids = append(ids, question.ID)
+	}
+
+	w := request(r, http.MethodPost, "/api/questions/bulk", admin, map[string]interface{}{"operation": "setSubject", "subject": "物理", "ids": append(ids, "missing")})
+	var result struct {
+		Changed int
+		Results []struct{ ID, Status string }
+	}
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if w.Code != http.StatusOK || result.Changed != 2 || len(result.Results) != 3 || result.Results[2].Status != "not_found" {
+		t.Fatalf("bulk set subject: %d %s", w.Code, w.Body.String())
+	}
+	if w := request(r, http.MethodPost, "/api/questions/bulk", admin, map[string]interface{}{"operation": "setDifficulty", "difficulty": 8, "ids": ids}); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad difficulty: status %d, want 400", w.Code)
+	}
+
+	hardDelete := map[string]interface{}{"operation": "hardDelete", "filter": map[string]interface{}{"subject": "物理"}}
+	w = request(r, http.MethodPost, "/api/questions/bulk", admin, hardDelete)
+	var unconfirmed struct {
+		ConfirmToken string
+		Matched      int
+	}
+	json.Unmarshal(w.Body.Bytes(), &unconfirmed)
+	if w.Code != http.StatusPreconditionRequired || unconfirmed.ConfirmToken == "" || unconfirmed.Matched != 2 {
+		t.Fatalf("unconfirmed hard delete: %d %s", w.Code, w.Body.String())
+	}
+	hardDelete["confirm"] = unconfirmed.ConfirmToken
+	if w := request(r, http.MethodPost, "/api/questions/bulk", admin, hardDelete); w.Code != http.StatusOK {
+		t.Fatalf("confirmed hard delete: %d %s", w.Code, w.Body.String())
+	}
+	w = request(r, http.MethodGet, "/api/questions", admin, nil)
+	if strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("questions left: %s", w.Body.String())
+	}
+}
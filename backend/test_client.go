@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/cookiejar"
+	"os"
 	"time"
 
 	"E-Bu-backend/models"
@@ -19,6 +21,17 @@ import (
 func main() {
 	baseURL := "http://localhost:8080/api"
 	
+	// Sign in as EBU_USERNAME / EBU_PASSWORD; the session cookie is kept in
+	// the default client's jar for the requests below.
+	http.DefaultClient.Jar, _ = cookiejar.New(nil)
+	login, _ := json.Marshal(map[string]string{"username": os.Getenv("EBU_USERNAME"), "password": os.Getenv("EBU_PASSWORD")})
+	resp, err := http.Post(baseURL+"/auth/login", "application/json", bytes.NewBuffer(login))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		fmt.Printf("Login failed: %v\n", err)
+		return
+	}
+	resp.Body.Close()
+	
 	// Test creating a question
 	fmt.Println("Testing question creation...")
 	question := models.Question{
@@ -34,7 +47,7 @@ func main() {
 	
 	questionJSON, _ := json.Marshal(question)
 	
-	resp, err := http.Post(baseURL+"/questions", "application/json", bytes.NewBuffer(questionJSON))
+	resp, err = http.Post(baseURL+"/questions", "application/json", bytes.NewBuffer(questionJSON))
 	if err != nil {
 		fmt.Printf("Error creating question: %v\n", err)
 		return
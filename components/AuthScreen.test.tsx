@@ -0,0 +1,67 @@
+import React from 'react';
+import { describe, expect, it, vi, beforeEach } from 'vitest';
+import { render, screen } from '@testing-library/react';
+import userEvent from '@testing-library/user-event';
+
+import AuthScreen from './AuthScreen';
+import { apiService } from '../services/apiService';
+
+vi.mock('../services/apiService', () => {
+  return {
+    apiService: {
+      setup: vi.fn(async (username: string) => ({ id: 'u1', username, role: 'admin' })),
+      login: vi.fn(async (username: string) => ({ id: 'u1', username, role: 'admin' })),
+    },
+  };
+});
+
+describe('AuthScreen', () => {
+  beforeEach(() => {
+    vi.clearAllMocks();
+  });
+
+  it('creates the admin account on first run', async () => {
+    const user = userEvent.setup();
+    const onSignedIn = vi.fn();
+    render(<AuthScreen mode="setup" onSignedIn={onSignedIn} />);
+
+    await user.type(screen.getByLabelText('用户名'), 'admin');
+    await user.type(screen.getByLabelText('密码'), 'secret-pass');
+    await user.type(screen.getByLabelText('确认密码'), 'secret-pass');
+    await user.type(screen.getByLabelText('初始化码'), 'abc123');
+    await user.click(screen.getByRole('button', { name: '创建并登录' }));
+
+    expect(apiService.setup).toHaveBeenCalledWith('admin', 'secret-pass', 'abc123');
+    expect(onSignedIn).toHaveBeenCalledWith({ id: 'u1', username: 'admin', role: 'admin' });
+  });
+
+  it('rejects mismatched passwords before calling the server', async () => {
+    const user = userEvent.setup();
+    render(<AuthScreen mode="setup" onSignedIn={() => {}} />);
+
+    await user.type(screen.getByLabelText('用户名'), 'admin');
+    await user.type(screen.getByLabelText('密码'), 'secret-pass');
+    await user.type(screen.getByLabelText('确认密码'), 'other-pass');
+    await user.type(screen.getByLabelText('初始化码'), 'abc123');
+    await user.click(screen.getByRole('button', { name: '创建并登录' }));
+
+    expect(screen.getByRole('alert')).toHaveTextContent('两次输入的密码不一致');
+    expect(apiService.setup).not.toHaveBeenCalled();
+  });
+
+  it('shows the login error', async () => {
+    vi.mocked(apiService.login).mockRejectedValueOnce(
+      Object.assign(new Error('用户名或密码错误'), { status: 401 })
+    );
+    const user = userEvent.setup();
+    const onSignedIn = vi.fn();
+    render(<AuthScreen mode="login" onSignedIn={onSignedIn} />);
+
+    await user.type(screen.getByLabelText('用户名'), 'admin');
+    await user.type(screen.getByLabelText('密码'), 'wrong');
+    await user.click(screen.getByRole('button', { name: '登录' }));
+
+    expect(await screen.findByRole('alert')).toHaveTextContent('用户名或密码错误');
+    expect(onSignedIn).not.toHaveBeenCalled();
+  });
+});
@@ -0,0 +1,146 @@
+import React, { useState } from 'react';
+import { apiService } from '../services/apiService';
+import { AuthUser } from '../types';
+
+interface AuthScreenProps {
+  // setup: 服务器还没有账号，先创建管理员；login: 普通登录
+  mode: 'setup' | 'login';
+  onSignedIn: (user: AuthUser) => void;
+}
+
+const MIN_PASSWORD_LENGTH = 8;
+
+const AuthScreen: React.FC<AuthScreenProps> = ({ mode: initialMode, onSignedIn }) => {
+  const [mode, setMode] = useState(initialMode);
+  const [username, setUsername] = useState('');
+  const [password, setPassword] = useState('');
+  const [confirmPassword, setConfirmPassword] = useState('');
+  const [setupCode, setSetupCode] = useState('');
+  const [error, setError] = useState<string | null>(null);
+  const [isSubmitting, setIsSubmitting] = useState(false);
+
+  const isSetup = mode === 'setup';
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError(null);
+    if (isSetup) {
+      if (password.length < MIN_PASSWORD_LENGTH) {
+        setError(`密码至少需要 ${MIN_PASSWORD_LENGTH} 个字符`);
+        return;
+      }
+      if (password !== confirmPassword) {
+        setError('两次输入的密码不一致');
+        return;
+      }
+    }
+
+    setIsSubmitting(true);
+    try {
+      const user = isSetup
+        ? await apiService.setup(username.trim(), password, setupCode.trim())
+        : await apiService.login(username.trim(), password);
+      onSignedIn(user);
+    } catch (err: any) {
+      setError(err?.message || '操作失败');
+      // 管理员账号已经存在（409），只能登录
+      if (isSetup && err?.status === 409) setMode('login');
+    } finally {
+      setIsSubmitting(false);
+    }
+  };
+
+  const inputClass =
+    'w-full px-4 py-2.5 bg-slate-50 border border-slate-200 rounded-xl focus:ring-2 focus:ring-indigo-500 focus:border-transparent outline-none transition-all text-sm';
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-slate-50 px-4">
+      <div className="w-full max-w-sm bg-white rounded-3xl shadow-xl border border-slate-100 p-8">
+        <div className="flex items-center gap-3 mb-6">
+          <div className="w-10 h-10 bg-indigo-600 rounded-xl flex items-center justify-center text-white shadow-lg shadow-indigo-200">
+            <svg className="w-6 h-6" fill="none" stroke="currentColor" viewBox="0 0 24 24">
+              <path strokeLinecap="round" strokeLinejoin="round" strokeWidth="2" d="M12 6.253v13m0-13C10.832 5.477 9.246 5 7.5 5S4.168 5.477 3 6.253v13C4.168 18.477 5.754 18 7.5 18s3.332.477 4.5 1.253m0-13C13.168 5.477 14.754 5 16.5 5c1.747 0 3.332.477 4.5 1.253v13C19.832 18.477 18.247 18 16.5 18c-1.746 0-3.332.477-4.5 1.253" />
+            </svg>
+          </div>
+          <div>
+            <h1 className="text-xl font-bold text-slate-900 leading-tight">
+              {isSetup ? '创建管理员账号' : '登录 E-Bu'}
+            </h1>
+            <p className="text-xs text-slate-400 mt-0.5">
+              {isSetup ? '首次使用，请先设置管理员账号' : '易补，知错能补'}
+            </p>
+          </div>
+        </div>
+
+        <form onSubmit={handleSubmit} className="space-y-4">
+          <label className="block">
+            <span className="block text-xs font-bold text-slate-500 mb-1">用户名</span>
+            <input
+              type="text"
+              autoComplete="username"
+              value={username}
+              onChange={(e) => setUsername(e.target.value)}
+              className={inputClass}
+              required
+            />
+          </label>
+          <label className="block">
+            <span className="block text-xs font-bold text-slate-500 mb-1">密码</span>
+            <input
+              type="password"
+              autoComplete={isSetup ? 'new-password' : 'current-password'}
+              value={password}
+              onChange={(e) => setPassword(e.target.value)}
+              className={inputClass}
+              required
+            />
+          </label>
+          {isSetup && (
+            <>
+              <label className="block">
+                <span className="block text-xs font-bold text-slate-500 mb-1">确认密码</span>
+                <input
+                  type="password"
+                  autoComplete="new-password"
+                  value={confirmPassword}
+                  onChange={(e) => setConfirmPassword(e.target.value)}
+                  className={inputClass}
+                  required
+                />
+              </label>
+              <label className="block">
+                <span className="block text-xs font-bold text-slate-500 mb-1">初始化码</span>
+                <input
+                  type="text"
+                  value={setupCode}
+                  onChange={(e) => setSetupCode(e.target.value)}
+                  className={`${inputClass} font-mono`}
+                  required
+                />
+              </label>
+              <p className="text-[11px] text-slate-400 -mt-3">
+                初始化码打印在服务器启动日志中（No accounts yet. Create the admin account with setup code ...）
+              </p>
+            </>
+          )}
+
+          {error && (
+            <div role="alert" className="px-3 py-2 bg-red-50 border border-red-100 text-red-600 text-sm rounded-xl">
+              {error}
+            </div>
+          )}
+
+          <button
+            type="submit"
+            disabled={isSubmitting}
+            className="w-full py-2.5 bg-indigo-600 text-white rounded-xl font-bold text-sm hover:bg-indigo-700 transition-colors shadow-lg shadow-indigo-200 disabled:opacity-60 disabled:cursor-not-allowed"
+          >
+            {isSubmitting ? '请稍候...' : isSetup ? '创建并登录' : '登录'}
+          </button>
+        </form>
+      </div>
+    </div>
+  );
+};
+
+export default AuthScreen;
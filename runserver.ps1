@@ -74,7 +74,7 @@ try {
   Write-Host "Server starting at http://localhost:8080"
   Write-Host "Press Ctrl+C to stop."
 
-  go run main.go
+  go run .
 } finally {
   Pop-Location
 }
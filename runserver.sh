@@ -53,4 +53,4 @@ go mod download
 echo "Server starting at http://localhost:8080"
 echo "Press Ctrl+C to stop."
 
-go run main.go
+go run .
@@ -1,6 +1,6 @@
 import { describe, expect, it, vi } from "vitest";
 
-import { apiService } from "./apiService";
+import { apiService, setUnauthorizedHandler } from "./apiService";
 
 describe("apiService.createQuestion LaTeX normalization", () => {
   it("wraps raw LaTeX when field is only math", async () => {
@@ -134,3 +134,39 @@ describe("apiService.createQuestion LaTeX normalization", () => {
     expect(body.options).toEqual(["选项含$\\frac{1}{6}$", "换行\\n不要包裹"]);
   });
 });
+
+describe("apiService session handling", () => {
+  it("sends the session cookie and reports 401 to the handler", async () => {
+    const fetchMock = vi.fn(async () => ({
+      ok: false,
+      status: 401,
+      json: async () => ({ error: "login required" }),
+    }));
+    // @ts-expect-error test override
+    global.fetch = fetchMock;
+    const onUnauthorized = vi.fn();
+    setUnauthorizedHandler(onUnauthorized);
+
+    await expect(apiService.hardDeleteQuestion("q1")).rejects.toThrow();
+
+    const calls = fetchMock.mock.calls as unknown as Array<any>;
+    expect(calls[0][1].credentials).toBe("same-origin");
+    expect(onUnauthorized).toHaveBeenCalledTimes(1);
+    setUnauthorizedHandler(null);
+  });
+
+  it("keeps the HTTP status on login errors", async () => {
+    const fetchMock = vi.fn(async () => ({
+      ok: false,
+      status: 401,
+      json: async () => ({ error: "invalid username or password" }),
+    }));
+    // @ts-expect-error test override
+    global.fetch = fetchMock;
+
+    await expect(apiService.login("admin", "wrong")).rejects.toMatchObject({
+      status: 401,
+      message: "用户名或密码错误",
+    });
+  });
+});
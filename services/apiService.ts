@@ -4,11 +4,50 @@ import {
   AIConfig,
   AIProviderType,
   Subject,
+  AuthUser,
+  AuthStatus,
 } from "../types";
 import { analyzeQuestionImage } from "./imageAnalysisService";
 
 const MOCK_DELAY = 300; // Minimal delay for UX feel if needed
 
+// Called when the backend answers 401, i.e. the session is missing or has
+// expired, so the app can show the login screen.
+let unauthorizedHandler: (() => void) | null = null;
+
+export const setUnauthorizedHandler = (handler: (() => void) | null) => {
+  unauthorizedHandler = handler;
+};
+
+// All /api calls go through here: the session cookie is sent along and a
+// 401 is reported to the app.
+const apiFetch = async (input: string, init?: RequestInit): Promise<Response> => {
+  const res = await fetch(input, { credentials: "same-origin", ...init });
+  if (res.status === 401 && unauthorizedHandler) unauthorizedHandler();
+  return res;
+};
+
+// Sign-in requests answer 401 for a wrong password, which must not count as
+// an expired session, so they use fetch directly.
+const authRequest = async (
+  path: string,
+  body: unknown,
+  messages: Record<number, string>
+): Promise<AuthUser> => {
+  const res = await fetch(path, {
+    method: "POST",
+    credentials: "same-origin",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(body),
+  });
+  const data = await res.json().catch(() => ({}));
+  if (!res.ok) {
+    const message = messages[res.status] || data.error || `请求失败: ${res.status}`;
+    throw Object.assign(new Error(message), { status: res.status });
+  }
+  return data.user;
+};
+
 // Helper to transform API/DB format to Frontend format
 // The backend returns options/knowledgePoints as JSON strings.
 const transformQuestionFromApi = (q: any): Question => {
@@ -111,10 +150,46 @@ const normalizeLatexInOptions = (options: any): string[] => {
 };
 
 export const apiService = {
+  // Accounts
+  async getAuthStatus(): Promise<AuthStatus> {
+    const res = await fetch("/api/auth/status", { credentials: "same-origin" });
+    if (!res.ok) throw new Error(`Failed to get sign-in status: ${res.status}`);
+    const data = await res.json();
+    return { setupRequired: Boolean(data.setupRequired), user: data.user || null };
+  },
+
+  // First run: creates the admin account and signs it in. The setup code is
+  // printed in the server log.
+  async setup(username: string, password: string, setupCode: string): Promise<AuthUser> {
+    return authRequest(
+      "/api/auth/setup",
+      { username, password, setupCode },
+      {
+        403: "初始化码不正确，请在服务器启动日志中查看",
+        409: "管理员账号已经创建，请直接登录",
+      }
+    );
+  },
+
+  async login(username: string, password: string): Promise<AuthUser> {
+    return authRequest(
+      "/api/auth/login",
+      { username, password },
+      {
+        401: "用户名或密码错误",
+        429: "登录失败次数过多，请稍后再试",
+      }
+    );
+  },
+
+  async logout(): Promise<void> {
+    await fetch("/api/auth/logout", { method: "POST", credentials: "same-origin" });
+  },
+
   // AI Config Management
   async getAIConfig(): Promise<AIConfig> {
     try {
-      const res = await fetch("/api/config");
+      const res = await apiFetch("/api/config");
       if (!res.ok) throw new Error("Failed to load config");
       const data = await res.json();
 
@@ -144,7 +219,7 @@ export const apiService = {
 
   async saveAIConfig(config: AIConfig): Promise<void> {
     try {
-      await fetch("/api/config", {
+      await apiFetch("/api/config", {
         method: "PUT",
         headers: { "Content-Type": "application/json" },
         body: JSON.stringify({ configData: JSON.stringify(config) }),
@@ -224,7 +299,7 @@ export const apiService = {
     if (params?.q) url.searchParams.set("q", params.q);
     if (params?.subject) url.searchParams.set("subject", params.subject);
 
-    const res = await fetch(url.toString().replace(window.location.origin, ""));
+    const res = await apiFetch(url.toString().replace(window.location.origin, ""));
     if (!res.ok) throw new Error("Failed to fetch questions");
     const data = await res.json();
 
@@ -268,7 +343,7 @@ export const apiService = {
     if (params?.q) url.searchParams.set("q", params.q);
     if (params?.subject) url.searchParams.set("subject", params.subject);
 
-    const res = await fetch(url.toString().replace(window.location.origin, ""));
+    const res = await apiFetch(url.toString().replace(window.location.origin, ""));
     if (!res.ok) throw new Error("Failed to fetch trash");
     const data = await res.json();
 
@@ -328,7 +403,7 @@ export const apiService = {
       difficulty: Number(data.difficulty) || 3,
     };
 
-    const res = await fetch("/api/questions", {
+    const res = await apiFetch("/api/questions", {
       method: "POST",
       headers: { "Content-Type": "application/json" },
       body: JSON.stringify(payload),
@@ -345,7 +420,7 @@ export const apiService = {
     id: string,
     updates: Partial<Question>
   ): Promise<Question> {
-    const res = await fetch(`/api/questions/${id}`, {
+    const res = await apiFetch(`/api/questions/${id}`, {
       method: "PUT",
       headers: { "Content-Type": "application/json" },
       body: JSON.stringify(updates),
@@ -359,19 +434,19 @@ export const apiService = {
   },
 
   async deleteQuestion(id: string): Promise<void> {
-    const res = await fetch(`/api/questions/${id}`, { method: "DELETE" });
+    const res = await apiFetch(`/api/questions/${id}`, { method: "DELETE" });
     if (!res.ok) throw new Error("Failed to delete question");
   },
 
   async restoreQuestion(id: string): Promise<void> {
-    const res = await fetch(`/api/questions/${id}/restore`, {
+    const res = await apiFetch(`/api/questions/${id}/restore`, {
       method: "PATCH",
     });
     if (!res.ok) throw new Error("Failed to restore question");
   },
 
   async hardDeleteQuestion(id: string): Promise<void> {
-    const res = await fetch(`/api/questions/${id}/hard`, { method: "DELETE" });
+    const res = await apiFetch(`/api/questions/${id}/hard`, { method: "DELETE" });
     if (!res.ok) throw new Error("Failed to hard delete question");
   },
 
@@ -401,7 +476,7 @@ export const apiService = {
 
   // Database migrations
   async getMigrationStatus(): Promise<any> {
-    const res = await fetch("/api/db/migrations");
+    const res = await apiFetch("/api/db/migrations");
     if (!res.ok) {
       const err = await res.json().catch(() => ({ error: "Unknown error" }));
       throw new Error(err.error || `Failed to get migrations: ${res.status}`);
@@ -410,7 +485,7 @@ export const apiService = {
   },
 
   async migrateToLatest(): Promise<any> {
-    const res = await fetch("/api/db/migrate", { method: "POST" });
+    const res = await apiFetch("/api/db/migrate", { method: "POST" });
     if (!res.ok) {
       const err = await res.json().catch(() => ({ error: "Unknown error" }));
       throw new Error(err.error || `Failed to migrate: ${res.status}`);
@@ -427,7 +502,7 @@ export const apiService = {
       throw new Error("Invalid JSON");
     }
 
-    const res = await fetch("/api/import", {
+    const res = await apiFetch("/api/import", {
       method: "POST",
       headers: { "Content-Type": "application/json" },
       body: JSON.stringify(parsed),
@@ -81,3 +81,15 @@ export interface GeminiAnalysisResponse {
   subject: Subject;
   difficulty: number;
 }
+
+// 当前登录的账号（与后端 /api/auth 返回的 user 一致）
+export interface AuthUser {
+  id: string;
+  username: string;
+  role: string;
+}
+
+export interface AuthStatus {
+  setupRequired: boolean; // 服务器还没有任何账号，需要先创建管理员
+  user: AuthUser | null;
+}